@@ -0,0 +1,30 @@
+package main
+
+import (
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Actor
+*	Identity used for limits and audit: verified calling service of signed
+*	internal requests (see signature.go), authenticated user id when set on
+*	context (by auth or DevAuthMiddleware), otherwise client ip.
+*/
+const userIdKey = "user_id"
+
+// UserID : authenticated user id of request, empty for anonymous
+func UserID(ctx *gin.Context) string {
+	return ctx.GetString(userIdKey)
+}
+
+// ActorID : service caller, user id or client ip of request
+func ActorID(ctx *gin.Context) string {
+	if caller := ServiceCaller(ctx); caller != "" {
+		return "service:" + caller
+	}
+	if userId := UserID(ctx); userId != "" {
+		return "user:" + userId
+	}
+	return "ip:" + ClientIP(ctx)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"strconv"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	--------------- HTTP GET /admin/stats Section ---------------
+*	1 - Get time window from query (days)
+*	2 - Run grouped aggregate queries
+*	3 - Return response (page is cached for a minute in main)
+*/
+
+// DailyCount is a single point of a per-day time-series
+type DailyCount struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// AdminStats is the dashboard payload returned by /admin/stats
+type AdminStats struct {
+	TotalPosts  int64        `json:"total_posts"`
+	PostsToday  int64        `json:"posts_today"`
+	PostsPerDay []DailyCount `json:"posts_per_day"`
+	Days        int          `json:"days"`
+	GeneratedAt time.Time    `json:"generated_at"`
+}
+
+/**
+*	sqlDayExpr : returns a dialect aware expression that truncates
+*	a timestamp column to its UTC day (YYYY-MM-DD) for grouping, Postgres
+*	would use the session timezone otherwise
+*/
+func sqlDayExpr(column string) string {
+	if db.Dialector.Name() == "sqlite" {
+		return "strftime('%Y-%m-%d', " + column + ")"
+	}
+	return "to_char(" + column + " AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+}
+
+// AdminStatsHandler godoc
+// @Summary Returns aggregate dashboard stats
+// @Schemes
+// @Description Returns aggregate counts and per-day time-series for admin dashboards. Cached for a minute.
+// @Tags admin
+// @Security BasicAuth
+// @Param days query int false "time-series window in days (1-90)"
+// @Accept */*
+// @Produce json
+// @Success 200 {object} object{stats=AdminStats}
+// @Failure 401 {object} object
+// @Failure 500 {object} object
+// @Router /admin/stats [get]
+func AdminStatsHandler(ctx *gin.Context) {
+	// get window param should be 1<=days<=90
+	days, err := strconv.Atoi(ctx.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 90 {
+		days = 30
+	}
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	stats := AdminStats{
+		Days:        days,
+		GeneratedAt: now,
+		PostsPerDay: []DailyCount{},
+	}
+
+	// total and today counts
+	if err := db.Model(&Post{}).Count(&stats.TotalPosts).Error; err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeAdminStatsQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := db.Model(&Post{}).Where("created_at >= ?", today).Count(&stats.PostsToday).Error; err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeAdminStatsQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// posts per day in a single grouped query
+	dayExpr := sqlDayExpr("created_at")
+	err = db.Model(&Post{}).
+		Select(dayExpr+" AS day, COUNT(*) AS count").
+		Where("created_at >= ?", since).
+		Group(dayExpr).
+		Order("day").
+		Scan(&stats.PostsPerDay).Error
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeAdminStatsQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"stats": stats,
+	})
+}
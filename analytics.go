@@ -0,0 +1,239 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	Analytics Ingestion
+*	POST /analytics/events takes batches of client events (screen views,
+*	post impressions) validated by AnalyticsEventDto tags. Accepted events
+*	are buffered in memory and flushed to NATS subject SubjectAnalyticsCollected as
+*	one JSON array every ANALYTICS_FLUSH_INTERVAL (5s) or when
+*	ANALYTICS_FLUSH_SIZE (500) events are waiting. When CLICKHOUSE_URL is set
+*	a worker (SubscribeWorker, retries and dead letters) inserts batches into
+*	CLICKHOUSE_TABLE over ClickHouse HTTP interface as JSONEachRow.
+*	Batches are rate limited per write key (X-Analytics-Key, one of
+*	ANALYTICS_KEYS) or per actor when no keys are configured, with policy
+*	rule analytics.batch.
+*/
+
+var analyticsEvents = NewCounter(
+	"analytics_events_total",
+	"Accepted analytics events by type.",
+	"type",
+)
+
+// AnalyticsEventDto : one client event
+type AnalyticsEventDto struct {
+	Type string `json:"type" validate:"required,oneof=screen_view post_impression" example:"post_impression"`
+	// Screen : required for screen_view
+	Screen string `json:"screen" validate:"required_if=Type screen_view,max=64" example:"home"`
+	// PostID : public post id, required for post_impression
+	PostID    string `json:"post_id" validate:"required_if=Type post_impression,max=36" example:"01a145c6-8c16-7129-a139-1c29724a6411"`
+	SessionID string `json:"session_id" validate:"max=64"`
+	Referrer  string `json:"referrer" validate:"max=255"`
+	// Timestamp : client time of event, defaults to receive time
+	Timestamp  *time.Time        `json:"timestamp"`
+	Properties map[string]string `json:"properties" validate:"max=20,dive,keys,max=64,endkeys,max=255"`
+}
+
+type AnalyticsBatchDto struct {
+	Events []AnalyticsEventDto `json:"events" validate:"required,min=1,max=100,dive"`
+}
+
+// analyticsRow : stored shape of event with server side fields
+type analyticsRow struct {
+	AnalyticsEventDto
+	// DeviceInfo : parsed user agent (device, os, browser) instead of raw string
+	DeviceInfo
+	Actor      string    `json:"actor"`
+	ClientIP   string    `json:"client_ip"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type AnalyticsBuffer struct {
+	mu        sync.Mutex
+	rows      []analyticsRow
+	flushSize int
+}
+
+var analyticsBuffer *AnalyticsBuffer
+
+// StartAnalyticsBuffer : flushes buffered events periodically
+func StartAnalyticsBuffer() *AnalyticsBuffer {
+	buffer := &AnalyticsBuffer{flushSize: int(getEnvInt64("ANALYTICS_FLUSH_SIZE", 500))}
+	interval := getEnvDuration("ANALYTICS_FLUSH_INTERVAL", 5*time.Second)
+	go func() {
+		for range time.Tick(interval) {
+			buffer.Flush()
+		}
+	}()
+	// events accepted by last requests are published before nats drains
+	OnShutdown("analytics buffer", func(ctx context.Context) { buffer.Flush() })
+	return buffer
+}
+
+func (b *AnalyticsBuffer) Add(rows []analyticsRow) {
+	b.mu.Lock()
+	b.rows = append(b.rows, rows...)
+	full := len(b.rows) >= b.flushSize
+	b.mu.Unlock()
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush : publishes waiting events as one batch, events are kept when publish fails
+func (b *AnalyticsBuffer) Flush() {
+	b.mu.Lock()
+	rows := b.rows
+	b.rows = nil
+	b.mu.Unlock()
+	if len(rows) == 0 {
+		return
+	}
+	payload, err := json.Marshal(rows)
+	if err == nil {
+		err = PublishEvent(SubjectAnalyticsCollected, payload)
+	}
+	if err != nil {
+		b.mu.Lock()
+		// keep at most 10 flushes worth of events while NATS is down
+		if len(b.rows)+len(rows) <= b.flushSize*10 {
+			b.rows = append(rows, b.rows...)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// StartAnalyticsSink : inserts analytics batches into ClickHouse, nil when CLICKHOUSE_URL is empty
+func StartAnalyticsSink() (*nats.Subscription, error) {
+	clickhouseUrl := os.Getenv("CLICKHOUSE_URL")
+	if clickhouseUrl == "" {
+		return nil, nil
+	}
+	table := os.Getenv("CLICKHOUSE_TABLE")
+	if table == "" {
+		table = "analytics_events"
+	}
+	insertUrl := strings.TrimRight(clickhouseUrl, "/") + "/?query=" + strings.ReplaceAll("INSERT INTO "+table+" FORMAT JSONEachRow", " ", "%20") +
+		// rows gain fields before table columns do
+		"&input_format_skip_unknown_fields=1"
+	client := NewHttpClient(10 * time.Second)
+	return SubscribeWorker(SubjectAnalyticsCollected, "analytics-sink", func(msg *nats.Msg) error {
+		var rows []json.RawMessage
+		if err := json.Unmarshal(msg.Data, &rows); err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		for _, row := range rows {
+			body.Write(row)
+			body.WriteByte('\n')
+		}
+		req, err := http.NewRequest(http.MethodPost, insertUrl, &body)
+		if err != nil {
+			return err
+		}
+		if user := os.Getenv("CLICKHOUSE_USER"); user != "" {
+			req.SetBasicAuth(user, os.Getenv("CLICKHOUSE_PASSWORD"))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New("clickhouse insert failed with status " + strconv.Itoa(resp.StatusCode))
+		}
+		return nil
+	})
+}
+
+// analyticsKey : rate limit key of request, false when key is required and missing or unknown
+func analyticsKey(ctx *gin.Context) (string, bool) {
+	keys := splitList(os.Getenv("ANALYTICS_KEYS"))
+	if len(keys) == 0 {
+		return ActorID(ctx), true
+	}
+	key := ctx.GetHeader("X-Analytics-Key")
+	for _, allowed := range keys {
+		if key != "" && key == allowed {
+			return "key:" + key, true
+		}
+	}
+	return "", false
+}
+
+// IngestAnalyticsHandler godoc
+// @Summary Ingest analytics events
+// @Schemes
+// @Description Batch of up to 100 client events, buffered and forwarded to analytics pipeline
+// @Tags analytics
+// @Param X-Analytics-Key header string false "write key when ANALYTICS_KEYS is set"
+// @Param body body AnalyticsBatchDto true "events"
+// @Accept application/json
+// @Produce json
+// @Success 202 {object} object
+// @Failure 400 {object} object
+// @Failure 401 {object} object
+// @Failure 429 {object} object
+// @Router /analytics/events [post]
+func IngestAnalyticsHandler(ctx *gin.Context) {
+	key, ok := analyticsKey(ctx)
+	if !ok {
+		WriteJSON(ctx, http.StatusUnauthorized, gin.H{
+			"status":  false,
+			"type":    ErrCodeAnalyticsKey,
+			"message": "Missing or unknown X-Analytics-Key header.",
+		})
+		return
+	}
+	if err := policies.AllowRequest(ctx, "analytics.batch", key, false); err != nil {
+		abortQuotaExceeded(ctx, err.(*QuotaExceededError))
+		return
+	}
+	var batch AnalyticsBatchDto
+	if err := BindDto(ctx, &batch, ErrAreaAnalytics); err != nil {
+		return
+	}
+
+	now := utcNow()
+	rows := make([]analyticsRow, 0, len(batch.Events))
+	for _, event := range batch.Events {
+		// client clocks drift, events from the future are stamped with receive time
+		if event.Timestamp == nil || event.Timestamp.After(now.Add(time.Minute)) {
+			event.Timestamp = &now
+		}
+		timestamp := ToUTC(*event.Timestamp)
+		event.Timestamp = &timestamp
+		rows = append(rows, analyticsRow{
+			AnalyticsEventDto: event,
+			Actor:             ActorID(ctx),
+			ClientIP:          ClientIP(ctx),
+			DeviceInfo:        Device(ctx),
+			ReceivedAt:        ToUTC(now),
+		})
+		analyticsEvents.Inc(event.Type)
+	}
+	analyticsBuffer.Add(rows)
+	WriteJSON(ctx, http.StatusAccepted, gin.H{
+		"status":   true,
+		"accepted": len(rows),
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// page cacher (used as counter store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+)
+
+/**
+*	Anomaly Detection
+*	Signals are counted per subject (actor or ip) in fixed windows like
+*	policy rate rules, configured with ANOMALY_RULES=signal:threshold/window.
+*	Signals observed now:
+*	- post.burst     : posts from one client ip (many accounts behind one ip)
+*	- post.duplicate : same body posted again by one actor
+*	account.create, like and follow signals are observed the same way once
+*	those modules exist. Crossing a threshold emits abuse.detected (once per
+*	window) with evidence and, when ANOMALY_CLAMP_FOR is set, clamps policy
+*	rate limits to 1/ANOMALY_CLAMP_FACTOR for that duration. Clamps are keyed
+*	by ActorID like policy rules, so every actor posting past the threshold
+*	of an ip is clamped, not the ip key authenticated users never hit.
+*/
+const defaultAnomalyRules = "post.burst:30/1m,post.duplicate:3/10m"
+
+var abuseDetected = NewCounter(
+	"abuse_detected_total",
+	"Anomaly thresholds crossed by signal.",
+	"signal",
+)
+
+type AnomalyDetector struct {
+	store       persistence.CacheStore
+	rules       map[string]PolicyRule
+	clampFor    time.Duration
+	clampFactor int64
+}
+
+var anomalies *AnomalyDetector
+
+// NewAnomalyDetector : parses ANOMALY_RULES (defaults to defaultAnomalyRules)
+func NewAnomalyDetector(store persistence.CacheStore) *AnomalyDetector {
+	config := os.Getenv("ANOMALY_RULES")
+	if config == "" {
+		config = defaultAnomalyRules
+	}
+	detector := &AnomalyDetector{
+		store:       store,
+		rules:       map[string]PolicyRule{},
+		clampFor:    getEnvDuration("ANOMALY_CLAMP_FOR", 0),
+		clampFactor: getEnvInt64("ANOMALY_CLAMP_FACTOR", 10),
+	}
+	if detector.clampFactor < 1 {
+		detector.clampFactor = 1
+	}
+	for _, item := range strings.Split(config, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		thresholdWindow := strings.SplitN(parts[1], "/", 2)
+		threshold, err := strconv.ParseInt(thresholdWindow[0], 10, 64)
+		if err != nil || len(thresholdWindow) != 2 {
+			LogWarn("Invalid anomaly rule skipping", LogFields{"rule": item})
+			continue
+		}
+		window, err := time.ParseDuration(thresholdWindow[1])
+		if err != nil || window <= 0 {
+			LogWarn("Invalid anomaly rule window skipping", LogFields{"rule": item})
+			continue
+		}
+		detector.rules[parts[0]] = PolicyRule{Name: parts[0], Limit: threshold, Window: window}
+	}
+	return detector
+}
+
+/**
+*	Observe : counts one occurrence of signal for subject, key narrows the
+*	counter (e.g. body hash for duplicates). Reports whether threshold is
+*	crossed, evidence is attached to abuse.detected event. actor is the
+*	ActorID clamped while the threshold stays crossed.
+*/
+func (d *AnomalyDetector) Observe(ctx context.Context, signal, subject, key, actor string, evidence map[string]interface{}) bool {
+	rule, ok := d.rules[signal]
+	if !ok {
+		return false
+	}
+	bucket := time.Now().UnixNano() / int64(rule.Window)
+	counterKey := "anomaly:" + signal + ":" + subject + ":" + key + ":" + strconv.FormatInt(bucket, 10)
+	count, err := IncrementCounter(d.store, counterKey, 1, rule.Window)
+	if err != nil {
+		LogWarn("Anomaly counter unavailable", LogFields{"signal": signal, "error": err})
+		return false
+	}
+	if int64(count) <= rule.Limit {
+		return false
+	}
+	clamped := d.clamp(actor)
+	// report only the crossing, later hits of the window are the same incident
+	if int64(count) == rule.Limit+1 {
+		d.report(ctx, rule, subject, int64(count), evidence, clamped)
+	}
+	return true
+}
+
+// ObservePost : signals of a created post
+func (d *AnomalyDetector) ObservePost(ctx *gin.Context, post Post) {
+	bodyHash := sha256.Sum256([]byte(post.Body))
+	actor := ActorID(ctx)
+	d.Observe(ctx.Request.Context(), "post.burst", "ip:"+ClientIP(ctx), "", actor, map[string]interface{}{"actor": actor, "post_id": post.PublicID})
+	d.Observe(ctx.Request.Context(), "post.duplicate", actor, hex.EncodeToString(bodyHash[:8]), actor, map[string]interface{}{"post_id": post.PublicID, "body": post.Body})
+}
+
+// clamp : divides policy rate limits of actor for ANOMALY_CLAMP_FOR, reports whether it is set
+func (d *AnomalyDetector) clamp(actor string) bool {
+	if d.clampFor <= 0 || actor == "" {
+		return false
+	}
+	if err := d.store.Set("anomaly_clamp:"+actor, uint64(d.clampFactor), d.clampFor); err != nil {
+		LogWarn("Error clamping actor", LogFields{"actor": actor, "error": err})
+		return false
+	}
+	return true
+}
+
+func (d *AnomalyDetector) report(ctx context.Context, rule PolicyRule, subject string, count int64, evidence map[string]interface{}, clamped bool) {
+	abuseDetected.Inc(rule.Name)
+	LogWarn("Abuse detected", LogFields{"signal": rule.Name, "subject": subject, "count": count, "clamped": clamped})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"signal":    rule.Name,
+		"subject":   subject,
+		"count":     count,
+		"threshold": rule.Limit,
+		"window":    rule.Window.String(),
+		"evidence":  evidence,
+		"clamped":   clamped,
+		"time":      utcNow(),
+	})
+	// request context so the event rides the request transaction when it lands in the outbox
+	PublishEventContext(ctx, SubjectAbuseDetected, payload)
+}
+
+// ClampFactor : divisor of policy rate limits for actor, 1 when not clamped
+func (d *AnomalyDetector) ClampFactor(actor string) int64 {
+	if d == nil || d.clampFor <= 0 {
+		return 1
+	}
+	var factor uint64
+	if err := d.store.Get("anomaly_clamp:"+actor, &factor); err != nil || factor < 1 {
+		return 1
+	}
+	return int64(factor)
+}
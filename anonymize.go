@@ -0,0 +1,243 @@
+package main
+
+import (
+	// system packages
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Anonymization
+*	Scrubs personal data of a copied production database before staging
+*	loads it: ./alyagofn anonymize --yes [--salt ...] (refused in prod).
+*	Each rule of anonymizeRules rewrites a column by distinct value, so
+*	a value gets the same fake in every table (user ids of tos_acceptances
+*	match actors of post_viewers) and rows stay joinable.
+*	Fakes are HMAC-SHA256 of ANONYMIZE_SALT (random per run when empty),
+*	the same salt gives the same fakes on the next refresh. Free text
+*	(post bodies, translations) gets emails and IPv4 addresses replaced,
+*	event payloads of dead letters, outbox and import jobs are dropped.
+*	Rules run in one transaction, delete and clear rules first, and fakes
+*	are cut to Size (characters of the column, 0 is unlimited).
+*	Emails, names and direct messages are added here with their models.
+*/
+type AnonymizeRule struct {
+	Table  string
+	Column string
+	Kind   string
+	Size   int
+}
+
+const (
+	AnonUser   = "user"   // user id
+	AnonIP     = "ip"     // IPv4 or IPv6 address
+	AnonActor  = "actor"  // user:<id> or ip:<address> of ActorID
+	AnonText   = "text"   // emails and IPv4 addresses inside text
+	AnonClear  = "clear"  // column set to NULL
+	AnonDelete = "delete" // all rows deleted
+)
+
+var anonymizeRules = []AnonymizeRule{
+	{Table: "tos_acceptances", Column: "user_id", Kind: AnonUser, Size: 128},
+	{Table: "tos_acceptances", Column: "client_ip", Kind: AnonIP, Size: 64},
+	{Table: "post_viewers", Column: "viewer", Kind: AnonActor, Size: 128},
+	{Table: "posts", Column: "body", Kind: AnonText, Size: 255},
+	{Table: "archived_posts", Column: "body", Kind: AnonText, Size: 255},
+	{Table: "post_translations", Column: "body", Kind: AnonText},
+	{Table: "import_jobs", Column: "payload", Kind: AnonClear},
+	{Table: "dead_letters", Kind: AnonDelete},
+	{Table: "event_outbox", Kind: AnonDelete},
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	ipv4Pattern  = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+)
+
+type Anonymizer struct {
+	salt []byte
+}
+
+type AnonymizeReport struct {
+	Rule AnonymizeRule
+	Rows int64
+}
+
+func init() {
+	registerCliCommand("anonymize", CliCommand{
+		Usage: "replace personal data of a copied database with consistent fakes (--yes --salt)",
+		Run:   runAnonymizeCommand,
+	})
+}
+
+func (a Anonymizer) digest(kind, value string) []byte {
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(kind + ":" + value))
+	return mac.Sum(nil)
+}
+
+// User : fake user id like anon-3f2a...
+func (a Anonymizer) User(userId string) string {
+	return "anon-" + hex.EncodeToString(a.digest(AnonUser, userId)[:8])
+}
+
+// IP : fake address of the same family, IPv4 in 10.0.0.0/8 and IPv6 in fd00::/8
+func (a Anonymizer) IP(address string) string {
+	sum := a.digest(AnonIP, address)
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		fake := make(net.IP, net.IPv6len)
+		fake[0] = 0xfd
+		copy(fake[1:], sum[:15])
+		return fake.String()
+	}
+	return net.IPv4(10, sum[0], sum[1], sum[2]).String()
+}
+
+// Email : fake address of reserved domain example.invalid
+func (a Anonymizer) Email(email string) string {
+	return "anon-" + hex.EncodeToString(a.digest("email", strings.ToLower(email))[:6]) + "@example.invalid"
+}
+
+// Actor : ActorID with its user id or ip replaced
+func (a Anonymizer) Actor(actor string) string {
+	switch {
+	case strings.HasPrefix(actor, "user:"):
+		return "user:" + a.User(strings.TrimPrefix(actor, "user:"))
+	case strings.HasPrefix(actor, "ip:"):
+		return "ip:" + a.IP(strings.TrimPrefix(actor, "ip:"))
+	case strings.HasPrefix(actor, "service:"):
+		// names of internal callers are config, not personal data
+		return actor
+	}
+	return a.User(actor)
+}
+
+// Text : text with emails and IPv4 addresses replaced
+func (a Anonymizer) Text(text string) string {
+	text = emailPattern.ReplaceAllStringFunc(text, a.Email)
+	return ipv4Pattern.ReplaceAllStringFunc(text, a.IP)
+}
+
+func (a Anonymizer) replace(kind, value string) string {
+	switch kind {
+	case AnonUser:
+		return a.User(value)
+	case AnonIP:
+		return a.IP(value)
+	case AnonActor:
+		return a.Actor(value)
+	}
+	return a.Text(value)
+}
+
+// Apply : rewrites column of rule by distinct value with database and returns rows changed
+func (a Anonymizer) Apply(database *gorm.DB, rule AnonymizeRule) (int64, error) {
+	switch rule.Kind {
+	case AnonDelete:
+		result := database.Exec("DELETE FROM " + rule.Table)
+		return result.RowsAffected, result.Error
+	case AnonClear:
+		result := database.Exec("UPDATE " + rule.Table + " SET " + rule.Column + " = NULL WHERE " + rule.Column + " IS NOT NULL")
+		return result.RowsAffected, result.Error
+	}
+	query := "SELECT DISTINCT " + rule.Column + " FROM " + rule.Table + " WHERE " + rule.Column + " <> ''"
+	if rule.Kind == AnonText {
+		// only text that may hold an email or address
+		query += " AND (" + rule.Column + " LIKE '%@%' OR " + rule.Column + " LIKE '%.%.%.%')"
+	}
+	var values []string
+	if err := database.Raw(query).Scan(&values).Error; err != nil {
+		return 0, err
+	}
+	var rows int64
+	for _, value := range values {
+		fake := a.replace(rule.Kind, value)
+		// replaced emails are longer than the originals
+		if runes := []rune(fake); rule.Size > 0 && len(runes) > rule.Size {
+			fake = string(runes[:rule.Size])
+		}
+		if fake == value {
+			continue
+		}
+		result := database.Exec("UPDATE "+rule.Table+" SET "+rule.Column+" = ? WHERE "+rule.Column+" = ?", fake, value)
+		if result.Error != nil {
+			return rows, fmt.Errorf("%s.%s: %w", rule.Table, rule.Column, result.Error)
+		}
+		rows += result.RowsAffected
+	}
+	return rows, nil
+}
+
+// Anonymize : applies every rule whose table exists in one transaction, delete and clear rules first
+func (a Anonymizer) Anonymize() ([]AnonymizeReport, error) {
+	reports := []AnonymizeReport{}
+	ordered := []AnonymizeRule{}
+	for _, rule := range anonymizeRules {
+		if rule.Kind == AnonDelete || rule.Kind == AnonClear {
+			ordered = append(ordered, rule)
+		}
+	}
+	for _, rule := range anonymizeRules {
+		if rule.Kind != AnonDelete && rule.Kind != AnonClear {
+			ordered = append(ordered, rule)
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, rule := range ordered {
+			if !tx.Migrator().HasTable(rule.Table) {
+				continue
+			}
+			rows, err := a.Apply(tx, rule)
+			reports = append(reports, AnonymizeReport{Rule: rule, Rows: rows})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return reports, err
+}
+
+func runAnonymizeCommand(args []string) error {
+	flags := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	salt := flags.String("salt", os.Getenv("ANONYMIZE_SALT"), "secret of fakes, same salt gives same fakes (ANONYMIZE_SALT, random when empty)")
+	yes := flags.Bool("yes", false, "confirm that personal data of the current database is overwritten")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if appEnv.Name == "prod" {
+		return errors.New("anonymize is refused with APP_ENV=prod, run it on a copy with APP_ENV=staging")
+	}
+	if !*yes {
+		return errors.New("anonymize overwrites personal data of the current database, run again with --yes")
+	}
+	if *salt == "" {
+		*salt = randomHex(16)
+	}
+	if err := initCliDatabase(); err != nil {
+		return err
+	}
+	reports, err := Anonymizer{salt: []byte(*salt)}.Anonymize()
+	for _, report := range reports {
+		target := report.Rule.Table
+		if report.Rule.Column != "" {
+			target += "." + report.Rule.Column
+		}
+		fmt.Printf("%s (%s): %d rows\n", target, report.Rule.Kind, report.Rows)
+	}
+	if err != nil {
+		return fmt.Errorf("rolled back, no rows were changed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Post Archival
+*	Posts created more than ARCHIVE_AFTER_MONTHS months ago are moved from
+*	posts to archived_posts (same columns plus archived_at) in batches of
+*	ARCHIVE_BATCH so the hot table and its indexes stay small. The archive
+*	row keeps public_id unique, it is the tombstone resolving /post/:id:
+*	FindPostByPublicID rehydrates an archived post (and its original) back
+*	into posts on a miss, rehydrated posts are archived again on a later
+*	run when they are still old. Originals of hot reposts stay hot so lists
+*	can embed them. Lists and exports only read hot posts.
+*	ARCHIVE_INTERVAL (e.g. 24h, empty disables) runs archival on the
+*	leader (see scheduler.go), manual runs:
+*	./alyagofn archive run [--months 12 --batch 500 --dry-run]
+*/
+type ArchivedPost struct {
+	ID            uint           `gorm:"column:id;primaryKey;autoIncrement:false"`
+	CreatedAt     time.Time      `gorm:"column:created_at;index"`
+	UpdatedAt     time.Time      `gorm:"column:updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"column:deleted_at"`
+	PublicID      string         `gorm:"column:public_id;size:36;uniqueIndex"`
+	Body          string         `gorm:"column:body;size:255;not null"`
+	ExternalID    *string        `gorm:"column:external_id;size:64;index"`
+	Visibility    string         `gorm:"column:visibility;size:16;not null"`
+	Kind          string         `gorm:"column:kind;size:8;not null"`
+	OriginalID    *uint          `gorm:"column:original_id;index"`
+	RepostedCount int            `gorm:"column:reposted_count;not null;default:0"`
+	Sensitive     bool           `gorm:"column:is_sensitive;not null;default:false"`
+	SpamStatus    string         `gorm:"column:spam_status;size:8;not null"`
+	SpamScore     float64        `gorm:"column:spam_score;not null;default:0"`
+	ArchivedAt    time.Time      `gorm:"column:archived_at;not null"`
+}
+
+// archivedPostColumns : columns copied between posts and archived_posts
+const archivedPostColumns = "id, created_at, updated_at, deleted_at, public_id, body, external_id, visibility, kind, original_id, reposted_count, is_sensitive, spam_status, spam_score"
+
+var postsArchived = NewCounter(
+	"posts_archived_total",
+	"Posts moved to archived_posts.",
+)
+
+var postsRehydrated = NewCounter(
+	"posts_rehydrated_total",
+	"Archived posts moved back to posts on request.",
+)
+
+type ArchivePolicy struct {
+	Months int
+	Batch  int
+}
+
+func init() {
+	registerCliCommand("archive run", CliCommand{
+		Usage: "move posts older than months to archived_posts (--months --batch --dry-run)",
+		Run:   runArchiveCommand,
+	})
+}
+
+// LoadArchivePolicy : policy of ARCHIVE_AFTER_MONTHS and ARCHIVE_BATCH, zero months disables archival
+func LoadArchivePolicy() ArchivePolicy {
+	return ArchivePolicy{
+		Months: int(getEnvInt64("ARCHIVE_AFTER_MONTHS", 0)),
+		Batch:  int(getEnvInt64("ARCHIVE_BATCH", 500)),
+	}
+}
+
+// archivableIds : next batch of old posts no hot repost or quote points to
+func (policy ArchivePolicy) archivableIds() ([]uint, error) {
+	cutoff := utcNow().AddDate(0, -policy.Months, 0)
+	var ids []uint
+	err := db.Raw(
+		"SELECT p.id FROM posts p WHERE p.created_at < ?"+
+			" AND NOT EXISTS (SELECT 1 FROM posts r WHERE r.original_id = p.id)"+
+			" ORDER BY p.id LIMIT ?",
+		cutoff, policy.Batch,
+	).Scan(&ids).Error
+	return ids, err
+}
+
+// ArchivePosts : moves archivable posts batch by batch, returns posts moved, stops before next batch when ctx is done
+func ArchivePosts(ctx context.Context, policy ArchivePolicy) (int, error) {
+	if policy.Months <= 0 || policy.Batch <= 0 {
+		return 0, errors.New("archive months and batch must be positive")
+	}
+	archived := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return archived, err
+		}
+		ids, err := policy.archivableIds()
+		if err != nil || len(ids) == 0 {
+			return archived, err
+		}
+		var publicIds []string
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Raw("SELECT public_id FROM posts WHERE id IN ?", ids).Scan(&publicIds).Error; err != nil {
+				return err
+			}
+			err := tx.Exec(
+				"INSERT INTO archived_posts ("+archivedPostColumns+", archived_at) SELECT "+archivedPostColumns+", ? FROM posts WHERE id IN ?",
+				utcNow(), ids,
+			).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM posts WHERE id IN ?", ids).Error
+		})
+		if err != nil {
+			return archived, err
+		}
+		archived += len(ids)
+		postsArchived.Add(float64(len(ids)))
+		invalidateArchivedPosts(publicIds)
+	}
+}
+
+// CountArchivablePosts : posts archivable now (dry run), originals of their reposts follow in later batches
+func CountArchivablePosts(policy ArchivePolicy) (int64, error) {
+	var count int64
+	err := db.Raw(
+		"SELECT COUNT(*) FROM posts p WHERE p.created_at < ?"+
+			" AND NOT EXISTS (SELECT 1 FROM posts r WHERE r.original_id = p.id)",
+		utcNow().AddDate(0, -policy.Months, 0),
+	).Scan(&count).Error
+	return count, err
+}
+
+func invalidateArchivedPosts(publicIds []string) {
+	if entityCache != nil {
+		for _, publicId := range publicIds {
+			entityCache.Invalidate("post", publicId)
+		}
+	}
+	if responseCache != nil {
+		responseCache.Invalidate(PostListCache.Group)
+	}
+}
+
+// RehydratePost : moves archived post of publicId and its originals back to posts,
+// gorm.ErrRecordNotFound when it was never archived
+func RehydratePost(publicId string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		archived := ArchivedPost{}
+		if err := tx.Unscoped().Where("public_id = ?", publicId).First(&archived).Error; err != nil {
+			return err
+		}
+		ids := []uint{archived.ID}
+		// reposts and quotes are shown with their original
+		for original := archived.OriginalID; original != nil; {
+			parent := ArchivedPost{}
+			err := tx.Unscoped().Where("id = ?", *original).Limit(1).Find(&parent).Error
+			if err != nil {
+				return err
+			}
+			if parent.ID == 0 {
+				break
+			}
+			ids = append(ids, parent.ID)
+			original = parent.OriginalID
+		}
+		err := tx.Exec(
+			"INSERT INTO posts ("+archivedPostColumns+") SELECT "+archivedPostColumns+" FROM archived_posts WHERE id IN ?",
+			ids,
+		).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM archived_posts WHERE id IN ?", ids).Error; err != nil {
+			return err
+		}
+		postsRehydrated.Add(float64(len(ids)))
+		LogInfo("Archived post rehydrated", LogFields{"public_id": publicId, "posts": len(ids)})
+		return nil
+	})
+}
+
+// StartArchiveSchedule : runs ArchivePosts every ARCHIVE_INTERVAL, empty interval or months disables it
+func StartArchiveSchedule() {
+	interval := getEnvDuration("ARCHIVE_INTERVAL", 0)
+	policy := LoadArchivePolicy()
+	if interval <= 0 || policy.Months <= 0 {
+		return
+	}
+	RunScheduled("archive", interval, func(ctx context.Context) error {
+		started := time.Now()
+		archived, err := ArchivePosts(ctx, policy)
+		if err != nil {
+			LogError("Post archival failed", LogFields{"archived": archived, "error": err})
+			return err
+		}
+		LogInfo("Posts archived", LogFields{"archived": archived, "duration_ms": time.Since(started).Milliseconds()})
+		return nil
+	})
+}
+
+func runArchiveCommand(args []string) error {
+	policy := LoadArchivePolicy()
+	flags := flag.NewFlagSet("archive run", flag.ContinueOnError)
+	flags.IntVar(&policy.Months, "months", policy.Months, "archive posts created more than months ago (ARCHIVE_AFTER_MONTHS)")
+	flags.IntVar(&policy.Batch, "batch", policy.Batch, "posts moved per transaction (ARCHIVE_BATCH)")
+	dryRun := flags.Bool("dry-run", false, "only count posts that would be archived")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if policy.Months <= 0 {
+		return errors.New("--months or ARCHIVE_AFTER_MONTHS is required")
+	}
+	if err := initCliDatabase(); err != nil {
+		return err
+	}
+	if *dryRun {
+		count, err := CountArchivablePosts(policy)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d posts older than %d months would be archived\n", count, policy.Months)
+		return nil
+	}
+	archived, err := ArchivePosts(context.Background(), policy)
+	fmt.Printf("%d posts archived\n", archived)
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	// system packages
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Antivirus Scanning
+*	AV_SCANNER selects scanner of upload pipeline, empty disables scanning:
+*	- clamav : clamd INSTREAM over tcp at CLAMAV_ADDR (default localhost:3310)
+*	  (Docs: https://docs.clamav.net/manual/Usage/Scanning.html#clamd)
+*	- http   : POST file to AV_SCAN_URL, expects {"infected":bool,"signature":"..."}
+*	Infected files are rejected with 422 and upload.rejected event, scanner
+*	errors fail closed with 503 so unscanned files never reach storage.
+*	No route uses UploadScanMiddleware yet, upload storage and handler are
+*	missing (README TODO), the upload group of module_post.go is commented.
+*/
+type ScanResult struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature"`
+}
+
+type VirusScanner interface {
+	Scan(reader io.Reader) (ScanResult, error)
+}
+
+var virusScanner VirusScanner
+
+// InitVirusScanner : scanner from env, nil when scanning is disabled
+func InitVirusScanner() (VirusScanner, error) {
+	switch os.Getenv("AV_SCANNER") {
+	case "":
+		return nil, nil
+	case "clamav":
+		addr := os.Getenv("CLAMAV_ADDR")
+		if addr == "" {
+			addr = "localhost:3310"
+		}
+		return ClamAVScanner{Addr: addr, Timeout: getEnvDuration("AV_SCAN_TIMEOUT", 30*time.Second)}, nil
+	case "http":
+		if os.Getenv("AV_SCAN_URL") == "" {
+			return nil, errors.New("AV_SCAN_URL is required for AV_SCANNER=http")
+		}
+		return HttpScanner{Url: os.Getenv("AV_SCAN_URL"), Client: NewHttpClient(getEnvDuration("AV_SCAN_TIMEOUT", 30*time.Second))}, nil
+	default:
+		return nil, errors.New("unknown AV_SCANNER: " + os.Getenv("AV_SCANNER"))
+	}
+}
+
+/**
+*	ClamAVScanner : streams file to clamd with INSTREAM command
+*/
+type ClamAVScanner struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func (s ClamAVScanner) Scan(reader io.Reader) (ScanResult, error) {
+	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+	chunk := make([]byte, 32*1024)
+	size := make([]byte, 4)
+	for {
+		n, readErr := reader.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(append(size, chunk[:n]...)); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	// zero length chunk ends stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	// replies: "stream: OK", "stream: <signature> FOUND", "INSTREAM size limit exceeded. ERROR"
+	answer := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+	switch {
+	case strings.HasSuffix(answer, " OK"):
+		return ScanResult{}, nil
+	case strings.HasSuffix(answer, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(answer, "stream: "), " FOUND")
+		return ScanResult{Infected: true, Signature: signature}, nil
+	}
+	return ScanResult{}, errors.New("clamav: " + answer)
+}
+
+/**
+*	HttpScanner : external scanning api
+*/
+type HttpScanner struct {
+	Url    string
+	Client *http.Client
+}
+
+func (s HttpScanner) Scan(reader io.Reader) (ScanResult, error) {
+	res, err := s.Client.Post(s.Url, "application/octet-stream", reader)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ScanResult{}, errors.New("av scan api: unexpected status " + res.Status)
+	}
+	var result ScanResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return ScanResult{}, err
+	}
+	return result, nil
+}
+
+/**
+*	UploadScanMiddleware : scans every file of multipart request before
+*	handler runs. Handlers should keep files quarantined (not public)
+*	until this middleware passed. No-op when scanner is nil.
+*/
+func UploadScanMiddleware(scanner VirusScanner) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if scanner == nil {
+			ctx.Next()
+			return
+		}
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			if isBodyTooLarge(err) {
+				abortBodyTooLarge(ctx, getEnvInt64("APP_MAX_UPLOAD_BODY", defaultMaxUploadBody))
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"status":  false,
+				"type":    ErrCodeUploadRequestBody,
+				"message": err.Error(),
+			})
+			return
+		}
+		for field, files := range form.File {
+			for _, header := range files {
+				file, err := header.Open()
+				if err != nil {
+					ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+						"status":  false,
+						"type":    ErrCodeUploadRequestBody,
+						"message": err.Error(),
+					})
+					return
+				}
+				result, err := scanner.Scan(file)
+				file.Close()
+				if err != nil {
+					LogError("Upload scan failed", LogFields{"file": header.Filename, "error": err, "request_id": RequestID(ctx)})
+					ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+						"status":  false,
+						"type":    ErrCodeUploadScanUnavailable,
+						"message": "File could not be scanned, please retry later.",
+					})
+					return
+				}
+				if result.Infected {
+					LogWarn("Infected upload rejected", LogFields{"file": header.Filename, "signature": result.Signature, "actor": ActorID(ctx)})
+					event, _ := json.Marshal(gin.H{
+						"field":     field,
+						"filename":  header.Filename,
+						"size":      header.Size,
+						"signature": result.Signature,
+						"actor":     ActorID(ctx),
+					})
+					PublishEventContext(ctx.Request.Context(), SubjectUploadRejected, event)
+					ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+						"status":  false,
+						"type":    ErrCodeUploadInfected,
+						"message": "File " + header.Filename + " was rejected by malware scan.",
+					})
+					return
+				}
+			}
+		}
+		ctx.Next()
+	}
+}
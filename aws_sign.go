@@ -0,0 +1,90 @@
+package main
+
+import (
+	// system packages
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+*	AWS Signature Version 4 for api calls (Secrets Manager, SES, S3)
+*	(Docs: https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html)
+*	Signs content-type, host, x-amz-date, x-amz-target and
+*	x-amz-content-sha256 (when set, S3 requires it) headers and query string.
+*/
+type AwsCredentials struct {
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+func (c AwsCredentials) Sign(req *http.Request, service string, payload []byte, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	c.SignHash(req, service, hex.EncodeToString(payloadHash[:]), now)
+}
+
+// SignHash : Sign with hex sha256 of payload, for bodies streamed from disk
+func (c AwsCredentials) SignHash(req *http.Request, service string, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         req.URL.Host,
+		"x-amz-date":   amzDate,
+	}
+	if target := req.Header.Get("X-Amz-Target"); target != "" {
+		headers["x-amz-target"] = target
+	}
+	if contentHash := req.Header.Get("X-Amz-Content-Sha256"); contentHash != "" {
+		headers["x-amz-content-sha256"] = contentHash
+	}
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+		headers["x-amz-security-token"] = c.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	// Encode sorts by key, sigv4 wants %20 for spaces
+	query := strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+	canonicalRequest := req.Method + "\n" + path + "\n" + query + "\n" + canonicalHeaders + "\n" + signedHeaders + "\n" + payloadHash
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + c.Region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSha256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSha256(key, c.Region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+*	Database Backup and Restore
+*	./alyagofn backup [--dir backups]
+*	./alyagofn restore --file backups/backup-20220101-030000.dump --yes
+*	Postgres is dumped with pg_dump custom format (.dump) and restored with
+*	pg_restore --clean, sqlite (DEV_MODE) is snapshotted with VACUUM INTO
+*	(.db) and restored by copying the file back (stop the server first).
+*	With BACKUP_S3_BUCKET set backups are uploaded to
+*	<bucket>/<BACKUP_S3_PREFIX><name> with AWS_* credentials
+*	(BACKUP_S3_ENDPOINT for S3 compatible stores like minio) and restore
+*	accepts --file s3://<name>. Uploads stream from disk, files larger than
+*	BACKUP_S3_PART_SIZE (default 64MiB) go as multipart upload. The database
+*	password reaches pg_dump/pg_restore as PGPASSWORD, not in argv.
+*	BACKUP_RETENTION keeps newest N backups
+*	locally and in bucket (default 7, 0 keeps all). BACKUP_INTERVAL (e.g.
+*	24h, empty disables) runs backups inside the server, set it on one
+*	instance only. Runs are counted in backup_runs_total{result}.
+*/
+const backupTimeFormat = "20060102-150405"
+
+var backupRuns = NewCounter(
+	"backup_runs_total",
+	"Database backups by result (success, failure).",
+	"result",
+)
+
+var backupLastSuccess = NewGauge(
+	"backup_last_success_timestamp_seconds",
+	"Unix time of last successful database backup.",
+)
+
+type BackupConfig struct {
+	Dir       string
+	Retention int
+	Bucket    string
+	Prefix    string
+	Endpoint  string
+	PartSize  int64
+	S3        AwsCredentials
+}
+
+func init() {
+	registerCliCommand("backup", CliCommand{
+		Usage: "dump database to BACKUP_DIR and upload to BACKUP_S3_BUCKET (--dir)",
+		Run:   runBackupCommand,
+	})
+	registerCliCommand("restore", CliCommand{
+		Usage: "restore database from backup file or s3://<name> (--file --yes)",
+		Run:   runRestoreCommand,
+	})
+}
+
+func LoadBackupConfig() BackupConfig {
+	config := BackupConfig{
+		Dir:       os.Getenv("BACKUP_DIR"),
+		Retention: int(getEnvInt64("BACKUP_RETENTION", 7)),
+		Bucket:    os.Getenv("BACKUP_S3_BUCKET"),
+		Prefix:    os.Getenv("BACKUP_S3_PREFIX"),
+		Endpoint:  strings.TrimSuffix(os.Getenv("BACKUP_S3_ENDPOINT"), "/"),
+		// s3 parts are at least 5MiB except the last one
+		PartSize: getEnvInt64("BACKUP_S3_PART_SIZE", 64<<20),
+	}
+	if config.PartSize < 5<<20 {
+		config.PartSize = 5 << 20
+	}
+	if config.Dir == "" {
+		config.Dir = "backups"
+	}
+	if config.Bucket != "" {
+		config.S3 = AwsCredentials{
+			Region:       os.Getenv("AWS_REGION"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    secrets.Get("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return config
+}
+
+// initCliSecrets : secrets provider for commands that run without server
+func initCliSecrets() error {
+	var err error
+	secrets, err = NewSecretStore()
+	return err
+}
+
+// initCliDatabase : secrets and db connection for commands that run without server
+func initCliDatabase() error {
+	if err := initCliSecrets(); err != nil {
+		return err
+	}
+	if db == nil {
+		if !devMode() && secrets.Get("DB_CONN_STRING") == "" {
+			return errors.New("DB_CONN_STRING is not defined")
+		}
+		InitDbConnection(secrets.Get("DB_CONN_STRING"))
+	}
+	return nil
+}
+
+func runBackupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := flags.String("dir", "", "local backup directory (BACKUP_DIR, default backups)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := initCliDatabase(); err != nil {
+		return err
+	}
+	config := LoadBackupConfig()
+	if *dir != "" {
+		config.Dir = *dir
+	}
+	path, err := RunBackup(context.Background(), config)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Backup written to", path)
+	return nil
+}
+
+func runRestoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	file := flags.String("file", "", "backup file path or s3://<name> in BACKUP_S3_BUCKET")
+	yes := flags.Bool("yes", false, "confirm that current database is overwritten")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		flags.Usage()
+		return errors.New("--file is required")
+	}
+	if !*yes {
+		return errors.New("restore overwrites the current database, run again with --yes")
+	}
+	// restore does not open the database, sqlite file is replaced underneath
+	if err := initCliSecrets(); err != nil {
+		return err
+	}
+	return RunRestore(LoadBackupConfig(), *file)
+}
+
+// RunBackup : writes a backup file, uploads it when bucket is set and prunes old backups, stops when ctx is done
+func RunBackup(ctx context.Context, config BackupConfig) (string, error) {
+	if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+		return "", err
+	}
+	name := "backup-" + time.Now().UTC().Format(backupTimeFormat)
+	var path string
+	if sqliteEnabled {
+		path = filepath.Join(config.Dir, name+".db")
+		if err := db.WithContext(ctx).Exec("VACUUM INTO ?", path).Error; err != nil {
+			return "", err
+		}
+	} else {
+		path = filepath.Join(config.Dir, name+".dump")
+		cmd := pgCommand(ctx, "pg_dump", "--format=custom", "--no-owner", "--file="+path)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("pg_dump: %w", err)
+		}
+	}
+	if config.Bucket != "" {
+		if err := config.s3Upload(ctx, config.Prefix+filepath.Base(path), path); err != nil {
+			return path, fmt.Errorf("upload: %w", err)
+		}
+	}
+	if err := pruneBackups(config); err != nil {
+		// backup itself is done, old files are pruned on next run
+		LogWarn("Error pruning old backups", LogFields{"error": err})
+	}
+	return path, nil
+}
+
+// RunRestore : replaces current database with backup file (local path or s3://<name>)
+func RunRestore(config BackupConfig, file string) error {
+	if strings.HasPrefix(file, "s3://") {
+		if config.Bucket == "" {
+			return errors.New("BACKUP_S3_BUCKET is required to restore from s3")
+		}
+		name := strings.TrimPrefix(file, "s3://")
+		body, err := config.s3Request(http.MethodGet, config.Prefix+name, nil, nil)
+		if err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+		if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+			return err
+		}
+		file = filepath.Join(config.Dir, filepath.Base(name))
+		if err := ioutil.WriteFile(file, body, 0o640); err != nil {
+			return err
+		}
+	}
+	if strings.HasSuffix(file, ".db") {
+		if !devMode() {
+			return errors.New("sqlite backups can only be restored with DEV_MODE=true")
+		}
+		target := devSqlitePath()
+		if err := copyFile(file, target+".restore"); err != nil {
+			return err
+		}
+		// stale wal of old database would be replayed onto the restored file
+		os.Remove(target + "-wal")
+		os.Remove(target + "-shm")
+		return os.Rename(target+".restore", target)
+	}
+	cmd := pgCommand(context.Background(), "pg_restore", "--clean", "--if-exists", "--no-owner", file)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore: %w", err)
+	}
+	return nil
+}
+
+var pgPasswordPattern = regexp.MustCompile(`(^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+
+// pgCommand : pg tool with DB_CONN_STRING as --dbname, password moved to PGPASSWORD of the child env,
+// killed when ctx is done
+func pgCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	conn, password := splitPgPassword(secrets.Get("DB_CONN_STRING"))
+	cmd := exec.CommandContext(ctx, name, append([]string{"--dbname=" + conn}, args...)...)
+	cmd.Env = os.Environ()
+	if password != "" {
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+password)
+	}
+	return cmd
+}
+
+// splitPgPassword : conn string (url or key=value) without password, and the password
+func splitPgPassword(conn string) (string, string) {
+	if strings.HasPrefix(conn, "postgres://") || strings.HasPrefix(conn, "postgresql://") {
+		parsed, err := url.Parse(conn)
+		if err != nil || parsed.User == nil {
+			return conn, ""
+		}
+		password, _ := parsed.User.Password()
+		parsed.User = url.User(parsed.User.Username())
+		return parsed.String(), password
+	}
+	match := pgPasswordPattern.FindStringSubmatch(conn)
+	if match == nil {
+		return conn, ""
+	}
+	password := match[2]
+	if strings.HasPrefix(password, "'") {
+		password = strings.NewReplacer(`\'`, "'", `\\`, `\`).Replace(strings.Trim(password, "'"))
+	}
+	return strings.TrimSpace(pgPasswordPattern.ReplaceAllString(conn, "$1")), password
+}
+
+func copyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+// pruneBackups : keeps newest Retention backups in dir and bucket, names sort by time
+func pruneBackups(config BackupConfig) error {
+	if config.Retention <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(config.Dir, "backup-*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for i := 0; i < len(files)-config.Retention; i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+	}
+	if config.Bucket == "" {
+		return nil
+	}
+	body, err := config.s3Request(http.MethodGet, "", url.Values{"list-type": {"2"}, "prefix": {config.Prefix + "backup-"}}, nil)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+	}
+	if err := xml.Unmarshal(body, &list); err != nil {
+		return err
+	}
+	keys := []string{}
+	for _, object := range list.Contents {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	for i := 0; i < len(keys)-config.Retention; i++ {
+		if _, err := config.s3Request(http.MethodDelete, keys[i], nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Upload : streams file at path to key, multipart when it is larger than PartSize,
+// a multipart upload is aborted between parts when ctx is done
+func (config BackupConfig) s3Upload(ctx context.Context, key, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= config.PartSize {
+		_, _, err := config.s3Stream(http.MethodPut, key, nil, io.NewSectionReader(file, 0, info.Size()))
+		return err
+	}
+	data, err := config.s3Request(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &initiated); err != nil {
+		return err
+	}
+	upload := url.Values{"uploadId": {initiated.UploadId}}
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	parts := []completedPart{}
+	for offset := int64(0); offset < info.Size(); offset += config.PartSize {
+		number, size := len(parts)+1, config.PartSize
+		if remaining := info.Size() - offset; remaining < size {
+			size = remaining
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadId}}
+		if err := ctx.Err(); err != nil {
+			config.s3Request(http.MethodDelete, key, upload, nil)
+			return err
+		}
+		_, header, err := config.s3Stream(http.MethodPut, key, query, io.NewSectionReader(file, offset, size))
+		if err != nil {
+			// uploaded parts are billed until the upload is aborted
+			config.s3Request(http.MethodDelete, key, upload, nil)
+			return err
+		}
+		parts = append(parts, completedPart{number, header.Get("ETag")})
+	}
+	complete, _ := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if _, err := config.s3Request(http.MethodPost, key, upload, complete); err != nil {
+		config.s3Request(http.MethodDelete, key, upload, nil)
+		return err
+	}
+	return nil
+}
+
+// s3Stream : s3Do with body read from section, hashed in a first pass so it is never held in memory
+func (config BackupConfig) s3Stream(method, key string, query url.Values, section *io.SectionReader) ([]byte, http.Header, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, section); err != nil {
+		return nil, nil, err
+	}
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	return config.s3Do(method, key, query, section, section.Size(), hex.EncodeToString(hash.Sum(nil)))
+}
+
+// s3Request : signed request to object key of bucket (empty key is the bucket itself)
+func (config BackupConfig) s3Request(method, key string, query url.Values, body []byte) ([]byte, error) {
+	payloadHash := sha256.Sum256(body)
+	data, _, err := config.s3Do(method, key, query, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(payloadHash[:]))
+	return data, err
+}
+
+func (config BackupConfig) s3Do(method, key string, query url.Values, body io.Reader, size int64, payloadHash string) ([]byte, http.Header, error) {
+	endpoint := "https://" + config.Bucket + ".s3." + config.S3.Region + ".amazonaws.com/" + key
+	if config.Endpoint != "" {
+		// path style for S3 compatible stores
+		endpoint = config.Endpoint + "/" + config.Bucket + "/" + key
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	config.S3.SignHash(req, "s3", payloadHash, time.Now().UTC())
+	client := http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, errors.New("s3 " + method + " " + key + " responded " + strconv.Itoa(resp.StatusCode) + ": " + string(data))
+	}
+	return data, resp.Header, nil
+}
+
+// StartBackupSchedule : runs RunBackup every BACKUP_INTERVAL, empty interval disables it
+func StartBackupSchedule() {
+	interval := getEnvDuration("BACKUP_INTERVAL", 0)
+	if interval <= 0 {
+		return
+	}
+	config := LoadBackupConfig()
+	// one replica backs up per interval (see scheduler.go)
+	RunScheduled("backup", interval, func(ctx context.Context) error {
+		started := time.Now()
+		path, err := RunBackup(ctx, config)
+		if err != nil {
+			backupRuns.Inc("failure")
+			LogError("Scheduled database backup failed", LogFields{"error": err})
+			return err
+		}
+		backupRuns.Inc("success")
+		backupLastSuccess.Set(float64(time.Now().Unix()))
+		LogInfo("Scheduled database backup written", LogFields{"path": path, "duration_ms": time.Since(started).Milliseconds()})
+		return nil
+	})
+}
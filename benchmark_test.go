@@ -0,0 +1,95 @@
+package main
+
+import (
+	// system packages
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	// database packages
+	"git.yazgan.xyz/alperreha/alya-go-fn-boilerplate/sqlcdb"
+)
+
+/**
+*	Benchmarks
+*	Hot paths of the post service through the router (go test -run none
+*	-bench . -benchmem), the same endpoints as loadtest run but in process
+*	on the DEV_MODE sqlite database. Post creates use a new dev user each
+*	time so POLICY_RULES limits are not hit. Reads invalidate the response
+*	cache first, so every request runs its query. Feed is the plain first
+*	page (served by sqlc), list sorts and pages through gorm.
+*/
+const benchSeedPosts = 200
+
+var benchSeeded bool
+
+// seedBenchPosts : posts for list and feed pages, created once per test binary
+func seedBenchPosts(b *testing.B) {
+	b.Helper()
+	if benchSeeded {
+		return
+	}
+	for i := 0; i < benchSeedPosts; i++ {
+		rec := testRequest(http.MethodPost, "/v1/post/", fmt.Sprintf(`{"body":"bench seed post %d"}`, i), devUser("bench-seed-"+strconv.Itoa(i)))
+		if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+			b.Fatalf("seed post: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	benchSeeded = true
+}
+
+func devUser(user string) http.Header {
+	return http.Header{devUserHeader: {user}}
+}
+
+// benchGet : GET path b.N times with a cold response cache, fails on non 200
+func benchGet(b *testing.B, path string) {
+	b.Helper()
+	seedBenchPosts(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		responseCache.Invalidate(PostListCache.Group)
+		rec := testRequest(http.MethodGet, path, "", nil)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func BenchmarkCreatePost(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rec := testRequest(http.MethodPost, "/v1/post/", fmt.Sprintf(`{"body":"bench post %d of %d"}`, i, b.N), devUser(fmt.Sprintf("bench-%d-%d", b.N, i)))
+		if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+			b.Fatalf("create post: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func BenchmarkListPosts(b *testing.B) {
+	benchGet(b, "/v1/post/?limit=20&sort=-created_at&page=3")
+}
+
+func BenchmarkFeed(b *testing.B) {
+	sqlDb, err := db.DB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	repositories := []struct {
+		name       string
+		repository PostRepository
+	}{
+		{"sqlc", sqlcPostRepository{queries: sqlcdb.New(sqlDb), fallback: gormPostRepository{}}},
+		{"gorm", gormPostRepository{}},
+	}
+	previous := postRepository
+	defer func() { postRepository = previous }()
+	for _, repo := range repositories {
+		b.Run(repo.name, func(b *testing.B) {
+			postRepository = repo.repository
+			benchGet(b, "/v1/post/")
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"os"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	Cache Invalidation Across Replicas
+*	With the in-memory store (no REDIS_HOST) every replica has its own
+*	response and entity caches. Invalidations are applied locally and
+*	published on SubjectCacheInvalidate, every instance (plain subscription,
+*	no queue group) applies the ones of other instances to its own store.
+*	Process local caches (current terms of service) are broadcast with
+*	redis too. After NATS reconnects missed invalidations are unknown, so
+*	every response group and entity kind this instance cached is dropped.
+*	CACHE_SYNC=false turns broadcasting off.
+*/
+var cacheInvalidations = NewCounter(
+	"cache_invalidations_received_total",
+	"Cache invalidations applied from other instances by cache (response, entity, tos).",
+	"cache",
+)
+
+type CacheInvalidation struct {
+	// Origin : instance id of publisher, own messages are skipped
+	Origin string   `json:"origin"`
+	Cache  string   `json:"cache"`
+	Groups []string `json:"groups,omitempty"`
+	Kind   string   `json:"kind,omitempty"`
+	ID     string   `json:"id,omitempty"`
+}
+
+type CacheSync struct {
+	instance string
+	// sharedStore : redis store, response and entity caches need no broadcast
+	sharedStore bool
+}
+
+var cacheSync *CacheSync
+
+// StartCacheSync : subscribes to invalidations of other instances, nil when CACHE_SYNC=false
+func StartCacheSync() (*CacheSync, error) {
+	if !envBool("CACHE_SYNC", true) {
+		return nil, nil
+	}
+	s := &CacheSync{instance: instanceID, sharedStore: os.Getenv("REDIS_HOST") != ""}
+	_, err := nc.Subscribe(SubjectCacheInvalidate, func(msg *nats.Msg) {
+		opened, err := eventCrypto.Open(msg)
+		if err != nil {
+			LogWarn("Dropped unreadable cache invalidation", LogFields{"error": err})
+			return
+		}
+		var invalidation CacheInvalidation
+		if err := json.Unmarshal(opened.Data, &invalidation); err != nil || invalidation.Origin == s.instance {
+			return
+		}
+		s.apply(invalidation)
+	})
+	return s, err
+}
+
+func (s *CacheSync) apply(invalidation CacheInvalidation) {
+	switch invalidation.Cache {
+	case "response":
+		if responseCache != nil {
+			responseCache.invalidateLocal(invalidation.Groups...)
+		}
+	case "entity":
+		if entityCache != nil {
+			entityCache.invalidateLocal(invalidation.Kind, invalidation.ID)
+		}
+	case "tos":
+		forgetCurrentTos()
+	default:
+		return
+	}
+	cacheInvalidations.Inc(invalidation.Cache)
+}
+
+// Broadcast : publishes invalidation of store backed cache, skipped when store is shared
+func (s *CacheSync) Broadcast(invalidation CacheInvalidation) {
+	if s == nil || s.sharedStore && invalidation.Cache != "tos" {
+		return
+	}
+	invalidation.Origin = s.instance
+	payload, _ := json.Marshal(invalidation)
+	// failures are logged by PublishEvent, caches expire by ttl
+	PublishEvent(SubjectCacheInvalidate, payload)
+}
+
+// Resync : drops every response group and entity kind cached here, run after reconnect
+func (s *CacheSync) Resync() {
+	if s == nil || s.sharedStore {
+		return
+	}
+	if responseCache != nil {
+		responseCache.invalidateLocal(indexedResponseGroups()...)
+	}
+	if entityCache != nil {
+		for _, kind := range entityCache.kinds() {
+			entityCache.invalidateLocal(kind, "")
+		}
+	}
+	forgetCurrentTos()
+	LogInfo("Local caches dropped after NATS reconnect", nil)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"sort"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Client Capabilities
+*	GET /_/capabilities tells clients what this deployment supports so UI
+*	adapts without hardcoded settings. Values are derived from the same
+*	config the handlers use (body limits, POLICY_RULES, APP_FEATURES,
+*	supported locales), features that do not exist yet report disabled.
+*/
+type CapabilitiesDto struct {
+	Environment      string             `json:"environment" example:"prod"`
+	Uploads          UploadCapabilities `json:"uploads"`
+	Posts            PostCapabilities   `json:"posts"`
+	DirectMessages   bool               `json:"direct_messages"`
+	RegistrationMode string             `json:"registration_mode" example:"none"`
+	MaxJsonBodyBytes int64              `json:"max_json_body_bytes" example:"1048576"`
+	RateLimits       []RateLimitDto     `json:"rate_limits"`
+	Locales          []string           `json:"locales"`
+	FeatureFlags     []string           `json:"feature_flags"`
+	// Modules : services mounted by APP_MODULES like core, post, analytics
+	Modules []string `json:"modules"`
+}
+
+type UploadCapabilities struct {
+	Enabled    bool     `json:"enabled"`
+	MaxBytes   int64    `json:"max_bytes" example:"10485760"`
+	ImageTypes []string `json:"image_types"`
+}
+
+type PostCapabilities struct {
+	MaxBodyLength  int      `json:"max_body_length" example:"255"`
+	Visibilities   []string `json:"visibilities"`
+	SensitiveModes []string `json:"sensitive_modes"`
+	DryRun         bool     `json:"dry_run"`
+	// Translation : GET /post/:id/translate is backed by a provider
+	Translation bool `json:"translation"`
+}
+
+// RateLimitDto : rate or count rule of POLICY_RULES, window_seconds is 0 for count rules
+type RateLimitDto struct {
+	Name          string  `json:"name" example:"post.create"`
+	Limit         int64   `json:"limit" example:"50"`
+	WindowSeconds int64   `json:"window_seconds" example:"86400"`
+	WarnAt        float64 `json:"warn_at" example:"0.8"`
+}
+
+// Rules : configured rules sorted by name
+func (e *PolicyEngine) Rules() []PolicyRule {
+	rules := make([]PolicyRule, 0, len(e.rules))
+	for _, rule := range e.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+func enabledNames(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key, enabled := range set {
+		if enabled {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CapabilitiesHandler godoc
+// @Summary Client capabilities
+// @Schemes
+// @Description Enabled features and limits of this deployment (uploads, rate limits, locales, feature flags)
+// @Tags post-service-health
+// @Produce json
+// @Success 200 {object} CapabilitiesDto
+// @Router /_/capabilities [get]
+func CapabilitiesHandler(ctx *gin.Context) {
+	rateLimits := []RateLimitDto{}
+	for _, rule := range policies.Rules() {
+		rateLimits = append(rateLimits, RateLimitDto{
+			Name:          rule.Name,
+			Limit:         rule.Limit,
+			WindowSeconds: int64(rule.Window.Seconds()),
+			WarnAt:        rule.WarnAt,
+		})
+	}
+	WriteJSON(ctx, http.StatusOK, CapabilitiesDto{
+		Environment: appEnv.Name,
+		Uploads: UploadCapabilities{
+			// upload routes are not mounted until upload storage exists
+			Enabled:    false,
+			MaxBytes:   getEnvInt64("APP_MAX_UPLOAD_BODY", defaultMaxUploadBody),
+			ImageTypes: []string{},
+		},
+		Posts: PostCapabilities{
+			MaxBodyLength:  maxPostBodyLength(),
+			Visibilities:   []string{VisibilityPublic, VisibilityUnlisted},
+			SensitiveModes: []string{SensitiveBlur, SensitiveShow, SensitiveHide},
+			DryRun:         true,
+			Translation:    translationProvider != nil,
+		},
+		DirectMessages:   false,
+		RegistrationMode: "none",
+		MaxJsonBodyBytes: getEnvInt64("APP_MAX_JSON_BODY", defaultMaxJsonBody),
+		RateLimits:       rateLimits,
+		Locales:          enabledNames(supportedLocales),
+		FeatureFlags:     enabledNames(runtimeConfig().FeatureFlags),
+		Modules:          ModuleNames(),
+	})
+}
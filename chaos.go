@@ -0,0 +1,293 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Chaos Drills
+*	Faults are injected for a duration through /_/chaos (admin auth, only
+*	mounted in dev and staging, APP_CHAOS=false turns it off there, prod
+*	never has it) so retries, circuit breakers and alerts can be checked
+*	against this service:
+*	- latency          : matching requests wait latency_ms before handling
+*	- error            : error_rate of matching requests fail with status
+*	                     (default 503) and chaos/injected-error
+*	- db-disconnect    : every query fails as if the database went away
+*	- nats-disconnect  : events are buffered as if NATS went away (see
+*	                     publish_buffer.go), flushed when the fault ends
+*	route is a route pattern like /v1/post/ or a path prefix, empty or *
+*	matches every route, method left out matches every method, db and
+*	nats faults are instance wide. Faults live
+*	in memory of the instance that received them and end by themselves
+*	after duration_seconds (at most CHAOS_MAX_DURATION, default 1h).
+*/
+const (
+	ChaosLatency        = "latency"
+	ChaosError          = "error"
+	ChaosDbDisconnect   = "db-disconnect"
+	ChaosNatsDisconnect = "nats-disconnect"
+)
+
+var chaosInjected = NewCounter(
+	"chaos_faults_injected_total",
+	"Requests, queries and publishes hit by chaos faults by kind.",
+	"kind",
+)
+
+var errChaosDbDisconnect = errors.New("chaos: database disconnected")
+
+type ChaosFaultDto struct {
+	Route           string  `json:"route" validate:"max=255" example:"/v1/post/"`
+	Method          string  `json:"method,omitempty" validate:"omitempty,oneof=GET POST PUT PATCH DELETE" example:"GET"`
+	Kind            string  `json:"kind" validate:"required,oneof=latency error db-disconnect nats-disconnect" example:"latency"`
+	LatencyMs       int     `json:"latency_ms" validate:"required_if=Kind latency,min=0,max=60000" example:"1500"`
+	ErrorRate       float64 `json:"error_rate" validate:"required_if=Kind error,min=0,max=1" example:"0.3"`
+	Status          int     `json:"status,omitempty" validate:"omitempty,min=400,max=599" example:"503"`
+	DurationSeconds int     `json:"duration_seconds" validate:"required,min=1" example:"300"`
+}
+
+type ChaosFault struct {
+	ID string `json:"id"`
+	ChaosFaultDto
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ChaosMonkey struct {
+	mu     sync.Mutex
+	faults []ChaosFault
+	nextId int
+}
+
+var chaos = &ChaosMonkey{}
+
+// active : faults not expired yet, expired nats faults flush the event buffer
+func (c *ChaosMonkey) active() []ChaosFault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	active := c.faults[:0]
+	natsEnded := false
+	for _, fault := range c.faults {
+		if fault.ExpiresAt.After(now) {
+			active = append(active, fault)
+		} else if fault.Kind == ChaosNatsDisconnect {
+			natsEnded = true
+		}
+	}
+	c.faults = active
+	if natsEnded && eventBuffer != nil {
+		go eventBuffer.Flush()
+	}
+	return append([]ChaosFault{}, active...)
+}
+
+func (c *ChaosMonkey) has(kind string) bool {
+	if c == nil {
+		return false
+	}
+	for _, fault := range c.active() {
+		if fault.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// NatsDown : nats-disconnect fault is active
+func (c *ChaosMonkey) NatsDown() bool {
+	if c.has(ChaosNatsDisconnect) {
+		chaosInjected.Inc(ChaosNatsDisconnect)
+		return true
+	}
+	return false
+}
+
+func (c *ChaosMonkey) add(dto ChaosFaultDto) ChaosFault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextId++
+	maxDuration := getEnvDuration("CHAOS_MAX_DURATION", time.Hour)
+	duration := time.Duration(dto.DurationSeconds) * time.Second
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+	if dto.Kind == ChaosError && dto.Status == 0 {
+		dto.Status = http.StatusServiceUnavailable
+	}
+	fault := ChaosFault{ID: strconv.Itoa(c.nextId), ChaosFaultDto: dto, ExpiresAt: time.Now().Add(duration)}
+	c.faults = append(c.faults, fault)
+	return fault
+}
+
+// remove : drops fault by id, empty id drops all, false when id is unknown
+func (c *ChaosMonkey) remove(id string) bool {
+	c.mu.Lock()
+	natsEnded := false
+	found := id == ""
+	kept := []ChaosFault{}
+	for _, fault := range c.faults {
+		if id == "" || fault.ID == id {
+			found = true
+			natsEnded = natsEnded || fault.Kind == ChaosNatsDisconnect
+			continue
+		}
+		kept = append(kept, fault)
+	}
+	c.faults = kept
+	c.mu.Unlock()
+	if natsEnded && eventBuffer != nil {
+		go eventBuffer.Flush()
+	}
+	return found
+}
+
+func (f ChaosFault) matches(ctx *gin.Context) bool {
+	if f.Method != "" && f.Method != ctx.Request.Method {
+		return false
+	}
+	if f.Route == "" || f.Route == "*" {
+		return true
+	}
+	return f.Route == ctx.FullPath() || strings.HasPrefix(ctx.Request.URL.Path, f.Route)
+}
+
+// ChaosMiddleware : applies latency and error faults of matching routes, chaos routes are never hit
+func ChaosMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if strings.Contains(ctx.Request.URL.Path, "/_/chaos") {
+			ctx.Next()
+			return
+		}
+		for _, fault := range chaos.active() {
+			if !fault.matches(ctx) {
+				continue
+			}
+			switch fault.Kind {
+			case ChaosLatency:
+				chaosInjected.Inc(ChaosLatency)
+				select {
+				case <-time.After(time.Duration(fault.LatencyMs) * time.Millisecond):
+				case <-ctx.Request.Context().Done():
+				}
+			case ChaosError:
+				if rand.Float64() < fault.ErrorRate {
+					chaosInjected.Inc(ChaosError)
+					ctx.AbortWithStatusJSON(fault.Status, gin.H{
+						"status":   false,
+						"type":     ErrCodeChaosInjectedError,
+						"message":  "Error injected by chaos fault " + fault.ID + ".",
+						"fault_id": fault.ID,
+					})
+					return
+				}
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// RegisterChaosCallbacks : fails every statement while db-disconnect fault is active
+func RegisterChaosCallbacks(db *gorm.DB) error {
+	fail := func(tx *gorm.DB) {
+		if chaos.has(ChaosDbDisconnect) {
+			chaosInjected.Inc(ChaosDbDisconnect)
+			tx.AddError(errChaosDbDisconnect)
+		}
+	}
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("chaos:create", fail); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("chaos:query", fail); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("chaos:update", fail); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("chaos:delete", fail); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("chaos:row", fail); err != nil {
+		return err
+	}
+	return callbacks.Raw().Before("gorm:raw").Register("chaos:raw", fail)
+}
+
+// GetChaosFaultsHandler godoc
+// @Summary Active chaos faults
+// @Schemes
+// @Description Faults injected on this instance that did not expire yet (dev and staging only)
+// @Tags chaos
+// @Security BasicAuth
+// @Produce json
+// @Success 200 {object} object{faults=[]ChaosFault}
+// @Router /_/chaos [get]
+func GetChaosFaultsHandler(ctx *gin.Context) {
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"faults": chaos.active(),
+	})
+}
+
+// AddChaosFaultHandler godoc
+// @Summary Inject chaos fault
+// @Schemes
+// @Description Injects latency, errors, db or nats disconnects for duration_seconds (dev and staging only)
+// @Tags chaos
+// @Security BasicAuth
+// @Param body body ChaosFaultDto true "fault"
+// @Accept application/json
+// @Produce json
+// @Success 200 {object} object{fault=ChaosFault}
+// @Failure 400 {object} object
+// @Router /_/chaos [post]
+func AddChaosFaultHandler(ctx *gin.Context) {
+	var dto ChaosFaultDto
+	if err := BindDto(ctx, &dto, ErrAreaChaos); err != nil {
+		return
+	}
+	fault := chaos.add(dto)
+	LogWarn("Chaos fault injected", LogFields{"fault_id": fault.ID, "kind": fault.Kind, "route": fault.Route, "expires_at": fault.ExpiresAt, "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"fault": fault,
+	})
+}
+
+// DeleteChaosFaultHandler godoc
+// @Summary End chaos faults
+// @Schemes
+// @Description Ends fault with id, without id ends every fault
+// @Tags chaos
+// @Security BasicAuth
+// @Param id path string false "fault id"
+// @Produce json
+// @Success 200 {object} object
+// @Failure 404 {object} object
+// @Router /_/chaos/{id} [delete]
+func DeleteChaosFaultHandler(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if !chaos.remove(id) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeChaosNotFound,
+			"message": "No active chaos fault with that id.",
+		})
+		return
+	}
+	LogWarn("Chaos faults ended", LogFields{"fault_id": id, "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"status": true,
+	})
+}
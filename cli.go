@@ -0,0 +1,61 @@
+package main
+
+import (
+	// system packages
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+/**
+*	CLI Subcommands
+*	Running binary with arguments executes a maintenance command instead of
+*	starting the server, e.g. ./alyagofn events replay --subject alya.post.created.v1
+*	Commands are registered in cliCommands by name.
+*/
+type CliCommand struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+var cliCommands = map[string]CliCommand{}
+
+func registerCliCommand(name string, command CliCommand) {
+	cliCommands[name] = command
+}
+
+// RunCli : runs command of args and returns process exit code
+func RunCli(args []string) int {
+	// "events replay" style commands are registered with full name
+	name := args[0]
+	rest := args[1:]
+	if len(args) > 1 {
+		if _, ok := cliCommands[args[0]+" "+args[1]]; ok {
+			name = args[0] + " " + args[1]
+			rest = args[2:]
+		}
+	}
+	command, ok := cliCommands[name]
+	if !ok {
+		printCliUsage()
+		return 2
+	}
+	if err := command.Run(rest); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func printCliUsage() {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: alyagofn [command] [flags]\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+name+strings.Repeat(" ", 20-len(name)%20)+cliCommands[name].Usage)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	// system packages
+	"os"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Client IP
+*	Forwarding headers are only read when the direct peer is in
+*	TRUSTED_PROXIES (comma separated ips/cidrs, empty trusts nobody so
+*	RemoteAddr is used). CLIENT_IP_HEADERS sets header precedence, default
+*	"X-Forwarded-For,X-Real-IP"; behind Cloudflare use
+*	"CF-Connecting-IP,X-Forwarded-For" with Cloudflare ranges as trusted
+*	proxies. X-Forwarded-For is walked right to left and the first ip that
+*	is not a trusted proxy wins, so clients can not spoof it by prepending.
+*	Handlers, filters and limiters use ClientIP(ctx) instead of ctx.ClientIP().
+*/
+const clientIpKey = "client_ip"
+
+// ConfigureClientIP : applies TRUSTED_PROXIES and CLIENT_IP_HEADERS to engine
+func ConfigureClientIP(r *gin.Engine) error {
+	r.ForwardedByClientIP = true
+	r.RemoteIPHeaders = splitList(os.Getenv("CLIENT_IP_HEADERS"))
+	if len(r.RemoteIPHeaders) == 0 {
+		r.RemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+	}
+	return r.SetTrustedProxies(splitList(os.Getenv("TRUSTED_PROXIES")))
+}
+
+// ClientIP : real client ip of request, resolved once per request
+func ClientIP(ctx *gin.Context) string {
+	if ip := ctx.GetString(clientIpKey); ip != "" {
+		return ip
+	}
+	ip := ctx.ClientIP()
+	ctx.Set(clientIpKey, ip)
+	return ip
+}
+
+// splitList : comma separated env value to trimmed non empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
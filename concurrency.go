@@ -0,0 +1,123 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// concurrency packages
+	"golang.org/x/sync/semaphore"
+)
+
+/**
+*	Concurrency Limiting (load shedding)
+*	Caps in-flight work to APP_MAX_INFLIGHT units (default 64) so a stampede
+*	can not exhaust the small db pool. Each route takes its weight from
+*	APP_INFLIGHT_WEIGHTS like "/v1/admin/stats:8,POST /v1/post/:2" (default
+*	1). Requests wait up to APP_INFLIGHT_QUEUE_TIMEOUT (default 100ms) for a
+*	slot, then get 503 with Retry-After. Health routes (/_/) are never shed.
+*/
+var (
+	httpInflight = NewGauge(
+		"http_inflight_weight",
+		"Weight units of requests currently in flight.",
+	)
+	httpShed = NewCounter(
+		"http_shed_requests_total",
+		"Requests rejected by concurrency limiter by route.",
+		"route",
+	)
+)
+
+type ConcurrencyLimiter struct {
+	sem          *semaphore.Weighted
+	capacity     int64
+	queueTimeout time.Duration
+	weights      map[string]int64
+}
+
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	capacity := getEnvInt64("APP_MAX_INFLIGHT", 64)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ConcurrencyLimiter{
+		sem:          semaphore.NewWeighted(capacity),
+		capacity:     capacity,
+		queueTimeout: getEnvDuration("APP_INFLIGHT_QUEUE_TIMEOUT", 100*time.Millisecond),
+		weights:      parseRouteWeights(os.Getenv("APP_INFLIGHT_WEIGHTS")),
+	}
+}
+
+// parseRouteWeights : "route:weight" pairs, route is gin full path optionally prefixed with method
+func parseRouteWeights(csv string) map[string]int64 {
+	weights := map[string]int64{}
+	for _, pair := range strings.Split(csv, ",") {
+		pair = strings.TrimSpace(pair)
+		index := strings.LastIndex(pair, ":")
+		if index <= 0 {
+			continue
+		}
+		weight, err := strconv.ParseInt(pair[index+1:], 10, 64)
+		if err != nil || weight < 1 {
+			LogWarn("Invalid APP_INFLIGHT_WEIGHTS entry", LogFields{"entry": pair})
+			continue
+		}
+		weights[pair[:index]] = weight
+	}
+	return weights
+}
+
+func (l *ConcurrencyLimiter) weight(ctx *gin.Context) int64 {
+	route := ctx.FullPath()
+	weight, ok := l.weights[ctx.Request.Method+" "+route]
+	if !ok {
+		weight, ok = l.weights[route]
+	}
+	if !ok {
+		weight = 1
+	}
+	if weight > l.capacity {
+		weight = l.capacity
+	}
+	return weight
+}
+
+func (l *ConcurrencyLimiter) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if strings.Contains(ctx.Request.URL.Path, "/_/") {
+			ctx.Next()
+			return
+		}
+		weight := l.weight(ctx)
+		waitCtx, cancel := context.WithTimeout(ctx.Request.Context(), l.queueTimeout)
+		err := l.sem.Acquire(waitCtx, weight)
+		cancel()
+		if err != nil {
+			route := ctx.FullPath()
+			if route == "" {
+				route = "unmatched"
+			}
+			httpShed.Inc(route)
+			ctx.Header("Retry-After", "1")
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"status":  false,
+				"type":    ErrCodeServerOverloaded,
+				"message": "Server is busy, please retry shortly.",
+			})
+			return
+		}
+		httpInflight.Add(float64(weight))
+		defer func() {
+			httpInflight.Add(-float64(weight))
+			l.sem.Release(weight)
+		}()
+		ctx.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	// system packages
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/**
+*	Config helpers : read typed values from env (.env file is loaded in main)
+*	and fall back to given default when the key is empty or malformed
+*/
+func getEnvInt64(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Println("Invalid "+key+" in .env file using default.", err)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	return parseEnvDuration(key, os.Getenv(key), fallback)
+}
+
+// parseEnvDuration : getEnvDuration for a value read from elsewhere (e.g. runtime config reload)
+func parseEnvDuration(key, value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Println("Invalid "+key+" in .env file using default.", err)
+		return fallback
+	}
+	return parsed
+}
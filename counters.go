@@ -0,0 +1,172 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+/**
+*	Counter Consistency
+*	Denormalized counters are bumped in place by handlers, a crash between
+*	writes or a bug leaves them off. CheckCounters recomputes each counter
+*	of counterChecks from its source rows and reports drifted rows in
+*	counter_drift_rows{counter}, with repair the counter is set to the
+*	recomputed value (compare and set, concurrent bumps win).
+*	./alyagofn counters check [--repair]
+*	COUNTER_CHECK_INTERVAL (e.g. 6h, empty disables) runs the check inside
+*	the server, COUNTER_CHECK_REPAIR=true repairs on scheduled runs too.
+*	Likes, comments, views and followers are added here with their models.
+*/
+type CounterCheck struct {
+	Name   string
+	Table  string
+	Column string
+	// Expected : correlated subquery of the recomputed value, row under check is aliased t
+	Expected string
+	// Kind : entity cache kind of rows dropped on repair
+	Kind string
+}
+
+var counterChecks = []CounterCheck{
+	{
+		Name:   "post.reposted_count",
+		Table:  "posts",
+		Column: "reposted_count",
+		// archived reposts still count (see archive.go)
+		Expected: "SELECT (SELECT COUNT(*) FROM posts s WHERE s.original_id = t.id AND s.deleted_at IS NULL)" +
+			" + (SELECT COUNT(*) FROM archived_posts a WHERE a.original_id = t.id AND a.deleted_at IS NULL)",
+		Kind: "post",
+	},
+}
+
+var counterDriftRows = NewGauge(
+	"counter_drift_rows",
+	"Rows whose denormalized counter differs from source rows on last check.",
+	"counter",
+)
+
+var counterRepairs = NewCounter(
+	"counter_repairs_total",
+	"Denormalized counter values repaired by counter check.",
+	"counter",
+)
+
+// CounterDrift : row whose stored counter differs from recomputed one
+type CounterDrift struct {
+	ID       uint   `json:"-"`
+	PublicID string `json:"id"`
+	Actual   int64  `json:"actual"`
+	Expected int64  `json:"expected"`
+}
+
+type CounterReport struct {
+	Counter  string         `json:"counter"`
+	Drifted  int            `json:"drifted"`
+	Repaired int            `json:"repaired"`
+	Rows     []CounterDrift `json:"rows"`
+}
+
+func init() {
+	registerCliCommand("counters check", CliCommand{
+		Usage: "recompute denormalized counters and report drift (--repair)",
+		Run:   runCounterCheckCommand,
+	})
+}
+
+// CheckCounter : finds drifted rows of check and repairs them when asked, stops when ctx is done
+func CheckCounter(ctx context.Context, check CounterCheck, repair bool) (CounterReport, error) {
+	report := CounterReport{Counter: check.Name, Rows: []CounterDrift{}}
+	database := db.WithContext(ctx)
+	expected := "(" + check.Expected + ")"
+	err := database.Raw(
+		"SELECT t.id, t.public_id, t." + check.Column + " AS actual, " + expected + " AS expected" +
+			" FROM " + check.Table + " t WHERE t.deleted_at IS NULL AND t." + check.Column + " <> " + expected +
+			" ORDER BY t.id",
+	).Scan(&report.Rows).Error
+	if err != nil {
+		return report, err
+	}
+	report.Drifted = len(report.Rows)
+	counterDriftRows.Set(float64(report.Drifted), check.Name)
+	if !repair {
+		return report, nil
+	}
+	for _, row := range report.Rows {
+		// only rows still holding the value seen above, a concurrent bump is checked again next run
+		result := database.Exec(
+			"UPDATE "+check.Table+" SET "+check.Column+" = ? WHERE id = ? AND "+check.Column+" = ?",
+			row.Expected, row.ID, row.Actual,
+		)
+		if result.Error != nil {
+			return report, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		report.Repaired++
+		counterRepairs.Inc(check.Name)
+		if entityCache != nil {
+			entityCache.Invalidate(check.Kind, row.PublicID)
+		}
+	}
+	counterDriftRows.Set(float64(report.Drifted-report.Repaired), check.Name)
+	return report, nil
+}
+
+// CheckCounters : runs every counter check
+func CheckCounters(ctx context.Context, repair bool) ([]CounterReport, error) {
+	reports := []CounterReport{}
+	for _, check := range counterChecks {
+		report, err := CheckCounter(ctx, check, repair)
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", check.Name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func runCounterCheckCommand(args []string) error {
+	flags := flag.NewFlagSet("counters check", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "set drifted counters to recomputed values")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := initCliDatabase(); err != nil {
+		return err
+	}
+	reports, err := CheckCounters(context.Background(), *repair)
+	for _, report := range reports {
+		fmt.Printf("%s: %d drifted, %d repaired\n", report.Counter, report.Drifted, report.Repaired)
+		for _, row := range report.Rows {
+			fmt.Printf("  %s stored %d, expected %d\n", row.PublicID, row.Actual, row.Expected)
+		}
+	}
+	return err
+}
+
+// StartCounterCheckSchedule : runs CheckCounters every COUNTER_CHECK_INTERVAL, empty interval disables it
+func StartCounterCheckSchedule() {
+	interval := getEnvDuration("COUNTER_CHECK_INTERVAL", 0)
+	if interval <= 0 {
+		return
+	}
+	repair := os.Getenv("COUNTER_CHECK_REPAIR") == "true"
+	// one replica checks per interval (see scheduler.go)
+	RunScheduled("counter-check", interval, func(ctx context.Context) error {
+		reports, err := CheckCounters(ctx, repair)
+		if err != nil {
+			LogError("Counter check failed", LogFields{"error": err})
+			return err
+		}
+		for _, report := range reports {
+			if report.Drifted > 0 {
+				LogWarn("Denormalized counter drift", LogFields{"counter": report.Counter, "drifted": report.Drifted, "repaired": report.Repaired})
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	// database packages
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+/**
+*	Database Query Logger
+*	GORM logger that writes every query as a structured debug log line with
+*	duration, rows and caller, flags queries slower than DB_SLOW_QUERY_THRESHOLD
+*	(default 200ms) as warn and observes db_query_duration_seconds histogram
+*	per table and operation.
+*/
+var dbQueryDuration = NewHistogram(
+	"db_query_duration_seconds",
+	"Database query latency by table and operation.",
+	defaultSecondsBuckets,
+	"table", "operation",
+)
+
+var dbSlowQueries = NewCounter(
+	"db_slow_queries_total",
+	"Queries slower than DB_SLOW_QUERY_THRESHOLD by table and operation.",
+	"table", "operation",
+)
+
+var sqlTablePattern = regexp.MustCompile(`(?i)\b(?:from|into|update|join)\s+"?([a-zA-Z0-9_.]+)"?`)
+
+type DbQueryLogger struct {
+	SlowThreshold time.Duration
+	Level         logger.LogLevel
+}
+
+func NewDbQueryLogger() *DbQueryLogger {
+	return &DbQueryLogger{
+		SlowThreshold: getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 200*time.Millisecond),
+		Level:         logger.Info,
+	}
+}
+
+func (l *DbQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.Level = level
+	return &copied
+}
+
+func (l *DbQueryLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.Level >= logger.Info {
+		LogInfo(fmt.Sprintf(msg, data...), LogFields{"caller": utils.FileWithLineNum()})
+	}
+}
+
+func (l *DbQueryLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.Level >= logger.Warn {
+		LogWarn(fmt.Sprintf(msg, data...), LogFields{"caller": utils.FileWithLineNum()})
+	}
+}
+
+func (l *DbQueryLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.Level >= logger.Error {
+		LogError(fmt.Sprintf(msg, data...), LogFields{"caller": utils.FileWithLineNum()})
+	}
+}
+
+func (l *DbQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.Level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	table, operation := sqlTableAndOperation(sql)
+	dbQueryDuration.Observe(elapsed.Seconds(), table, operation)
+
+	fields := LogFields{
+		"sql":         sql,
+		"rows":        rows,
+		"duration_ms": float64(elapsed.Microseconds()) / 1000,
+		"table":       table,
+		"operation":   operation,
+		"caller":      utils.FileWithLineNum(),
+	}
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.Level >= logger.Error:
+		fields["error"] = err
+		LogError("db query failed", fields)
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.Level >= logger.Warn:
+		dbSlowQueries.Inc(table, operation)
+		fields["slow"] = true
+		LogWarn("db slow query", fields)
+	case l.Level >= logger.Info:
+		LogDebug("db query", fields)
+	}
+}
+
+// sqlTableAndOperation : best effort table and operation of a sql statement
+func sqlTableAndOperation(sql string) (string, string) {
+	operation := "other"
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		operation = strings.ToLower(fields[0])
+	}
+	table := "unknown"
+	if match := sqlTablePattern.FindStringSubmatch(sql); len(match) == 2 {
+		table = strings.Trim(match[1], `"`)
+	}
+	return table, operation
+}
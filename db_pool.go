@@ -0,0 +1,165 @@
+package main
+
+import (
+	// system packages
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Database Pool Stats
+*	sql.DBStats exported as Prometheus gauges (refreshed on every scrape)
+*	and included in /_/app_kernel_stats payload.
+*	Soft quota: while every connection is in use and the average wait for
+*	a connection over the last second exceeds DB_POOL_READ_MAX_WAIT
+*	(default 200ms, 0 disables) reads get 503 with Retry-After instead of
+*	queueing until timeout. Guard is not global, modules put routes.DbGuard
+*	on their database reads after the response cache middleware, so cache
+*	hits are still served while the pool is saturated. Writes, health and
+*	admin routes have no guard and keep queueing.
+*/
+type DbPoolStats struct {
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDurationSec    float64 `json:"wait_duration_seconds"`
+	MaxIdleClosed      int64   `json:"max_idle_closed"`
+	MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+}
+
+var dbPoolGauge = NewGauge(
+	"db_pool_connections",
+	"Database pool connections by state (max_open, open, in_use, idle).",
+	"state",
+)
+
+var dbPoolWaitCount = NewGauge(
+	"db_pool_wait_count",
+	"Total number of connections waited for.",
+)
+
+var dbPoolWaitDuration = NewGauge(
+	"db_pool_wait_duration_seconds",
+	"Total time blocked waiting for a new connection.",
+)
+
+func init() {
+	registerCollector(func() {
+		stats := GetDbPoolStats()
+		dbPoolGauge.Set(float64(stats.MaxOpenConnections), "max_open")
+		dbPoolGauge.Set(float64(stats.OpenConnections), "open")
+		dbPoolGauge.Set(float64(stats.InUse), "in_use")
+		dbPoolGauge.Set(float64(stats.Idle), "idle")
+		dbPoolWaitCount.Set(float64(stats.WaitCount))
+		dbPoolWaitDuration.Set(stats.WaitDurationSec)
+	})
+}
+
+// GetDbPoolStats : current pool stats, zero value before database is connected
+func GetDbPoolStats() DbPoolStats {
+	if db == nil {
+		return DbPoolStats{}
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return DbPoolStats{}
+	}
+	stats := sqlDb.Stats()
+	return DbPoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationSec:    stats.WaitDuration.Seconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
+
+var dbPoolAvgWait = NewGauge(
+	"db_pool_avg_wait_seconds",
+	"Average wait for a connection over the last sample window.",
+)
+
+var dbPoolShed = NewCounter(
+	"db_pool_shed_requests_total",
+	"Read requests rejected while database pool was saturated by route.",
+	"route",
+)
+
+const dbPoolSampleWindow = time.Second
+
+type DbPoolGuard struct {
+	maxWait time.Duration
+
+	mu           sync.Mutex
+	sampledAt    time.Time
+	waitCount    int64
+	waitDuration time.Duration
+	avgWait      time.Duration
+}
+
+func NewDbPoolGuard() *DbPoolGuard {
+	return &DbPoolGuard{
+		maxWait: getEnvDuration("DB_POOL_READ_MAX_WAIT", 200*time.Millisecond),
+	}
+}
+
+// recentWait : average connection wait since previous sample, resampled once per window
+func (g *DbPoolGuard) recentWait(stats sql.DBStats) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if now.Sub(g.sampledAt) < dbPoolSampleWindow {
+		return g.avgWait
+	}
+	g.avgWait = 0
+	if waits := stats.WaitCount - g.waitCount; waits > 0 && !g.sampledAt.IsZero() {
+		g.avgWait = (stats.WaitDuration - g.waitDuration) / time.Duration(waits)
+	}
+	g.sampledAt, g.waitCount, g.waitDuration = now, stats.WaitCount, stats.WaitDuration
+	dbPoolAvgWait.Set(g.avgWait.Seconds())
+	return g.avgWait
+}
+
+// saturated : all connections busy and acquisitions wait longer than maxWait
+func (g *DbPoolGuard) saturated() bool {
+	if db == nil {
+		return false
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return false
+	}
+	stats := sqlDb.Stats()
+	wait := g.recentWait(stats)
+	return stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections && wait > g.maxWait
+}
+
+func (g *DbPoolGuard) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if g.maxWait <= 0 {
+			ctx.Next()
+			return
+		}
+		if g.saturated() {
+			dbPoolShed.Inc(ctx.FullPath())
+			ctx.Header("Retry-After", "1")
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"status":  false,
+				"type":    ErrCodeDbPoolExhausted,
+				"message": "Database is busy, please retry shortly.",
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
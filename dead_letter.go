@@ -0,0 +1,374 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats.go"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Workers and Dead Letters
+*	SubscribeWorker consumes a work queue subject in queue group queue, so
+*	each message is handled by one replica. With JetStream the group is a
+*	durable consumer of the WORK stream (see subjects.go): messages wait
+*	while no worker is connected and are redelivered when a worker dies
+*	before acking within WORKER_ACK_WAIT (default 10m), without JetStream
+*	it falls back to a core NATS queue group (at most once). A failing
+*	handler is retried WORKER_MAX_RETRIES times (default 3) with exponential
+*	backoff, then the message is saved to dead_letters table with the error
+*	and also published to "dlq.<subject>". Admin endpoints list, inspect
+*	and requeue dead letters, requeued letters are sealed again with a fresh
+*	Event-Time and Event-Id (see event_crypto.go), letters that do not verify
+*	are refused.
+*/
+var workerDeadLetters = NewCounter(
+	"worker_dead_letters_total",
+	"Messages moved to dead letter queue by subject.",
+	"subject",
+)
+
+type DeadLetter struct {
+	gorm.Model
+	Subject    string     `gorm:"column:subject;size:255;not null;index" json:"subject"`
+	Payload    []byte     `gorm:"column:payload" json:"payload"`
+	Headers    string     `gorm:"column:headers;type:text" json:"headers"`
+	Error      string     `gorm:"column:error;type:text" json:"error"`
+	Attempts   int        `gorm:"column:attempts" json:"attempts"`
+	RequeuedAt *time.Time `gorm:"column:requeued_at" json:"requeued_at"`
+}
+
+// DeadLetterDto is the admin shape of DeadLetter (timestamps RFC3339 UTC)
+type DeadLetterDto struct {
+	ID         uint       `json:"id,string"`
+	Subject    string     `json:"subject"`
+	Payload    []byte     `json:"payload"`
+	Headers    string     `json:"headers"`
+	Error      string     `json:"error"`
+	Attempts   int        `json:"attempts"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RequeuedAt *time.Time `json:"requeued_at"`
+}
+
+func ToDeadLetterDto(letter DeadLetter) DeadLetterDto {
+	dto := DeadLetterDto{
+		ID:        letter.ID,
+		Subject:   letter.Subject,
+		Payload:   letter.Payload,
+		Headers:   letter.Headers,
+		Error:     letter.Error,
+		Attempts:  letter.Attempts,
+		CreatedAt: ToUTC(letter.CreatedAt),
+	}
+	if letter.RequeuedAt != nil {
+		requeuedAt := ToUTC(*letter.RequeuedAt)
+		dto.RequeuedAt = &requeuedAt
+	}
+	return dto
+}
+
+// SubscribeWorker : subscribes handler to subject in queue group queue with retries and dead lettering
+func SubscribeWorker(subject, queue string, handler func(msg *nats.Msg) error) (*nats.Subscription, error) {
+	maxRetries := int(getEnvInt64("WORKER_MAX_RETRIES", 3))
+	backoff := getEnvDuration("WORKER_RETRY_BACKOFF", 100*time.Millisecond)
+	work := func(msg *nats.Msg) {
+		// forged or unreadable events are not retried (see event_crypto.go)
+		opened, err := eventCrypto.Open(msg)
+		if err != nil {
+			deadLetterMessage(msg, err, 0)
+			msg.Term()
+			return
+		}
+		if eventCrypto.Handled(msg, queue) {
+			LogWarn("Dropped replayed event", LogFields{"subject": msg.Subject, "queue": queue, "event_id": msg.Header.Get(eventIdHeader)})
+			msg.Ack()
+			return
+		}
+		attempts := 0
+		for attempts <= maxRetries {
+			attempts++
+			if err = runWorkerHandler(handler, opened); err == nil {
+				eventCrypto.MarkHandled(msg, queue)
+				msg.Ack()
+				return
+			}
+			LogWarn("Worker failed handling message", LogFields{"subject": msg.Subject, "attempt": attempts, "error": err, "request_id": msg.Header.Get(requestIdHeader)})
+			if attempts <= maxRetries {
+				time.Sleep(backoff << uint(attempts-1))
+			}
+		}
+		deadLetterMessage(msg, err, attempts)
+		// kept in dead_letters, requeue publishes it again
+		msg.Term()
+	}
+	if js, err := nc.JetStream(); err == nil {
+		sub, err := js.QueueSubscribe(subject, queue, work,
+			nats.Durable(queue),
+			nats.ManualAck(),
+			nats.AckWait(getEnvDuration("WORKER_ACK_WAIT", 10*time.Minute)),
+		)
+		if err == nil {
+			return sub, nil
+		}
+		LogWarn("JetStream work queue unavailable, using core NATS queue group (at most once)", LogFields{"subject": subject, "queue": queue, "error": err})
+	}
+	return nc.QueueSubscribe(subject, queue, work)
+}
+
+// runWorkerHandler : panics of handler are returned as error so they are retried
+func runWorkerHandler(handler func(msg *nats.Msg) error, msg *nats.Msg) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.New("panic: " + panicMessage(recovered))
+		}
+	}()
+	return handler(msg)
+}
+
+func panicMessage(value interface{}) string {
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	if str, ok := value.(string); ok {
+		return str
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+func deadLetterMessage(msg *nats.Msg, handlerErr error, attempts int) {
+	headers, _ := json.Marshal(msg.Header)
+	letter := DeadLetter{
+		Subject:  msg.Subject,
+		Payload:  msg.Data,
+		Headers:  string(headers),
+		Error:    handlerErr.Error(),
+		Attempts: attempts,
+	}
+	workerDeadLetters.Inc(msg.Subject)
+	if err := db.Create(&letter).Error; err != nil {
+		LogError("Error saving dead letter", LogFields{"subject": msg.Subject, "error": err})
+	}
+	LogError("Message moved to dead letter queue", LogFields{"subject": msg.Subject, "dead_letter_id": letter.ID, "error": handlerErr})
+
+	dlq := nats.NewMsg("dlq." + msg.Subject)
+	dlq.Data = msg.Data
+	for key, values := range msg.Header {
+		dlq.Header[key] = values
+	}
+	dlq.Header.Set("Dead-Letter-Error", handlerErr.Error())
+	dlq.Header.Set("Dead-Letter-Attempts", strconv.Itoa(attempts))
+	if err := nc.PublishMsg(dlq); err != nil {
+		LogError("Error publishing dead letter", LogFields{"subject": dlq.Subject, "error": err})
+	}
+}
+
+/**
+*	--------------- HTTP /admin/dead-letters Section ---------------
+*/
+var DeadLetterListFields = ListFields{
+	Sort: map[string]string{
+		"id":         "id",
+		"created_at": "created_at",
+	},
+	Filters: map[string]string{
+		"subject": "subject",
+	},
+	Ranges: map[string]string{
+		"created": "created_at",
+	},
+	DefaultSort: "-id",
+}
+
+// DeadLetterListQuery : query params of GET /admin/dead-letters
+type DeadLetterListQuery struct {
+	ListQuery
+	Subject       string `form:"subject" json:"subject" validate:"max=255"`
+	CreatedAfter  string `form:"created_after" json:"created_after" validate:"omitempty,querytime"`
+	CreatedBefore string `form:"created_before" json:"created_before" validate:"omitempty,querytime"`
+	// Requeued : true lists requeued, false lists waiting letters
+	Requeued *bool `form:"requeued" json:"requeued"`
+}
+
+// ListParams : filter and range params of the bound query by param name
+func (q DeadLetterListQuery) ListParams() map[string]string {
+	return map[string]string{
+		"subject":        q.Subject,
+		"created_after":  q.CreatedAfter,
+		"created_before": q.CreatedBefore,
+	}
+}
+
+// GetDeadLettersHandler godoc
+// @Summary List dead letters
+// @Schemes
+// @Description Supports page, limit, count, sort, subject and created_after/created_before params
+// @Tags admin
+// @Security BasicAuth
+// @Produce json
+// @Param subject query string false "filter by subject"
+// @Param page query int false "page number"
+// @Param limit query int false "page size (max 100)"
+// @Param requeued query bool false "true for requeued, false for waiting letters"
+// @Success 200 {object} object
+// @Failure 400 {object} object
+// @Failure 500 {object} object
+// @Router /admin/dead-letters [get]
+func GetDeadLettersHandler(ctx *gin.Context) {
+	var listQuery DeadLetterListQuery
+	if err := BindQuery(ctx, &listQuery, ErrAreaDeadLetter); err != nil {
+		return
+	}
+	paginator := NewPaginator(ctx, listQuery.ListQuery)
+	query, err := ApplyListQuery(db.Model(&DeadLetter{}), DeadLetterListFields, listQuery.ListQuery, listQuery.ListParams())
+	if err != nil {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    ErrAreaDeadLetter + ErrSuffixQueryParams,
+			"message": err.Error(),
+		})
+		return
+	}
+	if listQuery.Requeued != nil && *listQuery.Requeued {
+		query = query.Where("requeued_at IS NOT NULL")
+	} else if listQuery.Requeued != nil {
+		query = query.Where("requeued_at IS NULL")
+	}
+	var letters []DeadLetter
+	if err := paginator.Find(query, &letters); err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeDeadLetterQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	dtos := make([]DeadLetterDto, 0, len(letters))
+	for _, letter := range letters {
+		dtos = append(dtos, ToDeadLetterDto(letter))
+	}
+	WriteJSON(ctx, http.StatusOK, paginator.Response(dtos))
+}
+
+func findDeadLetter(ctx *gin.Context) (*DeadLetter, bool) {
+	var letter DeadLetter
+	err := db.First(&letter, ctx.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeDeadLetterNotFound,
+			"message": "Dead letter not found.",
+		})
+		return nil, false
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeDeadLetterQuery,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	return &letter, true
+}
+
+// GetDeadLetterHandler godoc
+// @Summary Inspect a dead letter
+// @Schemes
+// @Tags admin
+// @Security BasicAuth
+// @Produce json
+// @Param id path int true "dead letter id"
+// @Success 200 {object} DeadLetterDto
+// @Failure 404 {object} object
+// @Router /admin/dead-letters/{id} [get]
+func GetDeadLetterHandler(ctx *gin.Context) {
+	letter, ok := findDeadLetter(ctx)
+	if !ok {
+		return
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"dead_letter": ToDeadLetterDto(*letter),
+	})
+}
+
+// RequeueDeadLetterHandler godoc
+// @Summary Requeue a dead letter
+// @Schemes
+// @Description Publishes payload and headers again to original subject, sealed with fresh Event-Time and Event-Id
+// @Tags admin
+// @Security BasicAuth
+// @Produce json
+// @Param id path int true "dead letter id"
+// @Success 200 {object} DeadLetterDto
+// @Failure 404 {object} object
+// @Failure 409 {object} object
+// @Failure 500 {object} object
+// @Router /admin/dead-letters/{id}/requeue [post]
+func RequeueDeadLetterHandler(ctx *gin.Context) {
+	letter, ok := findDeadLetter(ctx)
+	if !ok {
+		return
+	}
+	stored := nats.NewMsg(letter.Subject)
+	stored.Data = letter.Payload
+	if letter.Headers != "" {
+		json.Unmarshal([]byte(letter.Headers), &stored.Header)
+	}
+	// only letters that still verify are sealed again with a fresh Event-Time and Event-Id
+	opened, err := eventCrypto.open(stored)
+	if err != nil {
+		WriteJSON(ctx, http.StatusConflict, gin.H{
+			"status":  false,
+			"type":    ErrCodeDeadLetterUnverifiable,
+			"message": err.Error(),
+		})
+		return
+	}
+	msg := nats.NewMsg(letter.Subject)
+	msg.Data = opened.Data
+	for key, values := range opened.Header {
+		switch key {
+		case "Event-Signature", "Event-Signer", "Event-Encryption", "Event-Key-Id":
+			continue
+		}
+		msg.Header[key] = values
+	}
+	msg.Header.Set("Event-Time", time.Now().UTC().Format(time.RFC3339Nano))
+	msg.Header.Set(eventIdHeader, randomHex(16))
+	msg.Header.Set("Requeued-From", strconv.FormatUint(uint64(letter.ID), 10))
+	if err := eventCrypto.Seal(msg); err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeDeadLetterRequeue,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := nc.PublishMsg(msg); err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeDeadLetterRequeue,
+			"message": err.Error(),
+		})
+		return
+	}
+	now := utcNow()
+	letter.RequeuedAt = &now
+	if err := db.Model(letter).Update("requeued_at", now).Error; err != nil {
+		LogError("Error marking dead letter requeued", LogFields{"dead_letter_id": letter.ID, "error": err})
+	}
+	LogInfo("Dead letter requeued", LogFields{"dead_letter_id": letter.ID, "subject": letter.Subject, "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"dead_letter": ToDeadLetterDto(*letter),
+	})
+}
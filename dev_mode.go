@@ -0,0 +1,97 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+/**
+*	Developer Mode
+*	DEV_MODE=true runs the boilerplate end-to-end with zero external services:
+*	- SQLite database at DEV_SQLITE_PATH (default dev.db) instead of postgres
+*	- embedded NATS server with JetStream when NATS_URL is empty
+*	- fake authenticated user from X-Dev-User header (default "dev-user")
+*	- dev profile of APP_ENV when APP_ENV is empty (debug logs, gin debug mode)
+*	Never enable in production, any client can impersonate any user. The
+*	server refuses to start in DEV_MODE with APP_ENV=prod, GIN_MODE=release
+*	or a listener that is not loopback (tcp binds 127.0.0.1 in DEV_MODE).
+*/
+const devUserHeader = "X-Dev-User"
+
+func devMode() bool {
+	return os.Getenv("DEV_MODE") == "true"
+}
+
+// ApplyDevModeDefaults : refuses DEV_MODE in production settings
+func ApplyDevModeDefaults() error {
+	if !devMode() {
+		return nil
+	}
+	if strings.ToLower(os.Getenv("APP_ENV")) == "prod" || os.Getenv("GIN_MODE") == gin.ReleaseMode {
+		return errors.New("DEV_MODE=true is refused with APP_ENV=prod or GIN_MODE=release")
+	}
+	LogWarn("DEV_MODE enabled, do not use in production", LogFields{})
+	return nil
+}
+
+// CheckDevModeListener : refuses DEV_MODE on tcp listeners reachable from other hosts
+func CheckDevModeListener(listener net.Listener) error {
+	if !devMode() {
+		return nil
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		return errors.New("DEV_MODE=true is refused on non-loopback listener " + addr.String())
+	}
+	return nil
+}
+
+func devSqlitePath() string {
+	if path := os.Getenv("DEV_SQLITE_PATH"); path != "" {
+		return path
+	}
+	return "dev.db"
+}
+
+// StartEmbeddedNats : in-process NATS server with JetStream, returns client url
+func StartEmbeddedNats() (string, error) {
+	natsServer, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  filepath.Join(os.TempDir(), "alyafn-dev-nats"),
+		NoSigs:    true,
+	})
+	if err != nil {
+		return "", err
+	}
+	go natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		return "", errors.New("embedded nats server not ready")
+	}
+	LogInfo("Embedded NATS server started", LogFields{"url": natsServer.ClientURL()})
+	return natsServer.ClientURL(), nil
+}
+
+// DevAuthMiddleware : sets fake user of X-Dev-User header in dev mode
+func DevAuthMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if devMode() {
+			user := ctx.GetHeader(devUserHeader)
+			if user == "" {
+				user = "dev-user"
+			}
+			ctx.Set(userIdKey, user)
+		}
+		ctx.Next()
+	}
+}
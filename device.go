@@ -0,0 +1,124 @@
+package main
+
+import (
+	// system packages
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Device Parsing
+*	DeviceMiddleware parses User-Agent once per request into device class
+*	(desktop, mobile, tablet, bot, other), os and browser family, read with
+*	Device(ctx). Analytics events and panic logs carry these instead of raw
+*	user agent strings. Devices per user (first/last seen, admin
+*	breakdown) wait for users and auth (README TODO).
+*/
+const deviceKey = "device"
+
+type DeviceInfo struct {
+	Device  string `json:"device" example:"mobile"`
+	OS      string `json:"os" example:"iOS"`
+	Browser string `json:"browser" example:"Safari"`
+}
+
+// userAgentRule : first rule whose token is in user agent wins, order matters
+type userAgentRule struct {
+	token string
+	name  string
+}
+
+var (
+	botTokens = []string{"bot", "crawler", "spider", "slurp", "curl/", "wget/", "python-requests", "go-http-client", "headless"}
+	osRules   = []userAgentRule{
+		{"windows nt", "Windows"},
+		{"iphone", "iOS"},
+		{"ipad", "iOS"},
+		{"ipod", "iOS"},
+		{"android", "Android"},
+		{"cros", "ChromeOS"},
+		{"mac os x", "macOS"},
+		{"macintosh", "macOS"},
+		{"linux", "Linux"},
+	}
+	// chromium based browsers also send Chrome/ and Safari/, Chrome sends Safari/
+	browserRules = []userAgentRule{
+		{"edg/", "Edge"},
+		{"edga/", "Edge"},
+		{"edgios/", "Edge"},
+		{"opr/", "Opera"},
+		{"samsungbrowser/", "Samsung Internet"},
+		{"yabrowser/", "Yandex"},
+		{"firefox/", "Firefox"},
+		{"fxios/", "Firefox"},
+		{"crios/", "Chrome"},
+		{"chrome/", "Chrome"},
+		{"version/", "Safari"},
+		{"curl/", "curl"},
+	}
+)
+
+func matchUserAgentRule(ua string, rules []userAgentRule) string {
+	for _, rule := range rules {
+		if strings.Contains(ua, rule.token) {
+			return rule.name
+		}
+	}
+	return "Other"
+}
+
+// ParseUserAgent : device class, os and browser family of user agent
+func ParseUserAgent(userAgent string) DeviceInfo {
+	ua := strings.ToLower(userAgent)
+	info := DeviceInfo{
+		OS:      matchUserAgentRule(ua, osRules),
+		Browser: matchUserAgentRule(ua, browserRules),
+	}
+	if info.Browser == "Safari" && !strings.Contains(ua, "safari/") {
+		// Version/ without Safari/ is an in-app webview
+		info.Browser = "Other"
+	}
+	switch {
+	case ua == "":
+		info.Device = "other"
+	case containsAny(ua, botTokens):
+		info.Device = "bot"
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		info.OS == "Android" && !strings.Contains(ua, "mobile"):
+		info.Device = "tablet"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		info.Device = "mobile"
+	case info.OS == "Windows" || info.OS == "macOS" || info.OS == "Linux" || info.OS == "ChromeOS":
+		info.Device = "desktop"
+	default:
+		info.Device = "other"
+	}
+	return info
+}
+
+func containsAny(value string, tokens []string) bool {
+	for _, token := range tokens {
+		if strings.Contains(value, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Device : parsed user agent of current request
+func Device(ctx *gin.Context) DeviceInfo {
+	if value, ok := ctx.Get(deviceKey); ok {
+		return value.(DeviceInfo)
+	}
+	return ParseUserAgent(ctx.Request.UserAgent())
+}
+
+// DeviceMiddleware : parses user agent of request once
+func DeviceMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(deviceKey, ParseUserAgent(ctx.Request.UserAgent()))
+		ctx.Next()
+	}
+}
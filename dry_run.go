@@ -0,0 +1,45 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"strconv"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Dry Run
+*	Write endpoints accept ?dry_run=true or Prefer: handling=validate-only
+*	header to run binding, validation and business checks (target exists,
+*	quota) without saving or publishing events. Response is the usual error
+*	envelope on failure or 200 with "dry_run": true and the record that
+*	would be written. Policy rate rules are only read, not counted, so form
+*	validation does not eat posting quota.
+*/
+const dryRunPreference = "handling=validate-only"
+
+// IsDryRun : true when request asks for validation only
+func IsDryRun(ctx *gin.Context) bool {
+	if dryRun, err := strconv.ParseBool(ctx.Query("dry_run")); err == nil {
+		return dryRun
+	}
+	for _, preference := range strings.Split(ctx.GetHeader("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(preference), dryRunPreference) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDryRun : answers dry run with what would happen, response fields are merged in
+func writeDryRun(ctx *gin.Context, response gin.H) {
+	if ctx.GetHeader("Prefer") != "" {
+		ctx.Header("Preference-Applied", dryRunPreference)
+	}
+	response["status"] = true
+	response["dry_run"] = true
+	WriteJSON(ctx, http.StatusOK, response)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Request DTOs (single source of truth)
+*	Request constraints live only on DTO struct tags:
+*	- json     : field name for binding, error keys and swagger
+*	- validate : validation rules, also read by swag for docs (required,
+*	             min/max -> minLength/maxLength, oneof -> enum)
+*	- example  : swagger example value
+*	GORM models carry storage tags only (no validate) so rules do not drift
+*	between model, validator and docs. Handlers bind with BindDto and
+*	document body with @Param body body <Dto> true.
+*	Query params bind the same way with BindQuery into structs with form
+*	tags (form:"page,default=1") next to json and validate tags.
+*/
+
+// BindDto : binds json body into dto and validates it, writes error envelope
+// typed "<area>/request-body" or "<area>/validation" and returns error on failure
+func BindDto(ctx *gin.Context, dto interface{}, area string) error {
+	if err := ctx.ShouldBindJSON(dto); err != nil {
+		if isBodyTooLarge(err) {
+			abortBodyTooLarge(ctx, getEnvInt64("APP_MAX_JSON_BODY", defaultMaxJsonBody))
+			return err
+		}
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixRequestBody,
+			"message": err.Error(),
+		})
+		return err
+	}
+	if err := validate.Struct(dto); err != nil {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixValidation,
+			"message": err.Error(),
+			"errors":  ValidationErrors(err),
+		})
+		return err
+	}
+	return nil
+}
+
+// BindQuery : binds query params into dto and validates it, writes error envelope
+// typed "<area>/query-params" and returns error on failure
+func BindQuery(ctx *gin.Context, dto interface{}, area string) error {
+	err := ctx.ShouldBindQuery(dto)
+	if err == nil {
+		err = validate.Struct(dto)
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixQueryParams,
+			"message": err.Error(),
+			"errors":  ValidationErrors(err),
+		})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	// system packages
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	// page cacher (used as shared state store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Entity Cache
+*	Read-through cache for single row lookups (post by public id) stored as
+*	"entity:v<schema>:<kind>:<generation>:<id>" in the shared store (redis
+*	when REDIS_HOST is set). Model hooks drop the key of the changed row in
+*	AfterUpdate/AfterDelete, bulk updates without a loaded row bump the
+*	generation of the kind so every cached row of it misses. Bump
+*	entityCacheSchema when a cached struct changes shape. TTL is
+*	ENTITY_CACHE_TTL (default 5m). Hit ratio per kind is exported as
+*	entity_cache_hit_ratio on /_/metrics.
+*/
+const entityCacheSchema = 3
+
+var entityCacheRequests = NewCounter(
+	"entity_cache_requests_total",
+	"Entity cache lookups by kind and result (hit, miss).",
+	"kind", "result",
+)
+
+var entityCacheHitRatio = NewGauge(
+	"entity_cache_hit_ratio",
+	"Entity cache hits divided by lookups since start by kind.",
+	"kind",
+)
+
+type EntityCache struct {
+	store persistence.CacheStore
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	stats map[string]*[2]float64 // kind -> hits, lookups
+}
+
+var entityCache *EntityCache
+
+func NewEntityCache(store persistence.CacheStore) *EntityCache {
+	c := &EntityCache{
+		store: store,
+		ttl:   getEnvDuration("ENTITY_CACHE_TTL", 5*time.Minute),
+		stats: map[string]*[2]float64{},
+	}
+	registerCollector(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for kind, stat := range c.stats {
+			entityCacheHitRatio.Set(stat[0]/stat[1], kind)
+		}
+	})
+	return c
+}
+
+func (c *EntityCache) generation(kind string) uint64 {
+	var generation uint64
+	if err := c.store.Get("entity_gen:"+kind, &generation); err != nil {
+		return 0
+	}
+	return generation
+}
+
+func (c *EntityCache) key(kind, id string) string {
+	return "entity:v" + strconv.Itoa(entityCacheSchema) + ":" + kind + ":" + strconv.FormatUint(c.generation(kind), 10) + ":" + id
+}
+
+func (c *EntityCache) record(kind string, hit bool) {
+	result := "miss"
+	c.mu.Lock()
+	stat, ok := c.stats[kind]
+	if !ok {
+		stat = &[2]float64{}
+		c.stats[kind] = stat
+	}
+	if hit {
+		stat[0]++
+		result = "hit"
+	}
+	stat[1]++
+	c.mu.Unlock()
+	entityCacheRequests.Inc(kind, result)
+}
+
+// Fetch : fills dest (pointer) from cache or from load, loaded rows are stored for ttl
+func (c *EntityCache) Fetch(kind, id string, dest interface{}, load func() error) error {
+	key := c.key(kind, id)
+	if err := c.store.Get(key, dest); err == nil {
+		c.record(kind, true)
+		return nil
+	}
+	c.record(kind, false)
+	if err := load(); err != nil {
+		return err
+	}
+	// stored by value, in-memory store hands the same value back to Get
+	if err := c.store.Set(key, reflect.ValueOf(dest).Elem().Interface(), c.ttl); err != nil {
+		LogWarn("Error storing entity cache", LogFields{"kind": kind, "error": err})
+	}
+	return nil
+}
+
+// Invalidate : drops cached row of kind, empty id drops all rows of kind, on other replicas too
+func (c *EntityCache) Invalidate(kind, id string) {
+	c.invalidateLocal(kind, id)
+	cacheSync.Broadcast(CacheInvalidation{Cache: "entity", Kind: kind, ID: id})
+}
+
+// kinds : kinds looked up through this instance
+func (c *EntityCache) kinds() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kinds := make([]string, 0, len(c.stats))
+	for kind := range c.stats {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// invalidateLocal : drops row or bumps kind generation in store of this instance
+func (c *EntityCache) invalidateLocal(kind, id string) {
+	if id != "" {
+		if err := c.store.Delete(c.key(kind, id)); err != nil && err != persistence.ErrCacheMiss {
+			LogWarn("Error invalidating entity cache", LogFields{"kind": kind, "id": id, "error": err})
+		}
+		return
+	}
+	key := "entity_gen:" + kind
+	if _, err := IncrementCounter(c.store, key, 1, persistence.FOREVER); err != nil {
+		LogWarn("Error invalidating entity cache", LogFields{"kind": kind, "error": err})
+	}
+}
+
+// invalidatePost : hooks run before entity cache is set up during migrations
+func invalidatePost(post *Post) {
+	if entityCache != nil {
+		entityCache.Invalidate("post", post.PublicID)
+	}
+}
+
+// AfterUpdate : drops cached post, bulk updates (no loaded row) drop all posts
+func (post *Post) AfterUpdate(tx *gorm.DB) error {
+	invalidatePost(post)
+	return nil
+}
+
+// AfterDelete : drops cached post, bulk deletes (no loaded row) drop all posts
+func (post *Post) AfterDelete(tx *gorm.DB) error {
+	invalidatePost(post)
+	return nil
+}
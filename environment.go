@@ -0,0 +1,193 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	App Environments
+*	APP_ENV=dev|staging|prod (default prod, DEV_MODE=true implies dev)
+*	selects a profile, explicit env values win over profile defaults:
+*	          gin mode  swagger  pprof  chaos  log level  access log  cors
+*	dev       debug     on       on     on     debug      on          any origin
+*	staging   release   on       on     on     info       on          CORS_ALLOWED_ORIGINS
+*	prod      release   off      off    off    warn       off         CORS_ALLOWED_ORIGINS
+*	Overrides: APP_SWAGGER, APP_PPROF, APP_CHAOS, APP_ACCESS_LOG (true|false)
+*	and APP_LOG_LEVEL. pprof is served at /debug/pprof behind admin auth,
+*	chaos faults at /v1/_/chaos (see chaos.go), prod never enables chaos.
+*	Global middleware stack of every environment is declared once in
+*	GlobalMiddlewares. Listed CORS origins may send credentials, * in
+*	CORS_ALLOWED_ORIGINS answers other origins with a literal * and no
+*	credentials (refused in prod).
+*/
+type EnvProfile struct {
+	Name    string
+	GinMode string
+	Swagger bool
+	Pprof   bool
+	// Chaos : fault injection routes of resilience drills, never on in prod
+	Chaos     bool
+	LogLevel  string
+	AccessLog bool
+	// CorsAnyOrigin : echo every Origin (dev only), otherwise CORS_ALLOWED_ORIGINS
+	CorsAnyOrigin bool
+}
+
+var envProfiles = map[string]EnvProfile{
+	"dev":     {Name: "dev", GinMode: gin.DebugMode, Swagger: true, Pprof: true, Chaos: true, LogLevel: "debug", AccessLog: true, CorsAnyOrigin: true},
+	"staging": {Name: "staging", GinMode: gin.ReleaseMode, Swagger: true, Pprof: true, Chaos: true, LogLevel: "info", AccessLog: true},
+	"prod":    {Name: "prod", GinMode: gin.ReleaseMode, LogLevel: "warn"},
+}
+
+// appEnv : profile of APP_ENV applied by ApplyEnvProfile
+var appEnv = envProfiles["prod"]
+
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// ApplyEnvProfile : selects profile of APP_ENV and applies gin mode and log level defaults
+func ApplyEnvProfile() error {
+	name := strings.ToLower(os.Getenv("APP_ENV"))
+	if name == "" && devMode() {
+		name = "dev"
+	}
+	if name == "" {
+		name = "prod"
+	}
+	profile, ok := envProfiles[name]
+	if !ok {
+		return errors.New("APP_ENV must be dev, staging or prod: " + name)
+	}
+	profile.Swagger = envBool("APP_SWAGGER", profile.Swagger)
+	profile.Pprof = envBool("APP_PPROF", profile.Pprof)
+	profile.AccessLog = envBool("APP_ACCESS_LOG", profile.AccessLog)
+	profile.Chaos = envBool("APP_CHAOS", profile.Chaos) && profile.Name != "prod"
+	if os.Getenv("APP_LOG_LEVEL") == "" {
+		os.Setenv("APP_LOG_LEVEL", profile.LogLevel)
+	}
+	if profile.Name == "prod" {
+		for _, origin := range splitList(os.Getenv("CORS_ALLOWED_ORIGINS")) {
+			if origin == "*" {
+				return errors.New("CORS_ALLOWED_ORIGINS can not be * in prod")
+			}
+		}
+	}
+	gin.SetMode(profile.GinMode)
+	appEnv = profile
+	return nil
+}
+
+/**
+*	GlobalMiddlewares : middleware stack in order, entries built with When
+*	are left out unless their profile switch is on.
+*/
+func GlobalMiddlewares(entries ...MiddlewareEntry) []gin.HandlerFunc {
+	handlers := []gin.HandlerFunc{}
+	for _, entry := range entries {
+		if entry.Enabled {
+			handlers = append(handlers, entry.Handler)
+		}
+	}
+	return handlers
+}
+
+type MiddlewareEntry struct {
+	Enabled bool
+	Handler gin.HandlerFunc
+}
+
+// Always : entry for every environment
+func Always(handler gin.HandlerFunc) MiddlewareEntry {
+	return MiddlewareEntry{Enabled: true, Handler: handler}
+}
+
+// When : entry only when enabled by profile
+func When(enabled bool, handler gin.HandlerFunc) MiddlewareEntry {
+	return MiddlewareEntry{Enabled: enabled, Handler: handler}
+}
+
+// corsExposedHeaders : response headers browser clients may read
+var corsExposedHeaders = strings.Join([]string{
+	requestIdHeader, "X-Cache", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-RateLimit-Warning", "Retry-After", "Preference-Applied",
+}, ", ")
+
+// CORSMiddleware : answers preflights and sets CORS headers for allowed origins
+func CORSMiddleware(profile EnvProfile) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, origin := range splitList(os.Getenv("CORS_ALLOWED_ORIGINS")) {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+	// * is public read access, cookies of the client are never allowed with it
+	publicOrigin := allowed["*"]
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		if origin == "" {
+			ctx.Next()
+			return
+		}
+		preflight := ctx.Request.Method == http.MethodOptions && ctx.GetHeader("Access-Control-Request-Method") != ""
+		credentials := profile.CorsAnyOrigin || allowed[origin]
+		if !credentials && !publicOrigin {
+			if preflight {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"status":  false,
+					"type":    ErrCodeRequestCorsOrigin,
+					"message": "Origin is not allowed.",
+				})
+				return
+			}
+			// browser blocks reading the response without allow headers
+			ctx.Next()
+			return
+		}
+		header := ctx.Writer.Header()
+		if credentials {
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+		header.Add("Vary", "Origin")
+		header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		if preflight {
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Analytics-Key, Prefer, Accept-Language, "+requestIdHeader+", "+traceparentHeader)
+			header.Set("Access-Control-Max-Age", "600")
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// PprofHandler : net/http/pprof under /debug/pprof/*name
+func PprofHandler(ctx *gin.Context) {
+	switch name := strings.TrimPrefix(ctx.Param("name"), "/"); name {
+	case "":
+		pprof.Index(ctx.Writer, ctx.Request)
+	case "cmdline":
+		pprof.Cmdline(ctx.Writer, ctx.Request)
+	case "profile":
+		pprof.Profile(ctx.Writer, ctx.Request)
+	case "symbol":
+		pprof.Symbol(ctx.Writer, ctx.Request)
+	case "trace":
+		pprof.Trace(ctx.Writer, ctx.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
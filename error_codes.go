@@ -0,0 +1,311 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Error Codes Catalog
+*	Every "type" of the error envelope {"status":false,"type":...,"message":...}
+*	is listed here with its http statuses and meaning, served at
+*	GET /post/_/errors for SDK generators and support tooling. Codes built
+*	from an area (BindDto, BindQuery, repost/quote helpers) are expanded per
+*	area. In debug mode ErrorCodeGuardMiddleware warns about error
+*	responses whose type is missing here. Handlers write the ErrCode* and
+*	ErrArea* + ErrSuffix* constants below, add new codes here first.
+*/
+type ErrorCode struct {
+	Code        string `json:"code"`
+	Statuses    []int  `json:"statuses"`
+	Description string `json:"description"`
+}
+
+// ErrCode* : error envelope types with a fixed code
+const (
+	ErrCodeRequestBodyTooLarge    = "request/body-too-large"
+	ErrCodeRequestIpDenied        = "request/ip-denied"
+	ErrCodeRequestCorsOrigin      = "request/cors-origin"
+	ErrCodeRequestNotFound        = "request/not-found"
+	ErrCodeServerMaintenance      = "server/maintenance"
+	ErrCodeServerOverloaded       = "server/overloaded"
+	ErrCodeServerPanic            = "server/panic"
+	ErrCodeDbPoolExhausted        = "db/pool-exhausted"
+	ErrCodeTransactionBegin       = "transaction/begin"
+	ErrCodeTransactionCommit      = "transaction/commit"
+	ErrCodeDbBackupUnsupported    = "db-backup/unsupported"
+	ErrCodeDbBackupFailed         = "db-backup/failed"
+	ErrCodeAuthCsrf               = "auth/csrf"
+	ErrCodeAuthSignature          = "auth/signature"
+	ErrCodePolicyQuotaExceeded    = "policy/quota-exceeded"
+	ErrCodeChaosInjectedError     = "chaos/injected-error"
+	ErrCodeChaosNotFound          = "chaos/not-found"
+	ErrCodeLeaderBackend          = "leader/backend"
+	ErrCodeSpamNotFound           = "spam/not-found"
+	ErrCodeSpamQuery              = "spam/query"
+	ErrCodeSpamSave               = "spam/save"
+	ErrCodeCreatePostSave         = "create-post/save"
+	ErrCodeGetPostsQuery          = "get-posts/query"
+	ErrCodeUploadRequestBody      = "upload/request-body"
+	ErrCodeUploadInfected         = "upload/infected"
+	ErrCodeUploadScanUnavailable  = "upload/scan-unavailable"
+	ErrCodeAnalyticsKey           = "analytics/key"
+	ErrCodeAdminStatsQuery        = "admin-stats/query"
+	ErrCodePostStatsNotFound      = "post-stats/not-found"
+	ErrCodePostStatsQuery         = "post-stats/query"
+	ErrCodeMaintenanceSave        = "maintenance/save"
+	ErrCodeIpFilterNotFound       = "ip-filter/not-found"
+	ErrCodeMailTestQueue          = "mail-test/queue"
+	ErrCodeDeadLetterNotFound     = "dead-letter/not-found"
+	ErrCodeDeadLetterQuery        = "dead-letter/query"
+	ErrCodeDeadLetterRequeue      = "dead-letter/requeue"
+	ErrCodeDeadLetterUnverifiable = "dead-letter/unverifiable"
+	ErrCodeExportQueryParams      = "export/query-params"
+	ErrCodeImportQueryParams      = "import/query-params"
+	ErrCodeImportRequestBody      = "import/request-body"
+	ErrCodeImportSave             = "import/save"
+	ErrCodeImportQueue            = "import/queue"
+	ErrCodeImportNotFound         = "import/not-found"
+	ErrCodeImportQuery            = "import/query"
+	ErrCodePatchContentType       = "patch/content-type"
+	ErrCodePatchInvalid           = "patch/invalid"
+	ErrCodePatchTestFailed        = "patch/test-failed"
+	ErrCodeTosAcceptanceRequired  = "tos/acceptance-required"
+	ErrCodeTosNotFound            = "tos/not-found"
+	ErrCodeTosOutdatedVersion     = "tos/outdated-version"
+	ErrCodeTosQuery               = "tos/query"
+	ErrCodeTosSave                = "tos/save"
+	ErrCodeTosUnauthenticated     = "tos/unauthenticated"
+	ErrCodeTosVersionExists       = "tos/version-exists"
+	ErrCodeTrendingQuery          = "trending/query"
+	ErrCodeTranslateEmpty         = "translate/empty"
+	ErrCodeTranslateNotFound      = "translate/not-found"
+	ErrCodeTranslateQuery         = "translate/query"
+	ErrCodeTranslateUnavailable   = "translate/unavailable"
+	ErrCodeUpdatePostNotFound     = "update-post/not-found"
+	ErrCodeUpdatePostNotEditable  = "update-post/not-editable"
+	ErrCodeUpdatePostQuery        = "update-post/query"
+	ErrCodeUpdatePostSave         = "update-post/save"
+)
+
+// ErrArea* : areas of codes built by BindDto, BindQuery, ApplyPatch,
+// CheckSpam and the repost/quote helpers as "<area>" + ErrSuffix*
+const (
+	ErrAreaCreatePost  = "create-post"
+	ErrAreaGetPosts    = "get-posts"
+	ErrAreaUpdatePost  = "update-post"
+	ErrAreaQuote       = "quote"
+	ErrAreaRepost      = "repost"
+	ErrAreaAnalytics   = "analytics"
+	ErrAreaMaintenance = "maintenance"
+	ErrAreaIpFilter    = "ip-filter"
+	ErrAreaMailTest    = "mail-test"
+	ErrAreaTos         = "tos"
+	ErrAreaChaos       = "chaos"
+	ErrAreaDeadLetter  = "dead-letter"
+	ErrAreaTranslate   = "translate"
+	ErrAreaTrending    = "trending"
+)
+
+// ErrSuffix* : code endings appended to an ErrArea*
+const (
+	ErrSuffixRequestBody  = "/request-body"
+	ErrSuffixValidation   = "/validation"
+	ErrSuffixQueryParams  = "/query-params"
+	ErrSuffixNotFound     = "/not-found"
+	ErrSuffixNotShareable = "/not-shareable"
+	ErrSuffixQuery        = "/query"
+	ErrSuffixSave         = "/save"
+	ErrSuffixSpam         = "/spam"
+)
+
+// bodyErrorCodes : codes written by BindDto for area
+func bodyErrorCodes(area string) []ErrorCode {
+	return []ErrorCode{
+		{area + ErrSuffixRequestBody, []int{http.StatusBadRequest}, "Body is not valid json for the endpoint."},
+		{area + ErrSuffixValidation, []int{http.StatusBadRequest}, "Body failed validation, errors has messages per field."},
+	}
+}
+
+// queryErrorCodes : codes written by BindQuery for area
+func queryErrorCodes(area string) []ErrorCode {
+	return []ErrorCode{
+		{area + ErrSuffixQueryParams, []int{http.StatusBadRequest}, "Query params are malformed or fail validation, errors has messages per field."},
+	}
+}
+
+// shareErrorCodes : codes of repost and quote endpoints
+func shareErrorCodes(area string) []ErrorCode {
+	return []ErrorCode{
+		{area + ErrSuffixNotFound, []int{http.StatusNotFound}, "Post to share does not exist."},
+		{area + ErrSuffixNotShareable, []int{http.StatusUnprocessableEntity}, "Only public posts can be shared."},
+		{area + ErrSuffixQuery, []int{http.StatusInternalServerError}, "Post to share could not be read."},
+		{area + ErrSuffixSave, []int{http.StatusUnprocessableEntity}, "Share could not be saved."},
+	}
+}
+
+var errorCatalog = buildErrorCatalog(
+	[]ErrorCode{
+		{ErrCodeRequestBodyTooLarge, []int{http.StatusRequestEntityTooLarge}, "Request body exceeds the limit of the route."},
+		{ErrCodeRequestIpDenied, []int{http.StatusForbidden}, "Client ip is blocked by ip allow/deny lists."},
+		{ErrCodeRequestCorsOrigin, []int{http.StatusForbidden}, "Preflight from an origin missing in CORS_ALLOWED_ORIGINS."},
+		{ErrCodeRequestNotFound, []int{http.StatusNotFound}, "No route matches method and path."},
+		{ErrCodeServerMaintenance, []int{http.StatusServiceUnavailable}, "Maintenance mode is on, retry after Retry-After."},
+		{ErrCodeDbPoolExhausted, []int{http.StatusServiceUnavailable}, "Database pool saturated, read shed until Retry-After."},
+		{ErrCodeServerOverloaded, []int{http.StatusServiceUnavailable}, "Too many requests in flight, retry after Retry-After."},
+		{ErrCodeServerPanic, []int{http.StatusInternalServerError}, "Unexpected error, request_id identifies the report."},
+		{ErrCodeTransactionBegin, []int{http.StatusInternalServerError}, "Database transaction of write request could not be started."},
+		{ErrCodeDbBackupUnsupported, []int{http.StatusNotImplemented}, "Online backup endpoint needs the sqlite driver."},
+		{ErrCodeDbBackupFailed, []int{http.StatusInternalServerError}, "Sqlite snapshot could not be written."},
+		{ErrCodeTransactionCommit, []int{http.StatusInternalServerError}, "Changes of write request could not be committed, nothing was saved."},
+		{ErrCodeAuthCsrf, []int{http.StatusForbidden, http.StatusInternalServerError}, "Missing or invalid X-CSRF-Token header in cookie auth mode."},
+		{ErrCodeAuthSignature, []int{http.StatusUnauthorized}, "Service signature of internal call is missing, expired or invalid."},
+		{ErrCodePolicyQuotaExceeded, []int{http.StatusTooManyRequests}, "Business limit of rule is reached, rule names the limit."},
+		{ErrAreaCreatePost + ErrSuffixSpam, []int{http.StatusUnprocessableEntity}, "Post scored as spam and was rejected."},
+		{ErrAreaQuote + ErrSuffixSpam, []int{http.StatusUnprocessableEntity}, "Quote scored as spam and was rejected."},
+		{ErrCodeChaosInjectedError, []int{http.StatusInternalServerError, http.StatusServiceUnavailable}, "Failure injected by an active chaos fault (dev and staging drills), status is the one of the fault."},
+		{ErrCodeChaosNotFound, []int{http.StatusNotFound}, "No active chaos fault with that id."},
+		{ErrCodeLeaderBackend, []int{http.StatusServiceUnavailable}, "Current leader could not be read from the lock backend."},
+		{ErrCodeSpamNotFound, []int{http.StatusNotFound}, "No held post with that id or decision is not approve/reject."},
+		{ErrCodeSpamQuery, []int{http.StatusInternalServerError}, "Held posts could not be read."},
+		{ErrCodeSpamSave, []int{http.StatusInternalServerError}, "Review decision could not be saved."},
+		{ErrCodeCreatePostSave, []int{http.StatusUnprocessableEntity}, "Post could not be saved."},
+		{ErrCodeGetPostsQuery, []int{http.StatusInternalServerError}, "Posts could not be read."},
+		{ErrCodeUploadRequestBody, []int{http.StatusBadRequest}, "Upload body could not be read."},
+		{ErrCodeUploadInfected, []int{http.StatusUnprocessableEntity}, "Uploaded file is flagged by malware scanner."},
+		{ErrCodeUploadScanUnavailable, []int{http.StatusServiceUnavailable}, "Malware scanner is unreachable, retry later."},
+		{ErrCodeAnalyticsKey, []int{http.StatusUnauthorized}, "Missing or unknown X-Analytics-Key header."},
+		{ErrCodeAdminStatsQuery, []int{http.StatusInternalServerError}, "Dashboard stats could not be read."},
+		{ErrCodePostStatsNotFound, []int{http.StatusNotFound}, "Post does not exist."},
+		{ErrCodePostStatsQuery, []int{http.StatusInternalServerError}, "Post stats could not be read."},
+		{ErrCodeMaintenanceSave, []int{http.StatusInternalServerError}, "Maintenance state could not be stored."},
+		{ErrCodeIpFilterNotFound, []int{http.StatusNotFound}, "No ip filter with that name."},
+		{ErrCodeMailTestQueue, []int{http.StatusInternalServerError}, "Test mail could not be rendered or queued."},
+		{ErrCodeDeadLetterNotFound, []int{http.StatusNotFound}, "Dead letter does not exist."},
+		{ErrCodeDeadLetterQuery, []int{http.StatusInternalServerError}, "Dead letters could not be read."},
+		{ErrCodeDeadLetterRequeue, []int{http.StatusInternalServerError}, "Dead letter could not be published again."},
+		{ErrCodeDeadLetterUnverifiable, []int{http.StatusConflict}, "Dead letter fails signature or decryption checks, it is not published again."},
+		{ErrCodeExportQueryParams, []int{http.StatusBadRequest}, "Unknown export format or field."},
+		{ErrCodeImportQueryParams, []int{http.StatusBadRequest}, "Import format must be jsonl or csv."},
+		{ErrCodeImportRequestBody, []int{http.StatusBadRequest}, "Import file is empty or could not be read."},
+		{ErrCodeImportSave, []int{http.StatusInternalServerError}, "Import job could not be saved."},
+		{ErrCodeImportQueue, []int{http.StatusInternalServerError}, "Import job could not be queued."},
+		{ErrCodeImportNotFound, []int{http.StatusNotFound}, "Import job does not exist."},
+		{ErrCodeImportQuery, []int{http.StatusInternalServerError}, "Import job could not be read."},
+		{ErrCodePatchContentType, []int{http.StatusUnsupportedMediaType}, "PATCH body must be merge patch or json patch."},
+		{ErrCodePatchInvalid, []int{http.StatusBadRequest}, "Patch is malformed, an op failed or result has unknown fields."},
+		{ErrCodePatchTestFailed, []int{http.StatusConflict}, "A json patch test op did not match current state."},
+		{ErrCodeTosAcceptanceRequired, []int{http.StatusUnavailableForLegalReasons}, "Current terms of service (tos_version, tos_url) are not accepted by the user."},
+		{ErrCodeTosNotFound, []int{http.StatusNotFound}, "No terms of service version is published."},
+		{ErrCodeTosOutdatedVersion, []int{http.StatusConflict}, "Accepted version is not the current terms of service."},
+		{ErrCodeTosQuery, []int{http.StatusInternalServerError}, "Terms of service could not be read."},
+		{ErrCodeTosSave, []int{http.StatusInternalServerError}, "Terms of service or acceptance could not be saved."},
+		{ErrCodeTosUnauthenticated, []int{http.StatusUnauthorized}, "Terms of service can only be accepted by authenticated users."},
+		{ErrCodeTosVersionExists, []int{http.StatusConflict}, "Terms of service version is already published."},
+		{ErrCodeTrendingQuery, []int{http.StatusInternalServerError}, "Trending posts could not be read."},
+		{ErrCodeTranslateEmpty, []int{http.StatusUnprocessableEntity}, "Post has no body to translate (reposts)."},
+		{ErrCodeTranslateNotFound, []int{http.StatusNotFound}, "Post does not exist."},
+		{ErrCodeTranslateQuery, []int{http.StatusInternalServerError}, "Post or translation could not be read."},
+		{ErrCodeTranslateUnavailable, []int{http.StatusServiceUnavailable}, "Translation is disabled or provider failed."},
+		{ErrCodeUpdatePostNotFound, []int{http.StatusNotFound}, "Post does not exist."},
+		{ErrCodeUpdatePostNotEditable, []int{http.StatusUnprocessableEntity}, "Reposts can not be edited."},
+		{ErrCodeUpdatePostQuery, []int{http.StatusInternalServerError}, "Post could not be read."},
+		{ErrCodeUpdatePostSave, []int{http.StatusInternalServerError}, "Post could not be saved."},
+		{ErrAreaUpdatePost + ErrSuffixValidation, []int{http.StatusBadRequest}, "Patched post failed validation, errors has messages per field."},
+	},
+	bodyErrorCodes(ErrAreaCreatePost),
+	bodyErrorCodes(ErrAreaQuote),
+	bodyErrorCodes(ErrAreaAnalytics),
+	bodyErrorCodes(ErrAreaMaintenance),
+	bodyErrorCodes(ErrAreaIpFilter),
+	bodyErrorCodes(ErrAreaMailTest),
+	bodyErrorCodes(ErrAreaTos),
+	bodyErrorCodes(ErrAreaChaos),
+	queryErrorCodes(ErrAreaGetPosts),
+	queryErrorCodes(ErrAreaDeadLetter),
+	queryErrorCodes(ErrAreaTranslate),
+	queryErrorCodes(ErrAreaTrending),
+	shareErrorCodes(ErrAreaRepost),
+	shareErrorCodes(ErrAreaQuote),
+)
+
+// buildErrorCatalog : merges code lists sorted by code
+func buildErrorCatalog(lists ...[]ErrorCode) []ErrorCode {
+	catalog := []ErrorCode{}
+	for _, list := range lists {
+		catalog = append(catalog, list...)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Code < catalog[j].Code })
+	return catalog
+}
+
+func knownErrorCode(code string) bool {
+	i := sort.Search(len(errorCatalog), func(i int) bool { return errorCatalog[i].Code >= code })
+	return i < len(errorCatalog) && errorCatalog[i].Code == code
+}
+
+// ErrorCatalogHandler godoc
+// @Summary Error codes catalog
+// @Schemes
+// @Description All error envelope types with http statuses and descriptions
+// @Tags post-service-health
+// @Produce json
+// @Success 200 {object} object{errors=[]ErrorCode}
+// @Router /post/_/errors [get]
+func ErrorCatalogHandler(ctx *gin.Context) {
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"errors": errorCatalog,
+	})
+}
+
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write : keeps only bodies of error responses
+func (w *errorBodyWriter) Write(data []byte) (int, error) {
+	if w.Status() >= 400 {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorBodyWriter) WriteString(data string) (int, error) {
+	if w.Status() >= 400 {
+		w.body.WriteString(data)
+	}
+	return w.ResponseWriter.WriteString(data)
+}
+
+/**
+*	ErrorCodeGuardMiddleware : in debug mode warns when an error response
+*	carries a type that is not in errorCatalog. No-op in release mode.
+*/
+func ErrorCodeGuardMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !gin.IsDebugging() {
+			ctx.Next()
+			return
+		}
+		writer := &errorBodyWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+		if writer.body.Len() == 0 || !strings.HasPrefix(writer.Header().Get("Content-Type"), "application/json") {
+			return
+		}
+		var envelope struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(writer.body.Bytes(), &envelope) == nil && envelope.Type != "" && !knownErrorCode(envelope.Type) {
+			LogWarn("Error code missing from catalog", LogFields{"path": ctx.FullPath(), "type": envelope.Type, "request_id": RequestID(ctx)})
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	// system packages
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// page cacher (used as handled event id store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	Event Encryption and Signing
+*	Optional protection of events on shared brokers, both off by default:
+*	- EVENT_ENCRYPTION_KEY  : base64 32 byte AES-256-GCM key (secret), payload
+*	                          becomes nonce+ciphertext with Event-Encryption and
+*	                          Event-Key-Id headers. EVENT_DECRYPTION_KEYS lists
+*	                          old keys (comma separated) during rotation.
+*	- EVENT_SIGNING_KEY     : base64 32 byte Ed25519 seed (secret), adds
+*	                          Event-Signature and Event-Signer headers.
+*	- EVENT_VERIFY_KEYS     : comma separated base64 Ed25519 public keys
+*	                          accepted by workers, EVENT_REQUIRE_SIGNATURE=true
+*	                          rejects unsigned events.
+*	Signature and GCM additional data cover Event-Subject, Event-Time,
+*	Event-Id and the (encrypted) payload so events can not be moved to other
+*	subjects, dlq. and replay. prefixed copies still verify. Signed events
+*	on their own subject must also be fresh: Event-Time at most
+*	EVENT_MAX_AGE old (default 24h, outbox events of longer outages end in
+*	dead letters) and 1m ahead, and an Event-Id handled by a consumer is
+*	remembered in the cache store for that long (Handled/MarkHandled), so
+*	captured events can not be published again. SubscribeWorker opens
+*	events before its handler, rejected events go straight to dead letters.
+*/
+const (
+	eventEncryptionAlgorithm = "aes-256-gcm"
+	eventSubjectHeader       = "Event-Subject"
+	eventIdHeader            = "Event-Id"
+	eventFutureSkew          = time.Minute
+)
+
+var errEventStale = errors.New("event is outside of EVENT_MAX_AGE")
+
+type EventCrypto struct {
+	encryptKeyId string
+	encrypt      cipher.AEAD
+	decrypt      map[string]cipher.AEAD
+	signKeyId    string
+	sign         ed25519.PrivateKey
+	verify       map[string]ed25519.PublicKey
+	requireSign  bool
+	maxAge       time.Duration
+	// handled : event ids handled per consumer, nil until UseStore
+	handled persistence.CacheStore
+}
+
+var eventCrypto = &EventCrypto{}
+
+// eventKeyId : short fingerprint of key material used in headers
+func eventKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func decodeEventKey(name, value string, size int) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != size {
+		return nil, errors.New(name + " must be base64 of " + strconv.Itoa(size) + " bytes")
+	}
+	return key, nil
+}
+
+func newEventAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// InitEventCrypto : loads keys from secrets and env, empty keys disable the feature
+func InitEventCrypto() (*EventCrypto, error) {
+	crypto := &EventCrypto{
+		decrypt:     map[string]cipher.AEAD{},
+		verify:      map[string]ed25519.PublicKey{},
+		requireSign: os.Getenv("EVENT_REQUIRE_SIGNATURE") == "true",
+		maxAge:      getEnvDuration("EVENT_MAX_AGE", 24*time.Hour),
+	}
+	keys := splitList(secrets.Get("EVENT_DECRYPTION_KEYS"))
+	if current := secrets.Get("EVENT_ENCRYPTION_KEY"); current != "" {
+		keys = append([]string{current}, keys...)
+	}
+	for i, value := range keys {
+		key, err := decodeEventKey("EVENT_ENCRYPTION_KEY", value, 32)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newEventAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		crypto.decrypt[eventKeyId(key)] = aead
+		if i == 0 && secrets.Get("EVENT_ENCRYPTION_KEY") != "" {
+			crypto.encryptKeyId, crypto.encrypt = eventKeyId(key), aead
+		}
+	}
+	if seed := secrets.Get("EVENT_SIGNING_KEY"); seed != "" {
+		key, err := decodeEventKey("EVENT_SIGNING_KEY", seed, ed25519.SeedSize)
+		if err != nil {
+			return nil, err
+		}
+		crypto.sign = ed25519.NewKeyFromSeed(key)
+		public := crypto.sign.Public().(ed25519.PublicKey)
+		crypto.signKeyId = eventKeyId(public)
+		// own events always verify
+		crypto.verify[crypto.signKeyId] = public
+	}
+	for _, value := range splitList(os.Getenv("EVENT_VERIFY_KEYS")) {
+		key, err := decodeEventKey("EVENT_VERIFY_KEYS", value, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, err
+		}
+		crypto.verify[eventKeyId(key)] = ed25519.PublicKey(key)
+	}
+	if crypto.requireSign && len(crypto.verify) == 0 {
+		return nil, errors.New("EVENT_REQUIRE_SIGNATURE needs EVENT_VERIFY_KEYS or EVENT_SIGNING_KEY")
+	}
+	return crypto, nil
+}
+
+// UseStore : store remembering handled event ids (in-memory or redis)
+func (c *EventCrypto) UseStore(store persistence.CacheStore) {
+	c.handled = store
+}
+
+// signedEventContent : bytes covered by signature and gcm additional data
+func signedEventContent(msg *nats.Msg, data []byte) []byte {
+	content := msg.Header.Get(eventSubjectHeader) + "\n" + msg.Header.Get("Event-Time") + "\n" + msg.Header.Get(eventIdHeader) + "\n"
+	return append([]byte(content), data...)
+}
+
+// liveSignedEvent : signed event delivered on its own subject (not a dlq. or replay. copy)
+func liveSignedEvent(msg *nats.Msg) bool {
+	return msg.Header.Get("Event-Signature") != "" && msg.Subject == msg.Header.Get(eventSubjectHeader)
+}
+
+func (c *EventCrypto) handledKey(msg *nats.Msg, consumer string) string {
+	return "event_handled:" + consumer + ":" + msg.Header.Get(eventIdHeader)
+}
+
+// Handled : signed event was already handled by consumer (a replay)
+func (c *EventCrypto) Handled(msg *nats.Msg, consumer string) bool {
+	if c.handled == nil || !liveSignedEvent(msg) {
+		return false
+	}
+	var seen uint64
+	return c.handled.Get(c.handledKey(msg, consumer), &seen) == nil
+}
+
+// MarkHandled : remembers signed event of consumer for EVENT_MAX_AGE, older copies are stale anyway
+func (c *EventCrypto) MarkHandled(msg *nats.Msg, consumer string) {
+	if c.handled == nil || !liveSignedEvent(msg) {
+		return
+	}
+	if err := c.handled.Set(c.handledKey(msg, consumer), uint64(1), c.maxAge+eventFutureSkew); err != nil {
+		LogWarn("Error remembering handled event", LogFields{"subject": msg.Subject, "error": err})
+	}
+}
+
+// Seal : encrypts and signs msg in place (msg headers Event-Subject and Event-Time must be set)
+func (c *EventCrypto) Seal(msg *nats.Msg) error {
+	if c.encrypt != nil {
+		nonce := make([]byte, c.encrypt.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		msg.Header.Set("Event-Encryption", eventEncryptionAlgorithm)
+		msg.Header.Set("Event-Key-Id", c.encryptKeyId)
+		msg.Data = c.encrypt.Seal(nonce, nonce, msg.Data, signedEventContent(msg, nil))
+	}
+	if c.sign != nil {
+		msg.Header.Set("Event-Signer", c.signKeyId)
+		signature := ed25519.Sign(c.sign, signedEventContent(msg, msg.Data))
+		msg.Header.Set("Event-Signature", base64.StdEncoding.EncodeToString(signature))
+	}
+	return nil
+}
+
+// Open : verifies and decrypts msg, returns copy with plain data (msg is kept for dead letters)
+func (c *EventCrypto) Open(msg *nats.Msg) (*nats.Msg, error) {
+	opened, err := c.open(msg)
+	if err != nil {
+		return nil, err
+	}
+	if liveSignedEvent(msg) {
+		sent, err := time.Parse(time.RFC3339Nano, msg.Header.Get("Event-Time"))
+		if err != nil || msg.Header.Get(eventIdHeader) == "" {
+			return nil, errors.New("signed event needs Event-Time and Event-Id")
+		}
+		if age := time.Since(sent); age > c.maxAge || age < -eventFutureSkew {
+			return nil, errEventStale
+		}
+	}
+	return opened, nil
+}
+
+// open : Open without freshness, for dead letters published again
+func (c *EventCrypto) open(msg *nats.Msg) (*nats.Msg, error) {
+	subject := msg.Header.Get(eventSubjectHeader)
+	signature := msg.Header.Get("Event-Signature")
+	if (signature != "" || msg.Header.Get("Event-Encryption") != "") && !strings.HasSuffix(msg.Subject, subject) {
+		return nil, errors.New("event subject header does not match subject " + msg.Subject)
+	}
+	if signature != "" {
+		key, ok := c.verify[msg.Header.Get("Event-Signer")]
+		if !ok {
+			return nil, errors.New("unknown event signer " + msg.Header.Get("Event-Signer"))
+		}
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil || !ed25519.Verify(key, signedEventContent(msg, msg.Data), decoded) {
+			return nil, errors.New("invalid event signature")
+		}
+	} else if c.requireSign {
+		return nil, errors.New("unsigned event rejected")
+	}
+	opened := *msg
+	if algorithm := msg.Header.Get("Event-Encryption"); algorithm != "" {
+		aead, ok := c.decrypt[msg.Header.Get("Event-Key-Id")]
+		if algorithm != eventEncryptionAlgorithm || !ok {
+			return nil, errors.New("no key to decrypt event (" + algorithm + " " + msg.Header.Get("Event-Key-Id") + ")")
+		}
+		if len(msg.Data) < aead.NonceSize() {
+			return nil, errors.New("encrypted event is too short")
+		}
+		nonce, sealed := msg.Data[:aead.NonceSize()], msg.Data[aead.NonceSize():]
+		data, err := aead.Open(nil, nonce, sealed, signedEventContent(msg, nil))
+		if err != nil {
+			return nil, errors.New("event could not be decrypted: " + err.Error())
+		}
+		opened.Data = data
+	}
+	return &opened, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"time"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	PublishEvent : publishes data to subject with metadata headers
+*	(App-Version, App-Commit, Event-Time, Event-Id, Event-Subject) so consumers know
+*	which build emitted the event, encrypted and signed when keys are set
+*	(see event_crypto.go). While NATS is disconnected events are buffered
+*	(see publish_buffer.go). PublishEventContext also attaches request id
+*	and traceparent of ctx (see trace.go).
+*/
+func PublishEvent(subject string, data []byte) error {
+	return PublishEventContext(context.Background(), subject, data)
+}
+
+func PublishEventContext(ctx context.Context, subject string, data []byte) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set("App-Version", appVersion)
+	msg.Header.Set("App-Commit", gitCommit)
+	msg.Header.Set("Event-Time", time.Now().UTC().Format(time.RFC3339Nano))
+	msg.Header.Set(eventIdHeader, randomHex(16))
+	msg.Header.Set(eventSubjectHeader, subject)
+	setTraceHeaders(msg.Header.Set, TraceFrom(ctx))
+	if err := eventCrypto.Seal(msg); err != nil {
+		LogError("Error sealing event", LogFields{"subject": subject, "error": err})
+		return err
+	}
+	publish := nc.PublishMsg
+	if eventBuffer != nil {
+		publish = func(msg *nats.Msg) error { return eventBuffer.Publish(ctx, msg) }
+	}
+	if err := publish(msg); err != nil {
+		LogError("Error publishing event", LogFields{"subject": subject, "error": err})
+		return err
+	}
+	return nil
+}
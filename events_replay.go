@@ -0,0 +1,120 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	events replay : re-emits events stored in JetStream stream for consumers
+*	that need to rebuild state.
+*	./alyagofn events replay --subject alya.post.created.v1 --since 2024-01-01 [--rate 100] [--dry-run]
+*	Messages are published to --target (default "replay.<subject>") with
+*	Replay-Of-Seq header so replays never land in the source stream again.
+*/
+func init() {
+	registerCliCommand("events replay", CliCommand{
+		Usage: "re-emit events from JetStream stream (--subject --since --rate --dry-run)",
+		Run:   runEventsReplay,
+	})
+}
+
+func runEventsReplay(args []string) error {
+	flags := flag.NewFlagSet("events replay", flag.ContinueOnError)
+	subject := flags.String("subject", "", "subject to replay like alya.post.created.v1")
+	since := flags.String("since", "", "start time RFC3339 or YYYY-MM-DD")
+	stream := flags.String("stream", os.Getenv("NATS_EVENTS_STREAM"), "JetStream stream name (NATS_EVENTS_STREAM)")
+	target := flags.String("target", "", "publish subject (default replay.<subject>)")
+	rate := flags.Int("rate", 100, "max messages per second")
+	dryRun := flags.Bool("dry-run", false, "print messages without publishing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *subject == "" || *since == "" {
+		flags.Usage()
+		return errors.New("--subject and --since are required")
+	}
+	startTime, err := parseQueryTime(*since)
+	if err != nil {
+		return errors.New("--since must be RFC3339 or YYYY-MM-DD")
+	}
+	// streams are per aggregate (see subjects.go)
+	if parsed, err := ParseSubject(*subject); *stream == "" && err == nil {
+		*stream = StreamName(parsed.Aggregate)
+	}
+	if *target == "" {
+		*target = "replay." + *subject
+	}
+	if *rate < 1 {
+		*rate = 1
+	}
+
+	conn, err := InitNatsConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	js, err := conn.JetStream()
+	if err != nil {
+		return err
+	}
+
+	opts := []nats.SubOpt{nats.OrderedConsumer(), nats.StartTime(startTime)}
+	if *stream != "" {
+		opts = append(opts, nats.BindStream(*stream))
+	}
+	sub, err := js.SubscribeSync(*subject, opts...)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	throttle := time.NewTicker(time.Second / time.Duration(*rate))
+	defer throttle.Stop()
+	replayed := 0
+	for {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err == nats.ErrTimeout {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return err
+		}
+
+		if *dryRun {
+			fmt.Printf("[dry-run] seq=%d time=%s subject=%s bytes=%d\n", meta.Sequence.Stream, meta.Timestamp.UTC().Format(time.RFC3339), msg.Subject, len(msg.Data))
+		} else {
+			<-throttle.C
+			out := nats.NewMsg(*target)
+			out.Data = msg.Data
+			for key, values := range msg.Header {
+				out.Header[key] = values
+			}
+			out.Header.Set("Replay-Of-Subject", msg.Subject)
+			out.Header.Set("Replay-Of-Seq", fmt.Sprint(meta.Sequence.Stream))
+			if err := conn.PublishMsg(out); err != nil {
+				return err
+			}
+		}
+		replayed++
+		if meta.NumPending == 0 {
+			break
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Replayed %d events of %s to %s (dry-run: %t)\n", replayed, *subject, *target, *dryRun)
+	return nil
+}
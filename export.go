@@ -0,0 +1,174 @@
+package main
+
+import (
+	// system packages
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Export
+*	GET /admin/export/posts?format=csv|jsonl&fields=id,body streams whole
+*	posts table in batches of exportBatchSize using keyset iteration
+*	(WHERE id > last ORDER BY id, no OFFSET), gzipped on the fly when client
+*	accepts gzip. Rows are written as they are read so memory stays flat.
+*	Write deadline is pushed EXPORT_WRITE_TIMEOUT (default 2m) ahead before
+*	every batch, so big exports outlive APP_WRITE_TIMEOUT but a stalled
+*	client is still dropped. Complete exports end with the http trailers
+*	X-Export-Complete: true and X-Export-Rows: <n>; a stream without them
+*	was cut short.
+*/
+const exportBatchSize = 1000
+
+// postExportColumns : export field -> value of post, order is the default column order
+var postExportColumns = []struct {
+	Name  string
+	Value func(post Post) string
+}{
+	{"id", func(post Post) string { return strconv.FormatUint(uint64(post.ID), 10) }},
+	{"public_id", func(post Post) string { return post.PublicID }},
+	{"body", func(post Post) string { return post.Body }},
+	{"visibility", func(post Post) string { return post.Visibility }},
+	{"created_at", func(post Post) string { return ToUTC(post.CreatedAt).Format(time.RFC3339) }},
+	{"updated_at", func(post Post) string { return ToUTC(post.UpdatedAt).Format(time.RFC3339) }},
+}
+
+type exportWriter interface {
+	Write(values []string) error
+	Flush() error
+}
+
+type csvExportWriter struct{ writer *csv.Writer }
+
+func (w csvExportWriter) Write(values []string) error { return w.writer.Write(values) }
+func (w csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func (w jsonlExportWriter) Write(values []string) error {
+	row := make(map[string]string, len(values))
+	for i, value := range values {
+		row[w.columns[i]] = value
+	}
+	return w.encoder.Encode(row)
+}
+func (w jsonlExportWriter) Flush() error { return nil }
+
+// ExportPostsHandler godoc
+// @Summary Export posts as csv or jsonl stream
+// @Schemes
+// @Description Streams all posts with keyset iteration, gzip when Accept-Encoding allows
+// @Tags admin
+// @Security BasicAuth
+// @Param format query string false "csv (default) or jsonl"
+// @Param fields query string false "comma separated columns id,public_id,body,visibility,created_at,updated_at"
+// @Produce plain
+// @Success 200 {string} string
+// @Failure 400 {object} object
+// @Router /admin/export/posts [get]
+func ExportPostsHandler(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    ErrCodeExportQueryParams,
+			"message": "format must be csv or jsonl",
+		})
+		return
+	}
+	columns := []string{}
+	values := []func(post Post) string{}
+	requested := ctx.Query("fields")
+	for _, column := range postExportColumns {
+		if requested == "" || strings.Contains(","+requested+",", ","+column.Name+",") {
+			columns = append(columns, column.Name)
+			values = append(values, column.Value)
+		}
+	}
+	if len(columns) == 0 || requested != "" && len(columns) != len(strings.Split(requested, ",")) {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    ErrCodeExportQueryParams,
+			"message": "unknown export field in: " + requested,
+		})
+		return
+	}
+
+	var out io.Writer = ctx.Writer
+	filename := "posts-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	if strings.Contains(ctx.GetHeader("Accept-Encoding"), "gzip") {
+		ctx.Header("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(ctx.Writer)
+		defer gz.Close()
+		out = gz
+	}
+	var writer exportWriter
+	if format == "csv" {
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		writer = csvExportWriter{csv.NewWriter(out)}
+		writer.Write(columns)
+	} else {
+		ctx.Header("Content-Type", "application/x-ndjson")
+		writer = jsonlExportWriter{encoder: json.NewEncoder(out), columns: columns}
+	}
+	ctx.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	ctx.Header("Trailer", "X-Export-Complete, X-Export-Rows")
+	ctx.Status(http.StatusOK)
+
+	writeTimeout := getEnvDuration("EXPORT_WRITE_TIMEOUT", 2*time.Minute)
+	var lastId uint
+	exported := 0
+	for {
+		ExtendWriteDeadline(ctx.Request, writeTimeout)
+		var posts []Post
+		if err := db.Where("id > ?", lastId).Order("id").Limit(exportBatchSize).Find(&posts).Error; err != nil {
+			// headers are sent, only logging is possible
+			LogError("Error exporting posts", LogFields{"error": err, "exported": exported})
+			return
+		}
+		for _, post := range posts {
+			row := make([]string, len(values))
+			for i, value := range values {
+				row[i] = value(post)
+			}
+			if err := writer.Write(row); err != nil {
+				LogWarn("Export aborted by client", LogFields{"error": err, "exported": exported})
+				return
+			}
+			exported++
+		}
+		writer.Flush()
+		if gz, ok := out.(*gzip.Writer); ok {
+			gz.Flush()
+		}
+		ctx.Writer.Flush()
+		if len(posts) < exportBatchSize {
+			break
+		}
+		lastId = posts[len(posts)-1].ID
+	}
+	if gz, ok := out.(*gzip.Writer); ok {
+		if err := gz.Close(); err != nil {
+			LogWarn("Export aborted by client", LogFields{"error": err, "exported": exported})
+			return
+		}
+	}
+	ctx.Writer.Header().Set("X-Export-Complete", "true")
+	ctx.Writer.Header().Set("X-Export-Rows", strconv.Itoa(exported))
+	LogInfo("Posts exported", LogFields{"rows": exported, "format": format, "client_ip": ClientIP(ctx)})
+}
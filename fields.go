@@ -0,0 +1,96 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Sparse Fieldsets
+*	?fields=id,body,created_at returns only requested keys of a dto.
+*	Allowed fields are json tag names of the dto struct, unknown fields
+*	are rejected so typos do not silently return empty objects.
+*/
+func ParseFields(ctx *gin.Context, dto interface{}) ([]string, error) {
+	param := ctx.Query("fields")
+	if param == "" {
+		return nil, nil
+	}
+	allowed := dtoFieldNames(dto)
+	fields := []string{}
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !allowed[field] {
+			return nil, errors.New("unknown field: " + field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// dtoFieldNames : json field names of a struct value
+func dtoFieldNames(dto interface{}) map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(dto)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.SplitN(t.Field(i).Tag.Get("json"), ",", 2)[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+/**
+*	ShapeFields : keeps only fields of a dto or slice of dtos.
+*	Empty fields returns data untouched.
+*/
+func ShapeFields(data interface{}, fields []string) interface{} {
+	if len(fields) == 0 {
+		return data
+	}
+	encoded, err := json.Marshal(SerializeValue(data))
+	if err != nil {
+		return data
+	}
+	// keep numbers as json.Number so large ids are not rounded
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return data
+	}
+	switch value := decoded.(type) {
+	case []interface{}:
+		for i, item := range value {
+			value[i] = pickFields(item, fields)
+		}
+		return value
+	default:
+		return pickFields(value, fields)
+	}
+}
+
+func pickFields(item interface{}, fields []string) interface{} {
+	object, ok := item.(map[string]interface{})
+	if !ok {
+		return item
+	}
+	picked := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := object[field]; ok {
+			picked[field] = value
+		}
+	}
+	return picked
+}
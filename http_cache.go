@@ -0,0 +1,136 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	HTTP Caching Headers
+*	Every response gets Cache-Control, Vary and Expires of its route class
+*	so CDNs and browsers only cache what is safe to share:
+*	- immutable   : public, a year, immutable (fingerprinted assets)
+*	- public      : public lists like GET /post/, browsers keep them
+*	                HTTP_CACHE_MAX_AGE (default 10s), CDNs
+*	                HTTP_CACHE_SHARED_MAX_AGE (default 30s) and serve stale
+*	                for HTTP_CACHE_STALE_WHILE_REVALIDATE (default 30s),
+*	                varies by encoding and language
+*	- private     : default of GET, only the browser caches and revalidates,
+*	                varies by Authorization and Cookie
+*	- no-store    : default of writes, errors, admin, auth and health routes
+*	Routes pick a class with CacheClass(...) middleware, headers a handler
+*	sets itself (static files) are kept.
+*/
+type HttpCacheClass string
+
+const (
+	CacheImmutable HttpCacheClass = "immutable"
+	CachePublic    HttpCacheClass = "public"
+	CachePrivate   HttpCacheClass = "private"
+	CacheNoStore   HttpCacheClass = "no-store"
+)
+
+const httpCacheClassKey = "http_cache:class"
+
+type httpCacheConfig struct {
+	maxAge               time.Duration
+	sharedMaxAge         time.Duration
+	staleWhileRevalidate time.Duration
+}
+
+// CacheClass : sets cache class of route, overrides the method default
+func CacheClass(class HttpCacheClass) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(httpCacheClassKey, class)
+		ctx.Next()
+	}
+}
+
+type cacheHeaderWriter struct {
+	gin.ResponseWriter
+	ctx     *gin.Context
+	config  httpCacheConfig
+	applied bool
+}
+
+// apply : writes caching headers once, right before the status line goes out
+func (w *cacheHeaderWriter) apply() {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	header := w.Header()
+	if header.Get("Cache-Control") != "" {
+		return
+	}
+	class := CachePrivate
+	if value, ok := w.ctx.Get(httpCacheClassKey); ok {
+		class = value.(HttpCacheClass)
+	} else if method := w.ctx.Request.Method; method != http.MethodGet && method != http.MethodHead {
+		class = CacheNoStore
+	}
+	if w.Status() >= 400 {
+		class = CacheNoStore
+	}
+	switch class {
+	case CacheImmutable:
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+		header.Set("Expires", time.Now().UTC().AddDate(1, 0, 0).Format(http.TimeFormat))
+		header.Add("Vary", "Accept-Encoding")
+	case CachePublic:
+		header.Set("Cache-Control", "public, max-age="+cacheSeconds(w.config.maxAge)+
+			", s-maxage="+cacheSeconds(w.config.sharedMaxAge)+
+			", stale-while-revalidate="+cacheSeconds(w.config.staleWhileRevalidate))
+		header.Set("Expires", time.Now().UTC().Add(w.config.maxAge).Format(http.TimeFormat))
+		header.Add("Vary", "Accept-Encoding, Accept-Language")
+	case CachePrivate:
+		header.Set("Cache-Control", "private, no-cache")
+		header.Set("Expires", "0")
+		header.Add("Vary", "Authorization, Cookie, Accept-Encoding")
+	default:
+		header.Set("Cache-Control", "no-store")
+		header.Set("Expires", "0")
+	}
+}
+
+func cacheSeconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(duration.Seconds()), 10)
+}
+
+func (w *cacheHeaderWriter) WriteHeaderNow() {
+	w.apply()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cacheHeaderWriter) Write(data []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheHeaderWriter) WriteString(data string) (int, error) {
+	w.apply()
+	return w.ResponseWriter.WriteString(data)
+}
+
+// HttpCacheMiddleware : sets caching headers of route class on every response
+func HttpCacheMiddleware() gin.HandlerFunc {
+	config := httpCacheConfig{
+		maxAge:               getEnvDuration("HTTP_CACHE_MAX_AGE", 10*time.Second),
+		sharedMaxAge:         getEnvDuration("HTTP_CACHE_SHARED_MAX_AGE", 30*time.Second),
+		staleWhileRevalidate: getEnvDuration("HTTP_CACHE_STALE_WHILE_REVALIDATE", 30*time.Second),
+	}
+	return func(ctx *gin.Context) {
+		path := ctx.Request.URL.Path
+		if strings.Contains(path, "/_/") || strings.Contains(path, "/admin/") || strings.Contains(path, "/auth/") {
+			ctx.Set(httpCacheClassKey, CacheNoStore)
+		}
+		ctx.Writer = &cacheHeaderWriter{ResponseWriter: ctx.Writer, ctx: ctx, config: config}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/**
+*	ID Generator
+*	ID_GENERATOR=db (default) keeps auto-increment primary keys,
+*	ID_GENERATOR=sonyflake lets replicas allocate sortable 64-bit ids
+*	without a database round trip. Layout follows sonyflake
+*	(Docs: https://github.com/sony/sonyflake):
+*	39 bits time in 10ms units since ID_EPOCH | 8 bits sequence | 16 bits machine id
+*	ID_MACHINE_ID must be unique per replica (0-65535), defaults to lower
+*	16 bits of the first private ipv4 address.
+*/
+type IDGenerator interface {
+	NextID() (uint64, error)
+}
+
+var idGenerator IDGenerator
+
+// InitIDGenerator : generator from env, nil means database assigns ids
+func InitIDGenerator() (IDGenerator, error) {
+	switch os.Getenv("ID_GENERATOR") {
+	case "", "db":
+		return nil, nil
+	case "sonyflake":
+		return NewSonyflake()
+	default:
+		return nil, errors.New("unknown ID_GENERATOR: " + os.Getenv("ID_GENERATOR"))
+	}
+}
+
+const (
+	sonyflakeTimeUnit     = 10 * time.Millisecond
+	sonyflakeBitsTime     = 39
+	sonyflakeBitsSequence = 8
+	sonyflakeBitsMachine  = 16
+)
+
+type Sonyflake struct {
+	mu          sync.Mutex
+	epoch       time.Time
+	elapsedTime int64
+	sequence    uint16
+	machineId   uint16
+}
+
+func NewSonyflake() (*Sonyflake, error) {
+	epoch := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if value := os.Getenv("ID_EPOCH"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil || parsed.After(time.Now()) {
+			return nil, errors.New("invalid ID_EPOCH, expected past date like 2022-01-01")
+		}
+		epoch = parsed
+	}
+	machineId, err := sonyflakeMachineId()
+	if err != nil {
+		return nil, err
+	}
+	return &Sonyflake{epoch: epoch, machineId: machineId, sequence: 1<<sonyflakeBitsSequence - 1}, nil
+}
+
+func sonyflakeMachineId() (uint16, error) {
+	if value := os.Getenv("ID_MACHINE_ID"); value != "" {
+		machineId, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return 0, errors.New("invalid ID_MACHINE_ID, expected 0-65535")
+		}
+		return uint16(machineId), nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return 0, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil && isPrivateIPv4(ip) {
+			return uint16(ip[2])<<8 + uint16(ip[3]), nil
+		}
+	}
+	return 0, errors.New("no private ip address, set ID_MACHINE_ID")
+}
+
+func isPrivateIPv4(ip net.IP) bool {
+	return ip[0] == 10 || ip[0] == 172 && ip[1] >= 16 && ip[1] < 32 || ip[0] == 192 && ip[1] == 168
+}
+
+func (sf *Sonyflake) elapsed() int64 {
+	return int64(time.Since(sf.epoch) / sonyflakeTimeUnit)
+}
+
+// NextID : next unique id, waits for next time unit when sequence overflows
+func (sf *Sonyflake) NextID() (uint64, error) {
+	const maskSequence = uint16(1<<sonyflakeBitsSequence - 1)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	current := sf.elapsed()
+	if sf.elapsedTime < current {
+		sf.elapsedTime = current
+		sf.sequence = 0
+	} else {
+		sf.sequence = (sf.sequence + 1) & maskSequence
+		if sf.sequence == 0 {
+			sf.elapsedTime++
+			time.Sleep(time.Duration(sf.elapsedTime-current) * sonyflakeTimeUnit)
+		}
+	}
+	if sf.elapsedTime >= 1<<sonyflakeBitsTime {
+		return 0, errors.New("sonyflake: over the time limit")
+	}
+	return uint64(sf.elapsedTime)<<(sonyflakeBitsSequence+sonyflakeBitsMachine) |
+		uint64(sf.sequence)<<sonyflakeBitsMachine |
+		uint64(sf.machineId), nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats.go"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Bulk Import
+*	POST /admin/import?format=jsonl|csv stores the file as an import job and
+*	queues it on SubjectImportRun, the import worker (SubscribeWorker, retries
+*	and dead letters) claims the job (queued to running, one worker wins),
+*	validates rows like CreatePostDto, skips rows whose external_id already
+*	exists in posts or archived_posts and records progress.
+*	GET /admin/import/:id returns job status.
+*	Rows: {"external_id":"...","body":"...","created_at":"RFC3339"}
+*	CSV needs a header row with the same names, created_at in the future is
+*	rejected. Only posts can be imported until a User model exists.
+*/
+const (
+	importProgressEvery = 100
+	importMaxErrors     = 100
+	// importStaleAfter : running job without progress save for this long is claimed again
+	importStaleAfter = 10 * time.Minute
+)
+
+type ImportJob struct {
+	gorm.Model
+	Format     string     `gorm:"column:format;size:8;not null"`
+	Payload    []byte     `gorm:"column:payload"`
+	Status     string     `gorm:"column:status;size:16;not null;index"`
+	Total      int        `gorm:"column:total"`
+	Imported   int        `gorm:"column:imported"`
+	Skipped    int        `gorm:"column:skipped"`
+	Failed     int        `gorm:"column:failed"`
+	Errors     string     `gorm:"column:errors;type:text"`
+	FinishedAt *time.Time `gorm:"column:finished_at"`
+}
+
+type ImportJobDto struct {
+	ID         uint       `json:"id,string"`
+	Format     string     `json:"format"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Imported   int        `json:"imported"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	Errors     []string   `json:"errors"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+func ToImportJobDto(job ImportJob) ImportJobDto {
+	dto := ImportJobDto{
+		ID:        job.ID,
+		Format:    job.Format,
+		Status:    job.Status,
+		Total:     job.Total,
+		Imported:  job.Imported,
+		Skipped:   job.Skipped,
+		Failed:    job.Failed,
+		Errors:    []string{},
+		CreatedAt: ToUTC(job.CreatedAt),
+	}
+	if job.Errors != "" {
+		json.Unmarshal([]byte(job.Errors), &dto.Errors)
+	}
+	if job.FinishedAt != nil {
+		finishedAt := ToUTC(*job.FinishedAt)
+		dto.FinishedAt = &finishedAt
+	}
+	return dto
+}
+
+// ImportPostRow : one row of import file, validated with post rules
+type ImportPostRow struct {
+	ExternalID string `json:"external_id" validate:"required,max=64"`
+	Body       string `json:"body" validate:"required,min=1,limit=post.body.max_length"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// readImportRows : decodes all rows of payload
+func readImportRows(format string, payload []byte) ([]ImportPostRow, error) {
+	rows := []ImportPostRow{}
+	if format == "jsonl" {
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		for {
+			var row ImportPostRow
+			err := decoder.Decode(&row)
+			if err == io.EOF {
+				return rows, nil
+			}
+			if err != nil {
+				return nil, errors.New("line " + strconv.Itoa(len(rows)+1) + ": " + err.Error())
+			}
+			rows = append(rows, row)
+		}
+	}
+	records, err := csv.NewReader(bytes.NewReader(payload)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return rows, nil
+	}
+	index := map[string]int{}
+	for i, name := range records[0] {
+		index[strings.TrimSpace(name)] = i
+	}
+	column := func(record []string, name string) string {
+		if i, ok := index[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	for _, record := range records[1:] {
+		rows = append(rows, ImportPostRow{
+			ExternalID: column(record, "external_id"),
+			Body:       column(record, "body"),
+			CreatedAt:  column(record, "created_at"),
+		})
+	}
+	return rows, nil
+}
+
+// RunImportJob : claims and processes queued import job, safe to retry since imported rows are skipped
+func RunImportJob(jobId uint) error {
+	// only one worker moves a job to running, a running job without progress for importStaleAfter was lost
+	claim := db.Model(&ImportJob{}).
+		Where("id = ? AND (status = ? OR status = ? AND updated_at < ?)", jobId, "queued", "running", utcNow().Add(-importStaleAfter)).
+		Update("status", "running")
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		LogInfo("Import job already claimed or finished", LogFields{"import_id": jobId})
+		return nil
+	}
+	var job ImportJob
+	if err := db.First(&job, jobId).Error; err != nil {
+		return err
+	}
+	if err := runClaimedImportJob(&job); err != nil {
+		// back to queued so the redelivered message claims it again
+		db.Model(&ImportJob{}).Where("id = ? AND status = ?", jobId, "running").Update("status", "queued")
+		return err
+	}
+	return nil
+}
+
+func runClaimedImportJob(job *ImportJob) error {
+	rows, err := readImportRows(job.Format, job.Payload)
+	if err != nil {
+		now := utcNow()
+		errorsJson, _ := json.Marshal([]string{err.Error()})
+		return db.Model(job).Updates(map[string]interface{}{"status": "failed", "errors": string(errorsJson), "finished_at": now}).Error
+	}
+
+	job.Status, job.Total, job.Imported, job.Skipped, job.Failed = "running", len(rows), 0, 0, 0
+	rowErrors := []string{}
+	saveProgress := func() error {
+		errorsJson, _ := json.Marshal(rowErrors)
+		job.Errors = string(errorsJson)
+		return db.Model(job).Select("status", "total", "imported", "skipped", "failed", "errors", "finished_at").Updates(job).Error
+	}
+	if err := saveProgress(); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		line := "row " + strconv.Itoa(i+1) + ": "
+		if err := validate.Struct(row); err != nil {
+			job.Failed++
+			if len(rowErrors) < importMaxErrors {
+				rowErrors = append(rowErrors, line+err.Error())
+			}
+			continue
+		}
+		post := Post{Body: row.Body, ExternalID: &row.ExternalID}
+		if row.CreatedAt != "" {
+			createdAt, err := parseQueryTime(row.CreatedAt)
+			if err != nil {
+				job.Failed++
+				if len(rowErrors) < importMaxErrors {
+					rowErrors = append(rowErrors, line+"created_at must be RFC3339 or YYYY-MM-DD")
+				}
+				continue
+			}
+			// future rows would block the posts partition of their month (see partition.go)
+			if createdAt.After(utcNow()) {
+				job.Failed++
+				if len(rowErrors) < importMaxErrors {
+					rowErrors = append(rowErrors, line+"created_at must not be in the future")
+				}
+				continue
+			}
+			post.CreatedAt = createdAt
+		}
+		exists, err := externalIdImported(row.ExternalID)
+		if err != nil {
+			job.Failed++
+			if len(rowErrors) < importMaxErrors {
+				rowErrors = append(rowErrors, line+err.Error())
+			}
+			continue
+		}
+		if exists {
+			job.Skipped++
+			continue
+		}
+		if err := db.Create(&post).Error; err != nil {
+			job.Failed++
+			if len(rowErrors) < importMaxErrors {
+				rowErrors = append(rowErrors, line+err.Error())
+			}
+			continue
+		}
+		job.Imported++
+		if (i+1)%importProgressEvery == 0 {
+			saveProgress()
+		}
+	}
+	now := utcNow()
+	job.Status, job.FinishedAt = "done", &now
+	if err := saveProgress(); err != nil {
+		return err
+	}
+	if job.Imported > 0 {
+		responseCache.Invalidate(PostListCache.Group)
+	}
+	LogInfo("Import finished", LogFields{"import_id": job.ID, "imported": job.Imported, "skipped": job.Skipped, "failed": job.Failed})
+	return nil
+}
+
+// externalIdImported : external id in posts or archived_posts, archived posts come back on rehydration
+func externalIdImported(externalId string) (bool, error) {
+	for _, model := range []interface{}{&Post{}, &ArchivedPost{}} {
+		var existing int64
+		if err := db.Model(model).Where("external_id = ?", externalId).Count(&existing).Error; err != nil || existing > 0 {
+			return existing > 0, err
+		}
+	}
+	return false, nil
+}
+
+// StartImportWorker : runs import jobs queued on SubjectImportRun
+func StartImportWorker() (*nats.Subscription, error) {
+	return SubscribeWorker(SubjectImportRun, "import", func(msg *nats.Msg) error {
+		jobId, err := strconv.ParseUint(string(msg.Data), 10, 64)
+		if err != nil {
+			return err
+		}
+		return RunImportJob(uint(jobId))
+	})
+}
+
+/**
+*	--------------- HTTP /admin/import Section ---------------
+*/
+
+// CreateImportHandler godoc
+// @Summary Queue bulk import of posts
+// @Schemes
+// @Description Body is jsonl or csv file of rows with external_id, body and optional created_at
+// @Tags admin
+// @Security BasicAuth
+// @Param format query string false "jsonl (default) or csv"
+// @Accept plain
+// @Produce json
+// @Success 202 {object} ImportJobDto
+// @Failure 400 {object} object
+// @Failure 413 {object} object
+// @Failure 500 {object} object
+// @Router /admin/import [post]
+func CreateImportHandler(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", "jsonl")
+	if format != "jsonl" && format != "csv" {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportQueryParams,
+			"message": "format must be jsonl or csv",
+		})
+		return
+	}
+	payload, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			abortBodyTooLarge(ctx, getEnvInt64("APP_MAX_IMPORT_BODY", defaultMaxImportBody))
+			return
+		}
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportRequestBody,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(bytes.TrimSpace(payload)) == 0 {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportRequestBody,
+			"message": "import file is empty",
+		})
+		return
+	}
+
+	job := ImportJob{Format: format, Payload: payload, Status: "queued"}
+	if err := db.Create(&job).Error; err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportSave,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := PublishEvent(SubjectImportRun, []byte(strconv.FormatUint(uint64(job.ID), 10))); err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportQueue,
+			"message": err.Error(),
+		})
+		return
+	}
+	LogInfo("Import queued", LogFields{"import_id": job.ID, "bytes": len(payload), "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusAccepted, gin.H{
+		"import": ToImportJobDto(job),
+	})
+}
+
+// GetImportHandler godoc
+// @Summary Import job status
+// @Schemes
+// @Tags admin
+// @Security BasicAuth
+// @Param id path int true "import id"
+// @Produce json
+// @Success 200 {object} ImportJobDto
+// @Failure 404 {object} object
+// @Router /admin/import/{id} [get]
+func GetImportHandler(ctx *gin.Context) {
+	var job ImportJob
+	err := db.Omit("payload").First(&job, ctx.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportNotFound,
+			"message": "Import not found.",
+		})
+		return
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeImportQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"import": ToImportJobDto(job),
+	})
+}
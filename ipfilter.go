@@ -0,0 +1,170 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	IP Allow/Deny Filter
+*	Deny list always wins, non-empty allow list rejects everything else.
+*	Lists are swapped atomically so they can be reloaded at runtime from env
+*	(SIGHUP, POST /_/reload) or from the admin endpoint.
+*	- global : IP_ALLOW_LIST / IP_DENY_LIST
+*	- admin  : ADMIN_IP_ALLOW_LIST / ADMIN_IP_DENY_LIST (e.g. office/VPN ranges)
+*/
+type IPFilterRules struct {
+	Allow    string `json:"allow"`
+	Deny     string `json:"deny"`
+	allowSet IPList
+	denySet  IPList
+}
+
+type IPFilter struct {
+	Name     string
+	AllowEnv string
+	DenyEnv  string
+	rules    atomic.Value
+}
+
+var (
+	ipFiltersMu sync.Mutex
+	ipFilters   = map[string]*IPFilter{}
+)
+
+// NewIPFilter : creates filter loaded from env keys and registers it for reloads
+func NewIPFilter(name, allowEnv, denyEnv string) *IPFilter {
+	filter := &IPFilter{Name: name, AllowEnv: allowEnv, DenyEnv: denyEnv}
+	filter.ReloadFromEnv(os.Getenv)
+	ipFiltersMu.Lock()
+	ipFilters[name] = filter
+	ipFiltersMu.Unlock()
+	return filter
+}
+
+// Set : swaps rules with given comma separated allow and deny lists
+func (f *IPFilter) Set(allow, deny string) IPFilterRules {
+	rules := IPFilterRules{
+		Allow:    allow,
+		Deny:     deny,
+		allowSet: ParseIPList(allow),
+		denySet:  ParseIPList(deny),
+	}
+	f.rules.Store(rules)
+	return rules
+}
+
+// ReloadFromEnv : sets rules from env keys of filter read with getenv
+func (f *IPFilter) ReloadFromEnv(getenv func(string) string) {
+	f.Set(getenv(f.AllowEnv), getenv(f.DenyEnv))
+}
+
+func (f *IPFilter) Rules() IPFilterRules {
+	rules, _ := f.rules.Load().(IPFilterRules)
+	return rules
+}
+
+// Allowed : applies deny then allow list to ip
+func (f *IPFilter) Allowed(ip string) bool {
+	rules := f.Rules()
+	if rules.denySet.Contains(ip) {
+		return false
+	}
+	return len(rules.allowSet) == 0 || rules.allowSet.Contains(ip)
+}
+
+func (f *IPFilter) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !f.Allowed(ClientIP(ctx)) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"status":  false,
+				"type":    ErrCodeRequestIpDenied,
+				"message": "Access from your network is not allowed.",
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// reloadIPFiltersFromEnv : called on runtime config reload
+func reloadIPFiltersFromEnv(getenv func(string) string) {
+	ipFiltersMu.Lock()
+	defer ipFiltersMu.Unlock()
+	for _, filter := range ipFilters {
+		filter.ReloadFromEnv(getenv)
+	}
+}
+
+/**
+*	--------------- HTTP /admin/ip-filters Section ---------------
+*	Changes are in-memory of the replica serving the request, persist them
+*	to env for restarts.
+*/
+type IPFilterDto struct {
+	Allow string `json:"allow" validate:"max=4096" example:"10.0.0.0/8,192.168.1.5"`
+	Deny  string `json:"deny" validate:"max=4096" example:"1.2.3.0/24"`
+}
+
+// GetIPFiltersHandler godoc
+// @Summary List ip allow/deny filters
+// @Schemes
+// @Tags admin
+// @Security BasicAuth
+// @Produce json
+// @Success 200 {object} object
+// @Router /admin/ip-filters [get]
+func GetIPFiltersHandler(ctx *gin.Context) {
+	ipFiltersMu.Lock()
+	filters := map[string]IPFilterRules{}
+	for name, filter := range ipFilters {
+		filters[name] = filter.Rules()
+	}
+	ipFiltersMu.Unlock()
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"filters": filters,
+	})
+}
+
+// SetIPFilterHandler godoc
+// @Summary Replace ip allow/deny lists of a filter
+// @Schemes
+// @Description Lists are comma separated ips or cidrs like 10.0.0.0/8,192.168.1.5
+// @Tags admin
+// @Security BasicAuth
+// @Param name path string true "filter name (global, admin)"
+// @Param body body IPFilterDto true "allow and deny lists"
+// @Accept application/json
+// @Produce json
+// @Success 200 {object} IPFilterRules
+// @Failure 400 {object} object
+// @Failure 404 {object} object
+// @Router /admin/ip-filters/{name} [put]
+func SetIPFilterHandler(ctx *gin.Context) {
+	ipFiltersMu.Lock()
+	filter, ok := ipFilters[ctx.Param("name")]
+	ipFiltersMu.Unlock()
+	if !ok {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeIpFilterNotFound,
+			"message": "Unknown ip filter.",
+		})
+		return
+	}
+	var dto IPFilterDto
+	if err := BindDto(ctx, &dto, ErrAreaIpFilter); err != nil {
+		return
+	}
+	rules := filter.Set(dto.Allow, dto.Deny)
+	LogWarn("IP filter changed", LogFields{"filter": filter.Name, "allow": rules.Allow, "deny": rules.Deny, "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"filter": rules,
+	})
+}
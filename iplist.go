@@ -0,0 +1,52 @@
+package main
+
+import (
+	// system packages
+	"log"
+	"net"
+	"strings"
+)
+
+/**
+*	IPList : set of CIDR ranges, single ips are treated as /32 (or /128)
+*/
+type IPList []*net.IPNet
+
+// ParseIPList : parses comma separated list like "10.0.0.0/8,192.168.1.5"
+func ParseIPList(value string) IPList {
+	list := IPList{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			log.Println("Invalid ip or cidr in list skipping.", item)
+			continue
+		}
+		list = append(list, network)
+	}
+	return list
+}
+
+// Contains : reports whether ip string is in one of ranges
+func (l IPList) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range l {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Leader Election
+*	With LEADER_ELECTION=true one replica holds the leader lock (lock.go,
+*	same LOCK_BACKEND) and alone relays event_outbox rows and runs
+*	scheduled jobs. The leader renews its lease every third of
+*	LEADER_LEASE, when it crashes or can not renew the lease runs out and
+*	another replica takes over on its next try. Without election every
+*	replica counts as leader and scheduled jobs only use their own locks.
+*	GET /v1/_/leader shows the current leader for debugging.
+*/
+const leaderLock = "leader"
+
+var leaderGauge = NewGauge(
+	"leader_is_leader",
+	"1 while this instance is the elected leader.",
+)
+
+var leaderChanges = NewCounter(
+	"leader_changes_total",
+	"Leadership changes of this instance by result (elected, lost).",
+	"result",
+)
+
+type LeaderElector struct {
+	Enabled bool
+	Lease   time.Duration
+	mu      sync.Mutex
+	lock    *Lock
+	since   time.Time
+	stop    chan struct{}
+}
+
+type LeaderStatusDto struct {
+	Enabled        bool       `json:"enabled"`
+	Backend        string     `json:"backend"`
+	Instance       string     `json:"instance"`
+	Leader         bool       `json:"leader"`
+	LeaderInstance string     `json:"leader_instance"`
+	Since          *time.Time `json:"since,omitempty"`
+	LeaseSeconds   float64    `json:"lease_seconds"`
+}
+
+// leader : disabled until StartLeaderElection
+var leader = &LeaderElector{}
+
+// StartLeaderElection : campaigns for leadership in background like LEADER_ELECTION=true LEADER_LEASE=15s
+func StartLeaderElection() *LeaderElector {
+	e := &LeaderElector{
+		Enabled: envBool("LEADER_ELECTION", false),
+		Lease:   getEnvDuration("LEADER_LEASE", 15*time.Second),
+		stop:    make(chan struct{}),
+	}
+	if !e.Enabled {
+		return e
+	}
+	go func() {
+		e.campaign()
+		ticker := time.NewTicker(e.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+				e.campaign()
+			}
+		}
+	}()
+	// hands over leadership right away instead of after the lease
+	OnShutdown("leader election", func(ctx context.Context) { e.resign() })
+	return e
+}
+
+// IsLeader : instance runs singleton work, always true without election
+func (e *LeaderElector) IsLeader() bool {
+	if !e.Enabled {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lock != nil
+}
+
+// campaign : renews the lease of the leader or tries to take the lock
+func (e *LeaderElector) campaign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lock != nil {
+		err := e.lock.Extend(e.Lease)
+		if err == nil {
+			return
+		}
+		// the lease can not be trusted when renewal fails, another replica may take over
+		e.lock = nil
+		leaderGauge.Set(0)
+		leaderChanges.Inc("lost")
+		LogWarn("Leadership lost", LogFields{"instance": instanceID, "error": err})
+	}
+	lock, err := locks.TryLock(leaderLock, e.Lease)
+	if err != nil {
+		LogWarn("Leader election failed", LogFields{"instance": instanceID, "error": err})
+		return
+	}
+	if lock == nil {
+		return
+	}
+	e.lock = lock
+	e.since = time.Now()
+	leaderGauge.Set(1)
+	leaderChanges.Inc("elected")
+	LogInfo("Elected as leader", LogFields{"instance": instanceID, "lease": e.Lease.String()})
+}
+
+func (e *LeaderElector) resign() {
+	close(e.stop)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lock != nil {
+		e.lock.Release()
+		e.lock = nil
+		leaderGauge.Set(0)
+	}
+}
+
+func (e *LeaderElector) status() (LeaderStatusDto, error) {
+	status := LeaderStatusDto{
+		Enabled:      e.Enabled,
+		Backend:      locks.Backend,
+		Instance:     instanceID,
+		Leader:       e.IsLeader(),
+		LeaseSeconds: e.Lease.Seconds(),
+	}
+	if !e.Enabled {
+		return status, nil
+	}
+	e.mu.Lock()
+	if e.lock != nil {
+		since := e.since.UTC()
+		status.Since = &since
+	}
+	e.mu.Unlock()
+	owner, err := locks.Owner(leaderLock)
+	status.LeaderInstance = owner
+	return status, err
+}
+
+// LeaderStatusHandler godoc
+// @Summary Leader status
+// @Schemes
+// @Description Whether this instance is the leader running the outbox relay and scheduled jobs and which instance holds the leader lock
+// @Tags post-service-health
+// @Security BasicAuth
+// @Produce json
+// @Success 200 {object} LeaderStatusDto
+// @Failure 503 {object} object
+// @Router /_/leader [get]
+func LeaderStatusHandler(ctx *gin.Context) {
+	status, err := leader.status()
+	if err != nil {
+		WriteJSON(ctx, http.StatusServiceUnavailable, gin.H{
+			"status":  false,
+			"type":    ErrCodeLeaderBackend,
+			"message": err.Error(),
+		})
+		return
+	}
+	WriteJSON(ctx, http.StatusOK, status)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"strconv"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Request Body Limits
+*	Defaults can be overridden from .env file like
+*	APP_MAX_JSON_BODY=1048576, APP_MAX_UPLOAD_BODY=10485760 and
+*	APP_MAX_IMPORT_BODY=52428800 (bytes)
+*/
+const (
+	defaultMaxJsonBody   int64 = 1 << 20  // 1MB
+	defaultMaxUploadBody int64 = 10 << 20 // 10MB
+	defaultMaxImportBody int64 = 50 << 20 // 50MB
+)
+
+// abortBodyTooLarge : writes 413 in standard error envelope
+func abortBodyTooLarge(ctx *gin.Context, maxBytes int64) {
+	ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"status":  false,
+		"type":    ErrCodeRequestBodyTooLarge,
+		"message": "Request body exceeds limit of " + strconv.FormatInt(maxBytes, 10) + " bytes.",
+	})
+}
+
+/**
+*	BodyLimitMiddleware : rejects requests whose body exceeds maxBytes.
+*	Declared Content-Length is checked first, chunked bodies are wrapped
+*	with http.MaxBytesReader so reading past the limit fails on bind.
+*/
+func BodyLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.ContentLength > maxBytes {
+			abortBodyTooLarge(ctx, maxBytes)
+			return
+		}
+		if ctx.Request.Body != nil {
+			ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBytes)
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+import (
+	// system packages
+	"strings"
+)
+
+// isBodyTooLarge : reports whether err comes from an exceeded http.MaxBytesReader
+// (http.MaxBytesError exists since Go 1.19, older readers only have the message)
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), "http: request body too large")
+}
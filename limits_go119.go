@@ -0,0 +1,15 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import (
+	// system packages
+	"errors"
+	"net/http"
+)
+
+// isBodyTooLarge : reports whether err comes from an exceeded http.MaxBytesReader
+func isBodyTooLarge(err error) bool {
+	return errors.As(err, new(*http.MaxBytesError))
+}
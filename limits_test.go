@@ -0,0 +1,28 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChunkedBodyOverLimitIsRejected(t *testing.T) {
+	body := `{"body":"` + strings.Repeat("x", int(defaultMaxJsonBody)) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/post/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	// unknown length, the limit is hit while binding instead of on Content-Length
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+
+	var response struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if rec.Code != http.StatusRequestEntityTooLarge || response.Type != ErrCodeRequestBodyTooLarge {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"strings"
+	"time"
+
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	List Query DSL
+*	Parses sort and filter query params of list endpoints into GORM clauses
+*	through whitelisted field maps, unknown fields are rejected so no raw
+*	user input reaches SQL.
+*	- sort=-created_at,id  (comma separated, "-" prefix for descending)
+*	- <filter>=value       (equality, e.g. user_id=3)
+*	- <range>_after=2024-01-01T00:00:00Z / <range>_before=... (RFC3339 or YYYY-MM-DD)
+*/
+type ListFields struct {
+	// Sort : api field name -> column
+	Sort map[string]string
+	// Filters : query param -> column for equality filters
+	Filters map[string]string
+	// Ranges : prefix -> time column, enables <prefix>_after and <prefix>_before
+	Ranges map[string]string
+	// DefaultSort : used when sort param is empty
+	DefaultSort string
+}
+
+// PostListFields : whitelisted sort/filter fields of GET /post
+var PostListFields = ListFields{
+	Sort: map[string]string{
+		"id":         "id",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	Filters: map[string]string{},
+	Ranges: map[string]string{
+		"created": "created_at",
+		"updated": "updated_at",
+	},
+	DefaultSort: "-id",
+}
+
+/**
+*	ListQuery : paging and sort params shared by list endpoints, embedded
+*	into per endpoint query structs bound with BindQuery
+*/
+type ListQuery struct {
+	Page  int    `form:"page,default=1" json:"page" validate:"min=1"`
+	Limit int    `form:"limit,default=10" json:"limit" validate:"min=1,max=100"`
+	Count bool   `form:"count,default=true" json:"count"`
+	Sort  string `form:"sort" json:"sort" validate:"max=255"`
+}
+
+// PostListQuery : query params of GET /post
+type PostListQuery struct {
+	ListQuery
+	CreatedAfter  string `form:"created_after" json:"created_after" validate:"omitempty,querytime"`
+	CreatedBefore string `form:"created_before" json:"created_before" validate:"omitempty,querytime"`
+	UpdatedAfter  string `form:"updated_after" json:"updated_after" validate:"omitempty,querytime"`
+	UpdatedBefore string `form:"updated_before" json:"updated_before" validate:"omitempty,querytime"`
+	Fields        string `form:"fields" json:"fields" validate:"max=255"`
+	Sensitive     string `form:"sensitive,default=blur" json:"sensitive" validate:"oneof=blur show hide"`
+}
+
+// ListParams : filter and range params of the bound query by param name
+func (q PostListQuery) ListParams() map[string]string {
+	return map[string]string{
+		"created_after":  q.CreatedAfter,
+		"created_before": q.CreatedBefore,
+		"updated_after":  q.UpdatedAfter,
+		"updated_before": q.UpdatedBefore,
+	}
+}
+
+/**
+*	ApplyListQuery : applies sort and filters of a query struct bound with
+*	BindQuery to query, params are looked up by the names in fields
+*/
+func ApplyListQuery(query *gorm.DB, fields ListFields, listQuery ListQuery, params map[string]string) (*gorm.DB, error) {
+	// equality filters
+	for param, column := range fields.Filters {
+		if value := params[param]; value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+
+	// time ranges
+	for prefix, column := range fields.Ranges {
+		if value := params[prefix+"_after"]; value != "" {
+			after, err := parseQueryTime(value)
+			if err != nil {
+				return nil, errors.New(prefix + "_after must be RFC3339 or YYYY-MM-DD")
+			}
+			query = query.Where(column+" >= ?", after)
+		}
+		if value := params[prefix+"_before"]; value != "" {
+			before, err := parseQueryTime(value)
+			if err != nil {
+				return nil, errors.New(prefix + "_before must be RFC3339 or YYYY-MM-DD")
+			}
+			query = query.Where(column+" < ?", before)
+		}
+	}
+
+	// sort
+	sort := listQuery.Sort
+	if sort == "" {
+		sort = fields.DefaultSort
+	}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		direction := " ASC"
+		if strings.HasPrefix(field, "-") {
+			direction = " DESC"
+			field = strings.TrimPrefix(field, "-")
+		}
+		column, ok := fields.Sort[field]
+		if !ok {
+			return nil, errors.New("unknown sort field: " + field)
+		}
+		query = query.Order(column + direction)
+	}
+	return query, nil
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
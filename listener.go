@@ -0,0 +1,74 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"net"
+	"os"
+	"strconv"
+)
+
+/**
+*	App Listener
+*	1. systemd socket activation : LISTEN_PID/LISTEN_FDS set by systemd, first fd (3) is used
+*	2. unix socket               : APP_SOCKET_PATH=/run/alyafn/alyafn.sock (APP_SOCKET_MODE=0660)
+*	3. tcp                       : APP_PORT (default 9090), loopback only in DEV_MODE
+*/
+const systemdListenFdsStart = 3
+
+func NewAppListener() (net.Listener, error) {
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		return listener, err
+	}
+	if socketPath := os.Getenv("APP_SOCKET_PATH"); socketPath != "" {
+		return unixListener(socketPath)
+	}
+	port := os.Getenv("APP_PORT")
+	if port == "" {
+		port = "9090"
+	}
+	host := ""
+	if devMode() {
+		host = "127.0.0.1"
+	}
+	return net.Listen("tcp", host+":"+port)
+}
+
+// systemdListener : listener passed by systemd socket unit, nil when not socket activated
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("LISTEN_FDS is not set by systemd")
+	}
+	// do not pass sockets to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(systemdListenFdsStart), "systemd-listener")
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// unixListener : listens on socket path removing stale socket file first
+func unixListener(socketPath string) (net.Listener, error) {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := strconv.ParseUint(os.Getenv("APP_SOCKET_MODE"), 8, 32)
+	if err != nil {
+		mode = 0660
+	}
+	if err := os.Chmod(socketPath, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
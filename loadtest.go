@@ -0,0 +1,620 @@
+package main
+
+import (
+	// system packages
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// database packages
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+*	Load Tests
+*	Release check of core endpoints against a running server:
+*	  ./alyagofn loadtest seed --yes --posts 10000 --days 7
+*	  ./alyagofn loadtest run --yes --url http://localhost:9090/v1 --rate 100 --duration 1m
+*	  ./alyagofn loadtest clean --yes
+*	seed inserts posts marked with external_id loadtest-<n> and impressions
+*	of the last days so feed, list and trending pages have data, running it
+*	again only adds missing posts. Posts of create scenarios start with
+*	loadTestMarker, clean deletes them and seeded posts with their stats.
+*	All three are refused against APP_ENV=prod (the local one for seed and
+*	clean, environment of /_/capabilities for run) and writes need --yes.
+*	run sends requests of a profile at a
+*	constant rate (open model, like vegeta), requests the workers can not
+*	take are counted as dropped. The report has p50/p95/p99 per scenario,
+*	status codes and database pool saturation sampled from /_/metrics
+*	(max in_use of max_open, waits and shed reads during the run).
+*	--max-p95 and --max-error-rate fail the command when exceeded. Create
+*	scenarios count against POLICY_RULES post.create, raise it on the target.
+*	In process benchmarks of the same endpoints are in benchmark_test.go.
+*/
+type LoadScenario struct {
+	Name   string
+	Weight int
+	Method string
+	Path   func(rnd *rand.Rand) string
+	Body   func(rnd *rand.Rand) []byte
+}
+
+func staticPath(path string) func(rnd *rand.Rand) string {
+	return func(rnd *rand.Rand) string { return path }
+}
+
+// loadTestMarker : body prefix of posts created by loadtest run
+const loadTestMarker = "[loadtest] "
+
+func loadPostBody(rnd *rand.Rand) []byte {
+	body, _ := json.Marshal(map[string]string{"body": fmt.Sprintf(loadTestMarker+"load test post %x", rnd.Int63())})
+	return body
+}
+
+var feedScenario = LoadScenario{Name: "feed", Weight: 50, Method: http.MethodGet, Path: staticPath("/post/")}
+var listScenario = LoadScenario{Name: "list", Weight: 20, Method: http.MethodGet, Path: func(rnd *rand.Rand) string {
+	return "/post/?limit=20&sort=-created_at&page=" + strconv.Itoa(1+rnd.Intn(20))
+}}
+var trendingScenario = LoadScenario{Name: "trending", Weight: 15, Method: http.MethodGet, Path: staticPath("/post/trending?days=7")}
+var createScenario = LoadScenario{Name: "create", Weight: 15, Method: http.MethodPost, Path: staticPath("/post/"), Body: loadPostBody}
+
+// loadProfiles : scenarios and weights per --profile
+var loadProfiles = map[string][]LoadScenario{
+	"core":  {feedScenario, listScenario, trendingScenario, createScenario},
+	"read":  {feedScenario, listScenario, trendingScenario},
+	"write": {createScenario},
+}
+
+func init() {
+	registerCliCommand("loadtest seed", CliCommand{
+		Usage: "insert posts and impressions for load tests (--yes --posts --days)",
+		Run:   runLoadtestSeed,
+	})
+	registerCliCommand("loadtest run", CliCommand{
+		Usage: "load test a server and report p50/p95/p99 and db pool saturation (--yes --url --rate --duration --profile)",
+		Run:   runLoadtest,
+	})
+	registerCliCommand("loadtest clean", CliCommand{
+		Usage: "delete seeded posts and posts created by loadtest run (--yes)",
+		Run:   runLoadtestClean,
+	})
+}
+
+// loadTestPosts : posts of loadtest seed and run, soft deleted ones included
+func loadTestPosts(database *gorm.DB) *gorm.DB {
+	return database.Unscoped().Model(&Post{}).Where("external_id LIKE ? OR body LIKE ?", "loadtest-%", loadTestMarker+"%")
+}
+
+func runLoadtestSeed(args []string) error {
+	flags := flag.NewFlagSet("loadtest seed", flag.ContinueOnError)
+	count := flags.Int("posts", 1000, "seeded posts in total")
+	days := flags.Int("days", 7, "days of impressions for trending")
+	batch := flags.Int("batch", 500, "rows per insert")
+	yes := flags.Bool("yes", false, "confirm that load test posts are inserted into the current database")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if appEnv.Name == "prod" {
+		return errors.New("loadtest seed is refused with APP_ENV=prod")
+	}
+	if !*yes {
+		return errors.New("loadtest seed inserts posts into the current database, run again with --yes")
+	}
+	if err := initCliDatabase(); err != nil {
+		return err
+	}
+	if err := MigrateModules(db); err != nil {
+		return err
+	}
+	var err error
+	if idGenerator, err = InitIDGenerator(); err != nil {
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	posts := make([]Post, 0, *count)
+	for i := 1; i <= *count; i++ {
+		externalId := "loadtest-" + strconv.Itoa(i)
+		posts = append(posts, Post{
+			Body:       fmt.Sprintf("load test post %d %x", i, rnd.Int63()),
+			ExternalID: &externalId,
+			Sensitive:  i%20 == 0,
+		})
+	}
+	created := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(posts, *batch)
+	if created.Error != nil {
+		return created.Error
+	}
+
+	ids := []uint{}
+	if err := db.Model(&Post{}).Where("external_id LIKE ?", "loadtest-%").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	stats := []PostStat{}
+	for _, id := range ids {
+		for day := 0; day < *days; day++ {
+			stats = append(stats, PostStat{
+				PostID:      id,
+				Day:         utcNow().AddDate(0, 0, -day).Format("2006-01-02"),
+				Referrer:    "loadtest",
+				Impressions: int64(rnd.Intn(1000)),
+			})
+		}
+	}
+	if len(stats) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(stats, *batch).Error; err != nil {
+			return err
+		}
+	}
+	fmt.Printf("seeded %d posts (%d new) with %d days of impressions\n", len(ids), created.RowsAffected, *days)
+	return nil
+}
+
+func runLoadtestClean(args []string) error {
+	flags := flag.NewFlagSet("loadtest clean", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "confirm that load test posts are deleted from the current database")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if appEnv.Name == "prod" {
+		return errors.New("loadtest clean is refused with APP_ENV=prod")
+	}
+	if !*yes {
+		return errors.New("loadtest clean deletes load test posts of the current database, run again with --yes")
+	}
+	if err := initCliDatabase(); err != nil {
+		return err
+	}
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ids := loadTestPosts(tx.Session(&gorm.Session{NewDB: true})).Select("id")
+		for _, model := range []interface{}{&PostStat{}, &PostViewer{}, &PostTranslation{}} {
+			if err := tx.Where("post_id IN (?)", ids).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		result := loadTestPosts(tx).Delete(&Post{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d load test posts, cached lists expire with APP_CACHE_TTL\n", deleted)
+	return nil
+}
+
+// targetEnvironment : APP_ENV of server at baseUrl from /_/capabilities
+func targetEnvironment(client *http.Client, baseUrl string, header http.Header) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, baseUrl+"/_/capabilities", nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var capabilities CapabilitiesDto
+	if err := json.NewDecoder(res.Body).Decode(&capabilities); err != nil || capabilities.Environment == "" {
+		return "", errors.New("no environment in " + baseUrl + "/_/capabilities")
+	}
+	return capabilities.Environment, nil
+}
+
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return errors.New("header must be like \"Name: value\"")
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+type loadResult struct {
+	scenario string
+	status   int
+	latency  time.Duration
+	failed   bool
+}
+
+type ScenarioReport struct {
+	Name     string         `json:"name"`
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"`
+	Statuses map[string]int `json:"statuses"`
+	P50Ms    float64        `json:"p50_ms"`
+	P95Ms    float64        `json:"p95_ms"`
+	P99Ms    float64        `json:"p99_ms"`
+	MaxMs    float64        `json:"max_ms"`
+	latency  []time.Duration
+}
+
+type DbPoolReport struct {
+	Sampled  bool    `json:"sampled"`
+	MaxOpen  float64 `json:"max_open"`
+	MaxInUse float64 `json:"max_in_use"`
+	// SaturatedSamples : samples with every connection in use
+	SaturatedSamples int     `json:"saturated_samples"`
+	Samples          int     `json:"samples"`
+	Waits            float64 `json:"waits"`
+	WaitSeconds      float64 `json:"wait_seconds"`
+	ShedRequests     float64 `json:"shed_requests"`
+}
+
+type LoadReport struct {
+	Profile     string            `json:"profile"`
+	Rate        int               `json:"rate"`
+	Duration    string            `json:"duration"`
+	Requests    int               `json:"requests"`
+	Dropped     int               `json:"dropped"`
+	ErrorRate   float64           `json:"error_rate"`
+	AchievedRps float64           `json:"achieved_rps"`
+	P50Ms       float64           `json:"p50_ms"`
+	P95Ms       float64           `json:"p95_ms"`
+	P99Ms       float64           `json:"p99_ms"`
+	Scenarios   []*ScenarioReport `json:"scenarios"`
+	DbPool      DbPoolReport      `json:"db_pool"`
+}
+
+func runLoadtest(args []string) error {
+	flags := flag.NewFlagSet("loadtest run", flag.ContinueOnError)
+	baseUrl := flags.String("url", "http://localhost:"+os.Getenv("APP_PORT")+"/v1", "api base url")
+	profileName := flags.String("profile", "core", "scenario profile: core, read or write")
+	rate := flags.Int("rate", 50, "requests per second")
+	duration := flags.Duration("duration", 30*time.Second, "test duration")
+	workers := flags.Int("workers", 64, "concurrent requests at most")
+	timeout := flags.Duration("timeout", 10*time.Second, "request timeout")
+	metricsUrl := flags.String("metrics-url", "", "metrics endpoint for db pool samples (default <url>/post/_/metrics, none to skip)")
+	maxP95 := flags.Duration("max-p95", 0, "fail when overall p95 is higher (0 disables)")
+	maxErrorRate := flags.Float64("max-error-rate", 0.01, "fail when error rate is higher")
+	out := flags.String("out", "", "write json report to file")
+	yes := flags.Bool("yes", false, "confirm that create scenarios add posts to the target")
+	var headers headerFlags
+	flags.Var(&headers, "header", "request header like \"Authorization: Bearer token\" (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	profile, ok := loadProfiles[*profileName]
+	if !ok {
+		return errors.New("unknown --profile: " + *profileName)
+	}
+	if *rate < 1 || *workers < 1 {
+		return errors.New("--rate and --workers must be positive")
+	}
+	*baseUrl = strings.TrimRight(*baseUrl, "/")
+	if *metricsUrl == "" {
+		*metricsUrl = *baseUrl + "/post/_/metrics"
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Timeout: *timeout, Jar: jar, Transport: &http.Transport{MaxIdleConnsPerHost: *workers}}
+	header := http.Header{}
+	for _, value := range headers {
+		parts := strings.SplitN(value, ":", 2)
+		header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	environment, err := targetEnvironment(client, *baseUrl, header)
+	if err != nil {
+		return err
+	}
+	if environment == "prod" {
+		return errors.New("loadtest run is refused against APP_ENV=prod servers: " + *baseUrl)
+	}
+	writes := false
+	for _, scenario := range profile {
+		writes = writes || scenario.Method != http.MethodGet
+	}
+	if writes && !*yes {
+		return errors.New("profile " + *profileName + " creates posts on " + *baseUrl + ", run again with --yes (loadtest clean deletes them)")
+	}
+	// csrf cookie and header for writes in cookie auth mode, harmless in header mode
+	if token, err := fetchCsrfToken(client, *baseUrl, header); err == nil {
+		header.Set(csrfHeaderName, token)
+	}
+
+	weights := 0
+	for _, scenario := range profile {
+		weights += scenario.Weight
+	}
+	jobs := make(chan LoadScenario, *workers)
+	results := make(chan loadResult, *workers)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for scenario := range jobs {
+				results <- sendLoadRequest(client, *baseUrl, header, scenario, rnd)
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	report := &LoadReport{Profile: *profileName, Rate: *rate, Duration: duration.String()}
+	byName := map[string]*ScenarioReport{}
+	for _, scenario := range profile {
+		byName[scenario.Name] = &ScenarioReport{Name: scenario.Name, Statuses: map[string]int{}}
+		report.Scenarios = append(report.Scenarios, byName[scenario.Name])
+	}
+	collected := make(chan struct{})
+	all := []time.Duration{}
+	errorCount := 0
+	go func() {
+		for result := range results {
+			scenario := byName[result.scenario]
+			scenario.Requests++
+			scenario.latency = append(scenario.latency, result.latency)
+			all = append(all, result.latency)
+			status := strconv.Itoa(result.status)
+			if result.status == 0 {
+				status = "transport"
+			}
+			scenario.Statuses[status]++
+			if result.failed {
+				scenario.Errors++
+				errorCount++
+			}
+		}
+		close(collected)
+	}()
+
+	stopSampling := make(chan struct{})
+	sampled := make(chan DbPoolReport)
+	go func() { sampled <- sampleDbPool(client, *metricsUrl, stopSampling) }()
+
+	// constant arrival rate, requests are not delayed by slow responses
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	interval := time.Second / time.Duration(*rate)
+	start := time.Now()
+	for i := 0; ; i++ {
+		next := start.Add(time.Duration(i) * interval)
+		if next.Sub(start) >= *duration {
+			break
+		}
+		time.Sleep(time.Until(next))
+		select {
+		case jobs <- pickScenario(profile, weights, rnd):
+		default:
+			report.Dropped++
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(results)
+	<-collected
+	close(stopSampling)
+	report.DbPool = <-sampled
+
+	report.Requests = len(all)
+	if report.Requests > 0 {
+		report.ErrorRate = float64(errorCount) / float64(report.Requests)
+	}
+	report.AchievedRps = float64(report.Requests) / elapsed.Seconds()
+	report.P50Ms, report.P95Ms, report.P99Ms, _ = latencyPercentiles(all)
+	for _, scenario := range report.Scenarios {
+		scenario.P50Ms, scenario.P95Ms, scenario.P99Ms, scenario.MaxMs = latencyPercentiles(scenario.latency)
+	}
+	printLoadReport(report)
+	if *out != "" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if *maxP95 > 0 && report.P95Ms > float64(*maxP95)/float64(time.Millisecond) {
+		return fmt.Errorf("p95 %.1fms is above --max-p95 %s", report.P95Ms, *maxP95)
+	}
+	if report.ErrorRate > *maxErrorRate {
+		return fmt.Errorf("error rate %.4f is above --max-error-rate %.4f", report.ErrorRate, *maxErrorRate)
+	}
+	return nil
+}
+
+func pickScenario(profile []LoadScenario, weights int, rnd *rand.Rand) LoadScenario {
+	pick := rnd.Intn(weights)
+	for _, scenario := range profile {
+		if pick < scenario.Weight {
+			return scenario
+		}
+		pick -= scenario.Weight
+	}
+	return profile[len(profile)-1]
+}
+
+func sendLoadRequest(client *http.Client, baseUrl string, header http.Header, scenario LoadScenario, rnd *rand.Rand) loadResult {
+	var body io.Reader
+	if scenario.Body != nil {
+		body = bytes.NewReader(scenario.Body(rnd))
+	}
+	req, err := http.NewRequest(scenario.Method, baseUrl+scenario.Path(rnd), body)
+	if err != nil {
+		return loadResult{scenario: scenario.Name, failed: true}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	started := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return loadResult{scenario: scenario.Name, latency: time.Since(started), failed: true}
+	}
+	// latency includes reading the body like a client would
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return loadResult{scenario: scenario.Name, status: res.StatusCode, latency: time.Since(started), failed: res.StatusCode >= 400}
+}
+
+func fetchCsrfToken(client *http.Client, baseUrl string, header http.Header) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, baseUrl+"/auth/csrf", nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var payload struct {
+		Token string `json:"csrf_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil || payload.Token == "" {
+		return "", errors.New("no csrf token")
+	}
+	return payload.Token, nil
+}
+
+// latencyPercentiles : p50, p95, p99 and max in milliseconds
+func latencyPercentiles(latency []time.Duration) (float64, float64, float64, float64) {
+	if len(latency) == 0 {
+		return 0, 0, 0, 0
+	}
+	sorted := append([]time.Duration{}, latency...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(percentile float64) float64 {
+		index := int(percentile*float64(len(sorted))+0.5) - 1
+		if index < 0 {
+			index = 0
+		}
+		if index >= len(sorted) {
+			index = len(sorted) - 1
+		}
+		return float64(sorted[index]) / float64(time.Millisecond)
+	}
+	return at(0.50), at(0.95), at(0.99), at(1)
+}
+
+// sampleDbPool : samples db pool metrics every second until stop, empty report when metrics can not be read
+func sampleDbPool(client *http.Client, metricsUrl string, stop chan struct{}) DbPoolReport {
+	report := DbPoolReport{}
+	if metricsUrl == "none" {
+		return report
+	}
+	first, err := readPoolMetrics(client, metricsUrl)
+	if err != nil {
+		return report
+	}
+	last := first
+	record := func(sample map[string]float64) {
+		report.Samples++
+		report.MaxOpen = sample["max_open"]
+		if sample["in_use"] > report.MaxInUse {
+			report.MaxInUse = sample["in_use"]
+		}
+		if sample["max_open"] > 0 && sample["in_use"] >= sample["max_open"] {
+			report.SaturatedSamples++
+		}
+	}
+	record(first)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if sample, err := readPoolMetrics(client, metricsUrl); err == nil {
+				record(sample)
+				last = sample
+			}
+		case <-stop:
+			if sample, err := readPoolMetrics(client, metricsUrl); err == nil {
+				last = sample
+			}
+			report.Sampled = true
+			report.Waits = last["waits"] - first["waits"]
+			report.WaitSeconds = last["wait_seconds"] - first["wait_seconds"]
+			report.ShedRequests = last["shed"] - first["shed"]
+			return report
+		}
+	}
+}
+
+// readPoolMetrics : db pool values of prometheus text output (see db_pool.go)
+func readPoolMetrics(client *http.Client, metricsUrl string) (map[string]float64, error) {
+	res, err := client.Get(metricsUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("metrics returned " + res.Status)
+	}
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		space := strings.LastIndex(line, " ")
+		if strings.HasPrefix(line, "#") || space < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[space+1:], 64)
+		if err != nil {
+			continue
+		}
+		name := line[:space]
+		switch {
+		case name == `db_pool_connections{state="max_open"}`:
+			values["max_open"] = value
+		case name == `db_pool_connections{state="in_use"}`:
+			values["in_use"] = value
+		case name == "db_pool_wait_count":
+			values["waits"] = value
+		case name == "db_pool_wait_duration_seconds":
+			values["wait_seconds"] = value
+		case strings.HasPrefix(name, "db_pool_shed_requests_total"):
+			values["shed"] += value
+		}
+	}
+	return values, scanner.Err()
+}
+
+func printLoadReport(report *LoadReport) {
+	fmt.Printf("profile %s, %d req/s for %s: %d requests (%.1f req/s achieved), %d dropped, error rate %.2f%%\n",
+		report.Profile, report.Rate, report.Duration, report.Requests, report.AchievedRps, report.Dropped, report.ErrorRate*100)
+	fmt.Printf("%-10s %8s %7s %9s %9s %9s %9s  %s\n", "scenario", "requests", "errors", "p50 ms", "p95 ms", "p99 ms", "max ms", "statuses")
+	for _, scenario := range report.Scenarios {
+		statuses := []string{}
+		for status, count := range scenario.Statuses {
+			statuses = append(statuses, status+":"+strconv.Itoa(count))
+		}
+		sort.Strings(statuses)
+		fmt.Printf("%-10s %8d %7d %9.1f %9.1f %9.1f %9.1f  %s\n", scenario.Name, scenario.Requests, scenario.Errors,
+			scenario.P50Ms, scenario.P95Ms, scenario.P99Ms, scenario.MaxMs, strings.Join(statuses, " "))
+	}
+	fmt.Printf("%-10s %8d %7s %9.1f %9.1f %9.1f\n", "total", report.Requests, "", report.P50Ms, report.P95Ms, report.P99Ms)
+	pool := report.DbPool
+	if !pool.Sampled {
+		fmt.Println("db pool: not sampled (metrics endpoint unreachable)")
+		return
+	}
+	fmt.Printf("db pool: max %.0f of %.0f connections in use, saturated in %d of %d samples, %.0f waits (%.3fs), %.0f shed reads\n",
+		pool.MaxInUse, pool.MaxOpen, pool.SaturatedSamples, pool.Samples, pool.Waits, pool.WaitSeconds, pool.ShedRequests)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	// system packages
+	"os"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Locale
+*	LocaleMiddleware derives locale from ?lang, Accept-Language (first
+*	supported tag) or APP_DEFAULT_LOCALE (default en) and stores it in
+*	context, response cache keys and post translations read it. Times are
+*	sent in UTC, local times and relative times ("5 minutes ago") are left
+*	to clients, responses of cached lists would freeze them.
+*/
+const localeKey = "locale"
+
+var supportedLocales = map[string]bool{"en": true, "tr": true}
+
+func defaultLocale() string {
+	if locale := os.Getenv("APP_DEFAULT_LOCALE"); supportedLocales[locale] {
+		return locale
+	}
+	return "en"
+}
+
+// parseAcceptLanguage : first supported language of header like "tr-TR,tr;q=0.9,en;q=0.8"
+func parseAcceptLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		lang := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if supportedLocales[lang] {
+			return lang
+		}
+	}
+	return ""
+}
+
+func LocaleMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		locale := strings.ToLower(ctx.Query("lang"))
+		if !supportedLocales[locale] {
+			locale = parseAcceptLanguage(ctx.GetHeader("Accept-Language"))
+		}
+		if locale == "" {
+			locale = defaultLocale()
+		}
+		ctx.Set(localeKey, locale)
+		ctx.Header("Content-Language", locale)
+		ctx.Next()
+	}
+}
+
+// Locale : locale of request like "en"
+func Locale(ctx *gin.Context) string {
+	if locale := ctx.GetString(localeKey); locale != "" {
+		return locale
+	}
+	return defaultLocale()
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// redis packages
+	"github.com/gomodule/redigo/redis"
+	// database packages
+	"gorm.io/gorm/clause"
+)
+
+/**
+*	Distributed Locks
+*	Locks with a lease so jobs of replicas do not overlap and a crashed
+*	holder frees its lock when the lease runs out. LOCK_BACKEND selects:
+*	- redis  : SET NX PX on REDIS_HOST, release and extend check the token
+*	           (default when REDIS_HOST is set)
+*	- db     : lease rows in job_locks of the app database, taken with an
+*	           upsert that only wins over expired rows (default otherwise)
+*	- memory : this process only, for single instance setups
+*	A lock is owned by the token of TryLock, Extend and Release of a lock
+*	whose lease expired and was taken by another owner fail with
+*	errLockLost and leave the new owner alone.
+*/
+var errLockLost = errors.New("lock is held by another owner")
+
+var lockAttempts = NewCounter(
+	"lock_attempts_total",
+	"Lock attempts by lock name and result (acquired, held, lost, error).",
+	"lock", "result",
+)
+
+var lockHeld = NewGauge(
+	"lock_held",
+	"Locks held by this instance (1 while held).",
+	"lock",
+)
+
+// instanceID : id of this process in locks, leader election and cache sync
+var instanceID = instanceName() + "-" + randomHex(4)
+
+func instanceName() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "instance"
+}
+
+type lockBackend interface {
+	acquire(name, token string, ttl time.Duration) (bool, error)
+	extend(name, token string, ttl time.Duration) (bool, error)
+	release(name, token string) error
+	// owner : token holding name, empty when free
+	owner(name string) (string, error)
+}
+
+type Locker struct {
+	Backend string
+	backend lockBackend
+	counter uint64
+	mu      sync.Mutex
+}
+
+type Lock struct {
+	Name   string
+	token  string
+	locker *Locker
+}
+
+var locks *Locker
+
+// InitLocker : locker of LOCK_BACKEND=redis|db|memory
+func InitLocker() (*Locker, error) {
+	name := os.Getenv("LOCK_BACKEND")
+	if name == "" {
+		name = "db"
+		if os.Getenv("REDIS_HOST") != "" {
+			name = "redis"
+		}
+	}
+	switch name {
+	case "redis":
+		if os.Getenv("REDIS_HOST") == "" {
+			return nil, errors.New("LOCK_BACKEND=redis needs REDIS_HOST")
+		}
+		return &Locker{Backend: name, backend: newRedisLocks(os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PASSWORD"))}, nil
+	case "db":
+		return &Locker{Backend: name, backend: dbLocks{}}, nil
+	case "memory":
+		return &Locker{Backend: name, backend: &memoryLocks{leases: map[string]memoryLease{}}}, nil
+	}
+	return nil, errors.New("LOCK_BACKEND must be redis, db or memory: " + name)
+}
+
+// TryLock : takes lock name for ttl, nil lock when another owner holds it
+func (l *Locker) TryLock(name string, ttl time.Duration) (*Lock, error) {
+	l.mu.Lock()
+	l.counter++
+	token := instanceID + "-" + strconv.FormatUint(l.counter, 10)
+	l.mu.Unlock()
+	acquired, err := l.backend.acquire(name, token, ttl)
+	if err != nil {
+		lockAttempts.Inc(name, "error")
+		return nil, err
+	}
+	if !acquired {
+		lockAttempts.Inc(name, "held")
+		return nil, nil
+	}
+	lockAttempts.Inc(name, "acquired")
+	lockHeld.Set(1, name)
+	return &Lock{Name: name, token: token, locker: l}, nil
+}
+
+// Extend : lease of lock runs for ttl from now
+func (lock *Lock) Extend(ttl time.Duration) error {
+	extended, err := lock.locker.backend.extend(lock.Name, lock.token, ttl)
+	if err != nil {
+		return err
+	}
+	if !extended {
+		lockAttempts.Inc(lock.Name, "lost")
+		lockHeld.Set(0, lock.Name)
+		return errLockLost
+	}
+	return nil
+}
+
+// Release : frees lock before its lease ends
+func (lock *Lock) Release() error {
+	lockHeld.Set(0, lock.Name)
+	return lock.locker.backend.release(lock.Name, lock.token)
+}
+
+// Owner : instance holding lock name, empty when free
+func (l *Locker) Owner(name string) (string, error) {
+	token, err := l.backend.owner(name)
+	if i := strings.LastIndex(token, "-"); i > 0 {
+		token = token[:i]
+	}
+	return token, err
+}
+
+/**
+*	redis backend
+*/
+var redisReleaseScript = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+var redisExtendScript = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+
+type redisLocks struct {
+	pool *redis.Pool
+}
+
+func newRedisLocks(host, password string) *redisLocks {
+	return &redisLocks{pool: newRedisPool(host, password)}
+}
+
+// newRedisPool : connections to REDIS_HOST for commands the cache store does not expose
+func newRedisPool(host, password string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     2,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", host)
+			if err != nil {
+				return nil, err
+			}
+			if password != "" {
+				if _, err := conn.Do("AUTH", password); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+	}
+}
+
+func redisLockKey(name string) string {
+	return "lock:" + name
+}
+
+func (r *redisLocks) acquire(name, token string, ttl time.Duration) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := redis.String(conn.Do("SET", redisLockKey(name), token, "NX", "PX", ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *redisLocks) extend(name, token string, ttl time.Duration) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	extended, err := redis.Int(redisExtendScript.Do(conn, redisLockKey(name), token, ttl.Milliseconds()))
+	return extended == 1, err
+}
+
+func (r *redisLocks) release(name, token string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := redisReleaseScript.Do(conn, redisLockKey(name), token)
+	return err
+}
+
+func (r *redisLocks) owner(name string) (string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	token, err := redis.String(conn.Do("GET", redisLockKey(name)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return token, err
+}
+
+/**
+*	db backend
+*/
+type JobLock struct {
+	Name      string    `gorm:"column:name;size:128;primaryKey"`
+	Owner     string    `gorm:"column:owner;size:128;not null"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null"`
+}
+
+type dbLocks struct{}
+
+func (dbLocks) acquire(name, token string, ttl time.Duration) (bool, error) {
+	now := utcNow()
+	// inserts a free lock or takes over an expired one, a live lease makes the update a no-op
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"owner", "expires_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "job_locks.expires_at < ?", Vars: []interface{}{now}}}},
+	}).Create(&JobLock{Name: name, Owner: token, ExpiresAt: now.Add(ttl)})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (dbLocks) extend(name, token string, ttl time.Duration) (bool, error) {
+	result := db.Model(&JobLock{}).Where("name = ? AND owner = ?", name, token).Update("expires_at", utcNow().Add(ttl))
+	return result.RowsAffected == 1, result.Error
+}
+
+func (dbLocks) release(name, token string) error {
+	return db.Where("name = ? AND owner = ?", name, token).Delete(&JobLock{}).Error
+}
+
+func (dbLocks) owner(name string) (string, error) {
+	rows := []JobLock{}
+	err := db.Where("name = ? AND expires_at >= ?", name, utcNow()).Limit(1).Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return "", err
+	}
+	return rows[0].Owner, nil
+}
+
+/**
+*	memory backend
+*/
+type memoryLease struct {
+	token     string
+	expiresAt time.Time
+}
+
+type memoryLocks struct {
+	mu     sync.Mutex
+	leases map[string]memoryLease
+}
+
+func (m *memoryLocks) acquire(name, token string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lease, ok := m.leases[name]; ok && time.Now().Before(lease.expiresAt) {
+		return false, nil
+	}
+	m.leases[name] = memoryLease{token: token, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLocks) extend(name, token string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lease, ok := m.leases[name]; !ok || lease.token != token {
+		return false, nil
+	}
+	m.leases[name] = memoryLease{token: token, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLocks) release(name, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lease, ok := m.leases[name]; ok && lease.token == token {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func (m *memoryLocks) owner(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lease, ok := m.leases[name]; ok && time.Now().Before(lease.expiresAt) {
+		return lease.token, nil
+	}
+	return "", nil
+}
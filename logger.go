@@ -0,0 +1,59 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+*	Structured Logger
+*	Writes one JSON object per line to stdout tagged with app version.
+*	Lines below runtime config log level (APP_LOG_LEVEL=debug|info|warn|error)
+*	are dropped.
+*/
+type LogFields map[string]interface{}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+var logMu sync.Mutex
+
+func logEnabled(level string) bool {
+	min, ok := logLevels[runtimeConfig().LogLevel]
+	if !ok {
+		min = logLevels["info"]
+	}
+	return logLevels[level] >= min
+}
+
+func logLine(level string, msg string, fields LogFields) {
+	if !logEnabled(level) {
+		return
+	}
+	line := LogFields{}
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		line[key] = value
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level
+	line["version"] = appVersion
+	line["msg"] = msg
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		encoded, _ = json.Marshal(LogFields{"level": "error", "msg": "unencodable log line", "error": err.Error()})
+	}
+	logMu.Lock()
+	os.Stdout.Write(append(encoded, '\n'))
+	logMu.Unlock()
+}
+
+func LogDebug(msg string, fields LogFields) { logLine("debug", msg, fields) }
+func LogInfo(msg string, fields LogFields)  { logLine("info", msg, fields) }
+func LogWarn(msg string, fields LogFields)  { logLine("warn", msg, fields) }
+func LogError(msg string, fields LogFields) { logLine("error", msg, fields) }
@@ -0,0 +1,367 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	Mail
+*	Outbound emails are rendered from templates (Look to mail_templates.go),
+*	queued on NATS subject SubjectMailSend with QueueMail and delivered by the
+*	mail worker with retries and dead lettering (SubscribeWorker). The
+*	request id and trace of the queuing request travel with the mail, api
+*	calls of providers carry them (EventContext).
+*	MAIL_PROVIDER selects delivery:
+*	- smtp     : SMTP_ADDR (default localhost:1025 = MailHog), SMTP_USER, SMTP_PASSWORD
+*	- ses      : AWS SES v2 with AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+*	- sendgrid : SENDGRID_API_KEY
+*	- file     : writes .eml files to MAIL_DIR (default ./mails), default in DEV_MODE
+*	Sender is MAIL_FROM like "Alya <no-reply@example.com>". From and To must
+*	parse as single addresses and no header value may contain CR or LF, mails
+*	failing that are refused by QueueMail and every provider.
+*	POST /admin/mail/test queues a template with sample data to check
+*	provider setup (MailHog, .eml files) before flows that send mail exist.
+*/
+
+type Mail struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+type MailProvider interface {
+	Send(ctx context.Context, from string, mail Mail) error
+}
+
+var mailProvider MailProvider
+
+var errMailHeader = errors.New("mail header contains line break")
+
+// mailAddresses : parsed sender and recipient of mail, rejects header values with CR or LF
+func mailAddresses(from string, mail Mail) (sender, recipient *netmail.Address, err error) {
+	for _, value := range []string{from, mail.To, mail.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, nil, errMailHeader
+		}
+	}
+	if sender, err = netmail.ParseAddress(from); err != nil {
+		return nil, nil, errors.New("invalid mail from: " + err.Error())
+	}
+	if recipient, err = netmail.ParseAddress(mail.To); err != nil {
+		return nil, nil, errors.New("invalid mail to: " + err.Error())
+	}
+	return sender, recipient, nil
+}
+
+// InitMailProvider : provider from MAIL_PROVIDER
+func InitMailProvider() (MailProvider, error) {
+	name := os.Getenv("MAIL_PROVIDER")
+	if name == "" && devMode() {
+		name = "file"
+	}
+	switch name {
+	case "", "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			addr = "localhost:1025"
+		}
+		return SmtpMailProvider{Addr: addr, User: os.Getenv("SMTP_USER"), Password: secrets.Get("SMTP_PASSWORD")}, nil
+	case "ses":
+		return SesMailProvider{Credentials: AwsCredentials{
+			Region:       os.Getenv("AWS_REGION"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    secrets.Get("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}}, nil
+	case "sendgrid":
+		apiKey := secrets.Get("SENDGRID_API_KEY")
+		if apiKey == "" {
+			return nil, errors.New("SENDGRID_API_KEY is required for MAIL_PROVIDER=sendgrid")
+		}
+		return SendGridMailProvider{ApiKey: apiKey}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mails"
+		}
+		return FileMailProvider{Dir: dir}, nil
+	}
+	return nil, errors.New("unknown MAIL_PROVIDER: " + name)
+}
+
+func mailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}
+
+// QueueMail : enqueues mail for async delivery by mail worker, with trace of ctx
+func QueueMail(ctx context.Context, mail Mail) error {
+	if _, _, err := mailAddresses(mailFrom(), mail); err != nil {
+		return err
+	}
+	data, err := json.Marshal(mail)
+	if err != nil {
+		return err
+	}
+	return PublishEventContext(ctx, SubjectMailSend, data)
+}
+
+// SendTemplateMail : renders template in locale and queues it
+func SendTemplateMail(ctx context.Context, to, template, locale string, data interface{}) error {
+	mail, err := RenderMail(template, locale, data)
+	if err != nil {
+		return err
+	}
+	mail.To = to
+	return QueueMail(ctx, mail)
+}
+
+type MailTestDto struct {
+	To       string `json:"to" validate:"required,email,max=254" example:"dev@example.com"`
+	Template string `json:"template" validate:"required,oneof=verification password_reset digest" example:"verification"`
+	Locale   string `json:"locale" validate:"omitempty,oneof=en tr" example:"tr"`
+}
+
+// SendTestMailHandler godoc
+// @Summary Queue a template mail with sample data
+// @Schemes
+// @Description Locale defaults to the one of the request (Accept-Language)
+// @Tags admin
+// @Security BasicAuth
+// @Param body body MailTestDto true "recipient and template"
+// @Accept application/json
+// @Produce json
+// @Success 202 {object} object
+// @Failure 400 {object} object
+// @Failure 500 {object} object
+// @Router /admin/mail/test [post]
+func SendTestMailHandler(ctx *gin.Context) {
+	var dto MailTestDto
+	if err := BindDto(ctx, &dto, ErrAreaMailTest); err != nil {
+		return
+	}
+	locale := dto.Locale
+	if locale == "" {
+		locale = Locale(ctx)
+	}
+	if err := SendTemplateMail(ctx.Request.Context(), dto.To, dto.Template, locale, mailSampleData[dto.Template]); err != nil {
+		LogError("Test mail could not be queued", LogFields{"template": dto.Template, "error": err})
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeMailTestQueue,
+			"message": "Mail could not be queued.",
+		})
+		return
+	}
+	WriteJSON(ctx, http.StatusAccepted, gin.H{
+		"status":   true,
+		"template": dto.Template,
+		"locale":   locale,
+	})
+}
+
+// StartMailWorker : delivers queued mails, failures are retried then dead lettered
+func StartMailWorker() (*nats.Subscription, error) {
+	return SubscribeWorker(SubjectMailSend, "mail", func(msg *nats.Msg) error {
+		var mail Mail
+		if err := json.Unmarshal(msg.Data, &mail); err != nil {
+			return err
+		}
+		if err := mailProvider.Send(EventContext(msg), mailFrom(), mail); err != nil {
+			return err
+		}
+		LogInfo("Mail sent", LogFields{"to": mail.To, "subject": mail.Subject, "request_id": msg.Header.Get(requestIdHeader)})
+		return nil
+	})
+}
+
+// buildMime : multipart/alternative message with text and html parts
+func buildMime(from string, mail Mail) ([]byte, error) {
+	sender, recipient, err := mailAddresses(from, mail)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", mail.Text},
+		{"text/html; charset=utf-8", mail.Html},
+	} {
+		if part.content == "" {
+			continue
+		}
+		partWriter, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		partWriter.Write([]byte(part.content))
+	}
+	writer.Close()
+
+	var message bytes.Buffer
+	message.WriteString("From: " + sender.String() + "\r\n")
+	message.WriteString("To: " + recipient.String() + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", mail.Subject) + "\r\n")
+	message.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: multipart/alternative; boundary=" + writer.Boundary() + "\r\n\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+/**
+*	SmtpMailProvider : plain SMTP (PLAIN auth when user is set)
+*/
+type SmtpMailProvider struct {
+	Addr     string
+	User     string
+	Password string
+}
+
+func (p SmtpMailProvider) Send(ctx context.Context, from string, mail Mail) error {
+	var auth smtp.Auth
+	if p.User != "" {
+		auth = smtp.PlainAuth("", p.User, p.Password, strings.Split(p.Addr, ":")[0])
+	}
+	sender, recipient, err := mailAddresses(from, mail)
+	if err != nil {
+		return err
+	}
+	message, err := buildMime(from, mail)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(p.Addr, auth, sender.Address, []string{recipient.Address}, message)
+}
+
+var mailHttpClient = NewHttpClient(10 * time.Second)
+
+func sendMailRequest(req *http.Request, provider string) error {
+	res, err := mailHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New(provider + ": unexpected status " + res.Status)
+	}
+	return nil
+}
+
+/**
+*	SesMailProvider : AWS SES v2 SendEmail
+*	(Docs: https://docs.aws.amazon.com/ses/latest/APIReference-V2/API_SendEmail.html)
+*/
+type SesMailProvider struct {
+	Credentials AwsCredentials
+}
+
+func (p SesMailProvider) Send(ctx context.Context, from string, mail Mail) error {
+	sender, recipient, err := mailAddresses(from, mail)
+	if err != nil {
+		return err
+	}
+	content := map[string]interface{}{"Subject": map[string]string{"Data": mail.Subject}, "Body": map[string]interface{}{}}
+	if mail.Text != "" {
+		content["Body"].(map[string]interface{})["Text"] = map[string]string{"Data": mail.Text}
+	}
+	if mail.Html != "" {
+		content["Body"].(map[string]interface{})["Html"] = map[string]string{"Data": mail.Html}
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"FromEmailAddress": sender.String(),
+		"Destination":      map[string][]string{"ToAddresses": {recipient.String()}},
+		"Content":          map[string]interface{}{"Simple": content},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://email."+p.Credentials.Region+".amazonaws.com/v2/email/outbound-emails", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.Credentials.Sign(req, "ses", payload, time.Now().UTC())
+	return sendMailRequest(req, "ses")
+}
+
+/**
+*	SendGridMailProvider : SendGrid v3 mail/send
+*	(Docs: https://docs.sendgrid.com/api-reference/mail-send/mail-send)
+*/
+type SendGridMailProvider struct {
+	ApiKey string
+}
+
+func (p SendGridMailProvider) Send(ctx context.Context, from string, mail Mail) error {
+	sender, recipient, err := mailAddresses(from, mail)
+	if err != nil {
+		return err
+	}
+	contents := []map[string]string{}
+	if mail.Text != "" {
+		contents = append(contents, map[string]string{"type": "text/plain", "value": mail.Text})
+	}
+	if mail.Html != "" {
+		contents = append(contents, map[string]string{"type": "text/html", "value": mail.Html})
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": []map[string]string{{"email": recipient.Address}}}},
+		"from":             map[string]string{"email": sender.Address},
+		"subject":          mail.Subject,
+		"content":          contents,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.ApiKey)
+	return sendMailRequest(req, "sendgrid")
+}
+
+/**
+*	FileMailProvider : writes each mail as .eml file, for development
+*/
+type FileMailProvider struct {
+	Dir string
+}
+
+func (p FileMailProvider) Send(ctx context.Context, from string, mail Mail) error {
+	_, recipient, err := mailAddresses(from, mail)
+	if err != nil {
+		return err
+	}
+	message, err := buildMime(from, mail)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.Dir, 0o755); err != nil {
+		return err
+	}
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(recipient.Address)) + ".eml"
+	return os.WriteFile(filepath.Join(p.Dir, name), message, 0o644)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+/**
+*	Mail Templates
+*	Each template has subject, text and html bodies per locale, "en" is
+*	the fallback. Templates are kept in Go source so the single binary
+*	(Dockerfile copies *.go only) carries them.
+*/
+type mailTemplate struct {
+	Subject string
+	Text    string
+	Html    string
+}
+
+const mailLayout = `<!doctype html><html><body style="font-family:sans-serif;max-width:560px;margin:auto">{{template "content" .}}</body></html>`
+
+var mailTemplates = map[string]map[string]mailTemplate{
+	"verification": {
+		"en": {
+			Subject: "Verify your email address",
+			Text:    "Hi {{.Name}},\n\nPlease verify your email address by opening the link below:\n{{.Link}}\n\nIf you did not sign up you can ignore this email.",
+			Html:    `<p>Hi {{.Name}},</p><p>Please verify your email address:</p><p><a href="{{.Link}}">Verify email</a></p><p>If you did not sign up you can ignore this email.</p>`,
+		},
+		"tr": {
+			Subject: "E-posta adresinizi doğrulayın",
+			Text:    "Merhaba {{.Name}},\n\nE-posta adresinizi doğrulamak için aşağıdaki bağlantıyı açın:\n{{.Link}}\n\nKayıt olmadıysanız bu e-postayı yok sayabilirsiniz.",
+			Html:    `<p>Merhaba {{.Name}},</p><p>E-posta adresinizi doğrulayın:</p><p><a href="{{.Link}}">E-postayı doğrula</a></p><p>Kayıt olmadıysanız bu e-postayı yok sayabilirsiniz.</p>`,
+		},
+	},
+	"password_reset": {
+		"en": {
+			Subject: "Reset your password",
+			Text:    "Hi {{.Name}},\n\nYou can reset your password with the link below, it expires in {{.ExpiresIn}}:\n{{.Link}}\n\nIf you did not request this you can ignore this email.",
+			Html:    `<p>Hi {{.Name}},</p><p>You can reset your password with the link below, it expires in {{.ExpiresIn}}.</p><p><a href="{{.Link}}">Reset password</a></p><p>If you did not request this you can ignore this email.</p>`,
+		},
+		"tr": {
+			Subject: "Şifrenizi sıfırlayın",
+			Text:    "Merhaba {{.Name}},\n\nŞifrenizi aşağıdaki bağlantı ile sıfırlayabilirsiniz, bağlantı {{.ExpiresIn}} geçerlidir:\n{{.Link}}\n\nBu isteği siz yapmadıysanız bu e-postayı yok sayabilirsiniz.",
+			Html:    `<p>Merhaba {{.Name}},</p><p>Şifrenizi aşağıdaki bağlantı ile sıfırlayabilirsiniz, bağlantı {{.ExpiresIn}} geçerlidir.</p><p><a href="{{.Link}}">Şifreyi sıfırla</a></p><p>Bu isteği siz yapmadıysanız bu e-postayı yok sayabilirsiniz.</p>`,
+		},
+	},
+	"digest": {
+		"en": {
+			Subject: "Your weekly digest",
+			Text:    "Hi {{.Name}},\n\nTop posts of the week:\n{{range .Posts}}- {{.Body}}\n{{end}}\nUnread notifications: {{.UnreadCount}}\n\nUnsubscribe: {{.UnsubscribeLink}}",
+			Html:    `<p>Hi {{.Name}},</p><p>Top posts of the week:</p><ul>{{range .Posts}}<li>{{.Body}}</li>{{end}}</ul><p>Unread notifications: {{.UnreadCount}}</p><p><a href="{{.UnsubscribeLink}}">Unsubscribe</a></p>`,
+		},
+		"tr": {
+			Subject: "Haftalık özetiniz",
+			Text:    "Merhaba {{.Name}},\n\nHaftanın öne çıkan gönderileri:\n{{range .Posts}}- {{.Body}}\n{{end}}\nOkunmamış bildirimler: {{.UnreadCount}}\n\nAboneliği iptal et: {{.UnsubscribeLink}}",
+			Html:    `<p>Merhaba {{.Name}},</p><p>Haftanın öne çıkan gönderileri:</p><ul>{{range .Posts}}<li>{{.Body}}</li>{{end}}</ul><p>Okunmamış bildirimler: {{.UnreadCount}}</p><p><a href="{{.UnsubscribeLink}}">Aboneliği iptal et</a></p>`,
+		},
+	},
+}
+
+// mailSampleData : template data of test mails (SendTestMailHandler)
+var mailSampleData = map[string]interface{}{
+	"verification": map[string]interface{}{
+		"Name": "Alya",
+		"Link": "https://example.com/verify?token=sample",
+	},
+	"password_reset": map[string]interface{}{
+		"Name":      "Alya",
+		"Link":      "https://example.com/reset?token=sample",
+		"ExpiresIn": "1 hour",
+	},
+	"digest": map[string]interface{}{
+		"Name":            "Alya",
+		"Posts":           []map[string]string{{"Body": "First sample post"}, {"Body": "Second sample post"}},
+		"UnreadCount":     3,
+		"UnsubscribeLink": "https://example.com/unsubscribe?token=sample",
+	},
+}
+
+// RenderMail : renders template in locale (falls back to en), To is left empty
+func RenderMail(name, locale string, data interface{}) (Mail, error) {
+	locales, ok := mailTemplates[name]
+	if !ok {
+		return Mail{}, errors.New("unknown mail template: " + name)
+	}
+	tmpl, ok := locales[locale]
+	if !ok {
+		tmpl = locales["en"]
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := texttemplate.Must(texttemplate.New("subject").Parse(tmpl.Subject)).Execute(&subject, data); err != nil {
+		return Mail{}, err
+	}
+	if err := texttemplate.Must(texttemplate.New("text").Parse(tmpl.Text)).Execute(&text, data); err != nil {
+		return Mail{}, err
+	}
+	layout := htmltemplate.Must(htmltemplate.New("layout").Parse(mailLayout))
+	if _, err := layout.New("content").Parse(tmpl.Html); err != nil {
+		return Mail{}, err
+	}
+	if err := layout.Execute(&html, data); err != nil {
+		return Mail{}, err
+	}
+	return Mail{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		Html:    html.String(),
+	}, nil
+}
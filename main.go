@@ -41,10 +41,11 @@ package main
 
 import (
 	// system packages
+	"encoding/json"
+	"errors"
     "net/http"
 	"time"
 	"log"
-	"strconv"
 	"os"
 	"strings"
 
@@ -52,24 +53,19 @@ import (
 	"github.com/joho/godotenv"
 	osstatus "github.com/fukata/golang-stats-api-handler"
 	"git.yazgan.xyz/alperreha/alya-go-fn-boilerplate/docs" // change here with your module name
-	swaggerfiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
 
 	// web server packages
     "github.com/gin-gonic/gin"
-	// page cacher
-	"github.com/gin-contrib/cache"
+	// page cacher store (Look to response_cache.go)
 	"github.com/gin-contrib/cache/persistence"
 	// security headers
 	"github.com/gin-contrib/secure"
 	// rbac middleware
 	"github.com/zpatrick/rbac"
-	// validator packages
-	"github.com/go-playground/validator/v10"
 	// database packages
 	"gorm.io/gorm"
 	"gorm.io/driver/postgres" 
-	// "gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlite"
 	// event packages
 	// go get github.com/nats-io/nats.go/@v1.13.0
 	"github.com/nats-io/nats.go"
@@ -121,11 +117,35 @@ var nc *nats.Conn
 func InitNatsConnection() (*nats.Conn, error) {
 	// get nats url from .env file like NATS_URL=nats://localhost:4222
 	natsUrl := os.Getenv("NATS_URL")
+	if natsUrl == "" && devMode() {
+		// DEV_MODE=true without NATS_URL runs embedded nats server
+		url, err := StartEmbeddedNats()
+		if err != nil {
+			return nil, err
+		}
+		natsUrl = url
+	}
 	if natsUrl == "" {
 		natsUrl = "nats://localhost:4222"
 	}
-	// connect to nats
-	nc, err := nats.Connect(natsUrl)
+	// connect to nats, reconnect forever and flush events buffered meanwhile
+	nc, err := nats.Connect(natsUrl,
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			// err is nil when connection is drained or closed on purpose
+			if err != nil {
+				LogWarn("NATS disconnected, buffering events", LogFields{"error": err})
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			LogInfo("NATS reconnected", LogFields{"url": conn.ConnectedUrl()})
+			if eventBuffer != nil {
+				go eventBuffer.Flush()
+			}
+			// invalidations published meanwhile were missed
+			cacheSync.Resync()
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -141,26 +161,100 @@ var db *gorm.DB
 
 func InitDbConnection(dbConnString string) {
     var err error
-	//sqlite
-    // db, err = gorm.Open(sqlite.Open("gorm.db"), &gorm.Config{})
-	// postgres
-	db, err = gorm.Open(postgres.Open(dbConnString), &gorm.Config{})
+	dialector := postgres.Open(dbConnString)
+	//sqlite (DEV_MODE=true uses DEV_SQLITE_PATH like dev.db)
+	if devMode() {
+		dialector = sqlite.Open(sqliteDSN(devSqlitePath()))
+	}
+	db, err = gorm.Open(dialector, &gorm.Config{
+		Logger:  NewDbQueryLogger(),
+		NowFunc: utcNow,
+	})
     if err != nil {
         log.Panic(err)
     }
+	// WAL, busy timeout and single writer for sqlite (see sqlite.go)
+	if devMode() {
+		if err := RegisterSQLiteWriteLock(db); err != nil {
+			log.Panic(err)
+		}
+	}
+	// db-disconnect faults of resilience drills (see chaos.go)
+	if appEnv.Chaos {
+		if err := RegisterChaosCallbacks(db); err != nil {
+			log.Panic(err)
+		}
+	}
 }
 
 
 // Post object for Gorm
 type Post struct {
 	gorm.Model
-	Body string `gorm:"column:body;size:255;not null" json:"body" validate:"required,min=1,max=255"`
+	PublicID string `gorm:"column:public_id;size:36;uniqueIndex" json:"public_id"`
+	Body     string `gorm:"column:body;size:255;not null" json:"body"`
+	// ExternalID : id in source system of imported posts, nil for posts created here
+	ExternalID *string `gorm:"column:external_id;size:64;uniqueIndex" json:"-"`
+	// Visibility : public or unlisted (see visibility.go)
+	Visibility string `gorm:"column:visibility;size:16;not null;default:public;index" json:"visibility"`
+	// Kind : post, repost or quote of OriginalID (see repost.go)
+	Kind          string `gorm:"column:kind;size:8;not null;default:post" json:"kind"`
+	OriginalID    *uint  `gorm:"column:original_id;index" json:"-"`
+	RepostedCount int    `gorm:"column:reposted_count;not null;default:0" json:"reposted_count"`
+	// Sensitive : set by creator or moderation, lists blur or hide it (see sensitive.go)
+	Sensitive bool `gorm:"column:is_sensitive;not null;default:false;index" json:"is_sensitive"`
+	// SpamStatus : clean, held for review or rejected by review (see spam.go)
+	SpamStatus string  `gorm:"column:spam_status;size:8;not null;default:clean;index" json:"-"`
+	SpamScore  float64 `gorm:"column:spam_score;not null;default:0" json:"-"`
 }
 
+// PostDto is the public shape of Post in responses (id is public_id, timestamps RFC3339 UTC)
+type PostDto struct {
+	ID         string    `json:"id"`
+	Body       string    `json:"body"`
+	Visibility string    `json:"visibility"`
+	Kind       string    `json:"kind"`
+	// Original : shared post of reposts and quotes
+	Original      *PostDto `json:"original,omitempty"`
+	RepostedCount int      `json:"reposted_count"`
+	Sensitive     bool     `json:"is_sensitive"`
+	// BodyHidden : body of sensitive post was omitted for the viewer
+	BodyHidden bool      `json:"body_hidden,omitempty"`
+	// Held : waiting for spam review, hidden from lists until approved
+	Held       bool      `json:"held,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
 
-// init database migrations if not exist
+func ToPostDto(post Post) PostDto {
+	return PostDto{
+		ID:        post.PublicID,
+		Body:      post.Body,
+		Visibility: post.Visibility,
+		Kind:      post.Kind,
+		RepostedCount: post.RepostedCount,
+		Sensitive: post.Sensitive,
+		Held: post.SpamStatus == SpamHeld,
+		CreatedAt: ToUTC(post.CreatedAt),
+		UpdatedAt: ToUTC(post.UpdatedAt),
+	}
+}
+
+func ToPostDtos(posts []Post) []PostDto {
+	dtos := make([]PostDto, 0, len(posts))
+	for _, post := range posts {
+		dtos = append(dtos, ToPostDto(post))
+	}
+	return dtos
+}
+
+
+// init database migrations of every module if not exist (see module.go)
 func InitDbMigrations() {
-	db.AutoMigrate(&Post{})
+	if err := MigrateModules(db); err != nil {
+		log.Println("Error migrating database")
+		log.Fatal(err)
+	}
 }
 
 
@@ -169,7 +263,11 @@ func InitDbMigrations() {
 */
 // app start time
 var startTime = time.Now()
-var appVersion = "1.0.0" // -> this will auto update when load from .env
+// build metadata injected at build time like
+// go build -ldflags "-X main.appVersion=1.2.0 -X main.gitCommit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var appVersion = "dev"
+var gitCommit = "unknown"
+var buildTime = "unknown"
 
 func main() {
 	// current directory
@@ -184,10 +282,53 @@ func main() {
 		log.Print("Error loading .env file ENV variables using if exist instead. ",err)
 	}
 
+	// DEV_MODE=true runs with sqlite, embedded nats and fake auth (refused in prod)
+	if err := ApplyDevModeDefaults(); err != nil {
+		log.Fatal(err)
+	}
+
+	// gin mode, log level, swagger and pprof per APP_ENV=dev|staging|prod (see environment.go)
+	if err := ApplyEnvProfile(); err != nil {
+		log.Fatal(err)
+	}
+
+	// services mounted like APP_MODULES=post,analytics (default all, see module.go)
+	if err := LoadModules(); err != nil {
+		log.Fatal(err)
+	}
+
+	// run cli subcommand instead of server like ./alyagofn events replay ...
+	if len(os.Args) > 1 {
+		os.Exit(RunCli(os.Args[1:]))
+	}
+
+	// reload runtime config (log level, feature flags, cache ttl) on SIGHUP
+	WatchReloadSignal()
+
+	// init secrets provider like SECRETS_PROVIDER=vault (env by default)
+	secrets, err = NewSecretStore()
+	if err != nil {
+		log.Println("Error loading secrets from provider")
+		log.Fatal(err)
+	}
+	secrets.StartRefresh(getEnvDuration("SECRETS_REFRESH_INTERVAL", 5*time.Minute))
+	secrets.OnChange(func(key string, value string) {
+		if key == "DB_CONN_STRING" {
+			log.Println("DB_CONN_STRING rotated, new connections need app restart")
+		}
+	})
+
+	// event encryption and signing keys like EVENT_SIGNING_KEY (see event_crypto.go)
+	eventCrypto, err = InitEventCrypto()
+	if err != nil {
+		log.Println("Error loading event keys")
+		log.Fatal(err)
+	}
+
 	// get db connection string
-	dbConnectionString := os.Getenv("DB_CONN_STRING")
-	if dbConnectionString == "" {
-		log.Fatal("DB_CONN_STRING is not defined in .env file")
+	dbConnectionString := secrets.Get("DB_CONN_STRING")
+	if dbConnectionString == "" && !devMode() {
+		log.Fatal("DB_CONN_STRING is not defined in .env file or secrets provider")
 	}
 
 	// init database connection and pool settings
@@ -197,14 +338,42 @@ func main() {
 		log.Println("Error initial connection to database")
 		log.Fatal(err)
 	}
-	dbConn.SetMaxOpenConns(10)
-	dbConn.SetMaxIdleConns(5)
-	dbConn.SetConnMaxLifetime(time.Minute * 5)
+	// pool sizes from .env file like DB_MAX_OPEN_CONNS=10 DB_MAX_IDLE_CONNS=5 DB_CONN_MAX_LIFETIME=5m
+	dbConn.SetMaxOpenConns(int(getEnvInt64("DB_MAX_OPEN_CONNS", 10)))
+	dbConn.SetMaxIdleConns(int(getEnvInt64("DB_MAX_IDLE_CONNS", 5)))
+	dbConn.SetConnMaxLifetime(getEnvDuration("DB_CONN_MAX_LIFETIME", time.Minute * 5))
+	dbConn.SetConnMaxIdleTime(getEnvDuration("DB_CONN_MAX_IDLE_TIME", time.Minute * 5))
+
 
+	// init id generator like ID_GENERATOR=sonyflake and ID_MACHINE_ID=1 (default db auto increment)
+	idGenerator, err = InitIDGenerator()
+	if err != nil {
+		log.Println("Error initializing id generator")
+		log.Fatal(err)
+	}
 
 	// init database migrations
 	InitDbMigrations()
 
+	// post list queries like POST_QUERIES=sqlc (sqlc for feed and trending, see post_repository.go)
+	postRepository, err = InitPostRepository()
+	if err != nil {
+		log.Println("Error initializing post repository")
+		log.Fatal(err)
+	}
+
+	// locks of scheduled jobs shared by replicas like LOCK_BACKEND=redis (see lock.go)
+	locks, err = InitLocker()
+	if err != nil {
+		log.Println("Error initializing locks")
+		log.Fatal(err)
+	}
+
+	// one replica relays the outbox and runs scheduled jobs like LEADER_ELECTION=true (see leader.go)
+	leader = StartLeaderElection()
+
+	// scheduled database backups like BACKUP_INTERVAL=24h (see backup.go)
+	StartBackupSchedule()
 
 	// init nats connection
 	nc, err = InitNatsConnection()
@@ -212,6 +381,13 @@ func main() {
 		log.Println("Error initial connection to NATS")
 		log.Fatal(err)
 	}
+	// JetStream stream per event aggregate (see subjects.go)
+	if err := BootstrapStreams(nc); err != nil {
+		log.Println("Error declaring JetStream streams")
+		log.Fatal(err)
+	}
+	// events published while NATS is down like NATS_PUBLISH_BUFFER=1000 (overflow to event_outbox)
+	eventBuffer = StartEventBuffer()
 
 
 	/**
@@ -222,19 +398,103 @@ func main() {
 	THIS IS NOT NEEDED FOR THIS APP BUT BOILERPLATE SHOULD STAY
 	-----------------------------------------------------
 	// Simple Async Subscriber
-	nc.Subscribe("post.created", func(m *nats.Msg) {
+	nc.Subscribe(SubjectPostCreated, func(m *nats.Msg) {
 		log.Println("Received a post.created:", string(m.Data))
 	})
 
-	nc.Subscribe("post.select", func(m *nats.Msg) {
-		log.Println("Received a post.select:", string(m.Data))
+	// Worker with retries, failed messages go to /v1/admin/dead-letters
+	SubscribeWorker(SubjectPostCreated, "post-created", func(m *nats.Msg) error {
+		log.Println("Received a post.created:", string(m.Data))
+		return nil
+	})
+
+	nc.Subscribe(SubjectPostListed, func(m *nats.Msg) {
+		log.Println("Received a post.listed:", string(m.Data))
 	})
 	*/
 
-	// create new gin app
-    r := gin.Default()
-	// gin maybe behind proxy so we need trust only known proxy
-	r.SetTrustedProxies([]string{"0.0.0.0"})
+	// init mail provider and worker like MAIL_PROVIDER=smtp SMTP_ADDR=localhost:1025
+	mailProvider, err = InitMailProvider()
+	if err != nil {
+		log.Println("Error initializing mail provider")
+		log.Fatal(err)
+	}
+
+	// mail, import, post stats and analytics workers of enabled modules
+	if err := StartModuleWorkers(); err != nil {
+		log.Println("Error starting module workers")
+		log.Fatal(err)
+	}
+
+	// init error reporter like SENTRY_DSN=https://key@o0.ingest.sentry.io/1 or ROLLBAR_TOKEN=...
+	errorReporter, err = InitErrorReporter()
+	if err != nil {
+		log.Println("Error initializing error reporter")
+		log.Fatal(err)
+	}
+
+	// init upload virus scanner like AV_SCANNER=clamav and CLAMAV_ADDR=localhost:3310 (disabled by default)
+	virusScanner, err = InitVirusScanner()
+	if err != nil {
+		log.Println("Error initializing virus scanner")
+		log.Fatal(err)
+	}
+	if virusScanner != nil {
+		log.Println("AV_SCANNER is set but no upload routes are mounted yet, nothing is scanned")
+	}
+	if authMode() == AuthModeCookie {
+		log.Println("APP_AUTH_MODE=cookie is set but no login route issues session cookies yet")
+	}
+
+	// init post translation like TRANSLATION_PROVIDER=deepl and DEEPL_API_KEY (disabled by default)
+	translationProvider, err = InitTranslationProvider()
+	if err != nil {
+		log.Println("Error initializing translation provider")
+		log.Fatal(err)
+	}
+
+	// routes of enabled modules with caches and middleware stack (see NewRouter)
+	r, err := NewRouter()
+	if err != nil {
+		log.Println("Error building router")
+		log.Fatal(err)
+	}
+
+	// get app listener (systemd socket, unix socket or tcp APP_PORT)
+	listener, err := NewAppListener()
+	if err != nil {
+		log.Println("Error creating app listener")
+		log.Fatal(err)
+	}
+	if err := CheckDevModeListener(listener); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Listening on " + listener.Addr().Network() + " " + listener.Addr().String())
+	// start server with timeouts (and http2 when tls or h2c is enabled)
+	srv := NewHttpServer(r)
+	// SIGTERM finishes requests, flushes buffered events and drains nats before exit (APP_SHUTDOWN_TIMEOUT=25s)
+	shutdownDone := HandleShutdown(srv)
+	if err := ServeHttp(srv, listener); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+}
+
+
+/**
+*	Router
+*	gin app with global middleware stack and routes of enabled modules,
+*	also stores caches, policies and spam scorer it wires. Connections
+*	(db, nats) are opened before by main, tests build it the same way
+*	without serving.
+*/
+func NewRouter() (*gin.Engine, error) {
+	// create new gin app (panics are recovered and reported by RecoveryMiddleware)
+	r := gin.New()
+	// gin maybe behind proxy so we need trust only known proxy like TRUSTED_PROXIES=10.0.0.0/8
+	if err := ConfigureClientIP(r); err != nil {
+		return nil, errors.New("Error parsing TRUSTED_PROXIES: " + err.Error())
+	}
 
 	/**
 	*	Security Middleware (Docs: https://github.com/gin-contrib/secure)
@@ -268,66 +528,122 @@ func main() {
 		statPassword = authUser[1]
 		// if no username or password exit
 		if statUsername == "" || statPassword == "" {
-			log.Fatal("Error loading APP_STAT_AUTH from .env file")
+			return nil, errors.New("Error loading APP_STAT_AUTH from .env file")
 		}
 	}
+	// get admin basic auth credentials like APP_ADMIN_AUTH=admin:password (falls back to APP_STAT_AUTH)
+	adminAccounts := gin.Accounts{ statUsername : statPassword }
+	if adminAuth := os.Getenv("APP_ADMIN_AUTH"); adminAuth != "" {
+		adminUser := strings.SplitN(adminAuth,":",2)
+		if len(adminUser) != 2 || adminUser[0] == "" || adminUser[1] == "" {
+			return nil, errors.New("Error loading APP_ADMIN_AUTH from .env file")
+		}
+		adminAccounts = gin.Accounts{ adminUser[0] : adminUser[1] }
+	}
 
 
 	/**
 	*	ALL APP ENDPOINTS
 	*/
-	// create memory store for caching (Look to /cache_health)
-	store := persistence.NewInMemoryStore(time.Second)
-
-	docs.SwaggerInfo.BasePath = "/v1"
-	version := r.Group("/v1")
-	{
-		service := version.Group("/post")
-		{
-			/**
-			*	--------------- APP ROUTES ---------------
-			*/
-			service.GET("/", GetPostsHandler)
-			service.POST("/", CreatePostHandler)
-			//service.GET("/:id", GetPostByIdHandler)
-
-			/**
-			*	--------------- HEALTH ROUTES ---------------
-			*/
-			status := service.Group("/_") 
-			{
-				// if mode is production disable swagger
-				status.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
-
-				status.GET("/app_kernel_stats", AppKernelStatsHandler)
-
-				/**
-				*	Caching Example (Docs: https://github.com/gin-contrib/cache)
-				*/
-				status.GET("/health", gin.BasicAuth(gin.Accounts{ statUsername : statPassword }) ,AppHealthCheckHandler)
-				status.GET("/cache_health", cache.CachePage(store, time.Minute,AppHealthCheckHandler))
-			}
-		}			
+	// create store for caching and shared state (Look to /cache_health)
+	// redis is used when REDIS_HOST=localhost:6379 is set so replicas share state
+	var store persistence.CacheStore = persistence.NewInMemoryStore(time.Second)
+	if redisHost := os.Getenv("REDIS_HOST"); redisHost != "" {
+		store = persistence.NewRedisCache(redisHost, os.Getenv("REDIS_PASSWORD"), time.Second)
+		// atomic counters of rate limits and generations (see store_counter.go)
+		counterPool = newRedisPool(redisHost, os.Getenv("REDIS_PASSWORD"))
 	}
+	// handled ids of signed events, replayed copies are dropped (see event_crypto.go)
+	eventCrypto.UseStore(store)
+
+	// ip allow/deny lists like IP_DENY_LIST=1.2.3.0/24 and ADMIN_IP_ALLOW_LIST=10.0.0.0/8
+	globalIPFilter := NewIPFilter("global", "IP_ALLOW_LIST", "IP_DENY_LIST")
+	adminIPFilter := NewIPFilter("admin", "ADMIN_IP_ALLOW_LIST", "ADMIN_IP_DENY_LIST")
 
+	// response cache with per route policies and invalidation on writes
+	responseCache = NewResponseCache(store)
 
+	// single row lookups cache invalidated by model hooks like ENTITY_CACHE_TTL=5m
+	entityCache = NewEntityCache(store)
 
-	// get app port
-	APP_PORT := os.Getenv("APP_PORT")
-	if APP_PORT == "" {
-		APP_PORT = "9090"
+	// invalidations of in-memory caches broadcast to replicas over NATS like CACHE_SYNC=true
+	var err error
+	cacheSync, err = StartCacheSync()
+	if err != nil {
+		return nil, errors.New("Error subscribing to cache invalidations: " + err.Error())
 	}
-	// start server
-	if err := r.Run(":" + APP_PORT); err != nil {
-		log.Fatal(err)
+
+	// denormalized counter drift checks like COUNTER_CHECK_INTERVAL=6h (see counters.go)
+	StartCounterCheckSchedule()
+
+	// business limits like POLICY_RULES=post.create:50/24h
+	policies = NewPolicyEngine(store)
+
+	// abuse signals like ANOMALY_RULES=post.burst:30/1m, ANOMALY_CLAMP_FOR=15m clamps limits of flagged actors
+	anomalies = NewAnomalyDetector(store)
+
+	// spam scoring of new posts like SPAM_HOLD_SCORE=0.5 SPAM_REJECT_SCORE=0.9 (see spam.go)
+	spamScorer = NewSpamScorer(store)
+
+	/**
+	*	Global middleware stack, When(...) entries depend on APP_ENV profile
+	*/
+	r.Use(GlobalMiddlewares(
+		When(appEnv.AccessLog, gin.Logger()),
+		Always(RequestIDMiddleware()),
+		// panics are recovered and reported
+		Always(RecoveryMiddleware()),
+		// response checks of debug mode
+		When(gin.IsDebugging(), LocalTimeGuardMiddleware()),
+		When(gin.IsDebugging(), ErrorCodeGuardMiddleware()),
+		// CORS_ALLOWED_ORIGINS=https://app.example.com (any origin in dev)
+		Always(CORSMiddleware(appEnv)),
+		When(devMode(), DevAuthMiddleware()),
+		Always(LocaleMiddleware()),
+		// device, os and browser of User-Agent
+		Always(DeviceMiddleware()),
+		// Cache-Control, Vary and Expires per route class like HTTP_CACHE_MAX_AGE=10s
+		Always(HttpCacheMiddleware()),
+		Always(globalIPFilter.Middleware()),
+		// maintenance mode toggled from /v1/admin/maintenance
+		Always(MaintenanceMiddleware(store)),
+		// in-flight request cap with load shedding like APP_MAX_INFLIGHT=64
+		Always(NewConcurrencyLimiter().Middleware()),
+		// latency and error faults injected from /v1/_/chaos in dev and staging
+		When(appEnv.Chaos, ChaosMiddleware()),
+	)...)
+
+	// request body limits per route group (bytes) like APP_MAX_JSON_BODY=1048576
+	maxJsonBody := getEnvInt64("APP_MAX_JSON_BODY", defaultMaxJsonBody)
+
+	docs.SwaggerInfo.BasePath = "/v1"
+	// users who did not accept current terms of service get 451 (see tos.go)
+	version := r.Group("/v1", CSRFMiddleware(), TosMiddleware(store))
+	routes := &ModuleRoutes{
+		Engine:      r,
+		Version:     version,
+		AdminAuth:   []gin.HandlerFunc{adminIPFilter.Middleware(), gin.BasicAuth(adminAccounts)},
+		StatAuth:    gin.BasicAuth(gin.Accounts{ statUsername : statPassword }),
+		// reads get 503 while db pool is saturated like DB_POOL_READ_MAX_WAIT=200ms
+		DbGuard:     NewDbPoolGuard().Middleware(),
+		Store:       store,
+		MaxJsonBody: maxJsonBody,
 	}
+	routes.Admin = version.Group("/admin", routes.WithAdminAuth(BodyLimitMiddleware(maxJsonBody))...)
+	// routes of enabled modules (see module_core.go, module_post.go, module_analytics.go)
+	RegisterModuleRoutes(routes)
+
+	// frontend at / with spa fallback like APP_STATIC_DIR=./web/dist
+	RegisterStatic(r)
+
+	return r, nil
 }
 
 
 // AppHealtCheckHandler godoc
 // @Summary Returns container kernel info
 // @Schemes 
-// @Description Returns container kernel info
+// @Description Returns container kernel info and database pool stats
 // @Tags post-service-health
 // @Security BasicAuth
 // @Accept */*
@@ -335,7 +651,10 @@ func main() {
 // @Success 200 {object} object
 // @Router /post/_/app_kernel_stats [get]
 func AppKernelStatsHandler(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, osstatus.GetStats())
+	WriteJSON(ctx, http.StatusOK, struct {
+		*osstatus.Stats
+		DbPool DbPoolStats `json:"db_pool"`
+	}{osstatus.GetStats(), GetDbPoolStats()})
 }
 
 
@@ -351,7 +670,7 @@ func AppKernelStatsHandler(ctx *gin.Context) {
 // @Router /post/_/health [get]
 // @Router /post/_/cache_health [get]
 func AppHealthCheckHandler(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, gin.H{
+	WriteJSON(ctx, http.StatusOK, gin.H{
 		"status": true,
 		"uptime": time.Since(startTime).String(),
 		"version": appVersion,
@@ -368,7 +687,11 @@ func AppHealthCheckHandler(ctx *gin.Context) {
 *	6 - Return response
 */
 type CreatePostDto struct {
-	Body string `json:"body" validate:"required,min=1,max=255"`
+	Body string `json:"body" validate:"required,min=1,limit=post.body.max_length" example:"Hello world!"`
+	// Visibility : public (default) or unlisted
+	Visibility string `json:"visibility" validate:"omitempty,oneof=public unlisted" example:"public"`
+	// Sensitive : body is blurred in lists unless viewers ask to see it
+	Sensitive bool `json:"is_sensitive" example:"false"`
 }
 
 /**
@@ -383,27 +706,11 @@ func CreatePostDtoValidator(ctx *gin.Context) (CreatePostDto,error) {
 	fmt.Printf("Can watch %s? %t\n", rating, canWatch)
 	*/
     
+	// bind and validate with rules of CreatePostDto tags
 	var createPostDto CreatePostDto
-	// cast to json
-    if err := ctx.BindJSON(&createPostDto); err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{
-			"status": false,
-			"type": "create-post/request-body",
-            "message": err.Error(),
-        })
-		// return error
-		return createPostDto,err
-    }
-	// validate
-	validateDto := validator.New()
-	if err := validateDto.Struct(createPostDto); err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{
-			"status": false,
-			"type": "create-post/validation",
-			"message": err.Error(),
-        })
+	if err := BindDto(ctx, &createPostDto, ErrAreaCreatePost); err != nil {
 		return createPostDto,err
-    }
+	}
 	// return createPostDto
 	return createPostDto,nil
 }
@@ -416,42 +723,72 @@ func CreatePostDtoValidator(ctx *gin.Context) (CreatePostDto,error) {
 // @Description Create Post by CreatePostDto
 // @Tags post-service
 // @Security BearerAuth
-// @Body CreatePostDto
+// @Param body body CreatePostDto true "post"
+// @Param dry_run query bool false "validate only, nothing is saved (or Prefer: handling=validate-only)"
 // @Accept application/json
 // @Produce json
 // @Success 200 {object} object
 // @Failure 400 {object} object
 // @Failure 401 {object} object
 // @Failure 422 {object} object
+// @Failure 429 {object} object
 // @Router /post/ [post]
 func CreatePostHandler(ctx *gin.Context) {
 	// validate request
 	createPostDto,err := CreatePostDtoValidator(ctx)
 	if err != nil { return }		
+
+	// check posting limits of user (client ip when anonymous)
+	if err := policies.AllowRequest(ctx, "post.create", ActorID(ctx), IsDryRun(ctx)); err != nil {
+		abortQuotaExceeded(ctx, err.(*QuotaExceededError))
+		return
+	}
 	
 	// create new product
 	post := Post{
 		Body: createPostDto.Body,
+		Visibility: createPostDto.Visibility,
+		Sensitive: createPostDto.Sensitive,
+	}
+
+	// validate only, nothing is saved or published
+	if IsDryRun(ctx) {
+		applyPostDefaults(&post)
+		writeDryRun(ctx, gin.H{"post": ToPostDto(post)})
+		return
+	}
+
+	// spam is rejected, likely spam is saved held for review (see spam.go)
+	if !CheckSpam(ctx, ErrAreaCreatePost, &post) {
+		return
 	}
 
-	// save to database
-	db.Create(&post)
+	// save to database (request transaction, see transaction.go)
+	DB(ctx).Create(&post)
 	if post.ID == 0 {
-		ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+		WriteJSON(ctx, http.StatusUnprocessableEntity, gin.H{
 			"status": false,
-			"type": "create-post/save",
+			"type": ErrCodeCreatePostSave,
 			"message": "Unprocessable inputs ensured.",
 		})
 		return
 	}
 
-	// fire event for notify other services for changes
-	// Simple Publisher
-	nc.Publish("post.created", []byte("Post Created Body: " + post.Body))
+	// abuse signals (bursts from one ip, repeated bodies)
+	anomalies.ObservePost(ctx, post)
+
+	AfterCommit(ctx, func() {
+		// new post changes post lists
+		responseCache.Invalidate(PostListCache.Group)
 
-	// return post
-	ctx.JSON(http.StatusOK, gin.H{
-		"post": post,
+		// fire event for notify other services for changes
+		// Simple Publisher
+		PublishEventContext(ctx.Request.Context(), SubjectPostCreated, []byte("Post Created Body: " + post.Body))
+	})
+
+	// return post (202 when held for spam review)
+	WriteJSON(ctx, createdPostStatus(post), gin.H{
+		"post": ToPostDto(post),
 	})
 }
 
@@ -459,7 +796,7 @@ func CreatePostHandler(ctx *gin.Context) {
 
 /**
 *	--------------- HTTP Get /post Section ---------------
-*	1 - Get Pagination values
+*	1 - Get Pagination values (Paginator)
 *	3 - Connect to Database
 *	4 - Do your database operations
 *	5 - Emit event for notify other services for changes
@@ -474,8 +811,14 @@ func CreatePostHandler(ctx *gin.Context) {
 // @Schemes 
 // @Description Get Posts with limit and page
 // @Tags post-service
-// @Param limit query int false "limit"
+// @Param limit query int false "limit (1-100)"
 // @Param page query int false "page"
+// @Param count query bool false "set false to skip total count"
+// @Param sort query string false "comma separated id,created_at,updated_at with - prefix for desc (default -id)"
+// @Param created_after query string false "RFC3339 or YYYY-MM-DD"
+// @Param created_before query string false "RFC3339 or YYYY-MM-DD"
+// @Param fields query string false "comma separated response fields like id,body,created_at"
+// @Param sensitive query string false "blur (default), show or hide sensitive posts"
 // @Accept application/json
 // @Produce json
 // @Success 200 {object} object
@@ -485,26 +828,64 @@ func CreatePostHandler(ctx *gin.Context) {
 // @Failure 500 {object} object
 // @Router /post/ [get]
 func GetPostsHandler(ctx *gin.Context) {
-	// get pagination params page should be 1<=page<100 and limit should be 1<=limit<50
-	limitQ := ctx.DefaultQuery("limit", "10")
-	if(limitQ == "" || limitQ < "1" || limitQ > "100") { limitQ = "10" } 
-	pageQ := ctx.DefaultQuery("page", "1")
-	if(pageQ == "" || pageQ < "1" || pageQ > "100") { pageQ = "1" }
+	// bind and validate query params like page=1&limit=10&created_after=2024-01-01
+	var listQuery PostListQuery
+	if err := BindQuery(ctx, &listQuery, ErrAreaGetPosts); err != nil {
+		return
+	}
+	paginator := NewPaginator(ctx, listQuery.ListQuery)
 
-	// cast to int
-	limit,_ := strconv.Atoi(limitQ)
-	page,_ := strconv.Atoi(pageQ)
-	offset := (page - 1) * limit
+	// validate sparse fieldset like fields=id,body
+	fields, err := ParseFields(ctx, PostDto{})
+	if err != nil {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status": false,
+			"type": ErrAreaGetPosts + ErrSuffixQueryParams,
+			"message": err.Error(),
+		})
+		return
+	}
 
-	// get all posts by limit and offset
-	var posts []Post
-	db.Limit(limit).Offset(offset).Find(&posts)
+	// get posts of page with whitelisted sort and filters like sort=-created_at&created_after=2024-01-01
+	// unlisted posts are only reachable by id (see post_repository.go)
+	posts, err := postRepository.ListPosts(ctx, listQuery, paginator)
+	var listQueryErr ListQueryError
+	if errors.As(err, &listQueryErr) {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status": false,
+			"type": ErrAreaGetPosts + ErrSuffixQueryParams,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status": false,
+			"type": ErrCodeGetPostsQuery,
+			"message": err.Error(),
+		})
+		return
+	}
 
 	// fire event for notify other services for changes
-	nc.Publish("post.select", []byte("Post Got by ip: " + ctx.ClientIP()))
+	// post stream keeps events for days, no client ip or other personal data in payload
+	payload, _ := json.Marshal(map[string]int{"page": paginator.Page, "limit": paginator.Limit, "count": len(posts)})
+	PublishEventContext(ctx.Request.Context(), SubjectPostListed, payload)
+
+	// embed shared posts of reposts and quotes
+	dtos := ToPostDtos(posts)
+	if err := EmbedOriginals(posts, dtos); err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status": false,
+			"type": ErrCodeGetPostsQuery,
+			"message": err.Error(),
+		})
+		return
+	}
 
-	// return posts
-	ctx.JSON(http.StatusOK, gin.H{
-		"posts": posts,
-	})
+	// sensitive bodies are omitted unless sensitive=show
+	dtos = RedactSensitivePosts(dtos, listQuery.Sensitive)
+
+	// return posts in list envelope
+	WriteJSON(ctx, http.StatusOK, paginator.Response(ShapeFields(dtos, fields)))
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Test App
+*	Tests run against the router of main (NewRouter) in DEV_MODE: sqlite
+*	in a temp dir and embedded NATS, so no services are needed. TMPDIR
+*	points into the temp dir as well, so messages queued by tests stay out
+*	of the JetStream store of dev runs. Requests go through httptest
+*	without a listener.
+*/
+var testRouter *gin.Engine
+
+const testAdminAuth = "admin:test"
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "alyafn-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DEV_MODE", "true")
+	os.Setenv("DEV_SQLITE_PATH", filepath.Join(dir, "test.db"))
+	os.Setenv("NATS_URL", "")
+	os.Setenv("APP_STAT_AUTH", testAdminAuth)
+	os.Setenv("APP_ACCESS_LOG", "false")
+	os.Setenv("MAIL_DIR", filepath.Join(dir, "mails"))
+	os.Setenv("TMPDIR", dir)
+
+	testRouter, err = setupTestApp()
+	if err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupTestApp : connections and globals of main before the router, then NewRouter
+func setupTestApp() (*gin.Engine, error) {
+	if err := ApplyDevModeDefaults(); err != nil {
+		return nil, err
+	}
+	if err := ApplyEnvProfile(); err != nil {
+		return nil, err
+	}
+	if err := LoadModules(); err != nil {
+		return nil, err
+	}
+	var err error
+	if secrets, err = NewSecretStore(); err != nil {
+		return nil, err
+	}
+	if eventCrypto, err = InitEventCrypto(); err != nil {
+		return nil, err
+	}
+	InitDbConnection("")
+	if idGenerator, err = InitIDGenerator(); err != nil {
+		return nil, err
+	}
+	InitDbMigrations()
+	if postRepository, err = InitPostRepository(); err != nil {
+		return nil, err
+	}
+	if locks, err = InitLocker(); err != nil {
+		return nil, err
+	}
+	leader = StartLeaderElection()
+	if nc, err = InitNatsConnection(); err != nil {
+		return nil, err
+	}
+	if err := BootstrapStreams(nc); err != nil {
+		return nil, err
+	}
+	eventBuffer = StartEventBuffer()
+	if mailProvider, err = InitMailProvider(); err != nil {
+		return nil, err
+	}
+	if errorReporter, err = InitErrorReporter(); err != nil {
+		return nil, err
+	}
+	if translationProvider, err = InitTranslationProvider(); err != nil {
+		return nil, err
+	}
+	return NewRouter()
+}
+
+// testRequest : serves request on testRouter, body is sent as json when not empty
+func testRequest(method string, path string, body string, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewBufferString(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	return rec
+}
+
+// basicAuth : Authorization header value of user:password
+func basicAuth(credentials string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
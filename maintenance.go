@@ -0,0 +1,146 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// page cacher (used as shared state store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+)
+
+/**
+*	Maintenance Mode
+*	State is kept in cache store (shared between replicas when redis is
+*	used) and toggled from admin endpoint. While enabled all routes except
+*	maintenanceAllowedRoutes return 503 with Retry-After, clients in
+*	MAINTENANCE_ALLOWED_IPS pass through.
+*/
+const maintenanceKey = "maintenance_mode"
+
+// maintenanceAllowedRoutes : route templates served during maintenance,
+// probes and ops endpoints plus the maintenance toggle itself
+var maintenanceAllowedRoutes = map[string]bool{
+	"/v1/post/_/ready":            true,
+	"/v1/post/_/health":           true,
+	"/v1/post/_/metrics":          true,
+	"/v1/post/_/version":          true,
+	"/v1/post/_/errors":           true,
+	"/v1/post/_/app_kernel_stats": true,
+	"/v1/post/_/reload":           true,
+	"/v1/_/capabilities":          true,
+	"/v1/_/leader":                true,
+	"/v1/admin/maintenance":       true,
+}
+
+type MaintenanceState struct {
+	Enabled    bool      `json:"enabled"`
+	Message    string    `json:"message"`
+	RetryAfter int       `json:"retry_after"`
+	Since      time.Time `json:"since"`
+}
+
+func getMaintenanceState(store persistence.CacheStore) MaintenanceState {
+	var state MaintenanceState
+	if err := store.Get(maintenanceKey, &state); err != nil {
+		return MaintenanceState{}
+	}
+	return state
+}
+
+func MaintenanceMiddleware(store persistence.CacheStore) gin.HandlerFunc {
+	allowedIPs := ParseIPList(os.Getenv("MAINTENANCE_ALLOWED_IPS"))
+	return func(ctx *gin.Context) {
+		if maintenanceAllowedRoutes[ctx.FullPath()] {
+			ctx.Next()
+			return
+		}
+		state := getMaintenanceState(store)
+		if !state.Enabled || allowedIPs.Contains(ClientIP(ctx)) {
+			ctx.Next()
+			return
+		}
+		ctx.Header("Retry-After", strconv.Itoa(state.RetryAfter))
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"status":      false,
+			"type":        ErrCodeServerMaintenance,
+			"message":     state.Message,
+			"retry_after": state.RetryAfter,
+		})
+	}
+}
+
+/**
+*	--------------- HTTP /admin/maintenance Section ---------------
+*/
+type MaintenanceDto struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message" validate:"max=255"`
+	RetryAfter int    `json:"retry_after" validate:"min=0,max=86400"`
+}
+
+// GetMaintenanceHandler godoc
+// @Summary Get maintenance mode state
+// @Schemes
+// @Tags admin
+// @Security BasicAuth
+// @Produce json
+// @Success 200 {object} MaintenanceState
+// @Router /admin/maintenance [get]
+func GetMaintenanceHandler(store persistence.CacheStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		WriteJSON(ctx, http.StatusOK, gin.H{
+			"maintenance": getMaintenanceState(store),
+		})
+	}
+}
+
+// SetMaintenanceHandler godoc
+// @Summary Enable or disable maintenance mode
+// @Schemes
+// @Description While enabled all non-health routes return 503 with Retry-After except MAINTENANCE_ALLOWED_IPS
+// @Tags admin
+// @Security BasicAuth
+// @Param body body MaintenanceDto true "maintenance state"
+// @Accept application/json
+// @Produce json
+// @Success 200 {object} MaintenanceState
+// @Failure 400 {object} object
+// @Failure 500 {object} object
+// @Router /admin/maintenance [put]
+func SetMaintenanceHandler(store persistence.CacheStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var dto MaintenanceDto
+		if err := BindDto(ctx, &dto, ErrAreaMaintenance); err != nil {
+			return
+		}
+		state := MaintenanceState{
+			Enabled:    dto.Enabled,
+			Message:    dto.Message,
+			RetryAfter: dto.RetryAfter,
+			Since:      time.Now().UTC(),
+		}
+		if state.Message == "" {
+			state.Message = "We are doing some maintenance, please try again shortly."
+		}
+		if state.RetryAfter == 0 {
+			state.RetryAfter = 300
+		}
+		if err := store.Set(maintenanceKey, state, persistence.FOREVER); err != nil {
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeMaintenanceSave,
+				"message": err.Error(),
+			})
+			return
+		}
+		LogWarn("Maintenance mode changed", LogFields{"enabled": state.Enabled, "client_ip": ClientIP(ctx)})
+		WriteJSON(ctx, http.StatusOK, gin.H{
+			"maintenance": state,
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Prometheus Metrics
+*	Minimal counter, gauge and histogram types rendered in Prometheus text
+*	exposition format (Docs: https://prometheus.io/docs/instrumenting/exposition_formats/)
+*	at GET /_/metrics. Metrics register themselves on creation.
+*/
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+var (
+	metricsMu         sync.Mutex
+	metricsRegistry   []metric
+	metricsCollectors []func()
+)
+
+// registerCollector : fn runs before every scrape to refresh pulled values (e.g. gauges from sql.DBStats)
+func registerCollector(fn func()) {
+	metricsMu.Lock()
+	metricsCollectors = append(metricsCollectors, fn)
+	metricsMu.Unlock()
+}
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	metricsRegistry = append(metricsRegistry, m)
+	metricsMu.Unlock()
+}
+
+// labelKey : joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatLabels(names []string, key string, extra ...string) string {
+	pairs := []string{}
+	if len(names) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, name := range names {
+			pairs = append(pairs, name+"=\""+escapeLabel(values[i])+"\"")
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"=\""+escapeLabel(extra[i+1])+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\n", "\\n", -1)
+	return strings.Replace(value, "\"", "\\\"", -1)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/**
+*	Counter : monotonically increasing value per label set
+*/
+type Counter struct {
+	name, help string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{name: name, help: help, labelNames: labelNames, values: map[string]float64{}}
+	registerMetric(c)
+	return c
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[labelKey(labelValues)] += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf.WriteString("# HELP " + c.name + " " + c.help + "\n# TYPE " + c.name + " counter\n")
+	for _, key := range sortedKeys(c.values) {
+		buf.WriteString(c.name + formatLabels(c.labelNames, key) + " " + formatFloat(c.values[key]) + "\n")
+	}
+}
+
+/**
+*	Gauge : value that can go up and down per label set
+*/
+type Gauge struct {
+	name, help string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{name: name, help: help, labelNames: labelNames, values: map[string]float64{}}
+	registerMetric(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	g.values[labelKey(labelValues)] = value
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	g.values[labelKey(labelValues)] += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(buf *bytes.Buffer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	buf.WriteString("# HELP " + g.name + " " + g.help + "\n# TYPE " + g.name + " gauge\n")
+	for _, key := range sortedKeys(g.values) {
+		buf.WriteString(g.name + formatLabels(g.labelNames, key) + " " + formatFloat(g.values[key]) + "\n")
+	}
+}
+
+/**
+*	Histogram : cumulative buckets, sum and count per label set
+*/
+var defaultSecondsBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type Histogram struct {
+	name, help string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labelNames: labelNames, buckets: buckets, series: map[string]*histogramSeries{}}
+	registerMetric(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buf.WriteString("# HELP " + h.name + " " + h.help + "\n# TYPE " + h.name + " histogram\n")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			buf.WriteString(h.name + "_bucket" + formatLabels(h.labelNames, key, "le", formatFloat(bound)) + " " + strconv.FormatUint(series.counts[i], 10) + "\n")
+		}
+		buf.WriteString(h.name + "_bucket" + formatLabels(h.labelNames, key, "le", "+Inf") + " " + strconv.FormatUint(series.count, 10) + "\n")
+		buf.WriteString(h.name + "_sum" + formatLabels(h.labelNames, key) + " " + formatFloat(series.sum) + "\n")
+		buf.WriteString(h.name + "_count" + formatLabels(h.labelNames, key) + " " + strconv.FormatUint(series.count, 10) + "\n")
+	}
+}
+
+// MetricsHandler godoc
+// @Summary Prometheus metrics
+// @Schemes
+// @Description Returns app metrics in Prometheus text exposition format
+// @Tags post-service-health
+// @Produce plain
+// @Success 200 {string} string
+// @Router /post/_/metrics [get]
+func MetricsHandler(ctx *gin.Context) {
+	metricsMu.Lock()
+	registry := metricsRegistry
+	collectors := metricsCollectors
+	metricsMu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	var buf bytes.Buffer
+	for _, m := range registry {
+		m.write(&buf)
+	}
+	ctx.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
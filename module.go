@@ -0,0 +1,139 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"os"
+	"strings"
+
+	// web server packages
+	"github.com/gin-contrib/cache/persistence"
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Modules
+*	Services of the app are modules listed in appModules, each one owns its
+*	tables, routes and workers so a new service (likes, tags, dms) is a new
+*	module_<name>.go file and one line in appModules:
+*	- Migrate         : AutoMigrate of module tables, runs for every module
+*	                    even when disabled so shared jobs (counter checks,
+*	                    admin stats, exports) and re-enabling keep working
+*	- RegisterRoutes  : mounts handlers on groups of ModuleRoutes
+*	- RegisterWorkers : starts NATS subscribers after the connection is up
+*	APP_MODULES=post,analytics enables a subset (default all), core is always
+*	enabled, unknown names stop the app at start.
+*/
+type Module interface {
+	Name() string
+	Migrate(db *gorm.DB) error
+	RegisterRoutes(routes *ModuleRoutes)
+	RegisterWorkers() error
+}
+
+// ModuleRoutes : shared groups and settings modules mount their routes on
+type ModuleRoutes struct {
+	Engine *gin.Engine
+	// Version : /v1 group with CSRF and terms of service checks
+	Version *gin.RouterGroup
+	// Admin : /v1/admin group with admin auth and json body limit
+	Admin *gin.RouterGroup
+	// AdminAuth : admin ip filter and basic auth for admin groups with other body limits
+	AdminAuth []gin.HandlerFunc
+	// StatAuth : basic auth of APP_STAT_AUTH for health routes
+	StatAuth gin.HandlerFunc
+	// DbGuard : 503 while database pool is saturated, goes after response cache on reads
+	DbGuard     gin.HandlerFunc
+	Store       persistence.CacheStore
+	MaxJsonBody int64
+}
+
+// WithAdminAuth : admin ip filter and basic auth followed by handlers
+func (r *ModuleRoutes) WithAdminAuth(handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	chain := make([]gin.HandlerFunc, 0, len(r.AdminAuth)+len(handlers))
+	chain = append(chain, r.AdminAuth...)
+	return append(chain, handlers...)
+}
+
+// coreModule : name of the module that can not be disabled
+const coreModule = "core"
+
+var appModules = []Module{
+	CoreModule{},
+	PostModule{},
+	AnalyticsModule{},
+}
+
+// enabledModules : modules of APP_MODULES set by LoadModules
+var enabledModules = []Module{}
+
+// LoadModules : selects modules of APP_MODULES, core is always kept
+func LoadModules() error {
+	names := splitList(os.Getenv("APP_MODULES"))
+	enabledModules = []Module{}
+	if len(names) == 0 {
+		enabledModules = append(enabledModules, appModules...)
+		return nil
+	}
+	wanted := map[string]bool{coreModule: true}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if findModule(name) == nil {
+			return errors.New("APP_MODULES has unknown module: " + name)
+		}
+		wanted[name] = true
+	}
+	for _, module := range appModules {
+		if wanted[module.Name()] {
+			enabledModules = append(enabledModules, module)
+		}
+	}
+	return nil
+}
+
+func findModule(name string) Module {
+	for _, module := range appModules {
+		if module.Name() == name {
+			return module
+		}
+	}
+	return nil
+}
+
+// ModuleNames : names of enabled modules in registration order
+func ModuleNames() []string {
+	names := make([]string, 0, len(enabledModules))
+	for _, module := range enabledModules {
+		names = append(names, module.Name())
+	}
+	return names
+}
+
+// MigrateModules : tables of every module, enabled or not
+func MigrateModules(db *gorm.DB) error {
+	for _, module := range appModules {
+		if err := module.Migrate(db); err != nil {
+			return errors.New("migrating module " + module.Name() + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// RegisterModuleRoutes : routes of enabled modules
+func RegisterModuleRoutes(routes *ModuleRoutes) {
+	for _, module := range enabledModules {
+		module.RegisterRoutes(routes)
+	}
+}
+
+// StartModuleWorkers : NATS workers of enabled modules
+func StartModuleWorkers() error {
+	for _, module := range enabledModules {
+		if err := module.RegisterWorkers(); err != nil {
+			return errors.New("starting workers of module " + module.Name() + ": " + err.Error())
+		}
+	}
+	return nil
+}
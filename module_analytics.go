@@ -0,0 +1,32 @@
+package main
+
+import (
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Analytics Module
+*	Client event ingestion, the buffer publishing batches to NATS and the
+*	ClickHouse sink (see analytics.go). Events live outside the app
+*	database, post view counters are aggregated by the post module.
+*/
+type AnalyticsModule struct{}
+
+func (AnalyticsModule) Name() string { return "analytics" }
+
+func (AnalyticsModule) Migrate(db *gorm.DB) error { return nil }
+
+func (AnalyticsModule) RegisterRoutes(routes *ModuleRoutes) {
+	analytics := routes.Version.Group("/analytics", BodyLimitMiddleware(routes.MaxJsonBody))
+	{
+		analytics.POST("/events", IngestAnalyticsHandler)
+	}
+}
+
+func (AnalyticsModule) RegisterWorkers() error {
+	// analytics events buffered for NATS, written to ClickHouse when CLICKHOUSE_URL is set
+	analyticsBuffer = StartAnalyticsBuffer()
+	_, err := StartAnalyticsSink()
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Post Module
+*	Posts with reposts, quotes and translations, signed internal routes,
+*	admin moderation, export and import of posts and their workers.
+*/
+type PostModule struct{}
+
+func (PostModule) Name() string { return "post" }
+
+func (PostModule) Migrate(db *gorm.DB) error {
+	// monthly partitions on Postgres like POSTS_PARTITIONED=true (see partition.go)
+	if err := MigratePostPartitions(db); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&Post{}, &ImportJob{}, &PostStat{}, &PostViewer{}, &PostTranslation{}, &ArchivedPost{}); err != nil {
+		return err
+	}
+	BackfillPublicIDs(&Post{}, "posts")
+	return nil
+}
+
+func (PostModule) RegisterRoutes(routes *ModuleRoutes) {
+	service := routes.Version.Group("/post", BodyLimitMiddleware(routes.MaxJsonBody))
+	{
+		/**
+		*	--------------- APP ROUTES ---------------
+		 */
+		service.GET("/", CacheClass(CachePublic), responseCache.Middleware(PostListCache), routes.DbGuard, GetPostsHandler)
+		service.POST("/", TxMiddleware(), CreatePostHandler)
+		service.GET("/trending", CacheClass(CachePublic), responseCache.Middleware(TrendingCache), routes.DbGuard, TrendingPostsHandler)
+		//service.GET("/:id", GetPostByIdHandler)
+		service.POST("/:id/repost", TxMiddleware(), RepostHandler)
+		service.POST("/:id/quote", TxMiddleware(), QuotePostHandler)
+		service.GET("/:id/translate", routes.DbGuard, TranslatePostHandler)
+
+		/**
+		*	--------------- INTERNAL ROUTES (signed service-to-service calls) ---------------
+		 */
+		internal := service.Group("/internal", ServiceSignatureMiddleware(routes.Store))
+		{
+			internal.GET("/", routes.DbGuard, GetPostsHandler)
+			internal.POST("/", TxMiddleware(), CreatePostHandler)
+		}
+	}
+
+	/**
+	*	--------------- ADMIN ROUTES ---------------
+	 */
+	admin := routes.Admin
+	{
+		admin.GET("/export/posts", ExportPostsHandler)
+		admin.GET("/import/:id", GetImportHandler)
+		admin.PATCH("/posts/:id", TxMiddleware(), PatchPostHandler)
+		admin.GET("/posts/:id/stats", GetPostStatsHandler)
+		admin.GET("/spam", GetHeldPostsHandler)
+		admin.POST("/spam/:id/:decision", ReviewSpamHandler)
+	}
+
+	// import files are larger than json bodies like APP_MAX_IMPORT_BODY=52428800
+	maxImportBody := getEnvInt64("APP_MAX_IMPORT_BODY", defaultMaxImportBody)
+	adminImport := routes.Version.Group("/admin/import", routes.WithAdminAuth(BodyLimitMiddleware(maxImportBody))...)
+	{
+		adminImport.POST("", CreateImportHandler)
+	}
+
+	/*
+		-----------------------------------------------------
+		UPLOAD ROUTES GET LARGER BODY LIMIT AND MALWARE SCAN
+		-----------------------------------------------------
+		maxUploadBody := getEnvInt64("APP_MAX_UPLOAD_BODY", defaultMaxUploadBody)
+		upload := routes.Version.Group("/upload", BodyLimitMiddleware(maxUploadBody), UploadScanMiddleware(virusScanner))
+	*/
+}
+
+func (PostModule) RegisterWorkers() error {
+	// bulk imports queued from /v1/admin/import
+	if _, err := StartImportWorker(); err != nil {
+		return err
+	}
+	// old posts moved to archived_posts like ARCHIVE_AFTER_MONTHS=12 ARCHIVE_INTERVAL=24h
+	StartArchiveSchedule()
+	// upcoming monthly partitions of posts on Postgres
+	StartPostPartitionSchedule()
+	// view counters of posts from analytics events
+	_, err := StartPostStatsWorker()
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	// openapi packages
+	"git.yazgan.xyz/alperreha/alya-go-fn-boilerplate/docs"
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/swaggo/swag"
+)
+
+/**
+*	Response Validation
+*	Responses of the router are checked against the swagger annotations
+*	(generated docs, converted to OpenAPI 3): status code has to be
+*	documented for the route and the body has to match its schema.
+*	Regenerate docs with swag init after changing annotations.
+*/
+func openapiRouter(t *testing.T) routers.Router {
+	t.Helper()
+	spec, err := swag.ReadDoc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc2 openapi2.T
+	if err := json.Unmarshal([]byte(spec), &doc2); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// httptest requests are sent to example.com
+	doc.Servers = openapi3.Servers{{URL: "http://example.com" + docs.SwaggerInfo.BasePath}}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+// validateResponse : fails test when response of req is not documented by spec
+func validateResponse(t *testing.T, router routers.Router, req *http.Request, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	route, pathParams, err := router.FindRoute(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status: rec.Code,
+		Header: rec.Header(),
+		Body:   io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+		},
+	}
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		t.Errorf("%s %s -> %d %s: %v", req.Method, req.URL.Path, rec.Code, rec.Body.String(), err)
+	}
+}
+
+func TestResponsesMatchOpenAPI(t *testing.T) {
+	router := openapiRouter(t)
+	admin := http.Header{"Authorization": {basicAuth(testAdminAuth)}}
+
+	created := testRequest(http.MethodPost, "/v1/post/", `{"body":"openapi test post"}`, nil)
+	var createdBody struct {
+		Post PostDto `json:"post"`
+	}
+	if err := json.Unmarshal(created.Body.Bytes(), &createdBody); err != nil || createdBody.Post.ID == "" {
+		t.Fatalf("create post: %d %s", created.Code, created.Body.String())
+	}
+	postID := createdBody.Post.ID
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header http.Header
+	}{
+		{"create post", http.MethodPost, "/v1/post/", `{"body":"second openapi post"}`, nil},
+		{"create post invalid", http.MethodPost, "/v1/post/", `{"body":""}`, nil},
+		{"create post dry run", http.MethodPost, "/v1/post/?dry_run=true", `{"body":"dry run post"}`, nil},
+		{"list posts", http.MethodGet, "/v1/post/?limit=5", "", nil},
+		{"list posts invalid sort", http.MethodGet, "/v1/post/?sort=body", "", nil},
+		{"trending posts", http.MethodGet, "/v1/post/trending", "", nil},
+		{"repost", http.MethodPost, "/v1/post/" + postID + "/repost", "", nil},
+		{"quote", http.MethodPost, "/v1/post/" + postID + "/quote", `{"body":"quoted"}`, nil},
+		{"repost missing", http.MethodPost, "/v1/post/00000000-0000-0000-0000-000000000000/repost", "", nil},
+		{"version", http.MethodGet, "/v1/post/_/version", "", nil},
+		{"ready", http.MethodGet, "/v1/post/_/ready", "", nil},
+		{"errors", http.MethodGet, "/v1/post/_/errors", "", nil},
+		{"health", http.MethodGet, "/v1/post/_/health", "", admin},
+		{"capabilities", http.MethodGet, "/v1/_/capabilities", "", nil},
+		{"csrf", http.MethodGet, "/v1/auth/csrf", "", nil},
+		{"tos", http.MethodGet, "/v1/tos", "", nil},
+		{"admin stats", http.MethodGet, "/v1/admin/stats", "", admin},
+		{"admin maintenance", http.MethodGet, "/v1/admin/maintenance", "", admin},
+		{"admin ip filters", http.MethodGet, "/v1/admin/ip-filters", "", admin},
+		{"admin spam", http.MethodGet, "/v1/admin/spam", "", admin},
+		{"admin post stats", http.MethodGet, "/v1/admin/posts/" + postID + "/stats", "", admin},
+		{"admin patch post", http.MethodPatch, "/v1/admin/posts/" + postID, `{"visibility":"public"}`, admin},
+		{"admin dead letters", http.MethodGet, "/v1/admin/dead-letters", "", admin},
+		{"admin dead letter missing", http.MethodGet, "/v1/admin/dead-letters/999999", "", admin},
+		{"admin import missing", http.MethodGet, "/v1/admin/import/999999", "", admin},
+		{"translate", http.MethodGet, "/v1/post/" + postID + "/translate?lang=de", "", nil},
+		{"accept tos", http.MethodPost, "/v1/user/me/accept-tos", `{"version":"missing"}`, nil},
+		{"mail test", http.MethodPost, "/v1/admin/mail/test", `{"to":"dev@example.com","template":"digest"}`, admin},
+		{"mail test invalid", http.MethodPost, "/v1/admin/mail/test", `{"to":"dev","template":"welcome"}`, admin},
+		{"leader", http.MethodGet, "/v1/_/leader", "", admin},
+		{"reload", http.MethodPost, "/v1/post/_/reload", "", admin},
+		{"chaos faults", http.MethodGet, "/v1/_/chaos", "", admin},
+		{"chaos fault", http.MethodPost, "/v1/_/chaos", `{"route":"/v1/_/unused","kind":"latency","latency_ms":1,"duration_seconds":1}`, admin},
+		{"chaos fault invalid", http.MethodPost, "/v1/_/chaos", `{"kind":"unknown"}`, admin},
+		{"chaos fault missing", http.MethodDelete, "/v1/_/chaos/missing", "", admin},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := testRequest(tc.method, tc.path, tc.body, tc.header)
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			validateResponse(t, router, req, rec)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	// system packages
+	"reflect"
+	"strconv"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Paginator
+*	Shared list response envelope used by all list endpoints:
+*	{data, meta:{page,limit,total,has_next}, links:{next,prev}}
+*	?count=false skips the COUNT query (total is null, has_next comes
+*	from fetching one extra row). page, limit (default 10, max 100) and
+*	count are bound and validated by ListQuery.
+*/
+type PageMeta struct {
+	Page    int    `json:"page"`
+	Limit   int    `json:"limit"`
+	Total   *int64 `json:"total"`
+	HasNext bool   `json:"has_next"`
+}
+
+type PageLinks struct {
+	Next *string `json:"next"`
+	Prev *string `json:"prev"`
+}
+
+type Paginator struct {
+	Page      int
+	Limit     int
+	WithCount bool
+	meta      PageMeta
+	ctx       *gin.Context
+}
+
+// NewPaginator : paginator of bound and validated list query
+func NewPaginator(ctx *gin.Context, query ListQuery) *Paginator {
+	return &Paginator{
+		Page:      query.Page,
+		Limit:     query.Limit,
+		WithCount: query.Count,
+		ctx:       ctx,
+	}
+}
+
+func (p *Paginator) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+/**
+*	Find : runs query into dest (pointer to slice) for current page.
+*	Query should carry model, filters and order; limit/offset are set here.
+*/
+func (p *Paginator) Find(query *gorm.DB, dest interface{}) error {
+	return p.FindFunc(dest,
+		func() (int64, error) {
+			var total int64
+			err := query.Session(&gorm.Session{}).Count(&total).Error
+			return total, err
+		},
+		func(limit, offset int) error {
+			return query.Limit(limit).Offset(offset).Find(dest).Error
+		},
+	)
+}
+
+/**
+*	FindFunc : same as Find for queries outside GORM, count is called
+*	unless count=false and find fills dest with limit rows from offset.
+*/
+func (p *Paginator) FindFunc(dest interface{}, count func() (int64, error), find func(limit, offset int) error) error {
+	p.meta = PageMeta{Page: p.Page, Limit: p.Limit}
+	if p.WithCount {
+		total, err := count()
+		if err != nil {
+			return err
+		}
+		p.meta.Total = &total
+		p.meta.HasNext = int64(p.Offset()+p.Limit) < total
+	}
+
+	// fetch one extra row to know if there is a next page without counting
+	if err := find(p.Limit+1, p.Offset()); err != nil {
+		return err
+	}
+	rows := reflect.ValueOf(dest).Elem()
+	if rows.Len() > p.Limit {
+		p.meta.HasNext = true
+		rows.Set(rows.Slice(0, p.Limit))
+	}
+	return nil
+}
+
+// Meta : returns page meta after Find
+func (p *Paginator) Meta() PageMeta {
+	return p.meta
+}
+
+// Links : next and prev urls keeping other query params
+func (p *Paginator) Links() PageLinks {
+	links := PageLinks{}
+	if p.meta.HasNext {
+		next := p.pageUrl(p.Page + 1)
+		links.Next = &next
+	}
+	if p.Page > 1 {
+		prev := p.pageUrl(p.Page - 1)
+		links.Prev = &prev
+	}
+	return links
+}
+
+func (p *Paginator) pageUrl(page int) string {
+	u := *p.ctx.Request.URL
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("limit", strconv.Itoa(p.Limit))
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// Response : standard list envelope for data
+func (p *Paginator) Response(data interface{}) gin.H {
+	return gin.H{
+		"data":  data,
+		"meta":  p.Meta(),
+		"links": p.Links(),
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Posts Partitioning (Postgres)
+*	With POSTS_PARTITIONED=true on Postgres a new posts table is created
+*	partitioned by month of created_at (posts_y2026m01, ...) plus
+*	posts_default for rows outside them (imports of old posts). A
+*	maintenance job keeps POSTS_PARTITIONS_AHEAD months created ahead
+*	(every POSTS_PARTITION_INTERVAL on the leader, see scheduler.go).
+*	Unique indexes of partitioned tables must contain created_at, so
+*	public_id and external_id get plain indexes under GORM's index names
+*	(AutoMigrate sees them as existing) and their uniqueness is kept by
+*	the unpartitioned post_unique_keys table, filled by a trigger on posts
+*	(an insert with a taken key fails on its primary key). Imports reject
+*	future created_at, such rows would sit in posts_default and block the
+*	partition of their month.
+*	The primary key is (id, created_at), so Postgres does not accept
+*	posts.* grouped by posts.id alone: aggregates over posts (trending)
+*	are computed in a subquery keyed by post id and joined.
+*	Lookups by public id add a created_at window from the UUIDv7 time so
+*	only one or two partitions are scanned, a miss (backfilled or imported
+*	ids) retries without it. An existing unpartitioned posts table is left
+*	as is and logged, it has to be copied into a partitioned one by hand.
+*	sqlite (DEV_MODE) ignores the setting.
+*/
+var postPartitioning bool
+
+var postPartitionCount = NewGauge(
+	"post_partitions",
+	"Partitions of the posts table (Postgres with POSTS_PARTITIONED=true).",
+)
+
+const createPartitionedPosts = `CREATE TABLE posts (
+	id             BIGSERIAL NOT NULL,
+	created_at     TIMESTAMPTZ NOT NULL,
+	updated_at     TIMESTAMPTZ,
+	deleted_at     TIMESTAMPTZ,
+	public_id      VARCHAR(36),
+	body           VARCHAR(255) NOT NULL,
+	external_id    VARCHAR(64),
+	visibility     VARCHAR(16) NOT NULL DEFAULT 'public',
+	kind           VARCHAR(8) NOT NULL DEFAULT 'post',
+	original_id    BIGINT,
+	reposted_count BIGINT NOT NULL DEFAULT 0,
+	is_sensitive   BOOLEAN NOT NULL DEFAULT false,
+	spam_status    VARCHAR(8) NOT NULL DEFAULT 'clean',
+	spam_score     DECIMAL NOT NULL DEFAULT 0,
+	PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at)`
+
+var partitionedPostIndexes = []string{
+	"CREATE INDEX idx_posts_deleted_at ON posts (deleted_at)",
+	"CREATE INDEX idx_posts_public_id ON posts (public_id)",
+	"CREATE INDEX idx_posts_external_id ON posts (external_id)",
+	"CREATE INDEX idx_posts_visibility ON posts (visibility)",
+	"CREATE INDEX idx_posts_original_id ON posts (original_id)",
+	"CREATE INDEX idx_posts_is_sensitive ON posts (is_sensitive)",
+	"CREATE INDEX idx_posts_spam_status ON posts (spam_status)",
+	"CREATE TABLE posts_default PARTITION OF posts DEFAULT",
+}
+
+// postUniqueKeys : key table and trigger, trigger and backfill run only when the trigger is missing
+var postUniqueKeys = []string{
+	`CREATE TABLE IF NOT EXISTS post_unique_keys (
+	name  VARCHAR(16) NOT NULL,
+	value VARCHAR(64) NOT NULL,
+	PRIMARY KEY (name, value)
+)`,
+	`CREATE OR REPLACE FUNCTION posts_unique_keys() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		DELETE FROM post_unique_keys WHERE (name = 'public_id' AND value = OLD.public_id) OR (name = 'external_id' AND value = OLD.external_id);
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		IF NEW.public_id IS NOT NULL THEN
+			INSERT INTO post_unique_keys (name, value) VALUES ('public_id', NEW.public_id);
+		END IF;
+		IF NEW.external_id IS NOT NULL THEN
+			INSERT INTO post_unique_keys (name, value) VALUES ('external_id', NEW.external_id);
+		END IF;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql`,
+}
+
+var postUniqueKeysTrigger = []string{
+	"CREATE TRIGGER posts_unique_keys AFTER INSERT OR UPDATE OF public_id, external_id OR DELETE ON posts" +
+		" FOR EACH ROW EXECUTE FUNCTION posts_unique_keys()",
+	"INSERT INTO post_unique_keys (name, value) SELECT 'public_id', public_id FROM posts WHERE public_id IS NOT NULL",
+	"INSERT INTO post_unique_keys (name, value) SELECT 'external_id', external_id FROM posts WHERE external_id IS NOT NULL",
+}
+
+// MigratePostPartitions : creates partitioned posts before AutoMigrate when asked and the table is new
+func MigratePostPartitions(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" || !envBool("POSTS_PARTITIONED", false) {
+		return nil
+	}
+	if !db.Migrator().HasTable(&Post{}) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range append([]string{createPartitionedPosts}, partitionedPostIndexes...) {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		LogInfo("Created partitioned posts table", nil)
+	}
+	var partitioned int64
+	if err := db.Raw("SELECT COUNT(*) FROM pg_partitioned_table WHERE partrelid = to_regclass('posts')").Scan(&partitioned).Error; err != nil {
+		return err
+	}
+	if partitioned == 0 {
+		LogWarn("POSTS_PARTITIONED is set but posts is not partitioned, copy it into a partitioned table by hand", nil)
+		return nil
+	}
+	postPartitioning = true
+	if err := ensurePostUniqueKeys(db); err != nil {
+		return err
+	}
+	// posts still land in posts_default, the schedule retries
+	if err := EnsurePostPartitions(db, utcNow(), int(getEnvInt64("POSTS_PARTITIONS_AHEAD", 3))); err != nil {
+		LogError("Post partition maintenance failed", LogFields{"error": err})
+	}
+	return nil
+}
+
+// ensurePostUniqueKeys : public_id and external_id uniqueness of partitioned posts
+func ensurePostUniqueKeys(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range postUniqueKeys {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		var triggers int64
+		if err := tx.Raw("SELECT COUNT(*) FROM pg_trigger WHERE tgname = 'posts_unique_keys' AND tgrelid = to_regclass('posts')").Scan(&triggers).Error; err != nil {
+			return err
+		}
+		if triggers > 0 {
+			return nil
+		}
+		// fails on duplicates left by tables created without the trigger, they have to be merged by hand
+		for _, statement := range postUniqueKeysTrigger {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		LogInfo("Created post_unique_keys trigger", nil)
+		return nil
+	})
+}
+
+// postPartitionName : partition of month like posts_y2026m01
+func postPartitionName(month time.Time) string {
+	return month.Format("posts_y2006m01")
+}
+
+// EnsurePostPartitions : creates monthly partitions from month of now to ahead months later
+func EnsurePostPartitions(db *gorm.DB, now time.Time, ahead int) error {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= ahead; i++ {
+		from, to := month.AddDate(0, i, 0), month.AddDate(0, i+1, 0)
+		// fails while posts_default holds rows of the month, they have to be moved out first
+		err := db.Exec(
+			"CREATE TABLE IF NOT EXISTS " + postPartitionName(from) + " PARTITION OF posts" +
+				" FOR VALUES FROM ('" + from.Format(time.RFC3339) + "') TO ('" + to.Format(time.RFC3339) + "')",
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	var partitions int64
+	err := db.Raw("SELECT COUNT(*) FROM pg_inherits WHERE inhparent = to_regclass('posts')").Scan(&partitions).Error
+	postPartitionCount.Set(float64(partitions))
+	return err
+}
+
+// StartPostPartitionSchedule : creates upcoming partitions every POSTS_PARTITION_INTERVAL (default 24h)
+func StartPostPartitionSchedule() {
+	if !postPartitioning {
+		return
+	}
+	ahead := int(getEnvInt64("POSTS_PARTITIONS_AHEAD", 3))
+	RunScheduled("post-partitions", getEnvDuration("POSTS_PARTITION_INTERVAL", 24*time.Hour), func(ctx context.Context) error {
+		if err := EnsurePostPartitions(db.WithContext(ctx), utcNow(), ahead); err != nil {
+			LogError("Post partition maintenance failed", LogFields{"error": err})
+			return err
+		}
+		return nil
+	})
+}
+
+// publicIDTime : creation time of UUIDv7 public id (first 48 bits are unix milliseconds)
+func publicIDTime(publicId string) (time.Time, bool) {
+	raw, err := hex.DecodeString(strings.Replace(publicId, "-", "", -1))
+	if err != nil || len(raw) != 16 || raw[6]>>4 != 7 {
+		return time.Time{}, false
+	}
+	var millis int64
+	for _, b := range raw[:6] {
+		millis = millis<<8 | int64(b)
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC(), true
+}
+
+// findPartitionedPost : lookup of publicId in partitions around its creation time first
+func findPartitionedPost(publicId string, post *Post) error {
+	created, ok := publicIDTime(publicId)
+	if !postPartitioning || !ok {
+		return db.Where("public_id = ?", publicId).First(post).Error
+	}
+	err := db.Where("public_id = ? AND created_at BETWEEN ? AND ?", publicId, created.Add(-24*time.Hour), created.Add(24*time.Hour)).First(post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// public id backfilled or created_at imported, not close to each other
+		err = db.Where("public_id = ?", publicId).First(post).Error
+	}
+	return err
+}
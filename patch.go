@@ -0,0 +1,349 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	PATCH Support
+*	ApplyPatch applies request body to the current state of an entity dto:
+*	- application/merge-patch+json : RFC 7396, null removes a field
+*	- application/json-patch+json  : RFC 6902 ops add, remove, replace,
+*	                                 move, copy, test (failed test is 409)
+*	Result is decoded strictly into the update dto (unknown fields are
+*	rejected so read-only fields can not be patched) and validated like
+*	BindDto. Changed top level fields are returned for audit logs and events.
+*/
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+var errPatchTestFailed = errors.New("test operation failed")
+
+type jsonPatchOp struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// ApplyPatch : patches current into dest (pointer to update dto), writes error envelope on failure
+func ApplyPatch(ctx *gin.Context, current interface{}, dest interface{}, area string) ([]string, error) {
+	contentType, _, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if contentType != MergePatchContentType && contentType != JSONPatchContentType {
+		WriteJSON(ctx, http.StatusUnsupportedMediaType, gin.H{
+			"status":  false,
+			"type":    ErrCodePatchContentType,
+			"message": "Content-Type must be " + MergePatchContentType + " or " + JSONPatchContentType + ".",
+		})
+		return nil, errors.New("unsupported patch content type")
+	}
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			abortBodyTooLarge(ctx, getEnvInt64("APP_MAX_JSON_BODY", defaultMaxJsonBody))
+			return nil, err
+		}
+		return nil, abortPatchInvalid(ctx, err)
+	}
+
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return nil, abortPatchInvalid(ctx, err)
+	}
+	var before, after interface{}
+	json.Unmarshal(encoded, &before)
+	json.Unmarshal(encoded, &after)
+
+	if contentType == MergePatchContentType {
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, abortPatchInvalid(ctx, err)
+		}
+		after = mergePatch(after, patch)
+	} else {
+		var ops []jsonPatchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, abortPatchInvalid(ctx, err)
+		}
+		if after, err = applyJSONPatch(after, ops); err != nil {
+			if errors.Is(err, errPatchTestFailed) {
+				WriteJSON(ctx, http.StatusConflict, gin.H{
+					"status":  false,
+					"type":    ErrCodePatchTestFailed,
+					"message": err.Error(),
+				})
+				return nil, err
+			}
+			return nil, abortPatchInvalid(ctx, err)
+		}
+	}
+
+	patched, _ := json.Marshal(after)
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dest); err != nil {
+		return nil, abortPatchInvalid(ctx, err)
+	}
+	if err := validate.Struct(dest); err != nil {
+		WriteJSON(ctx, http.StatusBadRequest, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixValidation,
+			"message": err.Error(),
+			"errors":  ValidationErrors(err),
+		})
+		return nil, err
+	}
+	return changedFields(before, after), nil
+}
+
+func abortPatchInvalid(ctx *gin.Context, err error) error {
+	WriteJSON(ctx, http.StatusBadRequest, gin.H{
+		"status":  false,
+		"type":    ErrCodePatchInvalid,
+		"message": err.Error(),
+	})
+	return err
+}
+
+// changedFields : top level keys whose values differ, sorted
+func changedFields(before, after interface{}) []string {
+	beforeMap, _ := before.(map[string]interface{})
+	afterMap, _ := after.(map[string]interface{})
+	fields := []string{}
+	for key, value := range afterMap {
+		if !reflect.DeepEqual(beforeMap[key], value) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range beforeMap {
+		if _, ok := afterMap[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// mergePatch : RFC 7396 merge of patch into target
+func mergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+			continue
+		}
+		targetMap[key] = mergePatch(targetMap[key], value)
+	}
+	return targetMap
+}
+
+// applyJSONPatch : RFC 6902 ops applied in order, doc is modified in place and any failure aborts whole patch
+func applyJSONPatch(doc interface{}, ops []jsonPatchOp) (interface{}, error) {
+	var err error
+	for i, op := range ops {
+		var value interface{}
+		if op.Value != nil {
+			json.Unmarshal(*op.Value, &value)
+		} else if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			return nil, errors.New("op " + strconv.Itoa(i) + ": value is required")
+		}
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, op.Path, value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, op.Path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, op.Path); err == nil {
+				doc, err = pointerAdd(doc, op.Path, value)
+			}
+		case "move", "copy":
+			var moved interface{}
+			if op.Op == "move" {
+				doc, moved, err = pointerRemove(doc, op.From)
+			} else {
+				moved, err = pointerGet(doc, op.From)
+			}
+			if err == nil {
+				doc, err = pointerAdd(doc, op.Path, moved)
+			}
+		case "test":
+			var current interface{}
+			if current, err = pointerGet(doc, op.Path); err == nil && !reflect.DeepEqual(current, value) {
+				err = errPatchTestFailed
+			}
+		default:
+			err = errors.New("unknown op " + op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("op %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// splitPointer : RFC 6901 pointer to unescaped tokens
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex : index token of array with length, "-" means end when allowEnd
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > length || (index == length && !allowEnd) {
+		return 0, errors.New("invalid array index " + token)
+	}
+	return index, nil
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, errors.New("path not found")
+			}
+			doc = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+		default:
+			return nil, errors.New("path not found")
+		}
+	}
+	return doc, nil
+}
+
+// pointerUpdate : runs fn on parent container of pointer and stores returned container
+func pointerUpdate(doc interface{}, tokens []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[tokens[0]]
+		if !ok {
+			return nil, errors.New("path not found")
+		}
+		updated, err := pointerUpdate(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[tokens[0]] = updated
+		return node, nil
+	case []interface{}:
+		index, err := arrayIndex(tokens[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := pointerUpdate(node[index], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = updated
+		return node, nil
+	}
+	return nil, errors.New("path not found")
+}
+
+func pointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, errors.New("path not found")
+	})
+}
+
+func pointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	var removed interface{}
+	doc, err = pointerUpdate(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, errors.New("path not found")
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[index]
+			return append(node[:index], node[index+1:]...), nil
+		}
+		return nil, errors.New("path not found")
+	})
+	return doc, removed, err
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// page cacher (used as counter store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+)
+
+/**
+*	Content Policy Engine
+*	Business limits configured with POLICY_RULES as comma separated rate
+*	rules name:limit/window like post.create:50/24h (429 when exceeded),
+*	counted per actor in fixed windows in the cache store. Count rules
+*	(tags per post) come with the Tag model (README TODO).
+*	Every violation emits quota.exceeded event for abuse monitoring.
+*	Soft limits: AllowRequest sets X-RateLimit-Limit/Remaining/Reset headers
+*	and, once usage passes the warn ratio, X-RateLimit-Warning so clients
+*	back off before 429. Ratio is POLICY_WARN_RATIO (default 0.8) or per rule
+*	with @ suffix e.g. post.create:50/24h@0.9, crossings are counted in
+*	policy_limit_warnings_total. Dry run requests (see dry_run.go) only
+*	read counters.
+*/
+const defaultPolicyRules = "post.create:50/24h,analytics.batch:120/1m,post.translate:100/1h"
+
+var policyWarnings = NewCounter(
+	"policy_limit_warnings_total",
+	"Requests over the warn ratio of a rate rule by rule.",
+	"rule",
+)
+
+type PolicyRule struct {
+	Name   string        `json:"name"`
+	Limit  int64         `json:"limit"`
+	Window time.Duration `json:"window"`
+	// WarnAt : usage ratio (0-1) that adds X-RateLimit-Warning
+	WarnAt float64 `json:"warn_at"`
+}
+
+type PolicyEngine struct {
+	store persistence.CacheStore
+	rules map[string]PolicyRule
+}
+
+// QuotaExceededError : returned by policy checks, Status is 429
+type QuotaExceededError struct {
+	Rule   PolicyRule
+	Actor  string
+	Status int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return "Limit of " + strconv.FormatInt(e.Rule.Limit, 10) + " per " + e.Rule.Window.String() + " reached for " + e.Rule.Name + "."
+}
+
+var policies *PolicyEngine
+
+// NewPolicyEngine : parses POLICY_RULES (defaults to defaultPolicyRules)
+func NewPolicyEngine(store persistence.CacheStore) *PolicyEngine {
+	config := os.Getenv("POLICY_RULES")
+	if config == "" {
+		config = defaultPolicyRules
+	}
+	warnAt, err := strconv.ParseFloat(os.Getenv("POLICY_WARN_RATIO"), 64)
+	if err != nil || warnAt <= 0 || warnAt > 1 {
+		warnAt = 0.8
+	}
+	engine := &PolicyEngine{store: store, rules: map[string]PolicyRule{}}
+	for _, item := range strings.Split(config, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rule := PolicyRule{Name: parts[0], WarnAt: warnAt}
+		if at := strings.LastIndex(parts[1], "@"); at >= 0 {
+			ratio, err := strconv.ParseFloat(parts[1][at+1:], 64)
+			if err != nil || ratio <= 0 || ratio > 1 {
+				LogWarn("Invalid policy rule warn ratio skipping", LogFields{"rule": item})
+				continue
+			}
+			rule.WarnAt, parts[1] = ratio, parts[1][:at]
+		}
+		limitWindow := strings.SplitN(parts[1], "/", 2)
+		limit, err := strconv.ParseInt(limitWindow[0], 10, 64)
+		if err != nil {
+			LogWarn("Invalid policy rule skipping", LogFields{"rule": item})
+			continue
+		}
+		rule.Limit = limit
+		if len(limitWindow) != 2 {
+			LogWarn("Policy rule without window skipping", LogFields{"rule": item})
+			continue
+		}
+		if rule.Window, err = time.ParseDuration(limitWindow[1]); err != nil || rule.Window <= 0 {
+			LogWarn("Invalid policy rule window skipping", LogFields{"rule": item})
+			continue
+		}
+		engine.rules[rule.Name] = rule
+	}
+	return engine
+}
+
+/**
+*	Allow : counts one action of actor for rate rule name.
+*	Unknown rules always allow.
+*/
+func (e *PolicyEngine) Allow(name, actor string) error {
+	_, err := e.count(name, actor, false)
+	return err
+}
+
+/**
+*	AllowRequest : Allow for http handlers, also writes rate limit headers
+*	and the soft limit warning. peek only reads the counter, pass
+*	IsDryRun(ctx) only from handlers that return before doing the work on
+*	dry runs, others pass false so dry_run can not skip their limits.
+*/
+func (e *PolicyEngine) AllowRequest(ctx *gin.Context, name, actor string, peek bool) error {
+	usage, err := e.count(name, actor, peek)
+	if usage == nil {
+		return err
+	}
+	remaining := usage.Rule.Limit - usage.Used
+	if remaining < 0 {
+		remaining = 0
+	}
+	ctx.Header("X-RateLimit-Limit", strconv.FormatInt(usage.Rule.Limit, 10))
+	ctx.Header("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	ctx.Header("X-RateLimit-Reset", strconv.FormatInt(int64(time.Until(usage.Reset).Seconds()+1), 10))
+	if err == nil && float64(usage.Used) >= usage.Rule.WarnAt*float64(usage.Rule.Limit) && !peek {
+		ctx.Header("X-RateLimit-Warning", name+" "+strconv.FormatInt(usage.Used, 10)+"/"+strconv.FormatInt(usage.Rule.Limit, 10)+" used")
+		policyWarnings.Inc(name)
+	}
+	return err
+}
+
+// policyUsage : counter state of a rate rule after one action
+type policyUsage struct {
+	Rule  PolicyRule
+	Used  int64
+	Reset time.Time
+}
+
+// count : increments counter of actor (peek only reads it and adds the pending action),
+// usage is nil for unknown rules or store failures
+func (e *PolicyEngine) count(name, actor string, peek bool) (*policyUsage, error) {
+	rule, ok := e.rules[name]
+	if !ok {
+		return nil, nil
+	}
+	bucket := time.Now().UnixNano() / int64(rule.Window)
+	key := "policy:" + name + ":" + actor + ":" + strconv.FormatInt(bucket, 10)
+	var used uint64
+	var err error
+	if peek {
+		if err = e.store.Get(key, &used); err == persistence.ErrCacheMiss {
+			err = nil
+		}
+		used++
+	} else {
+		used, err = IncrementCounter(e.store, key, 1, rule.Window)
+	}
+	if err != nil {
+		// counter store failure should not block content creation
+		LogWarn("Policy counter unavailable", LogFields{"rule": name, "error": err})
+		return nil, nil
+	}
+	// actors flagged by anomaly detection get a fraction of the limit
+	if factor := anomalies.ClampFactor(actor); factor > 1 {
+		rule.Limit = rule.Limit / factor
+		if rule.Limit < 1 {
+			rule.Limit = 1
+		}
+	}
+	usage := &policyUsage{Rule: rule, Used: int64(used), Reset: time.Unix(0, (bucket+1)*int64(rule.Window))}
+	if usage.Used > rule.Limit && peek {
+		return usage, &QuotaExceededError{Rule: rule, Actor: actor, Status: http.StatusTooManyRequests}
+	}
+	if usage.Used > rule.Limit {
+		return usage, e.exceeded(rule, actor, http.StatusTooManyRequests)
+	}
+	return usage, nil
+}
+
+func (e *PolicyEngine) exceeded(rule PolicyRule, actor string, status int) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"rule":   rule.Name,
+		"limit":  rule.Limit,
+		"window": rule.Window.String(),
+		"actor":  actor,
+		"time":   time.Now().UTC(),
+	})
+	PublishEvent(SubjectQuotaExceeded, payload)
+	return &QuotaExceededError{Rule: rule, Actor: actor, Status: status}
+}
+
+// abortQuotaExceeded : writes policy error in standard envelope
+func abortQuotaExceeded(ctx *gin.Context, err *QuotaExceededError) {
+	ctx.AbortWithStatusJSON(err.Status, gin.H{
+		"status":  false,
+		"type":    ErrCodePolicyQuotaExceeded,
+		"rule":    err.Rule.Name,
+		"message": err.Error(),
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	// page cacher (used as counter store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+)
+
+func TestPolicyRules(t *testing.T) {
+	os.Setenv("POLICY_RULES", "post.create:2/1h,post.tags:10,post.quote:1/bad")
+	defer os.Unsetenv("POLICY_RULES")
+	engine := NewPolicyEngine(persistence.NewInMemoryStore(time.Minute))
+
+	rules := engine.Rules()
+	if len(rules) != 1 || rules[0].Name != "post.create" {
+		t.Fatalf("rules: %+v", rules)
+	}
+	for i := 0; i < 2; i++ {
+		if err := engine.Allow("post.create", "user:policy-test"); err != nil {
+			t.Fatalf("action %d: %v", i+1, err)
+		}
+	}
+	err := engine.Allow("post.create", "user:policy-test")
+	if quota, ok := err.(*QuotaExceededError); !ok || quota.Status != http.StatusTooManyRequests {
+		t.Fatalf("third action: %v", err)
+	}
+	if err := engine.Allow("post.create", "user:other"); err != nil {
+		t.Errorf("other actor: %v", err)
+	}
+}
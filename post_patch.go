@@ -0,0 +1,110 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	--------------- HTTP PATCH /admin/posts/:id Section ---------------
+*	Moderation edit of a post with merge patch or json patch (see patch.go).
+*	Served under admin until posts have authors who edit their own posts.
+*/
+
+// UpdatePostDto : editable fields of a post, also the document patches apply to
+type UpdatePostDto struct {
+	Body       string `json:"body" validate:"required,min=1,limit=post.body.max_length" example:"Hello world!"`
+	Visibility string `json:"visibility" validate:"required,oneof=public unlisted" example:"public"`
+	// Sensitive : moderation flag, lists blur or hide sensitive posts
+	Sensitive bool `json:"is_sensitive" example:"false"`
+}
+
+// PatchPostHandler godoc
+// @Summary Patch a post
+// @Schemes
+// @Description Body is application/merge-patch+json or application/json-patch+json applied to UpdatePostDto
+// @Tags admin
+// @Security BasicAuth
+// @Param id path string true "post public id"
+// @Param body body UpdatePostDto true "merge patch (subset of fields) or json patch ops"
+// @Param dry_run query bool false "validate only, nothing is saved (or Prefer: handling=validate-only)"
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Success 200 {object} object
+// @Failure 400 {object} object
+// @Failure 404 {object} object
+// @Failure 409 {object} object
+// @Failure 415 {object} object
+// @Failure 422 {object} object
+// @Router /admin/posts/{id} [patch]
+func PatchPostHandler(ctx *gin.Context) {
+	post, err := FindPostByPublicID(ctx.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeUpdatePostNotFound,
+			"message": "Post not found.",
+		})
+		return
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeUpdatePostQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	if post.Kind == PostKindRepost {
+		WriteJSON(ctx, http.StatusUnprocessableEntity, gin.H{
+			"status":  false,
+			"type":    ErrCodeUpdatePostNotEditable,
+			"message": "Reposts have nothing to edit, delete and repost instead.",
+		})
+		return
+	}
+
+	var updateDto UpdatePostDto
+	changed, err := ApplyPatch(ctx, UpdatePostDto{Body: post.Body, Visibility: post.Visibility, Sensitive: post.Sensitive}, &updateDto, ErrAreaUpdatePost)
+	if err != nil {
+		return
+	}
+	if IsDryRun(ctx) {
+		post.Body, post.Visibility, post.Sensitive = updateDto.Body, updateDto.Visibility, updateDto.Sensitive
+		writeDryRun(ctx, gin.H{"post": ToPostDto(post), "changed": changed})
+		return
+	}
+	if len(changed) > 0 {
+		// Select writes is_sensitive=false too (struct Updates skips zero values), hooks drop the cached row
+		err := DB(ctx).Model(&post).Select("body", "visibility", "is_sensitive", "updated_at").
+			Updates(Post{Body: updateDto.Body, Visibility: updateDto.Visibility, Sensitive: updateDto.Sensitive}).Error
+		if err != nil {
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeUpdatePostSave,
+				"message": err.Error(),
+			})
+			return
+		}
+		LogInfo("Post updated", LogFields{"post_id": post.PublicID, "changed": changed, "client_ip": ClientIP(ctx)})
+		AfterCommit(ctx, func() {
+			// hooks dropped the row before commit, readers in between may have cached it again
+			invalidatePost(&post)
+			responseCache.Invalidate(PostListCache.Group)
+			payload, _ := json.Marshal(map[string]interface{}{"post_id": post.PublicID, "changed": changed})
+			PublishEventContext(ctx.Request.Context(), SubjectPostUpdated, payload)
+		})
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"post":    ToPostDto(post),
+		"changed": changed,
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"errors"
+	"os"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"git.yazgan.xyz/alperreha/alya-go-fn-boilerplate/sqlcdb" // generated by sqlc generate
+	"gorm.io/gorm"
+)
+
+/**
+*	Post Repository
+*	Handlers read post lists through postRepository, POST_QUERIES selects
+*	the implementation:
+*	- sqlc (default) : hand-tuned SQL of sql/queries generated into sqlcdb
+*	                   for the feed (GET /post without sort or ranges) and
+*	                   trending, every other list falls back to GORM
+*	- gorm           : GORM queries only
+*	sqlc statements go straight to database/sql, they skip GORM callbacks
+*	(slow query log, sqlite write lock, chaos faults). Edit sql/ and run
+*	sqlc generate (sqlc.yaml) to change them.
+*/
+type PostRepository interface {
+	// ListPosts : page of listed posts of GET /post, errors of invalid sort or filters are ListQueryError
+	ListPosts(ctx *gin.Context, query PostListQuery, paginator *Paginator) ([]Post, error)
+	// TrendingPosts : listed posts with most impressions since day (YYYY-MM-DD)
+	TrendingPosts(ctx context.Context, since string, limit int) ([]TrendingPost, error)
+}
+
+type TrendingPost struct {
+	Post
+	Impressions int64
+}
+
+// ListQueryError : sort or filter params the list can not apply
+type ListQueryError struct {
+	Err error
+}
+
+func (e ListQueryError) Error() string { return e.Err.Error() }
+
+var postRepository PostRepository = gormPostRepository{}
+
+// InitPostRepository : repository of POST_QUERIES=sqlc|gorm (default sqlc)
+func InitPostRepository() (PostRepository, error) {
+	switch os.Getenv("POST_QUERIES") {
+	case "", "sqlc":
+		sqlDb, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		return sqlcPostRepository{queries: sqlcdb.New(sqlDb), fallback: gormPostRepository{}}, nil
+	case "gorm":
+		return gormPostRepository{}, nil
+	}
+	return nil, errors.New("POST_QUERIES must be sqlc or gorm")
+}
+
+type gormPostRepository struct{}
+
+func (gormPostRepository) ListPosts(ctx *gin.Context, listQuery PostListQuery, paginator *Paginator) ([]Post, error) {
+	// apply whitelisted sort and filters like sort=-created_at&created_after=2024-01-01
+	// unlisted posts are only reachable by id
+	query, err := ApplyListQuery(db.Model(&Post{}).Scopes(ListedPosts, SensitivePosts(listQuery.Sensitive)), PostListFields, listQuery.ListQuery, listQuery.ListParams())
+	if err != nil {
+		return nil, ListQueryError{err}
+	}
+	posts := []Post{}
+	if err := paginator.Find(query, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (gormPostRepository) TrendingPosts(ctx context.Context, since string, limit int) ([]TrendingPost, error) {
+	rows := []TrendingPost{}
+	err := db.WithContext(ctx).Model(&Post{}).Scopes(ListedPosts).
+		Select("posts.*, stats.impressions").
+		Joins("JOIN (?) AS stats ON stats.post_id = posts.id", db.Model(&PostStat{}).
+			Select("post_id, CAST(SUM(impressions) AS BIGINT) AS impressions").
+			Where("day >= ?", since).
+			Group("post_id")).
+		Order("stats.impressions DESC, posts.id DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	return rows, err
+}
+
+type sqlcPostRepository struct {
+	queries  *sqlcdb.Queries
+	fallback PostRepository
+}
+
+// isFeedQuery : list query of the plain feed (newest first, no ranges)
+func isFeedQuery(listQuery PostListQuery) bool {
+	return (listQuery.Sort == "" || listQuery.Sort == PostListFields.DefaultSort) &&
+		listQuery.CreatedAfter == "" && listQuery.CreatedBefore == "" &&
+		listQuery.UpdatedAfter == "" && listQuery.UpdatedBefore == ""
+}
+
+func (r sqlcPostRepository) ListPosts(ctx *gin.Context, listQuery PostListQuery, paginator *Paginator) ([]Post, error) {
+	if !isFeedQuery(listQuery) {
+		return r.fallback.ListPosts(ctx, listQuery, paginator)
+	}
+	reqCtx := ctx.Request.Context()
+	hideSensitive := listQuery.Sensitive == SensitiveHide
+	posts := []Post{}
+	err := paginator.FindFunc(&posts,
+		func() (int64, error) {
+			if hideSensitive {
+				return r.queries.CountFeedPostsWithoutSensitive(reqCtx)
+			}
+			return r.queries.CountFeedPosts(reqCtx)
+		},
+		func(limit, offset int) error {
+			var rows []sqlcdb.Post
+			var err error
+			if hideSensitive {
+				rows, err = r.queries.ListFeedPostsWithoutSensitive(reqCtx, sqlcdb.ListFeedPostsWithoutSensitiveParams{Limit: int32(limit), Offset: int32(offset)})
+			} else {
+				rows, err = r.queries.ListFeedPosts(reqCtx, sqlcdb.ListFeedPostsParams{Limit: int32(limit), Offset: int32(offset)})
+			}
+			for _, row := range rows {
+				posts = append(posts, postOfRow(row))
+			}
+			return err
+		},
+	)
+	return posts, err
+}
+
+func (r sqlcPostRepository) TrendingPosts(ctx context.Context, since string, limit int) ([]TrendingPost, error) {
+	rows, err := r.queries.ListTrendingPosts(ctx, sqlcdb.ListTrendingPostsParams{Day: since, Limit: int32(limit)})
+	if err != nil {
+		return nil, err
+	}
+	trending := make([]TrendingPost, 0, len(rows))
+	for _, row := range rows {
+		trending = append(trending, TrendingPost{Post: postOfRow(row.Post), Impressions: row.Impressions})
+	}
+	return trending, nil
+}
+
+// postOfRow : GORM model of generated row
+func postOfRow(row sqlcdb.Post) Post {
+	post := Post{
+		PublicID:      row.PublicID,
+		Body:          row.Body,
+		Visibility:    row.Visibility,
+		Kind:          row.Kind,
+		RepostedCount: int(row.RepostedCount),
+		Sensitive:     row.IsSensitive,
+		SpamStatus:    row.SpamStatus,
+		SpamScore:     row.SpamScore,
+	}
+	post.ID = uint(row.ID)
+	post.CreatedAt = row.CreatedAt
+	post.UpdatedAt = row.UpdatedAt
+	if row.DeletedAt.Valid {
+		post.DeletedAt = gorm.DeletedAt{Time: row.DeletedAt.Time, Valid: true}
+	}
+	if row.ExternalID.Valid {
+		externalId := row.ExternalID.String
+		post.ExternalID = &externalId
+	}
+	if row.OriginalID.Valid {
+		originalId := uint(row.OriginalID.Int64)
+		post.OriginalID = &originalId
+	}
+	return post
+}
+
+// trendingSince : first day of trending window of days ending today (UTC)
+func trendingSince(days int) string {
+	return utcNow().AddDate(0, 0, 1-days).Format("2006-01-02")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats.go"
+	// database packages
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+*	Post Stats
+*	Aggregates post_impression analytics events (subject SubjectAnalyticsCollected)
+*	into post_stats (impressions per post, day and referrer host) and
+*	post_viewers (distinct actors per post and day). GET
+*	/admin/posts/:id/stats?days=7 sums them over a window. Served under
+*	admin until posts have authors who can see stats of their own posts.
+*	Likes and comments are added when those models exist.
+*/
+type PostStat struct {
+	PostID      uint   `gorm:"column:post_id;primaryKey;autoIncrement:false"`
+	Day         string `gorm:"column:day;size:10;primaryKey"`
+	Referrer    string `gorm:"column:referrer;size:255;primaryKey"`
+	Impressions int64  `gorm:"column:impressions;not null"`
+}
+
+type PostViewer struct {
+	PostID uint   `gorm:"column:post_id;primaryKey;autoIncrement:false"`
+	Day    string `gorm:"column:day;size:10;primaryKey"`
+	Viewer string `gorm:"column:viewer;size:128;primaryKey"`
+}
+
+type ReferrerCount struct {
+	Referrer    string `json:"referrer"`
+	Impressions int64  `json:"impressions"`
+}
+
+// PostStatsDto is the payload of /admin/posts/:id/stats
+type PostStatsDto struct {
+	PostID        string          `json:"post_id"`
+	Days          int             `json:"days"`
+	Since         string          `json:"since"`
+	Impressions   int64           `json:"impressions"`
+	UniqueViewers int64           `json:"unique_viewers"`
+	Reposts       int             `json:"reposts"`
+	Referrers     []ReferrerCount `json:"referrers"`
+	Daily         []DailyCount    `json:"daily"`
+}
+
+// referrerHost : host of referrer url, "direct" when empty
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return "direct"
+	}
+	if parsed, err := url.Parse(referrer); err == nil && parsed.Host != "" {
+		return strings.ToLower(parsed.Host)
+	}
+	if len(referrer) > 255 {
+		referrer = referrer[:255]
+	}
+	return strings.ToLower(referrer)
+}
+
+// AggregatePostStats : folds one analytics batch into post_stats and post_viewers
+func AggregatePostStats(rows []analyticsRow) error {
+	publicIds := []string{}
+	for _, row := range rows {
+		if row.Type == "post_impression" {
+			publicIds = append(publicIds, row.PostID)
+		}
+	}
+	if len(publicIds) == 0 {
+		return nil
+	}
+	posts := []Post{}
+	if err := db.Select("id", "public_id").Where("public_id IN ?", publicIds).Find(&posts).Error; err != nil {
+		return err
+	}
+	postIds := map[string]uint{}
+	for _, post := range posts {
+		postIds[post.PublicID] = post.ID
+	}
+
+	stats := map[PostStat]int64{}
+	viewers := map[PostViewer]bool{}
+	for _, row := range rows {
+		postId, ok := postIds[row.PostID]
+		if row.Type != "post_impression" || !ok {
+			continue
+		}
+		day := row.ReceivedAt.Format("2006-01-02")
+		if row.Timestamp != nil {
+			day = row.Timestamp.UTC().Format("2006-01-02")
+		}
+		stats[PostStat{PostID: postId, Day: day, Referrer: referrerHost(row.Referrer)}]++
+		viewers[PostViewer{PostID: postId, Day: day, Viewer: row.Actor}] = true
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for key, impressions := range stats {
+			key.Impressions = impressions
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "post_id"}, {Name: "day"}, {Name: "referrer"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"impressions": gorm.Expr("post_stats.impressions + excluded.impressions")}),
+			}).Create(&key).Error
+			if err != nil {
+				return err
+			}
+		}
+		for viewer := range viewers {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&viewer).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StartPostStatsWorker : aggregates analytics batches into post stats tables
+func StartPostStatsWorker() (*nats.Subscription, error) {
+	return SubscribeWorker(SubjectAnalyticsCollected, "post-stats", func(msg *nats.Msg) error {
+		var rows []analyticsRow
+		if err := json.Unmarshal(msg.Data, &rows); err != nil {
+			return err
+		}
+		return AggregatePostStats(rows)
+	})
+}
+
+/**
+*	--------------- HTTP GET /admin/posts/:id/stats Section ---------------
+*/
+
+// GetPostStatsHandler godoc
+// @Summary Impression and reach stats of a post
+// @Schemes
+// @Description Impressions, unique viewers, reposts, referrers and daily impressions over window
+// @Tags admin
+// @Security BasicAuth
+// @Param id path string true "post public id"
+// @Param days query int false "window in days (1-90)"
+// @Produce json
+// @Success 200 {object} PostStatsDto
+// @Failure 404 {object} object
+// @Failure 500 {object} object
+// @Router /admin/posts/{id}/stats [get]
+func GetPostStatsHandler(ctx *gin.Context) {
+	post, err := FindPostByPublicID(ctx.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodePostStatsNotFound,
+			"message": "Post not found.",
+		})
+		return
+	}
+
+	// get window param should be 1<=days<=90
+	days, convErr := strconv.Atoi(ctx.DefaultQuery("days", "7"))
+	if convErr != nil || days < 1 || days > 90 {
+		days = 7
+	}
+	since := utcNow().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+	stats := PostStatsDto{
+		PostID:    post.PublicID,
+		Days:      days,
+		Since:     since,
+		Reposts:   post.RepostedCount,
+		Referrers: []ReferrerCount{},
+		Daily:     []DailyCount{},
+	}
+	window := func() *gorm.DB {
+		return db.Model(&PostStat{}).Where("post_id = ? AND day >= ?", post.ID, since)
+	}
+	if err == nil {
+		err = window().Select("COALESCE(SUM(impressions), 0)").Scan(&stats.Impressions).Error
+	}
+	if err == nil {
+		err = db.Model(&PostViewer{}).Where("post_id = ? AND day >= ?", post.ID, since).Distinct("viewer").Count(&stats.UniqueViewers).Error
+	}
+	if err == nil {
+		err = window().Select("referrer, SUM(impressions) AS impressions").Group("referrer").Order("impressions DESC").Limit(20).Scan(&stats.Referrers).Error
+	}
+	if err == nil {
+		err = window().Select("day, SUM(impressions) AS count").Group("day").Order("day").Scan(&stats.Daily).Error
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodePostStatsQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"stats": stats,
+	})
+}
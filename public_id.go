@@ -0,0 +1,113 @@
+package main
+
+import (
+	// system packages
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	// concurrency packages
+	"golang.org/x/sync/singleflight"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Public IDs
+*	Rows keep auto-increment primary keys internally, external URLs and
+*	DTOs use public_id (UUIDv7, time ordered) so row counts are not leaked
+*	and ids can be generated before insert.
+*	(Docs: https://www.rfc-editor.org/rfc/rfc9562#name-uuid-version-7)
+*/
+func NewPublicID() string {
+	var uuid [16]byte
+	binary.BigEndian.PutUint64(uuid[0:8], uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		panic(err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x70 // version 7
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 // variant 10
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf)
+}
+
+// applyPostDefaults : default visibility and kind, also used to preview dry run posts
+func applyPostDefaults(post *Post) {
+	if post.Visibility == "" {
+		post.Visibility = VisibilityPublic
+	}
+	if post.Kind == "" {
+		post.Kind = PostKindPost
+	}
+}
+
+// BeforeCreate : assigns public id, default visibility and kind (and primary key when ID_GENERATOR is set) to new posts
+func (post *Post) BeforeCreate(tx *gorm.DB) error {
+	if post.PublicID == "" {
+		post.PublicID = NewPublicID()
+	}
+	applyPostDefaults(post)
+	if post.ID == 0 && idGenerator != nil {
+		id, err := idGenerator.NextID()
+		if err != nil {
+			return err
+		}
+		post.ID = uint(id)
+	}
+	return nil
+}
+
+var postReads singleflight.Group
+
+// FindPostByPublicID : lookup helper for routes like /post/:id, rows are
+// served from entity cache and concurrent misses of the same id share one
+// query (viral posts)
+func FindPostByPublicID(publicId string) (Post, error) {
+	result, err, _ := postReads.Do(publicId, func() (interface{}, error) {
+		var post Post
+		load := func() error {
+			// pruned to a few partitions of partitioned posts (see partition.go)
+			err := findPartitionedPost(publicId, &post)
+			// archived posts come back on request (see archive.go)
+			if errors.Is(err, gorm.ErrRecordNotFound) && RehydratePost(publicId) == nil {
+				err = findPartitionedPost(publicId, &post)
+			}
+			return err
+		}
+		if entityCache == nil {
+			return post, load()
+		}
+		err := entityCache.Fetch("post", publicId, &post, load)
+		return post, err
+	})
+	return result.(Post), err
+}
+
+// BackfillPublicIDs : sets public_id of rows created before the column existed
+func BackfillPublicIDs(model interface{}, table string) {
+	var ids []uint
+	if err := db.Model(model).Where("public_id IS NULL OR public_id = ''").Pluck("id", &ids).Error; err != nil {
+		LogError("Error reading rows without public id", LogFields{"table": table, "error": err})
+		return
+	}
+	for _, id := range ids {
+		if err := db.Model(model).Where("id = ?", id).Update("public_id", NewPublicID()).Error; err != nil {
+			LogError("Error backfilling public id", LogFields{"table": table, "id": id, "error": err})
+			return
+		}
+	}
+	if len(ids) > 0 {
+		LogInfo("Backfilled public ids", LogFields{"table": table, "rows": len(ids)})
+	}
+}
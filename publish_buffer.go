@@ -0,0 +1,248 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Publish Buffering
+*	Events published while NATS is disconnected wait in a bounded memory
+*	buffer (NATS_PUBLISH_BUFFER, default 1000 events), overflow goes to the
+*	event_outbox table. On reconnect (and on startup for rows left by a
+*	previous process) memory is flushed first, then the outbox by id, so
+*	events keep their publish order. While anything waits new events queue
+*	behind it. On shutdown waiting memory events are written to the outbox.
+*	Events are dropped only when the outbox write fails, counted in
+*	nats_publish_dropped_total.
+*/
+type EventOutbox struct {
+	ID        uint      `gorm:"primaryKey"`
+	Subject   string    `gorm:"column:subject;size:255;not null"`
+	Data      []byte    `gorm:"column:data"`
+	Headers   string    `gorm:"column:headers;type:text"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (EventOutbox) TableName() string {
+	return "event_outbox"
+}
+
+const outboxFlushBatch = 100
+
+var (
+	publishBufferDepth = NewGauge(
+		"nats_publish_buffer_depth",
+		"Events waiting in memory for NATS to reconnect.",
+	)
+	publishOverflow = NewCounter(
+		"nats_publish_outbox_total",
+		"Events written to event_outbox while NATS was disconnected.",
+	)
+	publishDropped = NewCounter(
+		"nats_publish_dropped_total",
+		"Events lost because NATS was disconnected and outbox write failed.",
+	)
+	publishFlushed = NewCounter(
+		"nats_publish_flushed_total",
+		"Buffered events published after reconnect by source (memory, outbox).",
+		"source",
+	)
+)
+
+type EventBuffer struct {
+	mu       sync.Mutex
+	memory   []*nats.Msg
+	capacity int
+	// overflowed : outbox may have rows, new events go there until it is drained
+	overflowed bool
+	// pendingTx : outbox rows written in request transactions still open, invisible to Flush until commit
+	pendingTx int
+	closing   bool
+	flushMu   sync.Mutex
+}
+
+var eventBuffer *EventBuffer
+
+// StartEventBuffer : buffer for PublishEvent, flushes outbox rows of previous runs
+func StartEventBuffer() *EventBuffer {
+	buffer := &EventBuffer{
+		capacity:   int(getEnvInt64("NATS_PUBLISH_BUFFER", 1000)),
+		overflowed: true,
+	}
+	go buffer.Flush()
+	// with LEADER_ELECTION=true the leader also relays rows other replicas wrote like OUTBOX_RELAY_INTERVAL=5s,
+	// other replicas re-check the outbox so they stop queueing behind it once the leader drained it
+	if leader.Enabled {
+		go func() {
+			ticker := time.NewTicker(getEnvDuration("OUTBOX_RELAY_INTERVAL", 5*time.Second))
+			defer ticker.Stop()
+			for range ticker.C {
+				if natsConnected() && (leader.IsLeader() || buffer.waiting()) {
+					buffer.Flush()
+				}
+			}
+		}()
+	}
+	OnShutdown("event buffer", func(ctx context.Context) { buffer.persist() })
+	return buffer
+}
+
+// waiting : events of this replica wait in memory or outbox
+func (b *EventBuffer) waiting() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.memory) > 0 || b.overflowed
+}
+
+// Publish : publishes msg or queues it when NATS is disconnected or events are waiting,
+// outbox rows of requests in TxMiddleware are written in their transaction while it is open
+func (b *EventBuffer) Publish(ctx context.Context, msg *nats.Msg) error {
+	b.mu.Lock()
+	waiting := len(b.memory) > 0 || b.overflowed
+	if !waiting && natsConnected() {
+		b.mu.Unlock()
+		return nc.PublishMsg(msg)
+	}
+	defer b.mu.Unlock()
+	if !b.closing && !b.overflowed && len(b.memory) < b.capacity {
+		b.memory = append(b.memory, msg)
+		publishBufferDepth.Set(float64(len(b.memory)))
+		return nil
+	}
+	b.overflowed = true
+	if tx := openRequestTx(ctx, b.requestTxClosed); tx != nil {
+		b.pendingTx++
+		return b.writeOutbox(tx, msg)
+	}
+	return b.writeOutbox(db, msg)
+}
+
+// requestTxClosed : outbox row of a request transaction is committed or rolled back
+func (b *EventBuffer) requestTxClosed(committed bool) {
+	b.mu.Lock()
+	b.pendingTx--
+	b.mu.Unlock()
+	if committed && natsConnected() {
+		go b.Flush()
+	}
+}
+
+// writeOutbox : stores msg in event_outbox with database, caller holds mu
+func (b *EventBuffer) writeOutbox(database *gorm.DB, msg *nats.Msg) error {
+	headers, _ := json.Marshal(msg.Header)
+	row := EventOutbox{Subject: msg.Subject, Data: msg.Data, Headers: string(headers)}
+	if database == nil {
+		publishDropped.Inc()
+		return errors.New("event dropped, NATS disconnected and no database for outbox")
+	}
+	if err := database.Create(&row).Error; err != nil {
+		publishDropped.Inc()
+		LogError("Event dropped, outbox write failed", LogFields{"subject": msg.Subject, "error": err})
+		return err
+	}
+	publishOverflow.Inc()
+	return nil
+}
+
+// Flush : publishes waiting events in order, stops at first failure (next reconnect resumes)
+func (b *EventBuffer) Flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	for {
+		b.mu.Lock()
+		if len(b.memory) == 0 {
+			b.mu.Unlock()
+			break
+		}
+		msg := b.memory[0]
+		b.mu.Unlock()
+		if !natsConnected() || nc.PublishMsg(msg) != nil {
+			return
+		}
+		b.mu.Lock()
+		b.memory = b.memory[1:]
+		publishBufferDepth.Set(float64(len(b.memory)))
+		b.mu.Unlock()
+		publishFlushed.Inc("memory")
+	}
+	// only the leader relays the outbox, others wait for it to be drained (see leader.go)
+	if db != nil && !leader.IsLeader() {
+		b.mu.Lock()
+		var count int64
+		if db.Model(&EventOutbox{}).Count(&count).Error == nil && count == 0 && b.pendingTx == 0 {
+			b.overflowed = false
+		}
+		b.mu.Unlock()
+		return
+	}
+	for db != nil {
+		rows := []EventOutbox{}
+		if err := db.Order("id").Limit(outboxFlushBatch).Find(&rows).Error; err != nil {
+			LogError("Error reading event outbox", LogFields{"error": err})
+			return
+		}
+		if len(rows) == 0 {
+			// rows are inserted under mu, but rows of open request transactions are
+			// not visible until commit, pendingTx keeps overflowed set for them and
+			// the commit flushes again
+			b.mu.Lock()
+			var count int64
+			err := db.Model(&EventOutbox{}).Count(&count).Error
+			if err == nil && count == 0 && b.pendingTx == 0 {
+				b.overflowed = false
+			}
+			b.mu.Unlock()
+			if count == 0 {
+				return
+			}
+			continue
+		}
+		for _, row := range rows {
+			msg := nats.NewMsg(row.Subject)
+			msg.Data = row.Data
+			if row.Headers != "" {
+				json.Unmarshal([]byte(row.Headers), &msg.Header)
+			}
+			if !natsConnected() || nc.PublishMsg(msg) != nil {
+				return
+			}
+			if err := db.Delete(&row).Error; err != nil {
+				// published again on next flush, delivery is at least once
+				LogError("Error deleting flushed outbox event", LogFields{"id": row.ID, "error": err})
+				return
+			}
+			publishFlushed.Inc("outbox")
+		}
+	}
+}
+
+// persist : moves memory events to outbox on shutdown, later events go straight there
+func (b *EventBuffer) persist() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closing = true
+	if len(b.memory) > 0 {
+		b.overflowed = true
+	}
+	for _, msg := range b.memory {
+		b.writeOutbox(db, msg)
+	}
+	b.memory = nil
+	publishBufferDepth.Set(0)
+}
+
+// natsConnected : NATS is connected and no nats-disconnect chaos fault is active (see chaos.go)
+func natsConnected() bool {
+	return nc.IsConnected() && !chaos.NatsDown()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	Readiness
+*	GET /_/ready checks database and NATS connections (503 when down) and
+*	event pipeline backlogs:
+*	- JetStream consumers of HEALTH_JETSTREAM_CONSUMERS like "POST:post-worker"
+*	  are degraded when pending + ack pending > HEALTH_MAX_CONSUMER_LAG (default 1000)
+*	- dead letters not requeued > HEALTH_MAX_DEAD_LETTERS (default 100)
+*	Degraded still returns 200 unless HEALTH_DEGRADED_UNREADY=true, backlogs
+*	are also exported as gauges for alerting.
+*/
+var (
+	jetstreamConsumerPending = NewGauge(
+		"jetstream_consumer_pending",
+		"Pending plus ack pending messages of monitored JetStream consumers.",
+		"stream", "consumer",
+	)
+	deadLettersPending = NewGauge(
+		"dead_letters_pending",
+		"Dead letters waiting for requeue.",
+	)
+)
+
+type ReadinessCheck struct {
+	Status    string `json:"status"`
+	Value     int64  `json:"value,omitempty"`
+	Threshold int64  `json:"threshold,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessHandler godoc
+// @Summary Readiness probe with event pipeline backlog
+// @Schemes
+// @Description Returns ok, degraded (backlog over thresholds) or down (db or nats unreachable, 503)
+// @Tags post-service-health
+// @Produce json
+// @Success 200 {object} object
+// @Failure 503 {object} object
+// @Router /post/_/ready [get]
+func ReadinessHandler(ctx *gin.Context) {
+	checks := map[string]ReadinessCheck{}
+	status := "ok"
+	report := func(name string, check ReadinessCheck, level string) {
+		checks[name] = check
+		if level == "down" || status == "ok" {
+			status = level
+		}
+	}
+
+	// database
+	if sqlDb, err := db.DB(); err != nil {
+		report("database", ReadinessCheck{Status: "down", Error: err.Error()}, "down")
+	} else if err := sqlDb.PingContext(ctx.Request.Context()); err != nil {
+		report("database", ReadinessCheck{Status: "down", Error: err.Error()}, "down")
+	} else if chaos.has(ChaosDbDisconnect) {
+		report("database", ReadinessCheck{Status: "down", Error: errChaosDbDisconnect.Error()}, "down")
+	} else {
+		checks["database"] = ReadinessCheck{Status: "ok"}
+	}
+
+	// nats
+	if nc == nil || !natsConnected() {
+		report("nats", ReadinessCheck{Status: "down", Error: "not connected"}, "down")
+	} else {
+		checks["nats"] = ReadinessCheck{Status: "ok"}
+		checkConsumerLag(ctx.Request.Context(), checks, report)
+	}
+
+	// dead letters
+	maxDeadLetters := getEnvInt64("HEALTH_MAX_DEAD_LETTERS", 100)
+	var pending int64
+	if err := db.Model(&DeadLetter{}).Where("requeued_at IS NULL").Count(&pending).Error; err == nil {
+		deadLettersPending.Set(float64(pending))
+		check := ReadinessCheck{Status: "ok", Value: pending, Threshold: maxDeadLetters}
+		if pending > maxDeadLetters {
+			check.Status = "degraded"
+			report("dead_letters", check, "degraded")
+		} else {
+			checks["dead_letters"] = check
+		}
+	}
+
+	code := http.StatusOK
+	if status == "down" || (status == "degraded" && os.Getenv("HEALTH_DEGRADED_UNREADY") == "true") {
+		code = http.StatusServiceUnavailable
+	}
+	WriteJSON(ctx, code, gin.H{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func checkConsumerLag(reqCtx context.Context, checks map[string]ReadinessCheck, report func(string, ReadinessCheck, string)) {
+	consumers := os.Getenv("HEALTH_JETSTREAM_CONSUMERS")
+	if consumers == "" {
+		return
+	}
+	maxLag := getEnvInt64("HEALTH_MAX_CONSUMER_LAG", 1000)
+	js, err := nc.JetStream()
+	if err != nil {
+		report("jetstream", ReadinessCheck{Status: "degraded", Error: err.Error()}, "degraded")
+		return
+	}
+	for _, pair := range strings.Split(consumers, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := "consumer:" + parts[0] + "/" + parts[1]
+		info, err := js.ConsumerInfo(parts[0], parts[1], nats.Context(reqCtx))
+		if err != nil {
+			report(name, ReadinessCheck{Status: "degraded", Error: err.Error()}, "degraded")
+			continue
+		}
+		lag := int64(info.NumPending) + int64(info.NumAckPending)
+		jetstreamConsumerPending.Set(float64(lag), parts[0], parts[1])
+		check := ReadinessCheck{Status: "ok", Value: lag, Threshold: maxLag}
+		if lag > maxLag {
+			check.Status = "degraded"
+			report(name, check, "degraded")
+			continue
+		}
+		checks[name] = check
+	}
+}
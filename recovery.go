@@ -0,0 +1,207 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Error Reporting
+*	Panics are reported to Sentry (SENTRY_DSN) or Rollbar (ROLLBAR_TOKEN)
+*	with stack trace and request context. Without config nothing is sent.
+*/
+type ErrorReport struct {
+	Message   string
+	Stack     string
+	RequestId string
+	Method    string
+	Path      string
+	ClientIP  string
+	UserAgent string
+}
+
+type ErrorReporter interface {
+	Report(report ErrorReport) error
+}
+
+var errorReporter ErrorReporter
+
+var errorReporterClient = NewHttpClient(5 * time.Second)
+
+// InitErrorReporter : picks reporter from env, nil when none configured
+func InitErrorReporter() (ErrorReporter, error) {
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		return NewSentryReporter(dsn)
+	}
+	if token := os.Getenv("ROLLBAR_TOKEN"); token != "" {
+		return RollbarReporter{Token: token}, nil
+	}
+	return nil, nil
+}
+
+/**
+*	SentryReporter : sends events to Sentry store endpoint
+*	(Docs: https://develop.sentry.dev/sdk/store/)
+*/
+type SentryReporter struct {
+	StoreUrl  string
+	PublicKey string
+}
+
+// NewSentryReporter : dsn like https://<public_key>@o0.ingest.sentry.io/<project_id>
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil || parsed.User == nil {
+		return nil, errors.New("invalid SENTRY_DSN")
+	}
+	projectId := strings.Trim(parsed.Path, "/")
+	if projectId == "" {
+		return nil, errors.New("invalid SENTRY_DSN: missing project id")
+	}
+	return &SentryReporter{
+		StoreUrl:  parsed.Scheme + "://" + parsed.Host + "/api/" + projectId + "/store/",
+		PublicKey: parsed.User.Username(),
+	}, nil
+}
+
+func (s *SentryReporter) Report(report ErrorReport) error {
+	event := map[string]interface{}{
+		"event_id":    newRequestId(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"release":     appVersion,
+		"environment": appEnv.Name,
+		"message":     report.Message,
+		"extra": map[string]string{
+			"stacktrace": report.Stack,
+		},
+		"tags": map[string]string{
+			"request_id": report.RequestId,
+		},
+		"request": map[string]interface{}{
+			"method":  report.Method,
+			"url":     report.Path,
+			"headers": map[string]string{"User-Agent": report.UserAgent},
+			"env":     map[string]string{"REMOTE_ADDR": report.ClientIP},
+		},
+	}
+	body, _ := json.Marshal(event)
+	req, err := http.NewRequest(http.MethodPost, s.StoreUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=alyafn/"+appVersion+", sentry_key="+s.PublicKey)
+	return sendErrorReport(req)
+}
+
+/**
+*	RollbarReporter : sends items to Rollbar api
+*	(Docs: https://docs.rollbar.com/reference/create-item)
+*/
+type RollbarReporter struct {
+	Token string
+}
+
+func (r RollbarReporter) Report(report ErrorReport) error {
+	item := map[string]interface{}{
+		"data": map[string]interface{}{
+			"environment":  appEnv.Name,
+			"level":        "critical",
+			"code_version": appVersion,
+			"body": map[string]interface{}{
+				"message": map[string]string{
+					"body":       report.Message,
+					"stacktrace": report.Stack,
+				},
+			},
+			"request": map[string]interface{}{
+				"method":     report.Method,
+				"url":        report.Path,
+				"user_ip":    report.ClientIP,
+				"headers":    map[string]string{"User-Agent": report.UserAgent},
+				"request_id": report.RequestId,
+			},
+		},
+	}
+	body, _ := json.Marshal(item)
+	req, err := http.NewRequest(http.MethodPost, "https://api.rollbar.com/api/1/item/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Rollbar-Access-Token", r.Token)
+	return sendErrorReport(req)
+}
+
+func sendErrorReport(req *http.Request) error {
+	res, err := errorReporterClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New("error reporter: unexpected status " + res.Status)
+	}
+	return nil
+}
+
+/**
+*	RecoveryMiddleware : replaces gin.Recovery. Recovers panics, logs stack
+*	as structured log, reports it asynchronously and returns 500 envelope
+*	with request id so users can refer to it.
+*/
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			report := ErrorReport{
+				Message:   fmt.Sprint(recovered),
+				Stack:     string(debug.Stack()),
+				RequestId: RequestID(ctx),
+				Method:    ctx.Request.Method,
+				Path:      ctx.Request.URL.Path,
+				ClientIP:  ClientIP(ctx),
+				UserAgent: ctx.Request.UserAgent(),
+			}
+			LogError("panic recovered", LogFields{
+				"error":      report.Message,
+				"stack":      report.Stack,
+				"request_id": report.RequestId,
+				"method":     report.Method,
+				"path":       report.Path,
+				"device":     Device(ctx),
+			})
+			if errorReporter != nil {
+				go func() {
+					if err := errorReporter.Report(report); err != nil {
+						LogWarn("Error reporting panic", LogFields{"error": err, "request_id": report.RequestId})
+					}
+				}()
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"status":     false,
+				"type":       ErrCodeServerPanic,
+				"message":    "Internal server error.",
+				"request_id": report.RequestId,
+			})
+		}()
+		ctx.Next()
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Reposts and Quotes
+*	A repost shares a post as is (no body), a quote shares it with a body.
+*	Both are posts with kind and original_id. Reposting or quoting a repost
+*	targets its original so repost chains never form, and only existing
+*	public posts can be shared so a post never ends up referencing itself.
+*	Originals count their reposts and quotes in reposted_count, lists embed
+*	the original post dto.
+*/
+const (
+	PostKindPost   = "post"
+	PostKindRepost = "repost"
+	PostKindQuote  = "quote"
+)
+
+// findShareTarget : original post to share for path id, 404/422 are written on error
+func findShareTarget(ctx *gin.Context, area string) (Post, bool) {
+	target, err := FindPostByPublicID(ctx.Param("id"))
+	if err == nil && target.Kind == PostKindRepost && target.OriginalID != nil {
+		originalId := *target.OriginalID
+		target = Post{}
+		err = db.First(&target, originalId).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixNotFound,
+			"message": "Post not found.",
+		})
+		return target, false
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixQuery,
+			"message": err.Error(),
+		})
+		return target, false
+	}
+	if target.Visibility != VisibilityPublic || target.SpamStatus == SpamHeld {
+		WriteJSON(ctx, http.StatusUnprocessableEntity, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixNotShareable,
+			"message": "Only public posts can be shared.",
+		})
+		return target, false
+	}
+	return target, true
+}
+
+// createShare : saves repost/quote and bumps reposted_count of original in request transaction
+func createShare(ctx *gin.Context, area string, post Post, original Post) {
+	if IsDryRun(ctx) {
+		applyPostDefaults(&post)
+		dto := ToPostDto(post)
+		originalDto := ToPostDto(original)
+		dto.Original = &originalDto
+		writeDryRun(ctx, gin.H{"post": dto})
+		return
+	}
+	tx := DB(ctx)
+	err := tx.Create(&post).Error
+	if err == nil {
+		// UpdateColumn keeps updated_at of original, cache is dropped by hand since hooks are skipped
+		err = tx.Model(&original).UpdateColumn("reposted_count", gorm.Expr("reposted_count + 1")).Error
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusUnprocessableEntity, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixSave,
+			"message": err.Error(),
+		})
+		return
+	}
+	original.RepostedCount++
+	if post.Kind == PostKindQuote {
+		anomalies.ObservePost(ctx, post)
+	}
+	AfterCommit(ctx, func() {
+		invalidatePost(&original)
+		responseCache.Invalidate(PostListCache.Group)
+		payload, _ := json.Marshal(map[string]string{"kind": post.Kind, "post_id": post.PublicID, "original_id": original.PublicID})
+		PublishEventContext(ctx.Request.Context(), SubjectPostShared, payload)
+	})
+
+	dto := ToPostDto(post)
+	originalDto := ToPostDto(original)
+	dto.Original = &originalDto
+	WriteJSON(ctx, createdPostStatus(post), gin.H{
+		"post": dto,
+	})
+}
+
+// RepostHandler godoc
+// @Summary Repost a post
+// @Schemes
+// @Description Shares post without body, reposting a repost shares its original
+// @Tags post-service
+// @Security BearerAuth
+// @Param id path string true "post public id"
+// @Param dry_run query bool false "validate only, nothing is saved (or Prefer: handling=validate-only)"
+// @Produce json
+// @Success 200 {object} object
+// @Failure 404 {object} object
+// @Failure 422 {object} object
+// @Failure 429 {object} object
+// @Router /post/{id}/repost [post]
+func RepostHandler(ctx *gin.Context) {
+	original, ok := findShareTarget(ctx, ErrAreaRepost)
+	if !ok {
+		return
+	}
+	if err := policies.AllowRequest(ctx, "post.create", ActorID(ctx), IsDryRun(ctx)); err != nil {
+		abortQuotaExceeded(ctx, err.(*QuotaExceededError))
+		return
+	}
+	createShare(ctx, ErrAreaRepost, Post{Kind: PostKindRepost, OriginalID: &original.ID}, original)
+}
+
+// QuotePostHandler godoc
+// @Summary Quote a post
+// @Schemes
+// @Description Shares post with body, quoting a repost quotes its original
+// @Tags post-service
+// @Security BearerAuth
+// @Param id path string true "post public id"
+// @Param dry_run query bool false "validate only, nothing is saved (or Prefer: handling=validate-only)"
+// @Param body body CreatePostDto true "quote"
+// @Accept application/json
+// @Produce json
+// @Success 200 {object} object
+// @Failure 400 {object} object
+// @Failure 404 {object} object
+// @Failure 422 {object} object
+// @Failure 429 {object} object
+// @Router /post/{id}/quote [post]
+func QuotePostHandler(ctx *gin.Context) {
+	var quoteDto CreatePostDto
+	if err := BindDto(ctx, &quoteDto, ErrAreaQuote); err != nil {
+		return
+	}
+	original, ok := findShareTarget(ctx, ErrAreaQuote)
+	if !ok {
+		return
+	}
+	if err := policies.AllowRequest(ctx, "post.create", ActorID(ctx), IsDryRun(ctx)); err != nil {
+		abortQuotaExceeded(ctx, err.(*QuotaExceededError))
+		return
+	}
+	post := Post{Kind: PostKindQuote, OriginalID: &original.ID, Body: quoteDto.Body, Visibility: quoteDto.Visibility, Sensitive: quoteDto.Sensitive}
+	if !CheckSpam(ctx, ErrAreaQuote, &post) {
+		return
+	}
+	createShare(ctx, ErrAreaQuote, post, original)
+}
+
+// EmbedOriginals : sets original dto of reposts and quotes in dtos (same order as posts) with one query
+func EmbedOriginals(posts []Post, dtos []PostDto) error {
+	ids := []uint{}
+	for _, post := range posts {
+		if post.OriginalID != nil {
+			ids = append(ids, *post.OriginalID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	originals := []Post{}
+	if err := db.Where("id IN ?", ids).Find(&originals).Error; err != nil {
+		return err
+	}
+	byId := map[uint]PostDto{}
+	for _, original := range originals {
+		byId[original.ID] = ToPostDto(original)
+	}
+	for i, post := range posts {
+		if post.OriginalID == nil {
+			continue
+		}
+		if original, ok := byId[*post.OriginalID]; ok {
+			dtos[i].Original = &original
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	// system packages
+	"crypto/rand"
+	"encoding/hex"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Request ID (correlation id)
+*	Incoming X-Request-ID header is kept (if sane) otherwise a new random
+*	id is generated. It is echoed in response header and stored in context
+*	with the trace context of the caller (see trace.go).
+*/
+const (
+	requestIdHeader = "X-Request-ID"
+	requestIdKey    = "request_id"
+)
+
+func newRequestId() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestId := ctx.GetHeader(requestIdHeader)
+		if requestId == "" || len(requestId) > 64 {
+			requestId = newRequestId()
+		}
+		ctx.Set(requestIdKey, requestId)
+		ctx.Header(requestIdHeader, requestId)
+		trace := NewTraceContext(requestId, ctx.GetHeader(traceparentHeader))
+		ctx.Set(traceKey, trace)
+		ctx.Request = ctx.Request.WithContext(WithTrace(ctx.Request.Context(), trace))
+		ctx.Next()
+	}
+}
+
+// RequestID : correlation id of current request
+func RequestID(ctx *gin.Context) string {
+	return ctx.GetString(requestIdKey)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// page cacher (used as shared state store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+	// concurrency packages
+	"golang.org/x/sync/singleflight"
+)
+
+/**
+*	Response Cache
+*	Routes declare a CachePolicy (group, ttl, key builder) and successful GET
+*	responses are stored in cache store under
+*	"response:<group>:<generation>:<key>". Write handlers call
+*	responseCache.Invalidate(group) which bumps generation of the group so
+*	all of its keys miss at once (old entries expire by ttl), this works the
+*	same for in-memory and redis stores. Concurrent misses of the same key
+*	run the handler once and share its response (singleflight) so a hot
+*	list does not stampede the db. X-Cache header tells HIT, MISS or SHARED,
+*	per route counters and the key listing live in response_cache_stats.go.
+*/
+type CacheKeyFunc func(ctx *gin.Context) string
+
+// CacheKeyByURL : same response for everyone per path and query
+func CacheKeyByURL(ctx *gin.Context) string {
+	return ctx.Request.URL.RequestURI()
+}
+
+// CacheKeyByURLAndAuth : separate entries per session token (anonymous users share one)
+// and locale since responses carry Content-Language
+func CacheKeyByURLAndAuth(ctx *gin.Context) string {
+	key := ctx.Request.URL.RequestURI() + "|" + Locale(ctx)
+	token := SessionToken(ctx)
+	if token == "" {
+		return key + "|anon"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return key + "|" + hex.EncodeToString(sum[:8])
+}
+
+type CachePolicy struct {
+	// Group : invalidation unit like "posts"
+	Group string
+	// TTL : 0 uses APP_CACHE_TTL of runtime config
+	TTL time.Duration
+	Key CacheKeyFunc
+}
+
+var (
+	PostListCache   = CachePolicy{Group: "posts", Key: CacheKeyByURLAndAuth}
+	HealthCache     = CachePolicy{Group: "health", Key: CacheKeyByURL}
+	AdminStatsCache = CachePolicy{Group: "admin-stats", TTL: time.Minute, Key: CacheKeyByURL}
+)
+
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// headers that belong to a single response and are never replayed
+var uncachedHeaders = []string{"Set-Cookie", requestIdHeader}
+
+type ResponseCache struct {
+	store persistence.CacheStore
+}
+
+var responseCache *ResponseCache
+
+var responseFlight singleflight.Group
+
+func NewResponseCache(store persistence.CacheStore) *ResponseCache {
+	return &ResponseCache{store: store}
+}
+
+func (c *ResponseCache) generation(group string) uint64 {
+	var generation uint64
+	if err := c.store.Get("response_gen:"+group, &generation); err != nil {
+		return 0
+	}
+	return generation
+}
+
+// Invalidate : drops all cached responses of groups, on other replicas too
+func (c *ResponseCache) Invalidate(groups ...string) {
+	c.invalidateLocal(groups...)
+	cacheSync.Broadcast(CacheInvalidation{Cache: "response", Groups: groups})
+}
+
+// invalidateLocal : bumps generation of groups in store of this instance
+func (c *ResponseCache) invalidateLocal(groups ...string) {
+	for _, group := range groups {
+		key := "response_gen:" + group
+		if _, err := IncrementCounter(c.store, key, 1, persistence.FOREVER); err != nil {
+			LogWarn("Error invalidating response cache", LogFields{"group": group, "error": err})
+		}
+	}
+}
+
+// writeCachedResponse : replays stored response and stops handler chain,
+// stored headers replace the ones earlier middlewares set for this request
+// (Content-Language, Cache-Control) and Vary gets missing values only
+func writeCachedResponse(ctx *gin.Context, cached cachedResponse, state string) {
+	header := ctx.Writer.Header()
+	for name, values := range cached.Header {
+		if name == "Vary" {
+			mergeVary(header, values)
+			continue
+		}
+		header[name] = append([]string(nil), values...)
+	}
+	ctx.Header("X-Cache", state)
+	ctx.Data(cached.Status, cached.Header.Get("Content-Type"), cached.Body)
+	ctx.Abort()
+}
+
+// mergeVary : adds each field name of values to Vary unless it is listed already
+func mergeVary(header http.Header, values []string) {
+	listed := map[string]bool{}
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			listed[strings.ToLower(strings.TrimSpace(field))] = true
+		}
+	}
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" || listed[strings.ToLower(field)] {
+				continue
+			}
+			listed[strings.ToLower(field)] = true
+			header.Add("Vary", field)
+		}
+	}
+}
+
+type cacheWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Middleware : serves GET requests of policy from cache and stores 200 responses
+func (c *ResponseCache) Middleware(policy CachePolicy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.Method != http.MethodGet {
+			ctx.Next()
+			return
+		}
+		ttl := policy.TTL
+		if ttl == 0 {
+			ttl = runtimeConfig().CacheTTL
+		}
+		generation := c.generation(policy.Group)
+		route := ctx.FullPath()
+		name := policy.Key(ctx)
+		key := "response:" + policy.Group + ":" + strconv.FormatUint(generation, 10) + ":" + name
+
+		var cached cachedResponse
+		if err := c.store.Get(key, &cached); err == nil {
+			responseCacheRequests.Inc(policy.Group, route, "hit")
+			writeCachedResponse(ctx, cached, "HIT")
+			return
+		}
+
+		// concurrent misses of same key share one handler run (singleflight)
+		leader := false
+		result, _, _ := responseFlight.Do(key, func() (interface{}, error) {
+			leader = true
+			responseCacheRequests.Inc(policy.Group, route, missResult(policy.Group, name))
+			ctx.Header("X-Cache", "MISS")
+			writer := &cacheWriter{ResponseWriter: ctx.Writer}
+			ctx.Writer = writer
+			ctx.Next()
+
+			header := ctx.Writer.Header().Clone()
+			for _, name := range append(uncachedHeaders, "X-Cache") {
+				header.Del(name)
+			}
+			response := cachedResponse{Status: ctx.Writer.Status(), Header: header, Body: writer.body.Bytes()}
+			if response.Status == http.StatusOK {
+				if err := c.store.Set(key, response, ttl); err != nil {
+					LogWarn("Error storing response cache", LogFields{"group": policy.Group, "error": err})
+				} else {
+					now := time.Now()
+					indexResponse(responseCacheEntry{Group: policy.Group, Route: route, Key: name, Generation: generation, StoredAt: now, ExpiresAt: now.Add(ttl)})
+				}
+			}
+			return response, nil
+		})
+		if !leader {
+			responseCacheRequests.Inc(policy.Group, route, "shared")
+			writeCachedResponse(ctx, result.(cachedResponse), "SHARED")
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Response Cache Stats
+*	Every cached GET counts into response_cache_requests_total by group,
+*	route and result:
+*	- hit    : served from cache
+*	- shared : waited for a concurrent miss of the same key (singleflight)
+*	- stale  : key was cached before but got invalidated or expired
+*	- miss   : key was never cached by this instance
+*	Stored keys are indexed in memory per instance (store backends can not
+*	list keys) and shown with remaining ttl at GET /admin/cache so cache
+*	durations can be tuned with data. Index holds at most
+*	responseCacheIndexSize keys, expired ones are pruned first.
+*/
+const responseCacheIndexSize = 10000
+
+var responseCacheRequests = NewCounter(
+	"response_cache_requests_total",
+	"Response cache lookups by group, route and result (hit, shared, stale, miss).",
+	"group", "route", "result",
+)
+
+type responseCacheEntry struct {
+	Group      string
+	Route      string
+	Key        string
+	Generation uint64
+	StoredAt   time.Time
+	ExpiresAt  time.Time
+}
+
+var (
+	responseCacheIndexMu sync.Mutex
+	responseCacheIndex   = map[string]*responseCacheEntry{}
+)
+
+// indexResponse : remembers stored key of group by its generation independent name
+func indexResponse(entry responseCacheEntry) {
+	responseCacheIndexMu.Lock()
+	defer responseCacheIndexMu.Unlock()
+	name := entry.Group + ":" + entry.Key
+	if _, ok := responseCacheIndex[name]; !ok && len(responseCacheIndex) >= responseCacheIndexSize {
+		now := time.Now()
+		for key, indexed := range responseCacheIndex {
+			if indexed.ExpiresAt.Before(now) {
+				delete(responseCacheIndex, key)
+			}
+		}
+		if len(responseCacheIndex) >= responseCacheIndexSize {
+			return
+		}
+	}
+	responseCacheIndex[name] = &entry
+}
+
+// indexedResponseGroups : groups this instance stored responses of
+func indexedResponseGroups() []string {
+	responseCacheIndexMu.Lock()
+	defer responseCacheIndexMu.Unlock()
+	seen := map[string]bool{}
+	groups := []string{}
+	for _, entry := range responseCacheIndex {
+		if !seen[entry.Group] {
+			seen[entry.Group] = true
+			groups = append(groups, entry.Group)
+		}
+	}
+	return groups
+}
+
+// missResult : stale when key was cached before (invalidated or expired), miss otherwise
+func missResult(group, key string) string {
+	responseCacheIndexMu.Lock()
+	defer responseCacheIndexMu.Unlock()
+	if _, ok := responseCacheIndex[group+":"+key]; ok {
+		return "stale"
+	}
+	return "miss"
+}
+
+type ResponseCacheKeyDto struct {
+	Group      string    `json:"group" example:"posts"`
+	Route      string    `json:"route" example:"/v1/post/"`
+	Key        string    `json:"key" example:"/v1/post/?page=1|en|UTC|anon"`
+	Status     string    `json:"status" example:"fresh"`
+	TTLSeconds int64     `json:"ttl_seconds" example:"42"`
+	StoredAt   time.Time `json:"stored_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// GetResponseCacheHandler godoc
+// @Summary List response cache keys of this instance with remaining ttl
+// @Schemes
+// @Description Keys are fresh, invalidated (group generation bumped) or expired. Only keys stored by the serving instance are known.
+// @Tags admin
+// @Security BasicAuth
+// @Param group query string false "filter by cache group e.g. posts"
+// @Produce json
+// @Success 200 {object} object
+// @Router /admin/cache [get]
+func GetResponseCacheHandler(ctx *gin.Context) {
+	group := ctx.Query("group")
+	generations := map[string]uint64{}
+	responseCacheIndexMu.Lock()
+	entries := make([]responseCacheEntry, 0, len(responseCacheIndex))
+	for _, entry := range responseCacheIndex {
+		if group == "" || entry.Group == group {
+			entries = append(entries, *entry)
+		}
+	}
+	responseCacheIndexMu.Unlock()
+
+	now := time.Now()
+	keys := make([]ResponseCacheKeyDto, 0, len(entries))
+	for _, entry := range entries {
+		generation, ok := generations[entry.Group]
+		if !ok {
+			generation = responseCache.generation(entry.Group)
+			generations[entry.Group] = generation
+		}
+		key := ResponseCacheKeyDto{
+			Group:     entry.Group,
+			Route:     entry.Route,
+			Key:       entry.Key,
+			Status:    "fresh",
+			StoredAt:  entry.StoredAt,
+			ExpiresAt: entry.ExpiresAt,
+		}
+		switch {
+		case entry.ExpiresAt.Before(now):
+			key.Status = "expired"
+		case entry.Generation != generation:
+			key.Status = "invalidated"
+		default:
+			key.TTLSeconds = int64(entry.ExpiresAt.Sub(now).Seconds())
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Group != keys[j].Group {
+			return keys[i].Group < keys[j].Group
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"generations": generations,
+		"keys":        keys,
+	})
+}
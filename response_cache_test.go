@@ -0,0 +1,37 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCachedResponseHeadersAreNotDuplicated(t *testing.T) {
+	responseCache.Invalidate(PostListCache.Group)
+	header := http.Header{"Accept-Language": {"tr"}, "Origin": {"http://localhost:3000"}}
+	miss := testRequest(http.MethodGet, "/v1/post/?limit=3", "", header)
+	hit := testRequest(http.MethodGet, "/v1/post/?limit=3", "", header)
+	if miss.Header().Get("X-Cache") != "MISS" || hit.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("X-Cache: %q then %q", miss.Header().Get("X-Cache"), hit.Header().Get("X-Cache"))
+	}
+	for _, name := range []string{"Content-Language", "Cache-Control", "Content-Type"} {
+		if values := hit.Header().Values(name); len(values) != 1 {
+			t.Errorf("%s of HIT: %q", name, values)
+		}
+	}
+	seen := map[string]int{}
+	for _, value := range hit.Header().Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			seen[strings.ToLower(strings.TrimSpace(field))]++
+		}
+	}
+	for field, count := range seen {
+		if count != 1 {
+			t.Errorf("Vary %s listed %d times: %q", field, count, hit.Header().Values("Vary"))
+		}
+	}
+	if seen["accept-language"] != 1 || seen["origin"] != 1 {
+		t.Errorf("Vary of HIT: %q", hit.Header().Values("Vary"))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	// third party packages
+	"github.com/joho/godotenv"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Runtime Config
+*	Subset of config that can change without restart. A new snapshot is
+*	built on SIGHUP or POST /_/reload and swapped atomically, handlers
+*	always read a consistent snapshot with runtimeConfig(). Feature flags
+*	are for clients, they are listed in /_/capabilities.
+*/
+type RuntimeConfig struct {
+	LogLevel     string          `json:"log_level"`
+	CacheTTL     time.Duration   `json:"cache_ttl" swaggertype:"integer"`
+	FeatureFlags map[string]bool `json:"feature_flags"`
+	// ValidationRules : values of configurable validation limits (see validation_rules.go)
+	ValidationRules map[string]int `json:"validation_rules"`
+	LoadedAt        time.Time      `json:"loaded_at"`
+	ruleProblems    []string
+}
+
+var currentRuntimeConfig atomic.Value
+
+// loadRuntimeConfig : builds a snapshot from env like
+// APP_LOG_LEVEL=info APP_CACHE_TTL=1m APP_FEATURES=flag1,flag2 APP_VALIDATION_RULES=post.body.max_length=140
+// read with getenv (os.Getenv at startup, see dotenvLookup for reloads)
+func loadRuntimeConfig(getenv func(string) string) *RuntimeConfig {
+	config := &RuntimeConfig{
+		LogLevel:     strings.ToLower(getenv("APP_LOG_LEVEL")),
+		CacheTTL:     parseEnvDuration("APP_CACHE_TTL", getenv("APP_CACHE_TTL"), time.Minute),
+		FeatureFlags: map[string]bool{},
+		LoadedAt:     utcNow(),
+	}
+	config.ValidationRules, config.ruleProblems = loadValidationRules(getenv)
+	if config.LogLevel == "" {
+		config.LogLevel = "info"
+	}
+	for _, flag := range strings.Split(getenv("APP_FEATURES"), ",") {
+		if flag = strings.TrimSpace(flag); flag != "" {
+			config.FeatureFlags[flag] = true
+		}
+	}
+	return config
+}
+
+// runtimeConfig : returns current snapshot, never mutate it
+func runtimeConfig() *RuntimeConfig {
+	if config, ok := currentRuntimeConfig.Load().(*RuntimeConfig); ok {
+		return config
+	}
+	config := loadRuntimeConfig(os.Getenv)
+	storeRuntimeConfig(config)
+	return config
+}
+
+// storeRuntimeConfig : swaps snapshot, then logs what could not be applied
+func storeRuntimeConfig(config *RuntimeConfig) {
+	currentRuntimeConfig.Store(config)
+	for _, problem := range config.ruleProblems {
+		LogWarn("Validation rule not applied", LogFields{"problem": problem})
+	}
+}
+
+/**
+*	dotenvLookup : reads .env file into a map, values of the file win over
+*	process env. Process env itself is left untouched so settings read once
+*	at startup (db, ports, secrets) can not drift from what is running.
+*/
+func dotenvLookup() func(string) string {
+	values := map[string]string{}
+	if dir, err := os.Getwd(); err == nil {
+		if read, err := godotenv.Read(dir + "/.env"); err != nil {
+			LogWarn("Error reloading .env file keeping current ENV variables", LogFields{"error": err})
+		} else {
+			values = read
+		}
+	}
+	return func(key string) string {
+		if value, ok := values[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	}
+}
+
+/**
+*	ReloadRuntimeConfig : re-reads .env file and swaps runtime config
+*	snapshot and ip filters, nothing else is reloaded
+*/
+func ReloadRuntimeConfig() *RuntimeConfig {
+	getenv := dotenvLookup()
+	config := loadRuntimeConfig(getenv)
+	storeRuntimeConfig(config)
+	reloadIPFiltersFromEnv(getenv)
+	LogInfo("Runtime config reloaded", LogFields{"log_level": config.LogLevel, "cache_ttl": config.CacheTTL.String()})
+	return config
+}
+
+// WatchReloadSignal : reloads runtime config on every SIGHUP
+func WatchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			ReloadRuntimeConfig()
+		}
+	}()
+}
+
+// ReloadConfigHandler godoc
+// @Summary Reload runtime config
+// @Schemes
+// @Description Re-reads .env and swaps runtime config (log level, feature flags, cache ttl, validation rules, ip filters) without restart. Same as sending SIGHUP.
+// @Tags post-service-health
+// @Security BasicAuth
+// @Accept */*
+// @Produce json
+// @Success 200 {object} RuntimeConfig
+// @Failure 401 {object} object
+// @Router /post/_/reload [post]
+func ReloadConfigHandler(ctx *gin.Context) {
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"status": true,
+		"config": ReloadRuntimeConfig(),
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"time"
+)
+
+/**
+*	Scheduled Jobs
+*	RunScheduled runs a job every interval on one replica at a time: each
+*	tick tries the lock job:<name> (see lock.go) with a lease of 90% of the
+*	interval, replicas whose tick falls inside the lease skip the round.
+*	Jobs running longer than the lease keep extending it, a crashed replica
+*	frees the job when its lease ends. When an extension fails the lease is
+*	lost (another replica may start the job), the context of the round is
+*	cancelled and jobs stop at their next database call or batch. The lock
+*	is kept after the job so a round runs once even when ticks of replicas
+*	are apart.
+*/
+var scheduledRuns = NewCounter(
+	"scheduled_job_runs_total",
+	"Scheduled job rounds by job and result (success, failure, skipped).",
+	"job", "result",
+)
+
+var scheduledLastRun = NewGauge(
+	"scheduled_job_last_run_timestamp_seconds",
+	"Unix time of last round run by this instance by job.",
+	"job",
+)
+
+// RunScheduled : runs job every interval while holding its lock, ctx of job is cancelled when the lock is lost
+func RunScheduled(name string, interval time.Duration, job func(ctx context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runScheduledRound(name, interval, job)
+		}
+	}()
+}
+
+func runScheduledRound(name string, interval time.Duration, job func(ctx context.Context) error) {
+	// with LEADER_ELECTION=true only the leader runs jobs (see leader.go)
+	if !leader.IsLeader() {
+		scheduledRuns.Inc(name, "skipped")
+		return
+	}
+	lease := interval - interval/10
+	lock, err := locks.TryLock("job:"+name, lease)
+	if err != nil {
+		scheduledRuns.Inc(name, "failure")
+		LogError("Scheduled job lock failed", LogFields{"job": name, "error": err})
+		return
+	}
+	if lock == nil {
+		scheduledRuns.Inc(name, "skipped")
+		return
+	}
+
+	// long rounds keep the lease until they end
+	started := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lock.Extend(lease); err != nil {
+					LogWarn("Scheduled job lost its lock, cancelling round", LogFields{"job": name, "error": err})
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err = job(ctx)
+	close(done)
+	scheduledLastRun.Set(float64(time.Now().Unix()), name)
+	if err != nil {
+		scheduledRuns.Inc(name, "failure")
+	} else {
+		scheduledRuns.Inc(name, "success")
+	}
+
+	// lease ends where it would have without extensions, released when the round took longer
+	if remaining := time.Until(started.Add(lease)); remaining > 0 {
+		lock.Extend(remaining)
+	} else {
+		lock.Release()
+	}
+}
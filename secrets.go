@@ -0,0 +1,222 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+*	Secrets Provider
+*	Resolves sensitive config (DB_CONN_STRING, JWT keys, SMTP credentials)
+*	from a secret backend selected by SECRETS_PROVIDER=env|vault|aws.
+*	Both vault and aws providers read a single key/value document and keys
+*	missing there fall back to plain env variables.
+*/
+type SecretProvider interface {
+	// Name : provider name for logs
+	Name() string
+	// Load : returns all key/values of configured secret document
+	Load() (map[string]string, error)
+}
+
+var secretsHttpClient = NewHttpClient(10 * time.Second)
+
+/**
+*	EnvSecretProvider : default provider, everything comes from env/.env
+*/
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) Name() string { return "env" }
+
+func (EnvSecretProvider) Load() (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+/**
+*	VaultSecretProvider : HashiCorp Vault KV v2 (Docs: https://developer.hashicorp.com/vault/api-docs/secret/kv/kv-v2)
+*	VAULT_ADDR=http://localhost:8200 VAULT_TOKEN=... VAULT_SECRET_PATH=secret/data/alyafn
+*/
+type VaultSecretProvider struct {
+	Addr  string
+	Token string
+	Path  string
+}
+
+func (p VaultSecretProvider) Name() string { return "vault" }
+
+func (p VaultSecretProvider) Load() (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(p.Addr, "/")+"/v1/"+strings.TrimLeft(p.Path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	res, err := secretsHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("vault: unexpected status " + res.Status)
+	}
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Data.Data, nil
+}
+
+/**
+*	AwsSecretProvider : AWS Secrets Manager GetSecretValue with a JSON SecretString
+*	AWS_REGION, AWS_SECRET_ID, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY (AWS_SESSION_TOKEN optional)
+*/
+type AwsSecretProvider struct {
+	Region       string
+	SecretId     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+func (p AwsSecretProvider) Name() string { return "aws" }
+
+func (p AwsSecretProvider) Load() (map[string]string, error) {
+	payload, _ := json.Marshal(map[string]string{"SecretId": p.SecretId})
+	host := "secretsmanager." + p.Region + ".amazonaws.com"
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	AwsCredentials{Region: p.Region, AccessKey: p.AccessKey, SecretKey: p.SecretKey, SessionToken: p.SessionToken}.
+		Sign(req, "secretsmanager", payload, time.Now().UTC())
+
+	res, err := secretsHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("aws secrets manager: unexpected status " + res.Status)
+	}
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal([]byte(body.SecretString), &values); err != nil {
+		return nil, errors.New("aws secrets manager: SecretString is not a JSON object of strings")
+	}
+	return values, nil
+}
+
+/**
+*	SecretStore : in-memory cache over a provider with periodic refresh
+*	so rotated secrets are picked up without restart.
+*/
+type SecretStore struct {
+	provider SecretProvider
+	mu       sync.RWMutex
+	values   map[string]string
+	onChange []func(key string, value string)
+}
+
+var secrets *SecretStore
+
+// NewSecretStore : picks provider from SECRETS_PROVIDER and loads it once
+func NewSecretStore() (*SecretStore, error) {
+	var provider SecretProvider = EnvSecretProvider{}
+	switch os.Getenv("SECRETS_PROVIDER") {
+	case "vault":
+		provider = VaultSecretProvider{
+			Addr:  os.Getenv("VAULT_ADDR"),
+			Token: os.Getenv("VAULT_TOKEN"),
+			Path:  os.Getenv("VAULT_SECRET_PATH"),
+		}
+	case "aws":
+		provider = AwsSecretProvider{
+			Region:       os.Getenv("AWS_REGION"),
+			SecretId:     os.Getenv("AWS_SECRET_ID"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	store := &SecretStore{provider: provider, values: map[string]string{}}
+	if err := store.Refresh(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Get : returns secret from provider or falls back to env variable
+func (s *SecretStore) Get(key string) string {
+	s.mu.RLock()
+	value, ok := s.values[key]
+	s.mu.RUnlock()
+	if ok && value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// OnChange : registers callback fired when a refreshed secret value differs
+func (s *SecretStore) OnChange(fn func(key string, value string)) {
+	s.mu.Lock()
+	s.onChange = append(s.onChange, fn)
+	s.mu.Unlock()
+}
+
+// Refresh : reloads secrets from provider and notifies changed keys
+func (s *SecretStore) Refresh() error {
+	values, err := s.provider.Load()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	changed := map[string]string{}
+	for key, value := range values {
+		if old, ok := s.values[key]; ok && old != value {
+			changed[key] = value
+		}
+	}
+	s.values = values
+	callbacks := s.onChange
+	s.mu.Unlock()
+
+	for key, value := range changed {
+		log.Println("Secret rotated:", key)
+		for _, fn := range callbacks {
+			fn(key, value)
+		}
+	}
+	return nil
+}
+
+// StartRefresh : refreshes secrets every interval until process exits
+func (s *SecretStore) StartRefresh(interval time.Duration) {
+	if interval <= 0 || s.provider.Name() == "env" {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := s.Refresh(); err != nil {
+				log.Println("Error refreshing secrets from "+s.provider.Name(), err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Sensitive Content
+*	Posts are flagged is_sensitive by their creator (create and quote body)
+*	or by moderation (PATCH /admin/posts/:id). Lists apply the viewer mode
+*	of the sensitive query param:
+*	blur (default) : listed with the flag, body omitted and body_hidden set
+*	show           : listed as is
+*	hide           : left out of the list with reposts and quotes of them
+*	Embedded originals follow the same mode. Age gating and stored user
+*	settings need User model with birth date and settings first, until then
+*	viewers choose per request and underage viewers can not be told apart.
+*/
+const (
+	SensitiveBlur = "blur"
+	SensitiveShow = "show"
+	SensitiveHide = "hide"
+)
+
+// SensitivePosts : scope leaving sensitive posts and shares of them out in hide mode
+func SensitivePosts(mode string) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if mode != SensitiveHide {
+			return tx
+		}
+		return tx.Where("is_sensitive = ?", false).
+			Where("original_id IS NULL OR original_id NOT IN (?)", db.Model(&Post{}).Select("id").Where("is_sensitive = ?", true))
+	}
+}
+
+// RedactSensitivePosts : omits bodies of sensitive posts and originals unless mode is show
+func RedactSensitivePosts(dtos []PostDto, mode string) []PostDto {
+	if mode == SensitiveShow {
+		return dtos
+	}
+	for i := range dtos {
+		redactSensitivePost(&dtos[i])
+		if dtos[i].Original != nil {
+			original := *dtos[i].Original
+			redactSensitivePost(&original)
+			dtos[i].Original = &original
+		}
+	}
+	return dtos
+}
+
+func redactSensitivePost(dto *PostDto) {
+	if dto.Sensitive {
+		dto.Body = ""
+		dto.BodyHidden = true
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Response Serialization
+*	Handlers write json with WriteJSON, values pass SerializeValue first:
+*	- struct keys are json tag names, untagged fields get snake_case names
+*	  (ID -> id, CreatedAt -> created_at)
+*	- gorm.DeletedAt and json:"-" fields are never rendered
+*	- nil slices render as [] and nil maps as {}
+*	- numbers tagged ,string render as strings, used for 64-bit ids which
+*	  lose precision as js numbers (ID_GENERATOR=sonyflake)
+*	- omitempty is honored, types with MarshalJSON/MarshalText (time.Time,
+*	  json.RawMessage) encode themselves
+*	In debug mode gin.H keys that are not snake_case are logged.
+*/
+var (
+	deletedAtType     = reflect.TypeOf(gorm.DeletedAt{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	ginHType          = reflect.TypeOf(gin.H{})
+	snakeCasePattern  = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+)
+
+// WriteJSON : writes obj through SerializeValue with status code
+func WriteJSON(ctx *gin.Context, code int, obj interface{}) {
+	ctx.Render(code, serializedJSON{Data: obj})
+}
+
+type serializedJSON struct {
+	Data interface{}
+}
+
+func (r serializedJSON) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	encoded, err := json.Marshal(SerializeValue(r.Data))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+func (r serializedJSON) WriteContentType(w http.ResponseWriter) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+}
+
+// orderedObject : struct rendered as json object keeping field order
+type orderedObject []objectField
+
+type objectField struct {
+	Key   string
+	Value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.Key)
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// SerializeValue : json ready copy of value following the rules above
+func SerializeValue(value interface{}) interface{} {
+	return serializeValue(reflect.ValueOf(value))
+}
+
+func serializeValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return serializeValue(v.Elem())
+	case reflect.Struct:
+		object := orderedObject{}
+		appendStructFields(&object, v)
+		return object
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		object := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if v.Type() == ginHType && gin.IsDebugging() && !snakeCasePattern.MatchString(key) {
+				LogWarn("Response key is not snake_case", LogFields{"key": key})
+			}
+			object[key] = serializeValue(iter.Value())
+		}
+		return object
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte encodes as base64 string
+			return v.Interface()
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = serializeValue(v.Index(i))
+		}
+		return list
+	default:
+		return v.Interface()
+	}
+}
+
+func appendStructFields(object *orderedObject, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.Type == deletedAtType {
+			continue
+		}
+		options := strings.Split(tag, ",")
+		name := options[0]
+		value := v.Field(i)
+		if field.Anonymous && name == "" {
+			// embedded structs like gorm.Model are flattened
+			for value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					break
+				}
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct && !value.Type().Implements(jsonMarshalerType) {
+				appendStructFields(object, value)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = snakeCase(field.Name)
+		}
+		if hasTagOption(options, "omitempty") && value.IsZero() {
+			continue
+		}
+		if hasTagOption(options, "string") && isNumberKind(value.Kind()) {
+			*object = append(*object, objectField{Key: name, Value: fmt.Sprint(value.Interface())})
+			continue
+		}
+		*object = append(*object, objectField{Key: name, Value: serializeValue(value)})
+	}
+}
+
+func hasTagOption(options []string, option string) bool {
+	for _, value := range options[1:] {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
+
+// snakeCase : go field name as snake_case key (PublicID -> public_id, HTTPCode -> http_code)
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			previousLower := i > 0 && !unicode.IsUpper(runes[i-1])
+			acronymEnd := i > 0 && i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])
+			if previousLower || acronymEnd {
+				out.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	// http2 packages
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+/**
+*	HTTP Server
+*	Fully configured http.Server instead of gin's r.Run so slow clients
+*	(slowloris) can not hold connections forever. Timeouts from .env like
+*	APP_READ_HEADER_TIMEOUT=5s APP_READ_TIMEOUT=30s APP_WRITE_TIMEOUT=30s
+*	APP_IDLE_TIMEOUT=120s APP_MAX_HEADER_BYTES=1048576
+*	- APP_TLS_CERT/APP_TLS_KEY set : https with HTTP/2
+*	- APP_H2C=true                 : cleartext HTTP/2 (h2c) for internal traffic
+*/
+func NewHttpServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("APP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("APP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      getEnvDuration("APP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("APP_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    int(getEnvInt64("APP_MAX_HEADER_BYTES", 1<<20)),
+		ConnContext: func(c context.Context, conn net.Conn) context.Context {
+			return context.WithValue(c, connContextKey{}, conn)
+		},
+	}
+}
+
+type connContextKey struct{}
+
+// ExtendWriteDeadline : moves write deadline of request's connection to now+timeout,
+// for long streaming routes (export) that would otherwise be cut by APP_WRITE_TIMEOUT
+func ExtendWriteDeadline(req *http.Request, timeout time.Duration) bool {
+	conn, ok := req.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return false
+	}
+	return conn.SetWriteDeadline(time.Now().Add(timeout)) == nil
+}
+
+// ServeHttp : serves on listener with tls/h2c setup from env, blocks until server stops
+func ServeHttp(srv *http.Server, listener net.Listener) error {
+	h2 := &http2.Server{
+		IdleTimeout:          srv.IdleTimeout,
+		MaxConcurrentStreams: uint32(getEnvInt64("APP_H2_MAX_CONCURRENT_STREAMS", 250)),
+	}
+
+	certFile, keyFile := os.Getenv("APP_TLS_CERT"), os.Getenv("APP_TLS_KEY")
+	if certFile != "" && keyFile != "" {
+		if err := http2.ConfigureServer(srv, h2); err != nil {
+			return err
+		}
+		return srv.ServeTLS(listener, certFile, keyFile)
+	}
+
+	if os.Getenv("APP_H2C") == "true" {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2)
+	}
+	return srv.Serve(listener)
+}
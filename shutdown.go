@@ -0,0 +1,106 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+*	Graceful Shutdown
+*	On SIGINT/SIGTERM within APP_SHUTDOWN_TIMEOUT (default 25s, keep it
+*	below the orchestrator grace period):
+*	1 - stop accepting connections and wait for in-flight requests
+*	2 - run shutdown hooks in registration order (flush buffered publishes
+*	    like analytics events, outbox relays register here too)
+*	3 - drain NATS: unsubscribe, let running worker handlers finish, flush
+*	    pending publishes, then close connection
+*	4 - close database pool
+*	Steps left when the deadline passes are cut short so the process exits.
+*/
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownHooks []shutdownHook
+)
+
+// OnShutdown : registers fn to run after http server stopped and before NATS drain
+func OnShutdown(name string, fn func(ctx context.Context)) {
+	shutdownMu.Lock()
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, fn: fn})
+	shutdownMu.Unlock()
+}
+
+/**
+*	HandleShutdown : waits for termination signal in background and shuts
+*	down srv and connections, returned channel is closed when done so main
+*	can wait for it after ServeHttp returns.
+*/
+func HandleShutdown(srv *http.Server) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(done)
+		received := <-signals
+		timeout := getEnvDuration("APP_SHUTDOWN_TIMEOUT", 25*time.Second)
+		LogInfo("Shutting down", LogFields{"signal": received.String(), "timeout": timeout.String()})
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			LogWarn("HTTP server shutdown cut short", LogFields{"error": err})
+		}
+
+		shutdownMu.Lock()
+		hooks := append([]shutdownHook{}, shutdownHooks...)
+		shutdownMu.Unlock()
+		for _, hook := range hooks {
+			if ctx.Err() != nil {
+				LogWarn("Shutdown hook skipped, deadline passed", LogFields{"hook": hook.name})
+				continue
+			}
+			hook.fn(ctx)
+		}
+
+		drainNats(ctx)
+
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		LogInfo("Shutdown complete", nil)
+	}()
+	return done
+}
+
+// drainNats : drains connection and waits until it is closed or ctx is done
+func drainNats(ctx context.Context) {
+	if nc == nil || nc.IsClosed() {
+		return
+	}
+	if err := nc.Drain(); err != nil {
+		LogWarn("Error draining NATS connection", LogFields{"error": err})
+		nc.Close()
+		return
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !nc.IsClosed() {
+		select {
+		case <-ctx.Done():
+			LogWarn("NATS drain cut short, closing connection", nil)
+			nc.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
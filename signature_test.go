@@ -0,0 +1,53 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignedInternalRequest(t *testing.T) {
+	os.Setenv("SERVICE_SIGNING_KEYS", "feed-service:test-secret")
+	defer os.Unsetenv("SERVICE_SIGNING_KEYS")
+
+	body := `{"body":"post of feed service"}`
+	signed := func(secret string) http.Header {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		return http.Header{
+			signatureCallerHeader:    {"feed-service"},
+			signatureTimestampHeader: {timestamp},
+			signatureHeader:          {RequestSignature(secret, timestamp, http.MethodPost, "/v1/post/internal/", []byte(body))},
+		}
+	}
+
+	header := signed("test-secret")
+	if rec := testRequest(http.MethodPost, "/v1/post/internal/", body, header); rec.Code != http.StatusOK {
+		t.Fatalf("signed request: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := testRequest(http.MethodPost, "/v1/post/internal/", body, header); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := testRequest(http.MethodPost, "/v1/post/internal/", body, signed("wrong-secret")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := testRequest(http.MethodPost, "/v1/post/internal/", body, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestActorOfServiceCaller(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/v1/post/internal/", nil)
+	ctx.Set(userIdKey, "dev-user")
+	ctx.Set(serviceCallerKey, "feed-service")
+	if actor := ActorID(ctx); actor != "service:feed-service" {
+		t.Errorf("actor: %q", actor)
+	}
+}
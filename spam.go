@@ -0,0 +1,385 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// page cacher (used as counter store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Spam Scoring
+*	Posts and quotes are scored 0..1 before they are saved:
+*	- links         : any link, more links than SPAM_MAX_LINKS (default 2)
+*	- duplicate     : same body from any actor within SPAM_DUPLICATE_WINDOW (1h),
+*	                  bodies shorter than SPAM_DUPLICATE_MIN_LENGTH (20 characters)
+*	                  are not counted ("thanks!" is not spam when many write it)
+*	- shouting      : mostly uppercase letters, long runs of one character
+*	- keywords      : words of SPAM_KEYWORDS
+*	- anonymous     : no authenticated user (account age once users exist)
+*	- classifier    : SPAM_CLASSIFIER_URL gets {"text":...} and answers
+*	                  {"spam_probability":0..1}, blended in with
+*	                  SPAM_CLASSIFIER_WEIGHT (default 0.5), errors fall back to
+*	                  heuristics only
+*	Score >= SPAM_REJECT_SCORE (0.9) rejects with 422 create-post/spam,
+*	>= SPAM_HOLD_SCORE (0.5) saves the post held: left out of lists, not
+*	shareable, answered with 202 until an admin approves or rejects it at
+*	/admin/spam. Holds and rejects emit spam.flagged. Scoring runs before the
+*	request transaction begins (see transaction.go), the classifier call
+*	holds no connection.
+*/
+const (
+	SpamClean    = "clean"
+	SpamHeld     = "held"
+	SpamRejected = "rejected"
+)
+
+var spamVerdicts = NewCounter(
+	"spam_verdicts_total",
+	"Spam scoring results by verdict (clean, held, rejected).",
+	"verdict",
+)
+
+var spamLinkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+type SpamVerdict struct {
+	Score   float64  `json:"score"`
+	Verdict string   `json:"verdict"`
+	Reasons []string `json:"reasons"`
+}
+
+type SpamScorer struct {
+	store             persistence.CacheStore
+	maxLinks          int
+	duplicateWindow   time.Duration
+	duplicateMinimum  int
+	keywords          []string
+	holdScore         float64
+	rejectScore       float64
+	classifierUrl     string
+	classifierWeight  float64
+	classifierTimeout time.Duration
+}
+
+var spamScorer *SpamScorer
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func NewSpamScorer(store persistence.CacheStore) *SpamScorer {
+	keywords := []string{}
+	for _, keyword := range splitList(os.Getenv("SPAM_KEYWORDS")) {
+		keywords = append(keywords, strings.ToLower(keyword))
+	}
+	return &SpamScorer{
+		store:             store,
+		maxLinks:          int(getEnvInt64("SPAM_MAX_LINKS", 2)),
+		duplicateWindow:   getEnvDuration("SPAM_DUPLICATE_WINDOW", time.Hour),
+		duplicateMinimum:  int(getEnvInt64("SPAM_DUPLICATE_MIN_LENGTH", 20)),
+		keywords:          keywords,
+		holdScore:         getEnvFloat("SPAM_HOLD_SCORE", 0.5),
+		rejectScore:       getEnvFloat("SPAM_REJECT_SCORE", 0.9),
+		classifierUrl:     os.Getenv("SPAM_CLASSIFIER_URL"),
+		classifierWeight:  math.Min(math.Max(getEnvFloat("SPAM_CLASSIFIER_WEIGHT", 0.5), 0), 1),
+		classifierTimeout: getEnvDuration("SPAM_CLASSIFIER_TIMEOUT", 2*time.Second),
+	}
+}
+
+// Score : verdict of body written by request actor, counts body for duplicate detection
+func (s *SpamScorer) Score(ctx *gin.Context, body string) SpamVerdict {
+	verdict := SpamVerdict{Reasons: []string{}}
+	add := func(score float64, reason string) {
+		verdict.Score += score
+		verdict.Reasons = append(verdict.Reasons, reason)
+	}
+
+	if links := len(spamLinkPattern.FindAllString(body, -1)); links > 0 {
+		add(0.1, "links")
+		if links > s.maxLinks {
+			add(0.25*float64(links-s.maxLinks), "too-many-links")
+		}
+	}
+	if copies := s.countDuplicate(body); copies >= 5 {
+		add(0.6, "duplicate")
+	} else if copies >= 2 {
+		add(0.3, "duplicate")
+	}
+	letters, upper, run, longestRun := 0, 0, 0, 0
+	var previous rune
+	for _, r := range body {
+		if r == previous && !unicode.IsSpace(r) {
+			run++
+		} else {
+			run = 1
+		}
+		previous = r
+		if run > longestRun {
+			longestRun = run
+		}
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= 20 && float64(upper)/float64(letters) > 0.7 {
+		add(0.15, "shouting")
+	}
+	if longestRun >= 8 {
+		add(0.1, "repeated-characters")
+	}
+	lower := strings.ToLower(body)
+	for _, keyword := range s.keywords {
+		if strings.Contains(lower, keyword) {
+			add(0.3, "keyword")
+			break
+		}
+	}
+	if UserID(ctx) == "" {
+		add(0.05, "anonymous")
+	}
+	verdict.Score = math.Min(verdict.Score, 1)
+
+	if s.classifierUrl != "" {
+		probability, err := s.classify(ctx.Request.Context(), body)
+		if err != nil {
+			LogWarn("Spam classifier unavailable, using heuristics", LogFields{"error": err, "request_id": RequestID(ctx)})
+		} else {
+			verdict.Score = (1-s.classifierWeight)*verdict.Score + s.classifierWeight*probability
+			if probability >= s.holdScore {
+				verdict.Reasons = append(verdict.Reasons, "classifier")
+			}
+		}
+	}
+
+	switch {
+	case verdict.Score >= s.rejectScore:
+		verdict.Verdict = SpamRejected
+	case verdict.Score >= s.holdScore:
+		verdict.Verdict = SpamHeld
+	default:
+		verdict.Verdict = SpamClean
+	}
+	spamVerdicts.Inc(verdict.Verdict)
+	return verdict
+}
+
+// countDuplicate : copies of body seen in duplicate window including this one, 0 for short bodies
+func (s *SpamScorer) countDuplicate(body string) uint64 {
+	normalized := strings.ToLower(strings.TrimSpace(body))
+	if utf8.RuneCountInString(normalized) < s.duplicateMinimum {
+		return 0
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	key := "spam:duplicate:" + hex.EncodeToString(sum[:12])
+	count, err := IncrementCounter(s.store, key, 1, s.duplicateWindow)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+func (s *SpamScorer) classify(ctx context.Context, body string) (float64, error) {
+	payload, _ := json.Marshal(map[string]string{"text": body})
+	client := NewHttpClient(s.classifierTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.classifierUrl, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, errors.New("spam classifier: unexpected status " + res.Status)
+	}
+	var reply struct {
+		SpamProbability *float64 `json:"spam_probability"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		return 0, err
+	}
+	if reply.SpamProbability == nil {
+		return 0, errors.New("spam classifier: spam_probability missing")
+	}
+	return math.Min(math.Max(*reply.SpamProbability, 0), 1), nil
+}
+
+/**
+*	CheckSpam : scores post body, rejected posts get 422 area/spam and
+*	false, held posts are marked. Dry runs and posts without body pass.
+*/
+func CheckSpam(ctx *gin.Context, area string, post *Post) bool {
+	if spamScorer == nil || IsDryRun(ctx) || strings.TrimSpace(post.Body) == "" {
+		return true
+	}
+	verdict := spamScorer.Score(ctx, post.Body)
+	post.SpamScore = verdict.Score
+	post.SpamStatus = verdict.Verdict
+	if verdict.Verdict == SpamRejected {
+		publishSpamFlagged(ctx, *post, verdict)
+		WriteJSON(ctx, http.StatusUnprocessableEntity, gin.H{
+			"status":  false,
+			"type":    area + ErrSuffixSpam,
+			"message": "Post looks like spam and was rejected.",
+		})
+		return false
+	}
+	if verdict.Verdict == SpamHeld {
+		AfterCommit(ctx, func() { publishSpamFlagged(ctx, *post, verdict) })
+	}
+	return true
+}
+
+func publishSpamFlagged(ctx *gin.Context, post Post, verdict SpamVerdict) {
+	LogWarn("Spam flagged", LogFields{"verdict": verdict.Verdict, "score": verdict.Score, "reasons": verdict.Reasons, "actor": ActorID(ctx)})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"post_id": post.PublicID,
+		"kind":    post.Kind,
+		"actor":   ActorID(ctx),
+		"body":    post.Body,
+		"verdict": verdict,
+		"time":    utcNow(),
+	})
+	PublishEventContext(ctx.Request.Context(), SubjectSpamFlagged, payload)
+}
+
+// createdPostStatus : 202 for posts held for review, 200 otherwise
+func createdPostStatus(post Post) int {
+	if post.SpamStatus == SpamHeld {
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
+
+// HeldPostDto : post waiting for spam review
+type HeldPostDto struct {
+	PostDto
+	SpamScore float64 `json:"spam_score" example:"0.65"`
+}
+
+// GetHeldPostsHandler godoc
+// @Summary Posts held for spam review
+// @Schemes
+// @Description Oldest held posts first
+// @Tags admin
+// @Security BasicAuth
+// @Param limit query int false "max posts (1-100, default 50)"
+// @Produce json
+// @Success 200 {object} object{posts=[]HeldPostDto}
+// @Failure 401 {object} object
+// @Failure 500 {object} object
+// @Router /admin/spam [get]
+func GetHeldPostsHandler(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+	posts := []Post{}
+	if err := db.Where("spam_status = ?", SpamHeld).Order("id").Limit(limit).Find(&posts).Error; err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeSpamQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	held := make([]HeldPostDto, 0, len(posts))
+	for _, post := range posts {
+		held = append(held, HeldPostDto{PostDto: ToPostDto(post), SpamScore: post.SpamScore})
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"posts": held,
+	})
+}
+
+// ReviewSpamHandler godoc
+// @Summary Approve or reject held post
+// @Schemes
+// @Description approve publishes the post, reject deletes it
+// @Tags admin
+// @Security BasicAuth
+// @Param id path string true "post public id"
+// @Param decision path string true "approve or reject"
+// @Produce json
+// @Success 200 {object} object
+// @Failure 401 {object} object
+// @Failure 404 {object} object
+// @Failure 500 {object} object
+// @Router /admin/spam/{id}/{decision} [post]
+func ReviewSpamHandler(ctx *gin.Context) {
+	decision := ctx.Param("decision")
+	if decision != "approve" && decision != "reject" {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeSpamNotFound,
+			"message": "Decision must be approve or reject.",
+		})
+		return
+	}
+	var post Post
+	err := db.Where("public_id = ? AND spam_status = ?", ctx.Param("id"), SpamHeld).First(&post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeSpamNotFound,
+			"message": "No held post with that id.",
+		})
+		return
+	}
+	if err == nil {
+		if decision == "approve" {
+			err = db.Model(&post).UpdateColumn("spam_status", SpamClean).Error
+		} else {
+			err = db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&post).UpdateColumn("spam_status", SpamRejected).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&post).Error
+			})
+		}
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeSpamSave,
+			"message": err.Error(),
+		})
+		return
+	}
+	invalidatePost(&post)
+	responseCache.Invalidate(PostListCache.Group)
+	LogInfo("Held post reviewed", LogFields{"post_id": post.PublicID, "decision": decision, "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"status":   true,
+		"post_id":  post.PublicID,
+		"decision": decision,
+	})
+}
@@ -0,0 +1,48 @@
+-- name: ListFeedPosts :many
+SELECT * FROM posts
+WHERE deleted_at IS NULL
+  AND visibility = 'public'
+  AND spam_status <> 'held'
+ORDER BY id DESC
+LIMIT $1 OFFSET $2;
+
+-- name: CountFeedPosts :one
+SELECT count(*) FROM posts
+WHERE deleted_at IS NULL
+  AND visibility = 'public'
+  AND spam_status <> 'held';
+
+-- name: ListFeedPostsWithoutSensitive :many
+SELECT p.* FROM posts p
+LEFT JOIN posts o ON o.id = p.original_id AND o.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND p.visibility = 'public'
+  AND p.spam_status <> 'held'
+  AND p.is_sensitive = false
+  AND (o.id IS NULL OR o.is_sensitive = false)
+ORDER BY p.id DESC
+LIMIT $1 OFFSET $2;
+
+-- name: CountFeedPostsWithoutSensitive :one
+SELECT count(*) FROM posts p
+LEFT JOIN posts o ON o.id = p.original_id AND o.deleted_at IS NULL
+WHERE p.deleted_at IS NULL
+  AND p.visibility = 'public'
+  AND p.spam_status <> 'held'
+  AND p.is_sensitive = false
+  AND (o.id IS NULL OR o.is_sensitive = false);
+
+-- name: ListTrendingPosts :many
+SELECT sqlc.embed(p), s.impressions
+FROM posts p
+JOIN (
+  SELECT post_id, CAST(sum(impressions) AS BIGINT) AS impressions
+  FROM post_stats
+  WHERE day >= $1
+  GROUP BY post_id
+) s ON s.post_id = p.id
+WHERE p.deleted_at IS NULL
+  AND p.visibility = 'public'
+  AND p.spam_status <> 'held'
+ORDER BY s.impressions DESC, p.id DESC
+LIMIT $2;
@@ -0,0 +1,27 @@
+-- Tables read by sql/queries, kept in sync with GORM models (AutoMigrate
+-- owns the real schema, this file only tells sqlc about columns).
+
+CREATE TABLE posts (
+    id             BIGSERIAL PRIMARY KEY,
+    created_at     TIMESTAMPTZ NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL,
+    deleted_at     TIMESTAMPTZ,
+    public_id      VARCHAR(36) NOT NULL,
+    body           VARCHAR(255) NOT NULL,
+    external_id    VARCHAR(64),
+    visibility     VARCHAR(16) NOT NULL DEFAULT 'public',
+    kind           VARCHAR(8) NOT NULL DEFAULT 'post',
+    original_id    BIGINT,
+    reposted_count BIGINT NOT NULL DEFAULT 0,
+    is_sensitive   BOOLEAN NOT NULL DEFAULT false,
+    spam_status    VARCHAR(8) NOT NULL DEFAULT 'clean',
+    spam_score     DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+CREATE TABLE post_stats (
+    post_id     BIGINT NOT NULL,
+    day         VARCHAR(10) NOT NULL,
+    referrer    VARCHAR(255) NOT NULL,
+    impressions BIGINT NOT NULL,
+    PRIMARY KEY (post_id, day, referrer)
+);
@@ -0,0 +1,160 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	SQLite Hardening
+*	SQLite connections (DEV_MODE) open with:
+*	- journal_mode=WAL : readers do not block the writer and the other way
+*	- busy_timeout     : SQLITE_BUSY_TIMEOUT (default 5s) waits for locks instead of failing
+*	- foreign_keys=ON
+*	- txlock=immediate : transactions take write lock at BEGIN (no lock upgrade deadlocks)
+*	Writes are serialized in process by sqliteWriter: gorm callbacks hold it
+*	around create/update/delete outside transactions and TxMiddleware holds
+*	it for the whole write request, so handlers under TxMiddleware must write
+*	through DB(ctx) (openRequestTx with a request context). Writes carrying the
+*	request context of such a request skip the lock instead of waiting on
+*	their own request forever. POST /admin/db/backup snapshots the live database with
+*	VACUUM INTO under SQLITE_BACKUP_DIR (default backups).
+*/
+const sqliteWriteLockKey = "sqlite:write_lock"
+
+var (
+	sqliteWriter  sync.Mutex
+	sqliteEnabled bool
+)
+
+// sqliteDSN : path with hardening params of go-sqlite3, params in path win
+func sqliteDSN(path string) string {
+	timeout := getEnvDuration("SQLITE_BUSY_TIMEOUT", 5*time.Second)
+	params := []string{
+		"_journal_mode=WAL",
+		"_busy_timeout=" + strconv.FormatInt(timeout.Milliseconds(), 10),
+		"_foreign_keys=1",
+		"_txlock=immediate",
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	for _, param := range params {
+		if !strings.Contains(path, strings.SplitN(param, "=", 2)[0]+"=") {
+			path += separator + param
+			separator = "&"
+		}
+	}
+	return path
+}
+
+// lockSQLiteWriter : takes single writer lock when database is sqlite, returns unlock (safe to call twice)
+func lockSQLiteWriter() func() {
+	if !sqliteEnabled {
+		return func() {}
+	}
+	sqliteWriter.Lock()
+	var once sync.Once
+	return func() { once.Do(sqliteWriter.Unlock) }
+}
+
+// RegisterSQLiteWriteLock : serializes writes of database outside explicit transactions
+func RegisterSQLiteWriteLock(database *gorm.DB) error {
+	sqliteEnabled = true
+	lock := func(tx *gorm.DB) {
+		// writes in a transaction run under lock of its owner (TxMiddleware) or busy_timeout
+		if _, inTx := tx.Statement.ConnPool.(gorm.TxCommitter); inTx {
+			return
+		}
+		// the request of this context already holds the lock (see transaction.go)
+		if tx.Statement.Context != nil && holdsSQLiteWriter(tx.Statement.Context) {
+			return
+		}
+		sqliteWriter.Lock()
+		tx.InstanceSet(sqliteWriteLockKey, true)
+	}
+	unlock := func(tx *gorm.DB) {
+		if _, locked := tx.InstanceGet(sqliteWriteLockKey); locked {
+			sqliteWriter.Unlock()
+		}
+	}
+	callbacks := database.Callback()
+	if err := callbacks.Create().Before("gorm:begin_transaction").Register("sqlite:lock_create", lock); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:commit_or_rollback_transaction").Register("sqlite:unlock_create", unlock); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:begin_transaction").Register("sqlite:lock_update", lock); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:commit_or_rollback_transaction").Register("sqlite:unlock_update", unlock); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:begin_transaction").Register("sqlite:lock_delete", lock); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("gorm:commit_or_rollback_transaction").Register("sqlite:unlock_delete", unlock)
+}
+
+// SQLiteBackupHandler godoc
+// @Summary Snapshot sqlite database
+// @Schemes
+// @Description Writes a consistent copy of the live sqlite database with VACUUM INTO under SQLITE_BACKUP_DIR
+// @Tags admin
+// @Security BasicAuth
+// @Produce json
+// @Success 200 {object} object
+// @Failure 500 {object} object
+// @Failure 501 {object} object
+// @Router /admin/db/backup [post]
+func SQLiteBackupHandler(ctx *gin.Context) {
+	if !sqliteEnabled {
+		WriteJSON(ctx, http.StatusNotImplemented, gin.H{
+			"status":  false,
+			"type":    ErrCodeDbBackupUnsupported,
+			"message": "Online backup is only available for sqlite, use pg_dump for postgres.",
+		})
+		return
+	}
+	dir := os.Getenv("SQLITE_BACKUP_DIR")
+	if dir == "" {
+		dir = "backups"
+	}
+	path := filepath.Join(dir, "backup-"+time.Now().UTC().Format("20060102-150405.000")+".db")
+	err := os.MkdirAll(dir, 0o750)
+	if err == nil {
+		err = db.Exec("VACUUM INTO ?", path).Error
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		LogError("Error backing up sqlite database", LogFields{"path": path, "error": err})
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeDbBackupFailed,
+			"message": err.Error(),
+		})
+		return
+	}
+	LogInfo("Sqlite database backed up", LogFields{"path": path, "size_bytes": info.Size(), "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"status":     true,
+		"path":       path,
+		"size_bytes": info.Size(),
+	})
+}
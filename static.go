@@ -0,0 +1,81 @@
+package main
+
+import (
+	// system packages
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Static Files and SPA Fallback
+*	Single binary deployments can serve a bundled frontend at / next to the
+*	/v1 api. Files come from APP_STATIC_DIR (e.g. ./web/dist) or from the
+*	binary when built with -tags embedweb (Look to static_embed.go).
+*	Unknown non-api paths fall back to index.html for client side routing,
+*	hashed assets (app.3f2a9c1b.js) are cached for a year, index.html is
+*	always revalidated.
+*/
+var hashedAssetPattern = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// embeddedStatic : set by static_embed.go when built with embedweb tag
+var embeddedStatic fs.FS
+
+func staticFS() fs.FS {
+	if dir := os.Getenv("APP_STATIC_DIR"); dir != "" {
+		return os.DirFS(dir)
+	}
+	return embeddedStatic
+}
+
+/**
+*	RegisterStatic : serves frontend for routes that no api route matched,
+*	api paths (/v1/...) keep json 404 envelope
+*/
+func RegisterStatic(r *gin.Engine) {
+	files := staticFS()
+	r.NoRoute(func(ctx *gin.Context) {
+		if files == nil || strings.HasPrefix(ctx.Request.URL.Path, "/v1/") || ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead {
+			WriteJSON(ctx, http.StatusNotFound, gin.H{
+				"status":  false,
+				"type":    ErrCodeRequestNotFound,
+				"message": "Route not found.",
+			})
+			return
+		}
+		name := strings.TrimPrefix(path.Clean(ctx.Request.URL.Path), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		if info, err := fs.Stat(files, name); err != nil || info.IsDir() {
+			// spa history fallback
+			name = "index.html"
+		}
+		if hashedAssetPattern.MatchString(name) {
+			ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			ctx.Header("Cache-Control", "no-cache")
+		}
+		// served directly since http.FileServer redirects /index.html to /
+		file, err := files.Open(name)
+		if err != nil {
+			ctx.Status(http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		content, ok := file.(io.ReadSeeker)
+		if err != nil || !ok {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(ctx.Writer, ctx.Request, name, info.ModTime(), content)
+	})
+}
@@ -0,0 +1,25 @@
+//go:build embedweb
+// +build embedweb
+
+package main
+
+import (
+	// system packages
+	"embed"
+	"io/fs"
+)
+
+/**
+*	Embedded frontend, build with: go build -tags embedweb
+*	Expects built frontend at web/dist (e.g. npm run build output).
+*/
+//go:embed web/dist
+var embeddedWeb embed.FS
+
+func init() {
+	dist, err := fs.Sub(embeddedWeb, "web/dist")
+	if err != nil {
+		panic(err)
+	}
+	embeddedStatic = dist
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	// system packages
+	"time"
+
+	// cache packages
+	"github.com/gin-contrib/cache/persistence"
+	// redis packages
+	"github.com/gomodule/redigo/redis"
+)
+
+/**
+*	Store Counters
+*	Increment of the gin-contrib redis store is GET then SET, replicas lose
+*	concurrent increments and SET drops the TTL of windowed counters.
+*	IncrementCounter runs INCRBY in a script on REDIS_HOST (TTL is set only
+*	when the key has none). Other stores (memory) create the key with Add
+*	and increment it in place, both atomic.
+*/
+var redisIncrementScript = redis.NewScript(1, `local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then redis.call("PEXPIRE", KEYS[1], ARGV[2]) end
+return value`)
+
+// counterPool : set with REDIS_HOST, nil uses the store methods
+var counterPool *redis.Pool
+
+// redisCounters : pool for counters of store, nil when store is not redis
+func redisCounters(store persistence.CacheStore) *redis.Pool {
+	if _, ok := store.(*persistence.RedisStore); ok {
+		return counterPool
+	}
+	return nil
+}
+
+// IncrementCounter : adds delta to key, a missing key starts at 0 and expires after ttl (persistence.FOREVER keeps it)
+func IncrementCounter(store persistence.CacheStore, key string, delta uint64, ttl time.Duration) (uint64, error) {
+	if pool := redisCounters(store); pool != nil {
+		conn := pool.Get()
+		defer conn.Close()
+		var ttlMs int64
+		if ttl > 0 {
+			ttlMs = ttl.Milliseconds()
+		}
+		value, err := redis.Uint64(redisIncrementScript.Do(conn, key, delta, ttlMs))
+		return value, err
+	}
+	// key may expire between Add and Increment, start over once
+	for attempt := 0; ; attempt++ {
+		if err := store.Add(key, uint64(0), ttl); err != nil && err != persistence.ErrNotStored {
+			return 0, err
+		}
+		value, err := store.Increment(key, delta)
+		if err != persistence.ErrCacheMiss || attempt > 0 {
+			return value, err
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	NATS Subjects and Topology
+*	Subjects follow <namespace>.<aggregate>.<event>.v<version> like
+*	alya.post.created.v1. Code publishes and subscribes only through the
+*	Subject* constants below, ParseSubject splits a name into its parts
+*	(events replay picks the stream of the aggregate with it). A breaking
+*	payload change gets a new version constant next to the old one instead
+*	of changing it. Dead letters and replays prefix the full subject
+*	(dlq.alya.post.created.v1).
+*	On startup BootstrapStreams declares one JetStream stream per event
+*	aggregate (POST captures alya.post.>) with NATS_STREAM_MAX_AGE retention
+*	(default 168h), NATS_STREAM_BOOTSTRAP=false leaves topology to ops.
+*	Work queues (mail, import, analytics) are kept in the WORK stream with
+*	interest retention, a message is dropped once every durable consumer
+*	of SubscribeWorker (see dead_letter.go) acked it.
+*/
+const subjectNamespace = "alya"
+
+const (
+	SubjectPostCreated        = subjectNamespace + ".post.created.v1"
+	SubjectPostUpdated        = subjectNamespace + ".post.updated.v1"
+	SubjectPostShared         = subjectNamespace + ".post.shared.v1"
+	SubjectPostListed         = subjectNamespace + ".post.listed.v1"
+	SubjectUploadRejected     = subjectNamespace + ".upload.rejected.v1"
+	SubjectQuotaExceeded      = subjectNamespace + ".quota.exceeded.v1"
+	SubjectAbuseDetected      = subjectNamespace + ".abuse.detected.v1"
+	SubjectSpamFlagged        = subjectNamespace + ".spam.flagged.v1"
+	SubjectAnalyticsCollected = subjectNamespace + ".analytics.collected.v1"
+	SubjectMailSend           = subjectNamespace + ".mail.send.v1"
+	SubjectImportRun          = subjectNamespace + ".import.run.v1"
+	SubjectCacheInvalidate    = subjectNamespace + ".cache.invalidate.v1"
+)
+
+// eventAggregates : aggregates whose events are kept in a JetStream stream
+var eventAggregates = []string{"post", "upload", "quota", "abuse", "spam"}
+
+// workStream : stream of work queue subjects consumed by SubscribeWorker
+const workStream = "WORK"
+
+var workSubjects = []string{SubjectMailSend, SubjectImportRun, SubjectAnalyticsCollected}
+
+type Subject struct {
+	Namespace string
+	Aggregate string
+	Event     string
+	Version   int
+}
+
+// ParseSubject : splits <namespace>.<aggregate>.<event>.v<version>, dlq. and replay. prefixes are dropped
+func ParseSubject(subject string) (Subject, error) {
+	subject = strings.TrimPrefix(strings.TrimPrefix(subject, "dlq."), "replay.")
+	parts := strings.Split(subject, ".")
+	if len(parts) != 4 || !strings.HasPrefix(parts[3], "v") {
+		return Subject{}, errors.New("subject is not <namespace>.<aggregate>.<event>.v<version>: " + subject)
+	}
+	version, err := strconv.Atoi(parts[3][1:])
+	if err != nil || version < 1 {
+		return Subject{}, errors.New("subject version is not v<number>: " + subject)
+	}
+	return Subject{Namespace: parts[0], Aggregate: parts[1], Event: parts[2], Version: version}, nil
+}
+
+// StreamName : JetStream stream of aggregate like POST
+func StreamName(aggregate string) string {
+	return strings.ToUpper(aggregate)
+}
+
+// BootstrapStreams : creates or updates stream of each event aggregate
+func BootstrapStreams(conn *nats.Conn) error {
+	if os.Getenv("NATS_STREAM_BOOTSTRAP") == "false" {
+		return nil
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		return err
+	}
+	if _, err := js.AccountInfo(); err != nil {
+		// core nats without JetStream, events are fire and forget
+		LogWarn("JetStream unavailable, skipping stream bootstrap", LogFields{"error": err})
+		return nil
+	}
+	maxAge := getEnvDuration("NATS_STREAM_MAX_AGE", 7*24*time.Hour)
+	configs := []*nats.StreamConfig{}
+	for _, aggregate := range eventAggregates {
+		configs = append(configs, &nats.StreamConfig{
+			Name:     StreamName(aggregate),
+			Subjects: []string{subjectNamespace + "." + aggregate + ".>"},
+			MaxAge:   maxAge,
+			Storage:  nats.FileStorage,
+		})
+	}
+	configs = append(configs, &nats.StreamConfig{
+		Name:      workStream,
+		Subjects:  workSubjects,
+		Retention: nats.InterestPolicy,
+		MaxAge:    maxAge,
+		Storage:   nats.FileStorage,
+	})
+	for _, config := range configs {
+		if _, err := js.StreamInfo(config.Name); err == nats.ErrStreamNotFound {
+			_, err = js.AddStream(config)
+			if err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if _, err := js.UpdateStream(config); err != nil {
+			return err
+		}
+	}
+	LogInfo("JetStream streams ready", LogFields{"aggregates": eventAggregates, "work": workSubjects})
+	return nil
+}
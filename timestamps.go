@@ -0,0 +1,53 @@
+package main
+
+import (
+	// system packages
+	"regexp"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Timestamps
+*	All timestamps leave the API as RFC3339 in UTC ("2024-01-01T10:00:00Z").
+*	GORM writes created_at/updated_at with utcNow and DTO mappers pass
+*	times through ToUTC, since SQLite and Postgres return different zones.
+*	Time filters (created_after, ...) accept RFC3339 or YYYY-MM-DD and are
+*	converted to UTC by parseQueryTime.
+*/
+func utcNow() time.Time {
+	return time.Now().UTC()
+}
+
+// ToUTC : time in UTC with second precision so it marshals as RFC3339
+func ToUTC(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+var localTimePattern = regexp.MustCompile(`"\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?[+-]\d{2}:\d{2}"`)
+
+/**
+*	LocalTimeGuardMiddleware : in debug mode warns when a json response
+*	contains a timestamp with a non-UTC offset, so DTOs that marshal raw
+*	model times are noticed during development. No-op in release mode.
+*/
+func LocalTimeGuardMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !gin.IsDebugging() {
+			ctx.Next()
+			return
+		}
+		writer := &cacheWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+		if !strings.HasPrefix(writer.Header().Get("Content-Type"), "application/json") {
+			return
+		}
+		if match := localTimePattern.Find(writer.body.Bytes()); match != nil {
+			LogWarn("Response contains non-UTC timestamp", LogFields{"path": ctx.FullPath(), "value": string(match), "request_id": RequestID(ctx)})
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	// system packages
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResponsesHaveNoLocalTimes(t *testing.T) {
+	// raw model times would marshal as +03:00 in this zone
+	previous := time.Local
+	time.Local = time.FixedZone("TRT", 3*60*60)
+	defer func() { time.Local = previous }()
+
+	localTime, _ := json.Marshal(time.Now())
+	if !localTimePattern.Match(localTime) {
+		t.Fatalf("localTimePattern does not match local time %s", localTime)
+	}
+
+	admin := http.Header{"Authorization": {basicAuth(testAdminAuth)}}
+	adminPatch := http.Header{"Authorization": {basicAuth(testAdminAuth)}, "Content-Type": {"application/merge-patch+json"}}
+	created := testRequest(http.MethodPost, "/v1/post/", `{"body":"local time test post"}`, nil)
+	var createdBody struct {
+		Post PostDto `json:"post"`
+	}
+	if err := json.Unmarshal(created.Body.Bytes(), &createdBody); err != nil || createdBody.Post.ID == "" {
+		t.Fatalf("create post: %d %s", created.Code, created.Body.String())
+	}
+	postID := createdBody.Post.ID
+	responseCache.Invalidate(PostListCache.Group)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header http.Header
+	}{
+		{"list posts", http.MethodGet, "/v1/post/?limit=50", "", nil},
+		{"list posts created after", http.MethodGet, "/v1/post/?created_after=2020-01-01T00:00:00%2B03:00", "", nil},
+		{"trending posts", http.MethodGet, "/v1/post/trending", "", nil},
+		{"repost", http.MethodPost, "/v1/post/" + postID + "/repost", "", nil},
+		{"quote", http.MethodPost, "/v1/post/" + postID + "/quote", `{"body":"local time quote"}`, nil},
+		{"admin patch post", http.MethodPatch, "/v1/admin/posts/" + postID, `{"visibility":"public"}`, adminPatch},
+		{"admin post stats", http.MethodGet, "/v1/admin/posts/" + postID + "/stats", "", admin},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := testRequest(tc.method, tc.path, tc.body, tc.header)
+			if rec.Code >= 300 {
+				t.Fatalf("%d %s", rec.Code, rec.Body.String())
+			}
+			if match := localTimePattern.Find(rec.Body.Bytes()); match != nil {
+				t.Errorf("non-UTC timestamp %s in %s", match, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	// system packages
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// page cacher (used as shared state store, in-memory or redis)
+	"github.com/gin-contrib/cache/persistence"
+	// database packages
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+*	Terms of Service
+*	Admins publish versions with POST /admin/tos, the newest published
+*	version is current (GET /tos). Authenticated users who have not accepted
+*	the current version get 451 tos/acceptance-required on every route
+*	except health, auth, admin, GET /tos and POST /user/me/accept-tos.
+*	Anonymous requests are not gated. Until there is a users and auth
+*	module only DevAuthMiddleware sets UserID, so outside DEV_MODE every
+*	request is anonymous and the gate does nothing (README TODO).
+*	Acceptances are kept per user and version with time and client ip as
+*	legal record, accepted users are remembered in the shared store so the
+*	check costs no query per request.
+*	Other replicas drop their cached version on the cache invalidation
+*	broadcast (see cache_sync.go), at the latest after tosVersionCacheTTL.
+*/
+const tosVersionCacheTTL = 30 * time.Second
+
+type TosVersion struct {
+	ID          uint      `gorm:"primaryKey"`
+	Version     string    `gorm:"column:version;size:32;uniqueIndex;not null"`
+	URL         string    `gorm:"column:url;size:255;not null"`
+	Summary     string    `gorm:"column:summary;type:text"`
+	PublishedAt time.Time `gorm:"column:published_at;not null;index"`
+}
+
+type TosAcceptance struct {
+	UserID     string    `gorm:"column:user_id;size:128;primaryKey"`
+	Version    string    `gorm:"column:version;size:32;primaryKey"`
+	AcceptedAt time.Time `gorm:"column:accepted_at;not null"`
+	ClientIP   string    `gorm:"column:client_ip;size:64"`
+}
+
+// TosVersionDto is the public shape of TosVersion (published_at RFC3339 UTC)
+type TosVersionDto struct {
+	Version     string    `json:"version" example:"2022-03"`
+	URL         string    `json:"url" example:"https://example.com/legal/tos/2022-03"`
+	Summary     string    `json:"summary"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func ToTosVersionDto(version TosVersion) TosVersionDto {
+	return TosVersionDto{
+		Version:     version.Version,
+		URL:         version.URL,
+		Summary:     version.Summary,
+		PublishedAt: ToUTC(version.PublishedAt),
+	}
+}
+
+type PublishTosDto struct {
+	Version string `json:"version" validate:"required,max=32" example:"2022-03"`
+	URL     string `json:"url" validate:"required,url,max=255" example:"https://example.com/legal/tos/2022-03"`
+	Summary string `json:"summary" validate:"max=4096" example:"Added section on data exports."`
+}
+
+type AcceptTosDto struct {
+	Version string `json:"version" validate:"required,max=32" example:"2022-03"`
+}
+
+var currentTos = struct {
+	mu       sync.Mutex
+	version  *TosVersion
+	loadedAt time.Time
+}{}
+
+// CurrentTos : newest published version, nil when none is published
+func CurrentTos() (*TosVersion, error) {
+	currentTos.mu.Lock()
+	defer currentTos.mu.Unlock()
+	if !currentTos.loadedAt.IsZero() && time.Since(currentTos.loadedAt) < tosVersionCacheTTL {
+		return currentTos.version, nil
+	}
+	var version TosVersion
+	err := db.Order("published_at desc, id desc").First(&version).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	currentTos.version = nil
+	if err == nil {
+		currentTos.version = &version
+	}
+	currentTos.loadedAt = time.Now()
+	return currentTos.version, nil
+}
+
+func forgetCurrentTos() {
+	currentTos.mu.Lock()
+	currentTos.loadedAt = time.Time{}
+	currentTos.mu.Unlock()
+}
+
+func tosAcceptedKey(version, userId string) string {
+	return "tos:" + version + ":" + userId
+}
+
+// hasAcceptedTos : acceptance of user for version, store first then database
+func hasAcceptedTos(store persistence.CacheStore, version, userId string) (bool, error) {
+	var accepted bool
+	if err := store.Get(tosAcceptedKey(version, userId), &accepted); err == nil && accepted {
+		return true, nil
+	}
+	var count int64
+	err := db.Model(&TosAcceptance{}).Where("user_id = ? AND version = ?", userId, version).Count(&count).Error
+	if err != nil || count == 0 {
+		return false, err
+	}
+	store.Set(tosAcceptedKey(version, userId), true, 24*time.Hour)
+	return true, nil
+}
+
+func tosExempt(path string) bool {
+	return strings.Contains(path, "/_/") || strings.Contains(path, "/auth/") || strings.Contains(path, "/admin/") ||
+		strings.HasSuffix(path, "/tos") || strings.HasSuffix(path, "/user/me/accept-tos")
+}
+
+// TosMiddleware : rejects authenticated users who have not accepted current terms
+func TosMiddleware(store persistence.CacheStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userId := UserID(ctx)
+		if userId == "" || tosExempt(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+		current, err := CurrentTos()
+		if err == nil && current != nil {
+			var accepted bool
+			accepted, err = hasAcceptedTos(store, current.Version, userId)
+			if err == nil && !accepted {
+				ctx.AbortWithStatusJSON(http.StatusUnavailableForLegalReasons, gin.H{
+					"status":      false,
+					"type":        ErrCodeTosAcceptanceRequired,
+					"message":     "Terms of service changed, accept them with POST /v1/user/me/accept-tos.",
+					"tos_version": current.Version,
+					"tos_url":     current.URL,
+				})
+				return
+			}
+		}
+		if err != nil {
+			// an unreadable terms table must not take the api down
+			LogError("Error checking terms of service acceptance", LogFields{"error": err})
+		}
+		ctx.Next()
+	}
+}
+
+// GetTosHandler godoc
+// @Summary Current terms of service
+// @Schemes
+// @Description Newest published version, accepted tells whether authenticated user accepted it
+// @Tags tos
+// @Produce json
+// @Success 200 {object} TosVersionDto
+// @Failure 404 {object} object
+// @Failure 500 {object} object
+// @Router /tos [get]
+func GetTosHandler(store persistence.CacheStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		current, err := CurrentTos()
+		if err != nil {
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeTosQuery,
+				"message": err.Error(),
+			})
+			return
+		}
+		if current == nil {
+			WriteJSON(ctx, http.StatusNotFound, gin.H{
+				"status":  false,
+				"type":    ErrCodeTosNotFound,
+				"message": "No terms of service published.",
+			})
+			return
+		}
+		response := gin.H{"tos": ToTosVersionDto(*current)}
+		if userId := UserID(ctx); userId != "" {
+			accepted, _ := hasAcceptedTos(store, current.Version, userId)
+			response["accepted"] = accepted
+		}
+		WriteJSON(ctx, http.StatusOK, response)
+	}
+}
+
+// AcceptTosHandler godoc
+// @Summary Accept current terms of service
+// @Schemes
+// @Description Records acceptance of authenticated user, version must be the current one
+// @Tags tos
+// @Param body body AcceptTosDto true "accepted version"
+// @Accept application/json
+// @Produce json
+// @Success 200 {object} object
+// @Failure 400 {object} object
+// @Failure 401 {object} object
+// @Failure 409 {object} object
+// @Failure 500 {object} object
+// @Router /user/me/accept-tos [post]
+func AcceptTosHandler(store persistence.CacheStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userId := UserID(ctx)
+		if userId == "" {
+			WriteJSON(ctx, http.StatusUnauthorized, gin.H{
+				"status":  false,
+				"type":    ErrCodeTosUnauthenticated,
+				"message": "Only authenticated users can accept terms of service.",
+			})
+			return
+		}
+		var dto AcceptTosDto
+		if err := BindDto(ctx, &dto, ErrAreaTos); err != nil {
+			return
+		}
+		current, err := CurrentTos()
+		if err != nil {
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeTosQuery,
+				"message": err.Error(),
+			})
+			return
+		}
+		if current == nil || current.Version != dto.Version {
+			WriteJSON(ctx, http.StatusConflict, gin.H{
+				"status":  false,
+				"type":    ErrCodeTosOutdatedVersion,
+				"message": "Only the current terms of service version can be accepted.",
+			})
+			return
+		}
+		acceptance := TosAcceptance{UserID: userId, Version: current.Version, AcceptedAt: utcNow(), ClientIP: ClientIP(ctx)}
+		// accepting twice keeps the first record
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&acceptance).Error; err != nil {
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeTosSave,
+				"message": err.Error(),
+			})
+			return
+		}
+		store.Set(tosAcceptedKey(current.Version, userId), true, 24*time.Hour)
+		LogInfo("Terms of service accepted", LogFields{"user_id": userId, "version": current.Version, "client_ip": acceptance.ClientIP})
+		WriteJSON(ctx, http.StatusOK, gin.H{
+			"status":  true,
+			"version": current.Version,
+		})
+	}
+}
+
+// PublishTosHandler godoc
+// @Summary Publish terms of service version
+// @Schemes
+// @Description New version becomes current, users have to accept it before using the api
+// @Tags admin
+// @Security BasicAuth
+// @Param body body PublishTosDto true "version and document url"
+// @Accept application/json
+// @Produce json
+// @Success 200 {object} TosVersionDto
+// @Failure 400 {object} object
+// @Failure 409 {object} object
+// @Failure 500 {object} object
+// @Router /admin/tos [post]
+func PublishTosHandler(ctx *gin.Context) {
+	var dto PublishTosDto
+	if err := BindDto(ctx, &dto, ErrAreaTos); err != nil {
+		return
+	}
+	var count int64
+	if err := db.Model(&TosVersion{}).Where("version = ?", dto.Version).Count(&count).Error; err == nil && count > 0 {
+		WriteJSON(ctx, http.StatusConflict, gin.H{
+			"status":  false,
+			"type":    ErrCodeTosVersionExists,
+			"message": "Terms of service version is already published.",
+		})
+		return
+	}
+	version := TosVersion{Version: dto.Version, URL: dto.URL, Summary: dto.Summary, PublishedAt: utcNow()}
+	if err := db.Create(&version).Error; err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeTosSave,
+			"message": err.Error(),
+		})
+		return
+	}
+	forgetCurrentTos()
+	cacheSync.Broadcast(CacheInvalidation{Cache: "tos"})
+	LogWarn("Terms of service published", LogFields{"version": version.Version, "client_ip": ClientIP(ctx)})
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"tos": ToTosVersionDto(version),
+	})
+}
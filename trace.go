@@ -0,0 +1,138 @@
+package main
+
+import (
+	// system packages
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+/**
+*	Trace Propagation
+*	RequestIDMiddleware keeps W3C trace context of the caller (traceparent
+*	header, new trace otherwise) next to the request id, in gin context and
+*	in the request context. Both travel on:
+*	- events published with PublishEventContext as X-Request-ID and
+*	  traceparent headers, workers log them and EventContext(msg) restores
+*	  them for calls the worker makes (mail provider apis)
+*	- outbound http calls of clients built with NewHttpClient when the
+*	  request is made with that context (http.NewRequestWithContext)
+*	TRACE_PROPAGATION lists what is sent: request-id, traceparent (default
+*	both), "none" keeps ids inside this service.
+*/
+const (
+	traceparentHeader = "traceparent"
+	traceKey          = "trace"
+)
+
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+type TraceContext struct {
+	RequestID string
+	TraceID   string
+	// SpanID : span of this service, parent id of downstream calls
+	SpanID string
+	Flags  string
+}
+
+type traceContextKey struct{}
+
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// NewTraceContext : continues trace of traceparent header, starts a sampled one when missing or invalid
+func NewTraceContext(requestId, traceparent string) TraceContext {
+	trace := TraceContext{RequestID: requestId, SpanID: randomHex(8), Flags: "01"}
+	if match := traceparentPattern.FindStringSubmatch(traceparent); match != nil && match[1] != "00000000000000000000000000000000" {
+		trace.TraceID = match[1]
+		trace.Flags = match[3]
+		return trace
+	}
+	trace.TraceID = randomHex(16)
+	return trace
+}
+
+// Traceparent : header value for downstream calls, empty without trace
+func (t TraceContext) Traceparent() string {
+	if t.TraceID == "" {
+		return ""
+	}
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + t.Flags
+}
+
+// WithTrace : context carrying trace
+func WithTrace(parent context.Context, trace TraceContext) context.Context {
+	return context.WithValue(parent, traceContextKey{}, trace)
+}
+
+// TraceFrom : trace of context, zero value when there is none
+func TraceFrom(ctx context.Context) TraceContext {
+	trace, _ := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace
+}
+
+// Trace : trace of current request
+func Trace(ctx *gin.Context) TraceContext {
+	if value, ok := ctx.Get(traceKey); ok {
+		return value.(TraceContext)
+	}
+	return TraceContext{RequestID: RequestID(ctx)}
+}
+
+// setTraceHeaders : writes propagated trace fields with set (nats headers are case sensitive, http ones are not)
+func setTraceHeaders(set func(key, value string), trace TraceContext) {
+	propagate := map[string]bool{"request-id": true, "traceparent": true}
+	if config := os.Getenv("TRACE_PROPAGATION"); config != "" {
+		propagate = map[string]bool{}
+		for _, name := range splitList(config) {
+			propagate[name] = true
+		}
+	}
+	if propagate["request-id"] && trace.RequestID != "" {
+		set(requestIdHeader, trace.RequestID)
+	}
+	if traceparent := trace.Traceparent(); propagate["traceparent"] && traceparent != "" {
+		set(traceparentHeader, traceparent)
+	}
+}
+
+// EventContext : context with trace of event headers, for calls made while handling it
+func EventContext(msg *nats.Msg) context.Context {
+	if msg.Header == nil || msg.Header.Get(requestIdHeader) == "" && msg.Header.Get(traceparentHeader) == "" {
+		return context.Background()
+	}
+	trace := NewTraceContext(msg.Header.Get(requestIdHeader), msg.Header.Get(traceparentHeader))
+	return WithTrace(context.Background(), trace)
+}
+
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := TraceFrom(req.Context())
+	if trace.RequestID == "" && trace.TraceID == "" {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTrip must not modify the caller's request
+	req = req.Clone(req.Context())
+	setTraceHeaders(req.Header.Set, trace)
+	return t.base.RoundTrip(req)
+}
+
+// NewHttpClient : http client forwarding request id and traceparent of request context
+func NewHttpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: tracingTransport{base: http.DefaultTransport}}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	// event packages
+	"github.com/nats-io/nats.go"
+)
+
+func TestEventContextPropagatesToHttpCalls(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	trace := NewTraceContext("req-123", "")
+	msg := nats.NewMsg(SubjectMailSend)
+	setTraceHeaders(msg.Header.Set, trace)
+
+	req, err := http.NewRequestWithContext(EventContext(msg), http.MethodPost, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewHttpClient(time.Second).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if got := received.Get(requestIdHeader); got != "req-123" {
+		t.Errorf("request id = %q, want req-123", got)
+	}
+	// the worker continues the trace of the event with a span of its own
+	if got := received.Get(traceparentHeader); !strings.HasPrefix(got, "00-"+trace.TraceID+"-") || got == trace.Traceparent() {
+		t.Errorf("traceparent = %q, want trace %s with a new span", got, trace.TraceID)
+	}
+}
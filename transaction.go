@@ -0,0 +1,238 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Transactional Request Scope
+*	TxMiddleware gives a write route one gorm transaction, begun by the first
+*	DB(ctx) call so validation, limits and spam scoring (slow classifier)
+*	run before it holds a connection or the sqlite writer. Handlers use
+*	DB(ctx) instead of global db so every write of the request shares it
+*	(no post saved while its counter update failed).
+*	Transaction commits when handler answers with status < 400 and rolls
+*	back on error status or panic (panic is raised again for recovery).
+*	Response is held back until commit so a failed commit answers 500
+*	"transaction/commit" instead of a false 200. Side effects that must not
+*	happen for rolled back writes (events, cache invalidation) are queued
+*	with AfterCommit. Outside TxMiddleware DB(ctx) is db and AfterCommit
+*	runs at once. The transaction is also on the request context, code
+*	that only gets a context.Context (event outbox) writes through
+*	openRequestTx so it does not wait on the sqlite writer the request
+*	holds. It is only handed out while begun and not yet committed or
+*	rolled back, AfterCommit callbacks and writes before the first DB(ctx)
+*	use global db.
+*/
+const (
+	txContextKey     = "db_tx"
+	txAfterCommitKey = "db_tx_after_commit"
+)
+
+// requestTx : transaction of a TxMiddleware request, begun on first use
+type requestTx struct {
+	mu     sync.Mutex
+	tx     *gorm.DB
+	unlock func()
+	// closed : committed or rolled back, tx must not be used anymore
+	closed  bool
+	onClose []func(committed bool)
+}
+
+func (r *requestTx) begin() *gorm.DB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tx == nil {
+		// sqlite allows one writer, request holds it until commit (see sqlite.go)
+		r.unlock = lockSQLiteWriter()
+		r.tx = db.Begin()
+	}
+	return r.tx
+}
+
+// started : transaction begun, nil when the request did not use it
+func (r *requestTx) started() *gorm.DB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tx
+}
+
+// open : transaction begun and not yet committed or rolled back, nil otherwise
+func (r *requestTx) open() *gorm.DB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	return r.tx
+}
+
+// close : marks transaction ended and runs onClose callbacks with the outcome
+func (r *requestTx) close(committed bool) {
+	r.mu.Lock()
+	r.closed = true
+	callbacks := r.onClose
+	r.onClose = nil
+	r.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(committed)
+	}
+}
+
+// DB : transaction of request when route runs in TxMiddleware, global db otherwise
+func DB(ctx *gin.Context) *gorm.DB {
+	if scope, ok := ctx.Get(txContextKey); ok {
+		return scope.(*requestTx).begin()
+	}
+	return db
+}
+
+type txContextKeyType struct{}
+
+/**
+*	openRequestTx : open transaction of request context c, nil when c has
+*	none or it is not begun yet or already ended. onClose runs with the
+*	commit outcome once an open transaction ends.
+*/
+func openRequestTx(c context.Context, onClose func(committed bool)) *gorm.DB {
+	scope, ok := c.Value(txContextKeyType{}).(*requestTx)
+	if !ok {
+		return nil
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	if scope.tx == nil || scope.closed {
+		return nil
+	}
+	scope.onClose = append(scope.onClose, onClose)
+	return scope.tx
+}
+
+// holdsSQLiteWriter : request of context c has an open transaction and holds the sqlite writer
+func holdsSQLiteWriter(c context.Context) bool {
+	scope, ok := c.Value(txContextKeyType{}).(*requestTx)
+	return ok && scope.open() != nil
+}
+
+// AfterCommit : runs fn once request transaction is committed, skipped on rollback
+func AfterCommit(ctx *gin.Context, fn func()) {
+	if _, ok := ctx.Get(txContextKey); !ok {
+		fn()
+		return
+	}
+	queued, _ := ctx.Get(txAfterCommitKey)
+	fns, _ := queued.([]func())
+	ctx.Set(txAfterCommitKey, append(fns, fn))
+}
+
+// txWriter : holds response body until transaction outcome is known
+type txWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *txWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *txWriter) WriteString(data string) (int, error) {
+	return w.body.WriteString(data)
+}
+
+func (w *txWriter) WriteHeaderNow() {}
+
+func (w *txWriter) Written() bool { return false }
+
+func (w *txWriter) Flush() {}
+
+// TxMiddleware : runs handler chain in one transaction, see DB and AfterCommit
+func TxMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		scope := &requestTx{}
+		defer func() {
+			if scope.unlock != nil {
+				scope.unlock()
+			}
+		}()
+		writer := &txWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Set(txContextKey, scope)
+		ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), txContextKeyType{}, scope))
+		done := false
+		defer func() {
+			if done {
+				return
+			}
+			// panic in handler, recovery middleware writes the response
+			if tx := scope.started(); tx != nil {
+				tx.Rollback()
+				scope.close(false)
+			}
+			ctx.Writer = writer.ResponseWriter
+		}()
+
+		ctx.Next()
+
+		done = true
+		ctx.Writer = writer.ResponseWriter
+		tx := scope.started()
+		if tx == nil {
+			// nothing was written, answered without a transaction
+			ctx.Writer.Write(writer.body.Bytes())
+			runAfterCommit(ctx)
+			return
+		}
+		unlock := scope.unlock
+		if tx.Error != nil && ctx.Writer.Status() < http.StatusBadRequest {
+			unlock()
+			scope.close(false)
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeTransactionBegin,
+				"message": tx.Error.Error(),
+			})
+			return
+		}
+		if ctx.Writer.Status() >= http.StatusBadRequest {
+			err := tx.Rollback().Error
+			unlock()
+			scope.close(false)
+			if err != nil {
+				LogWarn("Error rolling back request transaction", LogFields{"path": ctx.FullPath(), "error": err})
+			}
+			ctx.Writer.Write(writer.body.Bytes())
+			return
+		}
+		err := tx.Commit().Error
+		unlock()
+		scope.close(err == nil)
+		if err != nil {
+			LogError("Error committing request transaction", LogFields{"path": ctx.FullPath(), "error": err})
+			WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+				"status":  false,
+				"type":    ErrCodeTransactionCommit,
+				"message": "Changes could not be saved.",
+			})
+			return
+		}
+		ctx.Writer.Write(writer.body.Bytes())
+		runAfterCommit(ctx)
+	}
+}
+
+func runAfterCommit(ctx *gin.Context) {
+	if queued, ok := ctx.Get(txAfterCommitKey); ok {
+		for _, fn := range queued.([]func()) {
+			fn()
+		}
+	}
+}
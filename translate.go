@@ -0,0 +1,357 @@
+package main
+
+import (
+	// system packages
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+	// database packages
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/**
+*	Post Translation
+*	GET /post/:id/translate?to=en translates post body (to defaults to
+*	request locale) with TRANSLATION_PROVIDER, empty disables the endpoint:
+*	- libretranslate : LIBRETRANSLATE_URL (+ LIBRETRANSLATE_API_KEY)
+*	- deepl          : DEEPL_API_KEY, free api for keys ending with :fx
+*	- google         : GOOGLE_TRANSLATE_API_KEY (Cloud Translation v2)
+*	Translations are kept per post and language in post_translations with
+*	a hash of the translated body, edited posts are translated again.
+*	Provider calls (cache misses) count against policy rule post.translate.
+*	Responses mark machine_translated, posts already in the target language
+*	come back as is with machine_translated false. Rules of lists apply
+*	before any provider call: posts held for spam review are not found,
+*	sensitive posts follow the sensitive query param (blur answers with
+*	body_hidden and no body, hide answers not found, show translates).
+*/
+type TranslationResult struct {
+	Text string
+	// SourceLanguage : detected language of text, lowercase like "de"
+	SourceLanguage string
+}
+
+type TranslationProvider interface {
+	Name() string
+	Translate(ctx context.Context, text, target string) (TranslationResult, error)
+}
+
+var translationProvider TranslationProvider
+
+// InitTranslationProvider : provider from env, nil when translation is disabled
+func InitTranslationProvider() (TranslationProvider, error) {
+	client := NewHttpClient(getEnvDuration("TRANSLATION_TIMEOUT", 10*time.Second))
+	switch os.Getenv("TRANSLATION_PROVIDER") {
+	case "":
+		return nil, nil
+	case "libretranslate":
+		if os.Getenv("LIBRETRANSLATE_URL") == "" {
+			return nil, errors.New("LIBRETRANSLATE_URL is required for TRANSLATION_PROVIDER=libretranslate")
+		}
+		return LibreTranslateProvider{Url: strings.TrimRight(os.Getenv("LIBRETRANSLATE_URL"), "/"), ApiKey: secrets.Get("LIBRETRANSLATE_API_KEY"), Client: client}, nil
+	case "deepl":
+		apiKey := secrets.Get("DEEPL_API_KEY")
+		if apiKey == "" {
+			return nil, errors.New("DEEPL_API_KEY is required for TRANSLATION_PROVIDER=deepl")
+		}
+		return DeepLProvider{ApiKey: apiKey, Client: client}, nil
+	case "google":
+		apiKey := secrets.Get("GOOGLE_TRANSLATE_API_KEY")
+		if apiKey == "" {
+			return nil, errors.New("GOOGLE_TRANSLATE_API_KEY is required for TRANSLATION_PROVIDER=google")
+		}
+		return GoogleTranslateProvider{ApiKey: apiKey, Client: client}, nil
+	default:
+		return nil, errors.New("unknown TRANSLATION_PROVIDER: " + os.Getenv("TRANSLATION_PROVIDER"))
+	}
+}
+
+// postTranslationJSON : posts body as json and decodes 200 response into out
+func postTranslationJSON(client *http.Client, req *http.Request, out interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("translation api: unexpected status " + res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+/**
+*	LibreTranslateProvider : self hosted or libretranslate.com api
+*	(Docs: https://libretranslate.com/docs)
+*/
+type LibreTranslateProvider struct {
+	Url    string
+	ApiKey string
+	Client *http.Client
+}
+
+func (p LibreTranslateProvider) Name() string { return "libretranslate" }
+
+func (p LibreTranslateProvider) Translate(ctx context.Context, text, target string) (TranslationResult, error) {
+	body, _ := json.Marshal(map[string]string{"q": text, "source": "auto", "target": target, "format": "text", "api_key": p.ApiKey})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Url+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return TranslationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var reply struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := postTranslationJSON(p.Client, req, &reply); err != nil {
+		return TranslationResult{}, err
+	}
+	return TranslationResult{Text: reply.TranslatedText, SourceLanguage: strings.ToLower(reply.DetectedLanguage.Language)}, nil
+}
+
+/**
+*	DeepLProvider : DeepL api v2
+*	(Docs: https://www.deepl.com/docs-api/translating-text/)
+*/
+type DeepLProvider struct {
+	ApiKey string
+	Client *http.Client
+}
+
+func (p DeepLProvider) Name() string { return "deepl" }
+
+func (p DeepLProvider) Translate(ctx context.Context, text, target string) (TranslationResult, error) {
+	endpoint := "https://api.deepl.com/v2/translate"
+	if strings.HasSuffix(p.ApiKey, ":fx") {
+		endpoint = "https://api-free.deepl.com/v2/translate"
+	}
+	form := url.Values{"text": {text}, "target_lang": {strings.ToUpper(target)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return TranslationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+p.ApiKey)
+	var reply struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := postTranslationJSON(p.Client, req, &reply); err != nil {
+		return TranslationResult{}, err
+	}
+	if len(reply.Translations) == 0 {
+		return TranslationResult{}, errors.New("deepl: empty translations")
+	}
+	return TranslationResult{Text: reply.Translations[0].Text, SourceLanguage: strings.ToLower(reply.Translations[0].DetectedSourceLanguage)}, nil
+}
+
+/**
+*	GoogleTranslateProvider : Cloud Translation basic (v2) with api key
+*	(Docs: https://cloud.google.com/translate/docs/reference/rest/v2/translate)
+*/
+type GoogleTranslateProvider struct {
+	ApiKey string
+	Client *http.Client
+}
+
+func (p GoogleTranslateProvider) Name() string { return "google" }
+
+func (p GoogleTranslateProvider) Translate(ctx context.Context, text, target string) (TranslationResult, error) {
+	body, _ := json.Marshal(map[string]string{"q": text, "target": target, "format": "text"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://translation.googleapis.com/language/translate/v2?key="+url.QueryEscape(p.ApiKey), bytes.NewReader(body))
+	if err != nil {
+		return TranslationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var reply struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := postTranslationJSON(p.Client, req, &reply); err != nil {
+		return TranslationResult{}, err
+	}
+	if len(reply.Data.Translations) == 0 {
+		return TranslationResult{}, errors.New("google translate: empty translations")
+	}
+	translation := reply.Data.Translations[0]
+	return TranslationResult{Text: translation.TranslatedText, SourceLanguage: strings.ToLower(translation.DetectedSourceLanguage)}, nil
+}
+
+type PostTranslation struct {
+	PostID   uint   `gorm:"column:post_id;primaryKey;autoIncrement:false"`
+	Language string `gorm:"column:language;size:16;primaryKey"`
+	// SourceHash : sha256 of translated body, differs after the post is edited
+	SourceHash     string    `gorm:"column:source_hash;size:64;not null"`
+	SourceLanguage string    `gorm:"column:source_language;size:16"`
+	Body           string    `gorm:"column:body;type:text;not null"`
+	Provider       string    `gorm:"column:provider;size:32;not null"`
+	CreatedAt      time.Time `gorm:"column:created_at"`
+}
+
+type TranslatePostQuery struct {
+	// To : target language, defaults to request locale
+	To string `form:"to" validate:"omitempty,bcp47_language_tag,max=16" example:"en"`
+	// Sensitive : blur (default), show or hide like lists (see sensitive.go)
+	Sensitive string `form:"sensitive,default=blur" validate:"oneof=blur show hide" example:"blur"`
+}
+
+// PostTranslationDto is the payload of /post/:id/translate
+type PostTranslationDto struct {
+	PostID            string `json:"post_id"`
+	Language          string `json:"language" example:"en"`
+	SourceLanguage    string `json:"source_language" example:"tr"`
+	Body              string `json:"body"`
+	MachineTranslated bool   `json:"machine_translated"`
+	Provider          string `json:"provider,omitempty" example:"deepl"`
+	// BodyHidden : post is sensitive and was not translated for the viewer
+	BodyHidden bool `json:"body_hidden,omitempty"`
+}
+
+func translationSourceHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// TranslatePostHandler godoc
+// @Summary Translate post body
+// @Schemes
+// @Description Machine translation of post body into to (default request locale), cached per post and language
+// @Tags post
+// @Param id path string true "public post id"
+// @Param to query string false "target language like en or pt-BR"
+// @Param sensitive query string false "blur (default), show or hide sensitive posts"
+// @Produce json
+// @Success 200 {object} PostTranslationDto
+// @Failure 400 {object} object
+// @Failure 404 {object} object
+// @Failure 422 {object} object
+// @Failure 429 {object} object
+// @Failure 503 {object} object
+// @Router /post/{id}/translate [get]
+func TranslatePostHandler(ctx *gin.Context) {
+	var query TranslatePostQuery
+	if err := BindQuery(ctx, &query, ErrAreaTranslate); err != nil {
+		return
+	}
+	language := strings.ToLower(query.To)
+	if language == "" {
+		language = Locale(ctx)
+	}
+	post, err := FindPostByPublicID(ctx.Param("id"))
+	// held posts are out of lists and sensitive ones out of hide mode lists
+	if err == nil && (post.SpamStatus == SpamHeld || post.SpamStatus == SpamRejected || post.Sensitive && query.Sensitive == SensitiveHide) {
+		err = gorm.ErrRecordNotFound
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusNotFound, gin.H{
+			"status":  false,
+			"type":    ErrCodeTranslateNotFound,
+			"message": "Post not found.",
+		})
+		return
+	}
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeTranslateQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	if post.Sensitive && query.Sensitive != SensitiveShow {
+		WriteJSON(ctx, http.StatusOK, gin.H{
+			"translation": PostTranslationDto{PostID: post.PublicID, Language: language, BodyHidden: true},
+		})
+		return
+	}
+	if strings.TrimSpace(post.Body) == "" {
+		WriteJSON(ctx, http.StatusUnprocessableEntity, gin.H{
+			"status":  false,
+			"type":    ErrCodeTranslateEmpty,
+			"message": "Post has no text to translate, translate the original of reposts.",
+		})
+		return
+	}
+
+	hash := translationSourceHash(post.Body)
+	var cached PostTranslation
+	err = db.Where("post_id = ? AND language = ? AND source_hash = ?", post.ID, language, hash).First(&cached).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeTranslateQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		if translationProvider == nil {
+			WriteJSON(ctx, http.StatusServiceUnavailable, gin.H{
+				"status":  false,
+				"type":    ErrCodeTranslateUnavailable,
+				"message": "Translation is not enabled.",
+			})
+			return
+		}
+		if err := policies.AllowRequest(ctx, "post.translate", ActorID(ctx), false); err != nil {
+			abortQuotaExceeded(ctx, err.(*QuotaExceededError))
+			return
+		}
+		result, err := translationProvider.Translate(ctx.Request.Context(), post.Body, language)
+		if err != nil {
+			LogError("Post translation failed", LogFields{"provider": translationProvider.Name(), "language": language, "error": err, "request_id": RequestID(ctx)})
+			WriteJSON(ctx, http.StatusServiceUnavailable, gin.H{
+				"status":  false,
+				"type":    ErrCodeTranslateUnavailable,
+				"message": "Translation provider failed, please retry later.",
+			})
+			return
+		}
+		cached = PostTranslation{PostID: post.ID, Language: language, SourceHash: hash, SourceLanguage: result.SourceLanguage, Body: result.Text, Provider: translationProvider.Name(), CreatedAt: utcNow()}
+		// edited posts replace their previous translation
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "post_id"}, {Name: "language"}},
+			DoUpdates: clause.AssignmentColumns([]string{"source_hash", "source_language", "body", "provider", "created_at"}),
+		}).Create(&cached).Error
+		if err != nil {
+			LogWarn("Error storing post translation", LogFields{"post_id": post.PublicID, "language": language, "error": err})
+		}
+	}
+
+	dto := PostTranslationDto{
+		PostID:            post.PublicID,
+		Language:          language,
+		SourceLanguage:    cached.SourceLanguage,
+		Body:              cached.Body,
+		MachineTranslated: true,
+		Provider:          cached.Provider,
+	}
+	// same primary language (pt vs pt-br) needs no translation
+	if cached.SourceLanguage != "" && strings.SplitN(cached.SourceLanguage, "-", 2)[0] == strings.SplitN(language, "-", 2)[0] {
+		dto.Body = post.Body
+		dto.MachineTranslated = false
+		dto.Provider = ""
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"translation": dto,
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+/**
+*	Trending Posts
+*	GET /post/trending ranks listed posts by impressions of post_stats
+*	(aggregated from analytics events, see post_stats.go) over the last
+*	days, read through postRepository so the sqlc query serves it.
+*	Impressions change without post writes so entries live for a minute.
+*/
+var TrendingCache = CachePolicy{Group: "posts", TTL: time.Minute, Key: CacheKeyByURLAndAuth}
+
+type TrendingQuery struct {
+	Days      int    `form:"days,default=1" json:"days" validate:"min=1,max=30"`
+	Limit     int    `form:"limit,default=10" json:"limit" validate:"min=1,max=50"`
+	Sensitive string `form:"sensitive,default=blur" json:"sensitive" validate:"oneof=blur show hide"`
+}
+
+type TrendingPostDto struct {
+	PostDto
+	Impressions int64 `json:"impressions"`
+}
+
+// TrendingPostsHandler godoc
+// @Summary Trending posts
+// @Schemes
+// @Description Listed posts with most impressions in the last days (today counts as one day)
+// @Tags post-service
+// @Param days query int false "window in days (1-30)" default(1)
+// @Param limit query int false "posts (1-50)" default(10)
+// @Param sensitive query string false "blur, show or hide sensitive posts" default(blur)
+// @Produce json
+// @Success 200 {object} object{data=[]TrendingPostDto}
+// @Failure 400 {object} object
+// @Router /post/trending [get]
+func TrendingPostsHandler(ctx *gin.Context) {
+	var query TrendingQuery
+	if err := BindQuery(ctx, &query, ErrAreaTrending); err != nil {
+		return
+	}
+	trending, err := postRepository.TrendingPosts(ctx.Request.Context(), trendingSince(query.Days), query.Limit)
+	if err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeTrendingQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	posts := make([]Post, 0, len(trending))
+	impressions := map[string]int64{}
+	for _, row := range trending {
+		posts = append(posts, row.Post)
+		impressions[row.PublicID] = row.Impressions
+	}
+	dtos := ToPostDtos(posts)
+	if err := EmbedOriginals(posts, dtos); err != nil {
+		WriteJSON(ctx, http.StatusInternalServerError, gin.H{
+			"status":  false,
+			"type":    ErrCodeTrendingQuery,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// sensitive posts and shares of sensitive posts are left out with sensitive=hide
+	data := make([]TrendingPostDto, 0, len(dtos))
+	for _, dto := range RedactSensitivePosts(dtos, query.Sensitive) {
+		if query.Sensitive == SensitiveHide && (dto.Sensitive || dto.Original != nil && dto.Original.Sensitive) {
+			continue
+		}
+		data = append(data, TrendingPostDto{PostDto: dto, Impressions: impressions[dto.ID]})
+	}
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"data": data,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	// system packages
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// validator packages
+	"github.com/go-playground/validator/v10"
+)
+
+/**
+*	Shared Validator
+*	Custom tags are registered once here and used by every dto validator:
+*	- password : minimum strength score (APP_PASSWORD_MIN_SCORE, 0-4)
+*	- notpwned : not found in HaveIBeenPwned range api (APP_PASSWORD_HIBP_CHECK=true)
+*	- querytime: RFC3339 or YYYY-MM-DD time in query params
+*	- slug     : lowercase ascii letters and digits joined by single dashes
+*	- limit    : at most the value of a configurable rule (see validation_rules.go)
+*/
+var validate = NewValidator()
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	// report json field names instead of go field names
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("password", validatePasswordStrength)
+	v.RegisterValidation("notpwned", validatePasswordNotPwned)
+	v.RegisterValidation("querytime", func(fl validator.FieldLevel) bool {
+		_, err := parseQueryTime(fl.Field().String())
+		return err == nil
+	})
+	v.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugPattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("limit", validateLimit)
+	return v
+}
+
+/**
+*	ValidationErrors : converts validator errors to field level messages
+*	like {"password": "is too weak (minimum strength score 3 of 4)"}
+*/
+func ValidationErrors(err error) map[string]string {
+	fields := map[string]string{}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return fields
+	}
+	for _, fieldErr := range validationErrors {
+		fields[fieldErr.Field()] = validationMessage(fieldErr)
+	}
+	return fields
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "required_if":
+		// param like "Kind latency"
+		return "is required when " + strings.Replace(fieldErr.Param(), " ", " is ", 1)
+	case "min":
+		if isNumberKind(fieldErr.Kind()) {
+			return "must be at least " + fieldErr.Param()
+		}
+		return "must be at least " + fieldErr.Param() + " characters"
+	case "max":
+		if isNumberKind(fieldErr.Kind()) {
+			return "must be at most " + fieldErr.Param()
+		}
+		return "must be at most " + fieldErr.Param() + " characters"
+	case "password":
+		return "is too weak (minimum strength score " + strconv.Itoa(passwordMinScore()) + " of 4)"
+	case "notpwned":
+		return "appeared in a known data breach, choose another password"
+	case "querytime":
+		return "must be RFC3339 or YYYY-MM-DD"
+	case "bcp47_language_tag":
+		return "must be a language code like en or pt-BR"
+	case "slug":
+		return "must be lowercase letters and digits separated by single dashes"
+	case "limit":
+		return limitMessage(fieldErr)
+	}
+	return "failed on " + fieldErr.Tag() + " validation"
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
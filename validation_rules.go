@@ -0,0 +1,105 @@
+package main
+
+import (
+	// system packages
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	// validator packages
+	"github.com/go-playground/validator/v10"
+)
+
+/**
+*	Validation Rules
+*	Limits deployments tighten or loosen without recompiling. DTO fields
+*	name a rule of validationRules with the limit tag like
+*	`validate:"required,limit=post.body.max_length"` and the value comes
+*	from APP_VALIDATION_RULES (post.body.max_length=140,...) of the
+*	runtime config, so SIGHUP or POST /_/reload applies new values.
+*	Values above Ceiling (size of the column behind the field) are
+*	clamped to it, unknown names and values below one are ignored with a
+*	warning. limit counts characters of strings, items of slices and maps.
+*	Username charset and tag count rules are added here with their models.
+*/
+type ValidationRule struct {
+	Name    string
+	Default int
+	// Ceiling : largest value storage takes, zero when unbounded
+	Ceiling int
+}
+
+var validationRules = []ValidationRule{
+	{Name: "post.body.max_length", Default: 255, Ceiling: 255},
+}
+
+// loadValidationRules : rule values of APP_VALIDATION_RULES over defaults and
+// problems to log once the snapshot is stored (logging reads runtime config)
+func loadValidationRules(getenv func(string) string) (map[string]int, []string) {
+	values := map[string]int{}
+	problems := []string{}
+	known := map[string]ValidationRule{}
+	for _, rule := range validationRules {
+		values[rule.Name] = rule.Default
+		known[rule.Name] = rule
+	}
+	for _, entry := range splitList(getenv("APP_VALIDATION_RULES")) {
+		parts := strings.SplitN(entry, "=", 2)
+		rule, ok := known[strings.TrimSpace(parts[0])]
+		if !ok || len(parts) != 2 {
+			problems = append(problems, "unknown rule ignored: "+entry)
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || value < 1 {
+			problems = append(problems, "invalid value ignored: "+entry)
+			continue
+		}
+		if rule.Ceiling > 0 && value > rule.Ceiling {
+			problems = append(problems, rule.Name+" clamped to its ceiling "+strconv.Itoa(rule.Ceiling))
+			value = rule.Ceiling
+		}
+		values[rule.Name] = value
+	}
+	return values, problems
+}
+
+// validationLimit : current value of rule name
+func validationLimit(name string) (int, bool) {
+	value, ok := runtimeConfig().ValidationRules[name]
+	return value, ok
+}
+
+// validateLimit : field is within limit of the rule named by the tag param
+func validateLimit(fl validator.FieldLevel) bool {
+	limit, ok := validationLimit(fl.Param())
+	if !ok {
+		return false
+	}
+	field := fl.Field()
+	switch field.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(field.String()) <= limit
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return field.Len() <= limit
+	}
+	return false
+}
+
+func limitMessage(fieldErr validator.FieldError) string {
+	limit, ok := validationLimit(fieldErr.Param())
+	if !ok {
+		return "has unknown validation rule " + fieldErr.Param()
+	}
+	if fieldErr.Kind() == reflect.String {
+		return "must be at most " + strconv.Itoa(limit) + " characters"
+	}
+	return "must have at most " + strconv.Itoa(limit) + " items"
+}
+
+// maxPostBodyLength : current post body limit for capabilities
+func maxPostBodyLength() int {
+	limit, _ := validationLimit("post.body.max_length")
+	return limit
+}
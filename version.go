@@ -0,0 +1,30 @@
+package main
+
+import (
+	// system packages
+	"net/http"
+	"runtime"
+	"time"
+
+	// web server packages
+	"github.com/gin-gonic/gin"
+)
+
+// AppVersionHandler godoc
+// @Summary Returns build metadata
+// @Schemes
+// @Description Returns app version, git commit and build time injected with -ldflags plus Go runtime version
+// @Tags post-service-health
+// @Accept */*
+// @Produce json
+// @Success 200 {object} object
+// @Router /post/_/version [get]
+func AppVersionHandler(ctx *gin.Context) {
+	WriteJSON(ctx, http.StatusOK, gin.H{
+		"version":    appVersion,
+		"commit":     gitCommit,
+		"build_time": buildTime,
+		"go_version": runtime.Version(),
+		"uptime":     time.Since(startTime).String(),
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	// database packages
+	"gorm.io/gorm"
+)
+
+/**
+*	Post Visibility
+*	public   : listed in post lists and feeds
+*	unlisted : left out of lists, readable by anyone who has its id (link)
+*	Followers-only posts need post authors and follow relations first.
+*	Lists and feeds select posts through ListedPosts scope, which also
+*	leaves out posts held for spam review (see spam.go).
+*/
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+)
+
+// ListedPosts : scope of posts shown in lists and feeds
+func ListedPosts(tx *gorm.DB) *gorm.DB {
+	return tx.Where("visibility = ? AND spam_status <> ?", VisibilityPublic, SpamHeld)
+}